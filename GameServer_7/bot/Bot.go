@@ -0,0 +1,477 @@
+package bot
+
+import (
+	"GoTests/GameServer_7/gameserver"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	BOT_DIAL_TIMEOUT   = 5 * time.Second
+	BOT_COMMAND_PERIOD = 100 * time.Millisecond
+	BOT_HIT_PERIOD     = 1100 * time.Millisecond // чуть больше интервала атаки игрока
+	BOT_HIT_RADIUS     = 5.0
+	BOT_HIT_DAMAGE     = 1000
+	BOT_ACK_TIMEOUT    = 2 * time.Second
+)
+
+const (
+	BOT_TRANSPORT_TCP = "tcp"
+	BOT_TRANSPORT_KCP = "kcp" // параметры сессии из Config.KCP, как на сервере
+)
+
+type Config struct {
+	Address       string
+	Transport     string
+	KCP           gameserver.KCPConfig
+	TLS           *tls.Config // только для tcp, nil - без TLS
+	CommandPeriod time.Duration
+	HitPeriod     time.Duration
+	HitRadius     float64
+	HitDamage     int16
+	AckTimeout    time.Duration
+	ResourcesHash string // хеш ресурсов уровня в кеше клиента, пустой - кеша нет
+}
+
+func NewDefaultConfig(address string) Config {
+	config := Config{
+		Address:       address,
+		Transport:     BOT_TRANSPORT_TCP,
+		KCP:           gameserver.NewDefaultConfig().KCP,
+		CommandPeriod: BOT_COMMAND_PERIOD,
+		HitPeriod:     BOT_HIT_PERIOD,
+		HitRadius:     BOT_HIT_RADIUS,
+		HitDamage:     BOT_HIT_DAMAGE,
+		AckTimeout:    BOT_ACK_TIMEOUT,
+	}
+	return config
+}
+
+type cellCoord struct {
+	X int16
+	Y int16
+}
+
+// Бот без графики: читает ArenaInfo, ходит по проходимым ячейкам и бьет монстров рядом
+type Bot struct {
+	config Config
+	random *rand.Rand
+	conn   gameserver.ClientConnection // рамка сообщений та же, что у сервера
+	mutex  sync.Mutex
+	// Пишут цикл команд и ответы на Ping из цикла чтения
+	writeMutex sync.Mutex
+	// Данные арены, заполняются циклом чтения
+	clientId      uint32
+	walkable      map[cellCoord]bool
+	walkableList  []cellCoord
+	monsters      []gameserver.ServerMonsterState
+	lastStateTime time.Time
+	lastTick      uint64 // тик последнего состояния, отправляется с ударами
+	// Данные отправки
+	cell         cellCoord
+	hasCell      bool
+	lastHitTime  time.Time
+	seq          uint32               // номер последней отправленной команды
+	pending      map[uint32]time.Time // время отправки неподтвержденных команд по номеру
+	stats        Stats
+	arenaClosing bool
+}
+
+func NewBot(config Config, seed int64) *Bot {
+	bot := &Bot{
+		config:   config,
+		random:   rand.New(rand.NewSource(seed)),
+		walkable: make(map[cellCoord]bool),
+		pending:  make(map[uint32]time.Time),
+	}
+	return bot
+}
+
+// Копия статистики, можно вызывать во время работы
+func (bot *Bot) GetStats() Stats {
+	bot.mutex.Lock()
+	stats := bot.stats
+	stats.Latencies = append([]time.Duration{}, bot.stats.Latencies...)
+	stats.StateIntervals = append([]time.Duration{}, bot.stats.StateIntervals...)
+	stats.ServerRTTs = append([]time.Duration{}, bot.stats.ServerRTTs...)
+	bot.mutex.Unlock()
+	return stats
+}
+
+// Работа бота до отмены ctx или разрыва соединения
+func (bot *Bot) Run(ctx context.Context) error {
+	conn, err := bot.dial(ctx)
+	if err != nil {
+		bot.setError(err)
+		return err
+	}
+	bot.conn = gameserver.NewStreamConnection(conn, 0)
+	bot.mutex.Lock()
+	bot.stats.Connected = true
+	bot.mutex.Unlock()
+
+	// Без JOIN сервер ждет его и только потом присылает ArenaInfo
+	join := gameserver.ClientCommand{
+		CommandType:   gameserver.CLIENT_COMMAND_TYPE_JOIN,
+		ResourcesHash: bot.config.ResourcesHash,
+	}
+	err = bot.writeCommand(&join, time.Now())
+	if err != nil {
+		conn.Close()
+		bot.setError(err)
+		return err
+	}
+
+	readErrCh := make(chan error, 1)
+	go func() {
+		readErrCh <- bot.loopRead()
+	}()
+
+	ticker := time.NewTicker(bot.config.CommandPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			<-readErrCh
+			bot.expirePending(time.Now())
+			return nil
+
+		case err := <-readErrCh:
+			conn.Close()
+			bot.expirePending(time.Now())
+			if bot.isArenaClosing() {
+				return nil
+			}
+			bot.setError(err)
+			return err
+
+		case now := <-ticker.C:
+			err := bot.sendStep(now)
+			if err != nil {
+				conn.Close()
+				<-readErrCh
+				bot.setError(err)
+				return err
+			}
+		}
+	}
+}
+
+func (bot *Bot) dial(ctx context.Context) (net.Conn, error) {
+	switch bot.config.Transport {
+	case BOT_TRANSPORT_TCP:
+		dialer := net.Dialer{Timeout: BOT_DIAL_TIMEOUT}
+		if bot.config.TLS != nil {
+			tlsDialer := tls.Dialer{NetDialer: &dialer, Config: bot.config.TLS}
+			return tlsDialer.DialContext(ctx, "tcp", bot.config.Address)
+		}
+		return dialer.DialContext(ctx, "tcp", bot.config.Address)
+	case BOT_TRANSPORT_KCP:
+		session, err := gameserver.DialKCP(bot.config.Address, bot.config.KCP)
+		if err != nil {
+			return nil, err
+		}
+		return session, nil
+	}
+	return nil, errors.New("Unknown transport " + bot.config.Transport)
+}
+
+// Настройки TLS бота: caFile - CA сервера (пустой - системные), certFile и keyFile - клиентский
+// сертификат для сервера с tlsClientCAFile (пустые - без сертификата)
+func NewTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(data) == false {
+			return nil, errors.New("No certificates in " + caFile)
+		}
+		config.RootCAs = pool
+	}
+	if (certFile != "") || (keyFile != "") {
+		pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+func (bot *Bot) setError(err error) {
+	bot.mutex.Lock()
+	bot.stats.Error = err.Error()
+	bot.mutex.Unlock()
+}
+
+func (bot *Bot) isArenaClosing() bool {
+	bot.mutex.Lock()
+	defer bot.mutex.Unlock()
+	return bot.arenaClosing
+}
+
+// Шаг на соседнюю проходимую ячейку и удар по ближайшему монстру
+func (bot *Bot) sendStep(now time.Time) error {
+	bot.mutex.Lock()
+	if len(bot.walkableList) == 0 {
+		bot.mutex.Unlock()
+		return nil
+	}
+	if bot.hasCell == false {
+		bot.cell = bot.walkableList[bot.random.Intn(len(bot.walkableList))]
+		bot.hasCell = true
+	} else {
+		bot.cell = bot.nextCell()
+	}
+
+	bot.seq++
+	// Случайное смещение внутри ячейки, чтобы боты не ходили строго по центрам
+	command := gameserver.ClientCommand{
+		Seq:         bot.seq,
+		Tick:        bot.lastTick,
+		CommandType: gameserver.CLIENT_COMMAND_TYPE_MOVE,
+		X:           float64(bot.cell.X) + 0.3 + bot.random.Float64()*0.4,
+		Y:           float64(bot.cell.Y) + 0.3 + bot.random.Float64()*0.4,
+		Duration:    bot.config.CommandPeriod.Seconds(),
+	}
+
+	if now.Sub(bot.lastHitTime) >= bot.config.HitPeriod {
+		for _, monster := range bot.monsters {
+			if (monster.Status != gameserver.MONSTER_STATE_STATUS_ALIVE) || (monster.Health <= 0) {
+				continue
+			}
+			if math.Hypot(monster.X-command.X, monster.Y-command.Y) <= bot.config.HitRadius {
+				command.CommandType = gameserver.CLIENT_COMMAND_TYPE_HIT
+				command.HitMonsters = []gameserver.ClientCommandHitInfo{{ID: monster.ID, Damage: bot.config.HitDamage}}
+				bot.lastHitTime = now
+				bot.stats.Hits++
+				break
+			}
+		}
+	}
+
+	bot.expirePendingLocked(now)
+	bot.pending[command.Seq] = now
+	bot.stats.CommandsSent++
+	bot.mutex.Unlock()
+
+	return bot.writeCommand(&command, now)
+}
+
+func (bot *Bot) writeCommand(command *gameserver.ClientCommand, now time.Time) error {
+	data, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+	bot.writeMutex.Lock()
+	bot.conn.SetWriteDeadline(now.Add(BOT_DIAL_TIMEOUT))
+	err = bot.conn.WriteMessage(data)
+	bot.writeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	bot.mutex.Lock()
+	bot.stats.BytesSent += uint64(len(data) + 4)
+	bot.mutex.Unlock()
+	return nil
+}
+
+// Случайная соседняя проходимая ячейка, вызывается под mutex
+func (bot *Bot) nextCell() cellCoord {
+	neighbours := [4]cellCoord{
+		{bot.cell.X + 1, bot.cell.Y},
+		{bot.cell.X - 1, bot.cell.Y},
+		{bot.cell.X, bot.cell.Y + 1},
+		{bot.cell.X, bot.cell.Y - 1},
+	}
+	candidates := make([]cellCoord, 0, len(neighbours))
+	for _, cell := range neighbours {
+		if bot.walkable[cell] {
+			candidates = append(candidates, cell)
+		}
+	}
+	if len(candidates) == 0 {
+		return bot.cell
+	}
+	return candidates[bot.random.Intn(len(candidates))]
+}
+
+func (bot *Bot) expirePending(now time.Time) {
+	bot.mutex.Lock()
+	bot.expirePendingLocked(now)
+	bot.mutex.Unlock()
+}
+
+func (bot *Bot) expirePendingLocked(now time.Time) {
+	for key, sendTime := range bot.pending {
+		if now.Sub(sendTime) > bot.config.AckTimeout {
+			delete(bot.pending, key)
+			bot.stats.CommandsLost++
+		}
+	}
+}
+
+func (bot *Bot) loopRead() error {
+	for {
+		data, err := bot.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+
+		bot.mutex.Lock()
+		bot.stats.Messages++
+		bot.stats.BytesReceived += uint64(len(data) + 4)
+		bot.mutex.Unlock()
+
+		switch MessageType(data) {
+		case "ArenaInfo":
+			err = bot.handleArenaInfo(data)
+		case "ClientState":
+			err = bot.handleClientState(data)
+		case "ArenaState":
+			err = bot.handleArenaState(data, now)
+		case "Ping":
+			err = bot.handlePing(data, now)
+		case "ServerMessage":
+			bot.mutex.Lock()
+			bot.arenaClosing = true
+			bot.mutex.Unlock()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Ответ на Ping сразу из цикла чтения, иначе в замер сервера попадет период команд
+func (bot *Bot) handlePing(data []byte, now time.Time) error {
+	ping := gameserver.ServerPing{}
+	err := json.Unmarshal(data, &ping)
+	if err != nil {
+		return err
+	}
+	bot.mutex.Lock()
+	bot.stats.Pings++
+	if ping.RTT > 0 {
+		bot.stats.ServerRTTs = append(bot.stats.ServerRTTs, time.Duration(ping.RTT*float64(time.Second)))
+	}
+	bot.mutex.Unlock()
+
+	command := gameserver.ClientCommand{
+		CommandType: gameserver.CLIENT_COMMAND_TYPE_PONG,
+		PingID:      ping.ID,
+	}
+	return bot.writeCommand(&command, now)
+}
+
+func (bot *Bot) handleArenaInfo(data []byte) error {
+	arena := gameserver.ArenaModel{}
+	err := json.Unmarshal(data, &arena)
+	if err != nil {
+		return err
+	}
+
+	walkable := make(map[cellCoord]bool)
+	walkableList := make([]cellCoord, 0)
+	for _, row := range arena.Platforms {
+		for _, platform := range row {
+			if platform == nil {
+				continue
+			}
+			for i, cellType := range platform.Cells {
+				if (cellType == gameserver.CELL_TYPE_UNDEF) || (cellType&gameserver.CELL_TYPE_WALK == 0) {
+					continue
+				}
+				cell := cellCoord{
+					X: platform.PosX + int16(i%int(platform.Width)),
+					Y: platform.PosY + int16(i/int(platform.Width)),
+				}
+				walkable[cell] = true
+				walkableList = append(walkableList, cell)
+			}
+		}
+	}
+	if len(walkableList) == 0 {
+		return errors.New("No walkable cells in arena")
+	}
+
+	bot.mutex.Lock()
+	bot.walkable = walkable
+	bot.walkableList = walkableList
+	bot.mutex.Unlock()
+	return nil
+}
+
+// Первое состояние клиента приходит сразу после арены, из него берем свой id
+func (bot *Bot) handleClientState(data []byte) error {
+	state := gameserver.ServerClientState{}
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	bot.mutex.Lock()
+	if bot.clientId == 0 {
+		bot.clientId = state.ID
+	}
+	bot.mutex.Unlock()
+	return nil
+}
+
+func (bot *Bot) handleArenaState(data []byte, now time.Time) error {
+	state := gameserver.GameArenaState{}
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+
+	bot.mutex.Lock()
+	defer bot.mutex.Unlock()
+
+	bot.stats.States++
+	if bot.lastStateTime.IsZero() == false {
+		bot.stats.StateIntervals = append(bot.stats.StateIntervals, now.Sub(bot.lastStateTime))
+	}
+	bot.lastStateTime = now
+	bot.lastTick = state.Tick
+	bot.monsters = state.Monsters
+	if state.Status != gameserver.GAME_ROOM_STATUS_ACTIVE {
+		bot.arenaClosing = true
+	}
+
+	// Команда подтверждена, если ее номер пришел в состоянии. Команды с меньшими номерами
+	// сервер уже применил или отбросил, их тоже считаем подтвержденными
+	for _, client := range state.Clients {
+		if client.ID != bot.clientId {
+			continue
+		}
+		sendTime, exists := bot.pending[client.LastSeq]
+		if exists == false {
+			break
+		}
+		bot.stats.Latencies = append(bot.stats.Latencies, now.Sub(sendTime))
+		for seq := range bot.pending {
+			if seq <= client.LastSeq {
+				delete(bot.pending, seq)
+				bot.stats.CommandsAcked++
+			}
+		}
+		break
+	}
+	return nil
+}
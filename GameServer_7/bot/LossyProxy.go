@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	PROXY_PACKET_SIZE   = 64 * 1024
+	PROXY_REORDER_DELAY = 20 * time.Millisecond // задержка пакета, который обгонят следующие
+)
+
+// UDP прокси с потерей и перестановкой пакетов для проверки KCP на loopback. Клиенты шлют на адрес прокси,
+// прокси пересылает серверу через отдельный сокет для каждого клиента и в обе стороны
+// отбрасывает пакеты с вероятностью loss, а из оставшихся с вероятностью reorder задерживает
+// пакет на PROXY_REORDER_DELAY, чтобы следующие пакеты пришли раньше него
+type LossyProxy struct {
+	conn    *net.UDPConn
+	target  *net.UDPAddr
+	loss    float64
+	reorder float64
+	mutex   sync.Mutex
+	random  *rand.Rand
+	clients map[string]*net.UDPConn // сокет к серверу по адресу клиента
+	closed  bool
+	// Статистика
+	forwarded uint64
+	dropped   uint64
+	reordered uint64 // задержанные пакеты, входят в forwarded
+	waitGroup sync.WaitGroup
+}
+
+func NewLossyProxy(listenAddress string, target string, loss float64, reorder float64, seed int64) (*LossyProxy, error) {
+	targetAddress, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return nil, err
+	}
+	address, err := net.ResolveUDPAddr("udp", listenAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := &LossyProxy{
+		conn:    conn,
+		target:  targetAddress,
+		loss:    loss,
+		reorder: reorder,
+		random:  rand.New(rand.NewSource(seed)),
+		clients: make(map[string]*net.UDPConn),
+	}
+	proxy.waitGroup.Add(1)
+	go proxy.loopClients()
+	return proxy, nil
+}
+
+// Адрес, на который должны подключаться клиенты
+func (proxy *LossyProxy) Addr() net.Addr {
+	return proxy.conn.LocalAddr()
+}
+
+// Количество пересланных, отброшенных и переставленных пакетов
+func (proxy *LossyProxy) GetStats() (forwarded uint64, dropped uint64, reordered uint64) {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+	return proxy.forwarded, proxy.dropped, proxy.reordered
+}
+
+func (proxy *LossyProxy) Close() error {
+	proxy.mutex.Lock()
+	proxy.closed = true
+	for _, upstream := range proxy.clients {
+		upstream.Close()
+	}
+	proxy.mutex.Unlock()
+
+	err := proxy.conn.Close()
+	proxy.waitGroup.Wait()
+	return err
+}
+
+// Пересылка пакета: отбросить, отправить сразу или задержать, чтобы его обогнали следующие.
+// Задержанный пакет копируется, буфер цикла чтения переиспользуется
+func (proxy *LossyProxy) forward(packet []byte, write func(packet []byte)) {
+	proxy.mutex.Lock()
+	if proxy.random.Float64() < proxy.loss {
+		proxy.dropped++
+		proxy.mutex.Unlock()
+		return
+	}
+	proxy.forwarded++
+	delay := proxy.random.Float64() < proxy.reorder
+	if delay {
+		proxy.reordered++
+	}
+	proxy.mutex.Unlock()
+
+	if delay == false {
+		write(packet)
+		return
+	}
+	delayed := make([]byte, len(packet))
+	copy(delayed, packet)
+	proxy.waitGroup.Add(1)
+	time.AfterFunc(PROXY_REORDER_DELAY, func() {
+		defer proxy.waitGroup.Done()
+		write(delayed)
+	})
+}
+
+// Пакеты от клиентов к серверу
+func (proxy *LossyProxy) loopClients() {
+	defer proxy.waitGroup.Done()
+	buffer := make([]byte, PROXY_PACKET_SIZE)
+	for {
+		count, clientAddress, err := proxy.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		upstream, err := proxy.getUpstream(clientAddress)
+		if err != nil {
+			continue
+		}
+		proxy.forward(buffer[:count], func(packet []byte) {
+			upstream.Write(packet)
+		})
+	}
+}
+
+// Сокет к серверу для клиента, при первом пакете создается вместе с циклом ответов
+func (proxy *LossyProxy) getUpstream(clientAddress *net.UDPAddr) (*net.UDPConn, error) {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+	key := clientAddress.String()
+	if upstream, exists := proxy.clients[key]; exists {
+		return upstream, nil
+	}
+	if proxy.closed {
+		return nil, net.ErrClosed
+	}
+
+	upstream, err := net.DialUDP("udp", nil, proxy.target)
+	if err != nil {
+		return nil, err
+	}
+	proxy.clients[key] = upstream
+	proxy.waitGroup.Add(1)
+	go proxy.loopServer(clientAddress, upstream)
+	return upstream, nil
+}
+
+// Пакеты от сервера к клиенту
+func (proxy *LossyProxy) loopServer(clientAddress *net.UDPAddr, upstream *net.UDPConn) {
+	defer proxy.waitGroup.Done()
+	buffer := make([]byte, PROXY_PACKET_SIZE)
+	for {
+		count, err := upstream.Read(buffer)
+		if err != nil {
+			return
+		}
+		proxy.forward(buffer[:count], func(packet []byte) {
+			proxy.conn.WriteToUDP(packet, clientAddress)
+		})
+	}
+}
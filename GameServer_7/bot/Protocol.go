@@ -0,0 +1,17 @@
+package bot
+
+import (
+	"encoding/json"
+)
+
+// Тип сообщения сервера из поля "type"
+func MessageType(data []byte) string {
+	header := struct {
+		Type string `json:"type"`
+	}{}
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return ""
+	}
+	return header.Type
+}
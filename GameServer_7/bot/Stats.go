@@ -0,0 +1,54 @@
+package bot
+
+import (
+	"sort"
+	"time"
+)
+
+// Статистика бота, после завершения ботов складывается в общую
+type Stats struct {
+	Connected      bool
+	BytesSent      uint64
+	BytesReceived  uint64
+	CommandsSent   uint64
+	CommandsAcked  uint64 // команды, позиция из которых пришла в состоянии арены
+	CommandsLost   uint64 // команды без подтверждения дольше AckTimeout
+	Hits           uint64
+	Messages       uint64
+	States         uint64
+	Latencies      []time.Duration // от отправки команды до состояния с ней
+	StateIntervals []time.Duration // между соседними состояниями арены
+	Pings          uint64
+	ServerRTTs     []time.Duration // RTT бота по оценке сервера из Ping
+	Error          string
+}
+
+func (stats *Stats) Merge(other *Stats) {
+	if other.Connected {
+		stats.Connected = true
+	}
+	stats.BytesSent += other.BytesSent
+	stats.BytesReceived += other.BytesReceived
+	stats.CommandsSent += other.CommandsSent
+	stats.CommandsAcked += other.CommandsAcked
+	stats.CommandsLost += other.CommandsLost
+	stats.Hits += other.Hits
+	stats.Messages += other.Messages
+	stats.States += other.States
+	stats.Latencies = append(stats.Latencies, other.Latencies...)
+	stats.StateIntervals = append(stats.StateIntervals, other.StateIntervals...)
+	stats.Pings += other.Pings
+	stats.ServerRTTs = append(stats.ServerRTTs, other.ServerRTTs...)
+}
+
+// Перцентиль p от 0 до 100, сортирует переданный срез
+func Percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	index := int(float64(len(durations)-1) * p / 100.0)
+	return durations[index]
+}
@@ -0,0 +1,110 @@
+package main
+
+// Отрисовка сгенерированной арены или дампа арены в ASCII или PNG.
+// Сравнение генератора до и после изменения на одном seed:
+//   go run ./cmd/arenarender -seed 5 -dump before.json
+//   (изменение Platform.go)
+//   go run ./cmd/arenarender -seed 5 -compare before.json -png compare.png
+
+import (
+	"GoTests/GameServer_7/gameserver"
+	"GoTests/GameServer_7/harness"
+	"GoTests/GameServer_7/render"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func loadArena(staticInfo *gameserver.StaticInfo, inPath, dungeonName string, seed int64) (*render.Arena, error) {
+	if inPath != "" {
+		return render.NewArenaFromFile(inPath)
+	}
+	dungeon, exists := staticInfo.Dungeons[dungeonName]
+	if exists == false {
+		return nil, fmt.Errorf("No dungeon %s", dungeonName)
+	}
+	model, err := gameserver.NewArenaModelForLevel(staticInfo, dungeon.Level, seed)
+	if err != nil {
+		return nil, err
+	}
+	err = harness.CheckArenaObjects(&model)
+	if err != nil {
+		log.Printf("Arena objects check: %s\n", err)
+	}
+	return render.NewArenaFromModel(&model), nil
+}
+
+func main() {
+	dataDir := flag.String("data", "", "static data directory, empty - search from current directory")
+	dungeonName := flag.String("dungeon", gameserver.NewDefaultConfig().Arena.Dungeon, "dungeon for generated arena")
+	seed := flag.Int64("seed", 1, "arena seed")
+	inPath := flag.String("in", "", "arena JSON to render instead of generating, like data/arenaDump6x6.json")
+	comparePath := flag.String("compare", "", "arena JSON to render on the left for comparison")
+	dumpPath := flag.String("dump", "", "save rendered arena JSON to file")
+	pngPath := flag.String("png", "", "write PNG to file instead of ASCII to stdout")
+	scale := flag.Int("scale", render.IMAGE_DEFAULT_SCALE, "PNG pixels per cell")
+	verbose := flag.Bool("v", false, "print generator log")
+	flag.Parse()
+
+	if *verbose == false {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	if *dataDir == "" {
+		var err error
+		*dataDir, err = harness.FindDataDir()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	}
+	staticInfo, err := gameserver.NewStaticInfo(*dataDir)
+	if err != nil {
+		fmt.Printf("Static data error: %s\n", err)
+		os.Exit(2)
+	}
+
+	arena, err := loadArena(staticInfo, *inPath, *dungeonName, *seed)
+	if err != nil {
+		fmt.Printf("Arena error: %s\n", err)
+		os.Exit(1)
+	}
+	if *dumpPath != "" {
+		data, err := json.Marshal(arena)
+		if err == nil {
+			err = ioutil.WriteFile(*dumpPath, data, 0644)
+		}
+		if err != nil {
+			fmt.Printf("Dump error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	renderer := render.NewRenderer(staticInfo)
+	grids := []*render.Grid{renderer.Layout(arena)}
+	if *comparePath != "" {
+		before, err := render.NewArenaFromFile(*comparePath)
+		if err != nil {
+			fmt.Printf("Compare arena error: %s\n", err)
+			os.Exit(1)
+		}
+		grids = append([]*render.Grid{renderer.Layout(before)}, grids...)
+	}
+
+	if *pngPath != "" {
+		err = render.WritePNGFile(*pngPath, render.SideBySideImage(*scale, grids...))
+		if err != nil {
+			fmt.Printf("PNG error: %s\n", err)
+			os.Exit(1)
+		}
+	} else {
+		fmt.Print(render.SideBySideASCII(grids...))
+		fmt.Println(render.ASCII_LEGEND)
+	}
+	if len(grids) == 2 {
+		fmt.Printf("Changed cells: %d\n", grids[1].Diff(grids[0]))
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+// Нагрузочный тест: N ботов подключаются с разгоном и ходят/бьют монстров,
+// в конце печатаются задержки, потерянные сообщения и отставание тиков сервера.
+//   go run ./cmd/loadtest -bots 200 -ramp 20s -duration 60s -metrics http://127.0.0.1:9996/metrics
+// KCP через прокси с потерей 5% пакетов и перестановкой 10% (сервер запущен с -kcp-listen 127.0.0.1:9995):
+//   go run ./cmd/loadtest -transport kcp -addr 127.0.0.1:9995 -loss 0.05 -reorder 0.1
+// TLS с клиентским сертификатом:
+//   go run ./cmd/loadtest -tls -tls-ca ca.pem -tls-cert bot.pem -tls-key bot-key.pem
+
+import (
+	"GoTests/GameServer_7/bot"
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Значения метрик сервера, которые интересны тесту
+type serverMetrics struct {
+	queueFullDrops float64
+	tickSum        float64
+	tickCount      float64
+	clientsLive    float64
+}
+
+func scrapeMetrics(url string) (serverMetrics, error) {
+	result := serverMetrics{}
+	response, err := http.Get(url)
+	if err != nil {
+		return result, err
+	}
+	defer response.Body.Close()
+
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if (len(fields) != 2) || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "gameserver_queue_full_drops_total":
+			result.queueFullDrops = value
+		case "gameserver_tick_duration_seconds_sum":
+			result.tickSum = value
+		case "gameserver_tick_duration_seconds_count":
+			result.tickCount = value
+		case "gameserver_clients_live":
+			result.clientsLive = value
+		}
+	}
+	return result, scanner.Err()
+}
+
+func main() {
+	address := flag.String("addr", "127.0.0.1:9999", "game server address")
+	botsCount := flag.Int("bots", 10, "number of bots")
+	rampUp := flag.Duration("ramp", 10*time.Second, "time to connect all bots")
+	duration := flag.Duration("duration", 30*time.Second, "test duration after ramp-up")
+	tickPeriod := flag.Duration("tick", 50*time.Millisecond, "server tick period for lag calculation")
+	metricsUrl := flag.String("metrics", "", "server Prometheus metrics URL, empty - skip server metrics")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "random seed for bots")
+	commandPeriod := flag.Duration("command-period", bot.BOT_COMMAND_PERIOD, "bot command period")
+	transport := flag.String("transport", bot.BOT_TRANSPORT_TCP, "bot transport: tcp or kcp")
+	useTLS := flag.Bool("tls", false, "connect over TLS, tcp transport only")
+	tlsCA := flag.String("tls-ca", "", "server CA file, empty - system roots")
+	tlsCert := flag.String("tls-cert", "", "client certificate file for servers that require one")
+	tlsKey := flag.String("tls-key", "", "client certificate key file")
+	loss := flag.Float64("loss", 0, "simulated packet loss for kcp through a local UDP proxy, 0 - connect directly")
+	reorder := flag.Float64("reorder", 0, "share of packets the kcp proxy delays so that later packets overtake them")
+	flag.Parse()
+
+	if *botsCount < 1 {
+		fmt.Println("bots must be positive")
+		os.Exit(2)
+	}
+	if (*transport != bot.BOT_TRANSPORT_TCP) && (*transport != bot.BOT_TRANSPORT_KCP) {
+		fmt.Println("transport must be tcp or kcp")
+		os.Exit(2)
+	}
+	if (*loss < 0) || (*loss >= 1) {
+		fmt.Println("loss must be in [0, 1)")
+		os.Exit(2)
+	}
+	if (*reorder < 0) || (*reorder >= 1) {
+		fmt.Println("reorder must be in [0, 1)")
+		os.Exit(2)
+	}
+	if ((*loss > 0) || (*reorder > 0)) && (*transport != bot.BOT_TRANSPORT_KCP) {
+		fmt.Println("loss and reorder are simulated only for kcp")
+		os.Exit(2)
+	}
+
+	if *useTLS && (*transport != bot.BOT_TRANSPORT_TCP) {
+		fmt.Println("tls is supported only for tcp")
+		os.Exit(2)
+	}
+
+	// Боты подключаются к прокси, который теряет и переставляет пакеты по пути к серверу и обратно
+	botsAddress := *address
+	var proxy *bot.LossyProxy
+	if (*loss > 0) || (*reorder > 0) {
+		var err error
+		proxy, err = bot.NewLossyProxy("127.0.0.1:0", *address, *loss, *reorder, *seed)
+		if err != nil {
+			fmt.Printf("Proxy start error: %s\n", err)
+			os.Exit(2)
+		}
+		defer proxy.Close()
+		botsAddress = proxy.Addr().String()
+	}
+
+	var metricsBefore serverMetrics
+	if *metricsUrl != "" {
+		var err error
+		metricsBefore, err = scrapeMetrics(*metricsUrl)
+		if err != nil {
+			log.Printf("Metrics scrape error: %s\n", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *rampUp+*duration)
+	defer cancel()
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt)
+	go func() {
+		select {
+		case <-signalCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// Разгон: боты подключаются равномерно
+	config := bot.NewDefaultConfig(botsAddress)
+	config.CommandPeriod = *commandPeriod
+	config.Transport = *transport
+	if *useTLS {
+		tlsConfig, err := bot.NewTLSConfig(*tlsCA, *tlsCert, *tlsKey)
+		if err != nil {
+			fmt.Printf("TLS config error: %s\n", err)
+			os.Exit(2)
+		}
+		config.TLS = tlsConfig
+	}
+	bots := make([]*bot.Bot, *botsCount)
+	waitGroup := sync.WaitGroup{}
+	interval := *rampUp / time.Duration(*botsCount)
+	startTime := time.Now()
+	for i := range bots {
+		bots[i] = bot.NewBot(config, *seed+int64(i))
+		waitGroup.Add(1)
+		go func(b *bot.Bot) {
+			defer waitGroup.Done()
+			b.Run(ctx)
+		}(bots[i])
+
+		if i+1 < len(bots) {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+			}
+		}
+	}
+	log.Printf("All %d bots started in %s\n", len(bots), time.Since(startTime))
+
+	var metricsPeak serverMetrics
+	if *metricsUrl != "" {
+		// Снимаем метрики перед окончанием, пока боты подключены
+		select {
+		case <-time.After(*duration / 2):
+			metricsPeak, _ = scrapeMetrics(*metricsUrl)
+		case <-ctx.Done():
+		}
+	}
+	waitGroup.Wait()
+
+	// Итоги
+	total := bot.Stats{}
+	connected := 0
+	failed := 0
+	errors := make(map[string]int)
+	for _, b := range bots {
+		stats := b.GetStats()
+		if stats.Connected {
+			connected++
+		}
+		if stats.Error != "" {
+			failed++
+			errors[stats.Error]++
+		}
+		total.Merge(&stats)
+	}
+
+	elapsed := time.Since(startTime).Seconds()
+	fmt.Printf("Bots: %d, connected: %d, failed: %d\n", len(bots), connected, failed)
+	for text, count := range errors {
+		fmt.Printf("    %d x %s\n", count, text)
+	}
+	fmt.Printf("Commands: sent %d, acked %d, lost %d, hits %d\n", total.CommandsSent, total.CommandsAcked, total.CommandsLost, total.Hits)
+	fmt.Printf("Messages received: %d (%d states), %.1f KB/s in, %.1f KB/s out\n",
+		total.Messages, total.States, float64(total.BytesReceived)/1024/elapsed, float64(total.BytesSent)/1024/elapsed)
+	fmt.Printf("Command latency: p50 %s, p90 %s, p99 %s, max %s\n",
+		bot.Percentile(total.Latencies, 50), bot.Percentile(total.Latencies, 90),
+		bot.Percentile(total.Latencies, 99), bot.Percentile(total.Latencies, 100))
+	fmt.Printf("Server RTT: pings %d, p50 %s, p99 %s, max %s\n", total.Pings,
+		bot.Percentile(total.ServerRTTs, 50), bot.Percentile(total.ServerRTTs, 99), bot.Percentile(total.ServerRTTs, 100))
+
+	if proxy != nil {
+		forwarded, dropped, reordered := proxy.GetStats()
+		fmt.Printf("Proxy packets: forwarded %d, dropped %d, reordered %d\n", forwarded, dropped, reordered)
+	}
+
+	intervalP99 := bot.Percentile(total.StateIntervals, 99)
+	tickLag := intervalP99 - *tickPeriod
+	if tickLag < 0 {
+		tickLag = 0
+	}
+	fmt.Printf("State interval: p50 %s, p99 %s, max %s, tick lag p99 %s\n",
+		bot.Percentile(total.StateIntervals, 50), intervalP99, bot.Percentile(total.StateIntervals, 100), tickLag)
+
+	if *metricsUrl != "" {
+		metricsAfter, err := scrapeMetrics(*metricsUrl)
+		if err != nil {
+			log.Printf("Metrics scrape error: %s\n", err)
+			return
+		}
+		ticks := metricsAfter.tickCount - metricsBefore.tickCount
+		tickAverage := time.Duration(0)
+		if ticks > 0 {
+			tickAverage = time.Duration((metricsAfter.tickSum - metricsBefore.tickSum) / ticks * float64(time.Second))
+		}
+		fmt.Printf("Server: queue full drops %.0f, ticks %.0f, average tick %s, clients at peak %.0f\n",
+			metricsAfter.queueFullDrops-metricsBefore.queueFullDrops, ticks, tickAverage, metricsPeak.clientsLive)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+// Воспроизведение записи арены и сравнение состояний с записанными.
+// Запускать из папки GameServer_7 или указать папку статических данных флагом -data
+//   go run ./cmd/replay replays/arena_1_20180101_120000.replay
+
+import (
+	"GoTests/GameServer_7/gameserver"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func main() {
+	config := gameserver.NewDefaultConfig()
+	flag.StringVar(&config.Server.DataDir, "data", config.Server.DataDir, "static data directory")
+	maxDiffs := flag.Int("diffs", 20, "max printed state differences")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: replay [-data dir] [-diffs N] <file.replay>")
+		os.Exit(2)
+	}
+
+	err := gameserver.MakeApp(config)
+	if err != nil {
+		log.Printf("App not created: %s\n", err)
+		os.Exit(2)
+	}
+
+	result, err := gameserver.PlayReplayFile(flag.Arg(0))
+	if err != nil {
+		log.Printf("Replay failed: %s\n", err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("Arena %d, dungeon %s, seed %d\n", result.Info.ArenaId, result.Info.Dungeon, result.Info.Seed)
+	if result.Info.Arena == nil {
+		fmt.Println("No arena config in replay, playing with default arena config")
+	}
+	fmt.Printf("Ticks: %d, states recorded: %d, replayed: %d\n", result.Ticks, result.States, result.ReplayedStates)
+
+	for _, difference := range result.ArenaInfoDiffs {
+		fmt.Printf("ArenaInfo %s\n", difference)
+	}
+	for i, diff := range result.Diffs {
+		if i >= *maxDiffs {
+			fmt.Printf("... %d more states differ\n", len(result.Diffs)-i)
+			break
+		}
+		fmt.Printf("State %d (tick %d):\n", diff.Index, diff.Tick)
+		for _, difference := range diff.Differences {
+			fmt.Printf("    %s\n", difference)
+		}
+	}
+
+	if result.IsMatched() == false {
+		fmt.Println("Replay MISMATCH")
+		os.Exit(1)
+	}
+	fmt.Println("Replay OK")
+}
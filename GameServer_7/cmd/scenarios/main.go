@@ -0,0 +1,51 @@
+package main
+
+// Сценарии harness.Scenarios на сервере в том же процессе, код выхода 1 при ошибке.
+// Те же сценарии запускает go test ./harness, команда нужна для запуска без тестов с логом сервера:
+//   go run ./cmd/scenarios -run hit -v
+
+import (
+	"GoTests/GameServer_7/harness"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	filter := flag.String("run", "", "run only scenarios with names containing this text")
+	verbose := flag.Bool("v", false, "print server log")
+	flag.Parse()
+
+	if *verbose == false {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	failed := 0
+	for _, scenario := range harness.Scenarios {
+		if strings.Contains(scenario.Name, *filter) == false {
+			continue
+		}
+		config, err := harness.NewConfig()
+		if err != nil {
+			fmt.Printf("Config error: %s\n", err)
+			os.Exit(2)
+		}
+
+		startTime := time.Now()
+		err = harness.RunScenario(config, scenario)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s (%s): %s\n", scenario.Name, time.Since(startTime), err)
+		} else {
+			fmt.Printf("ok   %s (%s)\n", scenario.Name, time.Since(startTime))
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
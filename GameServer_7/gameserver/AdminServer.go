@@ -0,0 +1,356 @@
+package gameserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Клиент арены в ответах админки
+type AdminClientInfo struct {
+	State         ServerClientState `json:"state"`
+	RemoteAddress string            `json:"remoteAddress"`
+	Detached      bool              `json:"detached"` // игрок восстановленной арены еще не вернулся
+	HitViolations uint32            `json:"hitViolations"`
+	IsFlagged     bool              `json:"flagged"`
+	QueueSize     int               `json:"queueSize"`
+	Dropped       uint32            `json:"dropped"`   // сообщения, отброшенные при полной очереди
+	Coalesced     uint32            `json:"coalesced"` // состояния, замененные более новыми до отправки
+	RTT           float64           `json:"rtt"`       // сглаженное RTT в секундах, 0 - замеров еще нет
+	Jitter        float64           `json:"jitter"`
+}
+
+// Арена в ответах админки
+type AdminArenaInfo struct {
+	ID         uint32               `json:"id"`
+	Dungeon    string               `json:"dungeon"`
+	Status     int8                 `json:"status"`
+	Tick       uint64               `json:"tick"`
+	SimTime    float64              `json:"simTime"`
+	Spectators int                  `json:"spectators"`
+	Joining    int                  `json:"joining"` // подключились и ждут JOIN
+	Clients    []AdminClientInfo    `json:"clients"`
+	Monsters   []ServerMonsterState `json:"monsters"`
+}
+
+// Тело запроса создания монстра
+type AdminSpawnRequest struct {
+	Name string  `json:"name"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+}
+
+// Тело запроса перемещения монстра
+type AdminMoveRequest struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// HTTP сервер администрирования, все запросы требуют токен:
+//
+//	GET  /arenas                           - список арен с клиентами и монстрами
+//	GET  /arenas/{id}                      - состояние одной арены
+//	POST /arenas/{id}/close                - закрытие арены
+//	POST /arenas/{id}/monsters             - создание монстра {"name", "x", "y"}
+//	POST /arenas/{id}/monsters/{monsterId} - перемещение живого монстра {"x", "y"}
+//	POST /clients/{id}/kick                - отключение игрока
+//	GET  /leaderboard                      - таблица рекордов ?window=&dungeon=&limit=&around=
+//	POST /tls/reload                       - перечитать файлы сертификатов TLS
+//	POST /shutdown                         - остановка сервера
+type AdminServer struct {
+	server       *Server
+	token        string
+	shutdownFunc func()
+	httpServer   *http.Server
+	address      net.Addr // адрес после запуска, порт мог выбрать система
+}
+
+func NewAdminServer(server *Server, token string, shutdownFunc func()) *AdminServer {
+	admin := &AdminServer{
+		server:       server,
+		token:        token,
+		shutdownFunc: shutdownFunc,
+		httpServer:   nil,
+		address:      nil,
+	}
+	return admin
+}
+
+func (admin *AdminServer) Start(listenAddress string) error {
+	if admin.token == "" {
+		return errors.New("Admin token is empty")
+	}
+	if admin.httpServer != nil {
+		return errors.New("Admin server already active")
+	}
+
+	listener, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		log.Printf("Admin listener start error: %s\n", err)
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/arenas", admin.handleArenas)
+	mux.HandleFunc("/arenas/", admin.handleArena)
+	mux.HandleFunc("/clients/", admin.handleClient)
+	mux.HandleFunc("/leaderboard", admin.handleLeaderboard)
+	mux.HandleFunc("/tls/reload", admin.handleTLSReload)
+	mux.HandleFunc("/shutdown", admin.handleShutdown)
+
+	admin.httpServer = &http.Server{
+		Handler:      admin.checkToken(mux),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	admin.address = listener.Addr()
+	go func() {
+		err := admin.httpServer.Serve(listener)
+		if err != http.ErrServerClosed {
+			log.Printf("Admin server error: %s\n", err)
+		}
+	}()
+
+	log.Printf("Admin server listening on %s\n", listener.Addr())
+	return nil
+}
+
+func (admin *AdminServer) GetAddress() net.Addr {
+	return admin.address
+}
+
+func (admin *AdminServer) Stop(ctx context.Context) error {
+	if admin.httpServer == nil {
+		return errors.New("Admin server not active")
+	}
+	err := admin.httpServer.Shutdown(ctx)
+	admin.httpServer = nil
+	return err
+}
+
+// Токен передается в "Authorization: Bearer <token>" или в "X-Admin-Token"
+func (admin *AdminServer) checkToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Admin-Token")
+		if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+			token = strings.TrimPrefix(authorization, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(admin.token)) != 1 {
+			writeAdminError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (admin *AdminServer) handleArenas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	arenas := admin.server.GetArenas()
+	sort.Slice(arenas, func(i, j int) bool {
+		return arenas[i].arenaId < arenas[j].arenaId
+	})
+	infos := make([]AdminArenaInfo, 0, len(arenas))
+	for _, arena := range arenas {
+		// Арена могла закрыться, пока собирали список
+		if info, ok := arena.GetAdminInfo(); ok {
+			infos = append(infos, info)
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, infos)
+}
+
+// /arenas/{id}, /arenas/{id}/close, /arenas/{id}/monsters, /arenas/{id}/monsters/{monsterId}
+func (admin *AdminServer) handleArena(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/arenas/"), "/"), "/")
+	arenaId, err := strconv.ParseUint(parts[0], 10, 32)
+	if (err != nil) || (len(parts) > 3) || ((len(parts) == 3) && (parts[1] != "monsters")) {
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	arena := admin.server.FindArena(uint32(arenaId))
+	if arena == nil {
+		writeAdminError(w, http.StatusNotFound, "arena not found")
+		return
+	}
+
+	if len(parts) == 3 {
+		admin.handleMonster(w, r, arena, parts[2])
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch {
+	case (action == "") && (r.Method == http.MethodGet):
+		info, ok := arena.GetAdminInfo()
+		if ok == false {
+			writeAdminError(w, http.StatusNotFound, "arena closed")
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, info)
+
+	case (action == "close") && (r.Method == http.MethodPost):
+		log.Printf("Admin close arena %d\n", arena.arenaId)
+		arena.Exit()
+		writeAdminJSON(w, http.StatusOK, struct{}{})
+
+	case (action == "monsters") && (r.Method == http.MethodPost):
+		request := AdminSpawnRequest{}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		monster, err := arena.SpawnMonster(request.Name, request.X, request.Y)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, monster)
+
+	case (action == "") || (action == "close") || (action == "monsters"):
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+	default:
+		writeAdminError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// /arenas/{id}/monsters/{monsterId}
+func (admin *AdminServer) handleMonster(w http.ResponseWriter, r *http.Request, arena *ServerArena, idText string) {
+	monsterId, err := strconv.ParseUint(idText, 10, 32)
+	if err != nil {
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	request := AdminMoveRequest{}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	monster, err := arena.MoveMonster(uint32(monsterId), request.X, request.Y)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, monster)
+}
+
+// /clients/{id}/kick
+func (admin *AdminServer) handleClient(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/clients/"), "/"), "/")
+	if (len(parts) != 2) || (parts[1] != "kick") {
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	clientId, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	for _, arena := range admin.server.GetArenas() {
+		if arena.KickClient(uint32(clientId)) {
+			writeAdminJSON(w, http.StatusOK, struct{}{})
+			return
+		}
+	}
+	writeAdminError(w, http.StatusNotFound, "client not found")
+}
+
+func (admin *AdminServer) handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	values := r.URL.Query()
+	query := LeaderboardQuery{
+		Window:  values.Get("window"),
+		Dungeon: values.Get("dungeon"),
+		Around:  values.Get("around"),
+	}
+	if limit := values.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		query.Limit = value
+	}
+
+	message, err := admin.server.GetLeaderboard().Query(query, time.Now())
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, message)
+}
+
+func (admin *AdminServer) handleTLSReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	err := admin.server.ReloadTLS()
+	if err != nil {
+		writeAdminError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, struct{}{})
+}
+
+func (admin *AdminServer) handleShutdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	log.Printf("Admin shutdown request\n")
+	writeAdminJSON(w, http.StatusAccepted, struct{}{})
+	if admin.shutdownFunc != nil {
+		admin.shutdownFunc()
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Admin response marshaling error: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, adminError{Error: message})
+}
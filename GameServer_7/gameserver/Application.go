@@ -1,34 +1,62 @@
 package gameserver
 
 import (
+	"context"
 	"github.com/pkg/errors"
 	"log"
+	"net"
+	"net/http"
+	"sync"
 )
 
 var application *Application = nil
 
 type Application struct {
-	staticInfo *StaticInfo
-	server     *Server
+	config       *Config
+	staticInfo   *StaticInfo
+	server       *Server
+	adminServer  *AdminServer
+	metricsHttp  *http.Server
+	metricsAddr  net.Addr
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////
 
-func MakeApp() error {
+func MakeApp(config *Config) error {
 	if application == nil {
 		// Static info
-		staticInfo, err := NewStaticInfo()
+		staticInfo, err := NewStaticInfo(config.Server.DataDir)
 		if err != nil {
 			log.Printf("Failed create static info: %s\n", err)
 			return err
 		}
+		if _, exists := staticInfo.Dungeons[config.Arena.Dungeon]; exists == false {
+			return errors.Errorf("No dungeon %s in static info", config.Arena.Dungeon)
+		}
+
+		// Leaderboard
+		leaderboard := NewLeaderboard()
+		if config.Server.LeaderboardFile != "" {
+			leaderboard, err = NewLeaderboardFromFile(config.Server.LeaderboardFile)
+			if err != nil {
+				log.Printf("Failed load leaderboard: %s\n", err)
+				return err
+			}
+		}
 
 		// Server
-		server := NewServer()
+		server := NewServer(config)
+		server.leaderboard = leaderboard
 
 		application = &Application{
-			staticInfo: staticInfo,
-			server:     server,
+			config:      config,
+			staticInfo:  staticInfo,
+			server:      server,
+			adminServer: nil,
+			metricsHttp: nil,
+			shutdownCh:  make(chan struct{}),
 		}
 		return nil
 	}
@@ -39,14 +67,147 @@ func GetApp() *Application {
 	return application
 }
 
+// Сброс остановленного приложения, чтобы в том же процессе создать новое через MakeApp (тесты)
+func ReleaseApp() error {
+	if application == nil {
+		return nil
+	}
+	if application.server.isActive {
+		return errors.New("Application server still active")
+	}
+	application = nil
+	return nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////
 
+// Запуск игрового сервера, а также админки и метрик, если для них задан адрес
 func (app *Application) RunServer() error {
-	return app.server.StartListen()
+	err := app.server.StartListen()
+	if err != nil {
+		return err
+	}
+
+	if app.config.Server.AdminListenAddress != "" {
+		err = app.runAdmin(app.config.Server.AdminListenAddress, app.config.Server.AdminToken)
+		if err != nil {
+			app.ExitServer()
+			return err
+		}
+	}
+
+	if app.config.Server.MetricsListenAddress != "" {
+		err = app.runMetrics(app.config.Server.MetricsListenAddress)
+		if err != nil {
+			app.ExitServer()
+			return err
+		}
+	}
+	return nil
 }
 
+// Запуск админки, без токена не запускается
+func (app *Application) runAdmin(listenAddress string, token string) error {
+	if app.adminServer != nil {
+		return errors.New("Admin server already active")
+	}
+	adminServer := NewAdminServer(app.server, token, app.RequestShutdown)
+	err := adminServer.Start(listenAddress)
+	if err != nil {
+		return err
+	}
+	app.adminServer = adminServer
+	return nil
+}
+
+// Запуск HTTP сервера метрик в формате Prometheus, путь /metrics
+func (app *Application) runMetrics(listenAddress string) error {
+	if app.metricsHttp != nil {
+		return errors.New("Metrics server already active")
+	}
+	listener, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", app.server.GetMetrics())
+	app.metricsHttp = &http.Server{Handler: mux}
+	app.metricsAddr = listener.Addr()
+	go func() {
+		err := app.metricsHttp.Serve(listener)
+		if err != http.ErrServerClosed {
+			log.Printf("Metrics server error: %s\n", err)
+		}
+	}()
+	log.Printf("Metrics server listening on %s\n", listener.Addr())
+	return nil
+}
+
+// Запрос остановки сервера, main ждет его вместе с командой exit
+func (app *Application) RequestShutdown() {
+	app.shutdownOnce.Do(func() {
+		close(app.shutdownCh)
+	})
+}
+
+func (app *Application) ShutdownRequested() <-chan struct{} {
+	return app.shutdownCh
+}
+
+// Остановка с таймаутом из настроек
 func (app *Application) ExitServer() error {
-	return app.server.ExitServer()
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.Server.ShutdownTimeout.Duration())
+	defer cancel()
+	return app.Shutdown(ctx)
+}
+
+// Остановка игрового сервера, затем админки и метрик, все в пределах ctx
+func (app *Application) Shutdown(ctx context.Context) error {
+	err := app.server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Server stop error: %s\n", err)
+	}
+	// Арены записывают результаты до своей остановки
+	if leaderboardErr := app.server.leaderboard.Close(); leaderboardErr != nil {
+		log.Printf("Leaderboard close error: %s\n", leaderboardErr)
+	}
+	if app.adminServer != nil {
+		err := app.adminServer.Stop(ctx)
+		if err != nil {
+			log.Printf("Admin server stop error: %s\n", err)
+		}
+		app.adminServer = nil
+	}
+	if app.metricsHttp != nil {
+		err := app.metricsHttp.Shutdown(ctx)
+		if err != nil {
+			log.Printf("Metrics server stop error: %s\n", err)
+		}
+		app.metricsHttp = nil
+		app.metricsAddr = nil
+	}
+	return err
+}
+
+func (app *Application) GetConfig() *Config {
+	return app.config
+}
+
+func (app *Application) GetServer() *Server {
+	return app.server
+}
+
+// Адрес админки, nil - админка выключена
+func (app *Application) GetAdminAddress() net.Addr {
+	if app.adminServer == nil {
+		return nil
+	}
+	return app.adminServer.GetAddress()
+}
+
+// Адрес метрик, nil - метрики выключены
+func (app *Application) GetMetricsAddress() net.Addr {
+	return app.metricsAddr
 }
 
 func (app *Application) GetStaticInfo() *StaticInfo {
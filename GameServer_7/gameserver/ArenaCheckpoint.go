@@ -0,0 +1,381 @@
+package gameserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Значения по умолчанию, задаются в Config
+const (
+	CHECKPOINT_PERIOD           = 5 * time.Second
+	CHECKPOINT_REATTACH_TIMEOUT = 60 * time.Second
+)
+
+const (
+	CHECKPOINT_VERSION       = 1
+	CHECKPOINT_FILE_EXT      = ".checkpoint"
+	CHECKPOINT_FILE_TEMP_EXT = ".tmp"
+)
+
+// Монстр вместе с серверными полями
+type MonsterCheckpoint struct {
+	State          ServerMonsterState `json:"state"`
+	MaxHealth      int16              `json:"maxHealth"`
+	DeathTime      float64            `json:"deathTime"`
+	Damage         map[uint32]uint32  `json:"damage"`
+	NextAttackTime float64            `json:"nextAttackTime"`
+}
+
+type RespawnCheckpoint struct {
+	Name   string  `json:"name"`
+	Health int16   `json:"health"`
+	Time   float64 `json:"time"`
+}
+
+// Игрок с токеном сессии, по которому он возвращается на восстановленную арену
+type ClientCheckpoint struct {
+	Session       string               `json:"session"`
+	State         ServerClientState    `json:"state"`
+	StateValid    bool                 `json:"stateValid"`
+	Health        float64              `json:"health"`
+	MaxHealth     float64              `json:"maxHealth"`
+	Defence       float64              `json:"defence"`
+	Regeneration  float64              `json:"regeneration"`
+	DefeatTime    float64              `json:"defeatTime"`
+	JoinTime      float64              `json:"joinTime"`
+	HitBuckets    map[string]HitBucket `json:"hitBuckets"`
+	HitViolations uint32               `json:"hitViolations"`
+	IsFlagged     bool                 `json:"flagged"`
+	Profile       string               `json:"profile"`
+	ProfileName   string               `json:"profileName"`
+}
+
+// Снимок арены для восстановления после падения сервера. Генератор симуляции сохраняется
+// количеством выборок после seed, таймеры - оставшимся временем
+type ArenaCheckpoint struct {
+	Version         int                 `json:"version"`
+	Info            ReplayInfo          `json:"info"` // seed, подземелье и ArenaInfo
+	Tick            uint64              `json:"tick"`
+	SimTime         float64             `json:"simTime"`
+	RandomDraws     uint64              `json:"randomDraws"`
+	LastMonsterId   uint32              `json:"lastMonsterId"`
+	DungeonTimeLeft ConfigDuration      `json:"dungeonTimeLeft"`
+	MonsterTimeLeft ConfigDuration      `json:"monsterTimeLeft"` // до следующего срабатывания таймера монстров
+	Monsters        []MonsterCheckpoint `json:"monsters"`
+	Respawns        []RespawnCheckpoint `json:"respawns"`
+	Clients         []ClientCheckpoint  `json:"clients"`
+	SaveTime        time.Time           `json:"saveTime"`
+}
+
+func (checkpoint *ArenaCheckpoint) ToBytes() ([]byte, error) {
+	return json.Marshal(checkpoint)
+}
+
+// Источник случайных чисел со счетчиком выборок. Состояние math/rand не сериализуется,
+// поэтому в снимок идет количество выборок, а при восстановлении генератор проматывается
+type countingSource struct {
+	source rand.Source64
+	draws  uint64
+}
+
+func newCountingSource(seed int64) *countingSource {
+	return &countingSource{
+		source: rand.NewSource(seed).(rand.Source64),
+		draws:  0,
+	}
+}
+
+func (source *countingSource) Int63() int64 {
+	source.draws++
+	return source.source.Int63()
+}
+
+func (source *countingSource) Uint64() uint64 {
+	source.draws++
+	return source.source.Uint64()
+}
+
+func (source *countingSource) Seed(seed int64) {
+	source.source.Seed(seed)
+	source.draws = 0
+}
+
+// Каждая выборка Int63 и Uint64 сдвигает генератор на один шаг
+func (source *countingSource) skip(draws uint64) {
+	for source.draws < draws {
+		source.Int63()
+	}
+}
+
+// Файл снимка арены, у каждой арены один файл, новый снимок заменяет старый
+func ArenaCheckpointFile(dirPath string, arenaId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("arena_%d%s", arenaId, CHECKPOINT_FILE_EXT))
+}
+
+// Запись через временный файл и переименование: после падения на диске старый или новый снимок целиком
+func WriteArenaCheckpoint(dirPath string, checkpoint *ArenaCheckpoint) error {
+	data, err := checkpoint.ToBytes()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return err
+	}
+	filePath := ArenaCheckpointFile(dirPath, checkpoint.Info.ArenaId)
+	f, err := ioutil.TempFile(dirPath, filepath.Base(filePath)+"*"+CHECKPOINT_FILE_TEMP_EXT)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func RemoveArenaCheckpoint(dirPath string, arenaId uint32) error {
+	err := os.Remove(ArenaCheckpointFile(dirPath, arenaId))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func ReadArenaCheckpointFile(filePath string) (*ArenaCheckpoint, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &ArenaCheckpoint{}
+	err = json.Unmarshal(data, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint.Version != CHECKPOINT_VERSION {
+		return nil, fmt.Errorf("Unsupported checkpoint version %d", checkpoint.Version)
+	}
+	return checkpoint, nil
+}
+
+// Все снимки из папки по возрастанию id арены, битые файлы пропускаются с сообщением в лог.
+// Оставшиеся от прерванной записи временные файлы удаляются
+func ReadArenaCheckpoints(dirPath string) ([]*ArenaCheckpoint, error) {
+	temps, err := filepath.Glob(filepath.Join(dirPath, "*"+CHECKPOINT_FILE_TEMP_EXT))
+	if err != nil {
+		return nil, err
+	}
+	for _, temp := range temps {
+		os.Remove(temp)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dirPath, "*"+CHECKPOINT_FILE_EXT))
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := make([]*ArenaCheckpoint, 0, len(files))
+	for _, file := range files {
+		checkpoint, err := ReadArenaCheckpointFile(file)
+		if err != nil {
+			log.Printf("Failed checkpoint read %s: %s\n", file, err)
+			continue
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Info.ArenaId < checkpoints[j].Info.ArenaId
+	})
+	return checkpoints, nil
+}
+
+// Снимок текущего состояния, вызывается из цикла арены
+func (arena *ServerArena) makeCheckpoint(now time.Time) *ArenaCheckpoint {
+	checkpoint := &ArenaCheckpoint{
+		Version:         CHECKPOINT_VERSION,
+		Info:            arena.GetReplayInfo(),
+		Tick:            arena.tick,
+		SimTime:         arena.simTime,
+		RandomDraws:     arena.randomSource.draws,
+		LastMonsterId:   arena.lastMonsterId,
+		DungeonTimeLeft: ConfigDuration(timeLeft(arena.dungeonDeadline, now)),
+		MonsterTimeLeft: ConfigDuration(timeLeft(arena.monsterDeadline, now)),
+		Monsters:        make([]MonsterCheckpoint, 0, len(arena.arenaState.Monsters)),
+		Respawns:        make([]RespawnCheckpoint, 0, len(arena.respawns)),
+		Clients:         make([]ClientCheckpoint, 0, len(arena.clients)),
+		SaveTime:        time.Now(),
+	}
+	for _, monster := range arena.arenaState.Monsters {
+		item := MonsterCheckpoint{
+			State:          monster,
+			MaxHealth:      monster.maxHealth,
+			DeathTime:      monster.deathTime,
+			Damage:         monster.damage,
+			NextAttackTime: monster.nextAttackTime,
+		}
+		checkpoint.Monsters = append(checkpoint.Monsters, item)
+	}
+	for _, respawn := range arena.respawns {
+		checkpoint.Respawns = append(checkpoint.Respawns, RespawnCheckpoint{Name: respawn.name, Health: respawn.health, Time: respawn.time})
+	}
+	for _, client := range arena.clients {
+		checkpoint.Clients = append(checkpoint.Clients, client.getCheckpoint())
+	}
+	return checkpoint
+}
+
+func timeLeft(deadline time.Time, now time.Time) time.Duration {
+	left := deadline.Sub(now)
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// Запись снимка из цикла арены, ошибка только в лог - арена продолжает работать
+func (arena *ServerArena) writeCheckpoint(now time.Time) {
+	err := WriteArenaCheckpoint(arena.config.Server.CheckpointsDir, arena.makeCheckpoint(now))
+	if err != nil {
+		log.Printf("Failed checkpoint write for arena %d: %s\n", arena.arenaId, err)
+	}
+}
+
+// Арена из снимка. Игроки восстанавливаются без соединений и ждут возвращения по токену сессии.
+// Запись для воспроизведения не продолжается: ее нельзя проиграть с середины
+func RestoreServerArena(server *Server, checkpoint *ArenaCheckpoint) (*ServerArena, error) {
+	// Новые арены и клиенты не должны получить уже занятые id
+	raiseId(&LAST_ID, checkpoint.Info.ArenaId)
+	for _, client := range checkpoint.Clients {
+		raiseId(&MAX_ID, client.State.ID)
+	}
+
+	dungeon, exists := GetApp().GetStaticInfo().Dungeons[checkpoint.Info.Dungeon]
+	if exists == false {
+		return nil, errors.New("No dungeon with name")
+	}
+
+	arena := newServerArena(server.config, server, checkpoint.Info.ArenaId, checkpoint.Info.Seed, dungeon, checkpoint.Info.ArenaInfo)
+	arena.randomSource.skip(checkpoint.RandomDraws)
+	arena.startTime = checkpoint.Info.StartTime
+	arena.tick = checkpoint.Tick
+	arena.simTime = checkpoint.SimTime
+	arena.arenaState.Tick = checkpoint.Tick
+	arena.lastMonsterId = checkpoint.LastMonsterId
+	arena.dungeonTimeLeft = checkpoint.DungeonTimeLeft.Duration()
+	arena.monsterTimeLeft = checkpoint.MonsterTimeLeft.Duration()
+
+	for _, item := range checkpoint.Monsters {
+		monster := item.State
+		monster.maxHealth = item.MaxHealth
+		monster.deathTime = item.DeathTime
+		monster.damage = item.Damage
+		if monster.damage == nil {
+			monster.damage = make(map[uint32]uint32)
+		}
+		monster.nextAttackTime = item.NextAttackTime
+		arena.arenaState.Monsters = append(arena.arenaState.Monsters, monster)
+	}
+	for _, item := range checkpoint.Respawns {
+		arena.respawns = append(arena.respawns, monsterRespawn{name: item.Name, health: item.Health, time: item.Time})
+	}
+	for _, item := range checkpoint.Clients {
+		arena.clients = append(arena.clients, newRestoredClient(item, arena))
+	}
+	arena.clientsCount = int32(len(arena.clients))
+	arena.metrics = server.metrics.RegisterArena(arena.arenaId)
+
+	log.Printf("Arena %d restored at tick %d with %d clients and %d monsters\n", arena.arenaId, arena.tick, len(arena.clients), len(arena.arenaState.Monsters))
+	return arena, nil
+}
+
+// Игрок из снимка без соединения, соединение передается в attachSession
+func newRestoredClient(checkpoint ClientCheckpoint, serverArena *ServerArena) *ServerClient {
+	hitBuckets := checkpoint.HitBuckets
+	if hitBuckets == nil {
+		hitBuckets = make(map[string]HitBucket)
+	}
+	return &ServerClient{
+		config:        serverArena.config.Client,
+		server:        serverArena.server,
+		serverArena:   serverArena,
+		connection:    nil,
+		id:            checkpoint.State.ID,
+		session:       checkpoint.Session,
+		role:          CLIENT_ROLE_PLAYER,
+		stateValid:    checkpoint.StateValid,
+		state:         checkpoint.State,
+		commands:      make([]*ClientCommand, 0),
+		attacks:       make([]ClientAttack, 0),
+		sendQueue:     NewSendQueue(serverArena.config.Client),
+		exitReadCh:    make(chan bool, 1),
+		exitWriteCh:   make(chan bool, 1),
+		writeDoneCh:   make(chan struct{}),
+		hitBuckets:    hitBuckets,
+		hitViolations: checkpoint.HitViolations,
+		isFlagged:     checkpoint.IsFlagged,
+		health:        checkpoint.Health,
+		maxHealth:     checkpoint.MaxHealth,
+		defence:       checkpoint.Defence,
+		regeneration:  checkpoint.Regeneration,
+		defeatTime:    checkpoint.DefeatTime,
+		joinTime:      checkpoint.JoinTime,
+		profile:       checkpoint.Profile,
+		profileName:   checkpoint.ProfileName,
+	}
+}
+
+// Данные игрока для снимка, вызывается из цикла арены
+func (client *ServerClient) getCheckpoint() ClientCheckpoint {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	hitBuckets := make(map[string]HitBucket, len(client.hitBuckets))
+	for key, bucket := range client.hitBuckets {
+		hitBuckets[key] = bucket
+	}
+	return ClientCheckpoint{
+		Session:       client.session,
+		State:         client.state,
+		StateValid:    client.stateValid,
+		Health:        client.health,
+		MaxHealth:     client.maxHealth,
+		Defence:       client.defence,
+		Regeneration:  client.regeneration,
+		DefeatTime:    client.defeatTime,
+		JoinTime:      client.joinTime,
+		HitBuckets:    hitBuckets,
+		HitViolations: client.hitViolations,
+		IsFlagged:     client.isFlagged,
+		Profile:       client.profile,
+		ProfileName:   client.profileName,
+	}
+}
+
+// Счетчик id не меньше value
+func raiseId(counter *uint32, value uint32) {
+	for {
+		current := atomic.LoadUint32(counter)
+		if (current >= value) || atomic.CompareAndSwapUint32(counter, current, value) {
+			return
+		}
+	}
+}
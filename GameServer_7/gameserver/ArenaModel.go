@@ -2,6 +2,7 @@ package gameserver
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"math/rand"
 )
@@ -9,11 +10,42 @@ import (
 const ARENA_SIZE = 2 // Размер арены - сколько на сколь ячеек
 
 type ArenaModel struct {
-	Type      string                            `json:"type"`
-	Platforms [ARENA_SIZE][ARENA_SIZE]*Platform `json:"platforms"`
+	Type          string                            `json:"type"`
+	Platforms     [ARENA_SIZE][ARENA_SIZE]*Platform `json:"platforms"`
+	ResourcesHash string                            `json:"resourcesHash,omitempty"` // хеш Resources
+	Resources     *ArenaResources                   `json:"resources,omitempty"`     // нет, если у клиента ресурсы с тем же хешем
+	Lights        map[string][]LightInfo            `json:"lights,omitempty"`        // свет объектов этой арены
 }
 
-func NewArenaModel(infos []*PlatformInfo) ArenaModel {
+// Ресурсы уровня вместе с их хешем
+func (arena *ArenaModel) SetResources(resources *ArenaResources) error {
+	hash, err := resources.Hash()
+	if err != nil {
+		return err
+	}
+	arena.Resources = resources
+	arena.ResourcesHash = hash
+	return nil
+}
+
+// ArenaInfo без ресурсов для клиента, у которого они уже есть, и хеш этих ресурсов
+func arenaDataWithoutResources(arenaData []byte) ([]byte, string, error) {
+	arena := ArenaModel{}
+	err := json.Unmarshal(arenaData, &arena)
+	if err != nil {
+		return nil, "", err
+	}
+	hash := arena.ResourcesHash
+	arena.Resources = nil
+	data, err := arena.ToBytes()
+	if err != nil {
+		return nil, "", err
+	}
+	return data, hash, nil
+}
+
+// Генерация арены, при одинаковом random получается одинаковая арена
+func NewArenaModel(infos []*PlatformInfo, random *rand.Rand) ArenaModel {
 	arena := ArenaModel{}
 
 	arena.Type = "ArenaInfo"
@@ -32,15 +64,56 @@ func NewArenaModel(infos []*PlatformInfo) ArenaModel {
 
 	for y := int16(0); y < ARENA_SIZE; y++ {
 		for x := int16(0); x < ARENA_SIZE; x++ {
-			platform := makePlatform(battlePlatforms, &arena, x, y)
+			platform := makePlatform(battlePlatforms, &arena, x, y, random)
 			arena.Platforms[y][x] = platform
 			log.Printf("Made platform %dx%d\n", y, x)
 		}
 	}
+	arena.assignObjectUids()
 
 	return arena
 }
 
+// Генерация арены из платформ уровня
+func NewArenaModelForLevel(staticInfo *StaticInfo, levelName string, seed int64) (ArenaModel, error) {
+	item, exists := staticInfo.Levels[levelName]
+	if exists == false {
+		return ArenaModel{}, errors.New("No level with name")
+	}
+	platformsForArena := make([]*PlatformInfo, 0)
+	for _, key := range item.Platforms {
+		value, ok := staticInfo.Platforms[key]
+		if ok {
+			platformsForArena = append(platformsForArena, value)
+		}
+	}
+	if len(platformsForArena) == 0 {
+		return ArenaModel{}, errors.New("No platforms for arena")
+	}
+	return NewArenaModel(platformsForArena, rand.New(rand.NewSource(seed))), nil
+}
+
+// Номера объектов по порядку платформ: сначала блоки, потом объекты
+func (arena *ArenaModel) assignObjectUids() {
+	uid := uint32(0)
+	for y := range arena.Platforms {
+		for x := range arena.Platforms[y] {
+			platform := arena.Platforms[y][x]
+			if platform == nil {
+				continue
+			}
+			for i := range platform.Blocks {
+				uid++
+				platform.Blocks[i].Uid = uid
+			}
+			for i := range platform.Objects {
+				uid++
+				platform.Objects[i].Uid = uid
+			}
+		}
+	}
+}
+
 func (arena *ArenaModel) ToBytes() ([]byte, error) {
 	jsonData, err := json.Marshal(arena)
 	if err != nil {
@@ -50,13 +123,13 @@ func (arena *ArenaModel) ToBytes() ([]byte, error) {
 }
 
 // TODO: ???
-func makePlatform(infos []*PlatformInfo, arena *ArenaModel, x, y int16) *Platform {
+func makePlatform(infos []*PlatformInfo, arena *ArenaModel, x, y int16, random *rand.Rand) *Platform {
 	if len(infos) == 0 {
 		return nil
 	}
 
 	// Дергаем рандомную платформу
-	randomIndex := rand.Int() % len(infos)
+	randomIndex := random.Int() % len(infos)
 	info := infos[randomIndex]
 
 	exitCoord := [4]int16{}
@@ -66,7 +139,7 @@ func makePlatform(infos []*PlatformInfo, arena *ArenaModel, x, y int16) *Platfor
 		if arena.Platforms[y-1][x] != nil {
 			exitCoord[DIR_NORTH] = arena.Platforms[y-1][x].ExitCoord[DIR_SOUTH]
 		} else {
-			exitCoord[DIR_NORTH] = int16(rand.Int()%((PLATFORM_SIDE_SIZE-6-5)/3)*3 + 3 + 1)
+			exitCoord[DIR_NORTH] = int16(random.Int()%((PLATFORM_SIDE_SIZE-6-5)/3)*3 + 3 + 1)
 		}
 	} else {
 		exitCoord[DIR_NORTH] = -1
@@ -76,7 +149,7 @@ func makePlatform(infos []*PlatformInfo, arena *ArenaModel, x, y int16) *Platfor
 		if arena.Platforms[y][x+1] != nil {
 			exitCoord[DIR_EAST] = arena.Platforms[y][x+1].ExitCoord[DIR_WEST]
 		} else {
-			exitCoord[DIR_EAST] = int16(rand.Int()%((PLATFORM_SIDE_SIZE-6-5)/3)*3 + 3 + 1)
+			exitCoord[DIR_EAST] = int16(random.Int()%((PLATFORM_SIDE_SIZE-6-5)/3)*3 + 3 + 1)
 		}
 	} else {
 		exitCoord[DIR_EAST] = -1
@@ -86,7 +159,7 @@ func makePlatform(infos []*PlatformInfo, arena *ArenaModel, x, y int16) *Platfor
 		if arena.Platforms[y+1][x] != nil {
 			exitCoord[DIR_SOUTH] = arena.Platforms[y+1][x].ExitCoord[DIR_NORTH]
 		} else {
-			exitCoord[DIR_SOUTH] = int16(rand.Int()%((PLATFORM_SIDE_SIZE-6-5)/3)*3 + 3 + 1)
+			exitCoord[DIR_SOUTH] = int16(random.Int()%((PLATFORM_SIDE_SIZE-6-5)/3)*3 + 3 + 1)
 		}
 	} else {
 		exitCoord[DIR_SOUTH] = -1
@@ -96,12 +169,12 @@ func makePlatform(infos []*PlatformInfo, arena *ArenaModel, x, y int16) *Platfor
 		if arena.Platforms[y][x-1] != nil {
 			exitCoord[DIR_WEST] = arena.Platforms[y][x-1].ExitCoord[DIR_EAST]
 		} else {
-			exitCoord[DIR_WEST] = int16(rand.Int()%((PLATFORM_SIDE_SIZE-6-5)/3)*3 + 3 + 1)
+			exitCoord[DIR_WEST] = int16(random.Int()%((PLATFORM_SIDE_SIZE-6-5)/3)*3 + 3 + 1)
 		}
 	} else {
 		exitCoord[DIR_WEST] = -1
 	}
 
-	platform := NewPlatform(info, x * PLATFORM_SIDE_SIZE, y * PLATFORM_SIDE_SIZE, exitCoord, false)
+	platform := NewPlatform(info, x*PLATFORM_SIDE_SIZE, y*PLATFORM_SIDE_SIZE, exitCoord, false, random)
 	return platform
 }
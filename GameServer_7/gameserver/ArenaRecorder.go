@@ -0,0 +1,220 @@
+package gameserver
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	REPLAY_RECORD_INFO     uint8 = 0  // заголовок: seed, подземелье, ArenaInfo
+	REPLAY_RECORD_JOIN     uint8 = 1  // клиент подключился
+	REPLAY_RECORD_LEAVE    uint8 = 2  // клиент отключился
+	REPLAY_RECORD_TICK     uint8 = 3  // тик мира с delta
+	REPLAY_RECORD_COMMAND  uint8 = 4  // команда клиента, примененная в тике
+	REPLAY_RECORD_MONSTER  uint8 = 5  // срабатывание таймера монстров
+	REPLAY_RECORD_COMPLETE uint8 = 6  // подземелье завершено
+	REPLAY_RECORD_STATE    uint8 = 7  // разосланное состояние арены или событие
+	REPLAY_RECORD_SPAWN    uint8 = 8  // монстр, созданный через админку
+	REPLAY_RECORD_SHUTDOWN uint8 = 9  // арена закрыта остановкой сервера
+	REPLAY_RECORD_MOVE     uint8 = 10 // монстр перемещен через MoveMonster
+)
+
+// Заголовок записи арены. Настройки арены влияют на симуляцию, воспроизведение идет с ними
+type ReplayInfo struct {
+	ArenaId   uint32          `json:"arenaId"`
+	Seed      int64           `json:"seed"`
+	Dungeon   string          `json:"dungeon"`
+	StartTime time.Time       `json:"startTime"`
+	ArenaInfo json.RawMessage `json:"arenaInfo"`
+	Arena     *ArenaConfig    `json:"arena,omitempty"` // nil - запись без настроек, воспроизводится с настройками по умолчанию
+}
+
+// Одна запись в файле, файл - это gzip со строками JSON
+type ReplayRecord struct {
+	Kind     uint8           `json:"k"`
+	Tick     uint64          `json:"t"`
+	ClientId uint32          `json:"c,omitempty"`
+	Delta    float64         `json:"d,omitempty"`
+	Data     json.RawMessage `json:"p,omitempty"`
+}
+
+// Запись всего, что влияет на симуляцию арены. Используется только из цикла арены,
+// все методы допускают nil, когда запись выключена
+type ArenaRecorder struct {
+	writer     io.WriteCloser
+	gzipWriter *gzip.Writer
+	encoder    *json.Encoder
+}
+
+func NewArenaRecorder(writer io.WriteCloser) *ArenaRecorder {
+	gzipWriter := gzip.NewWriter(writer)
+	return &ArenaRecorder{
+		writer:     writer,
+		gzipWriter: gzipWriter,
+		encoder:    json.NewEncoder(gzipWriter),
+	}
+}
+
+func NewArenaRecorderFile(dirPath string, arenaId uint32) (*ArenaRecorder, error) {
+	err := os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return nil, err
+	}
+	fileName := fmt.Sprintf("arena_%d_%s.replay", arenaId, time.Now().Format("20060102_150405"))
+	f, err := os.Create(filepath.Join(dirPath, fileName))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Recording arena %d to %s\n", arenaId, f.Name())
+	return NewArenaRecorder(f), nil
+}
+
+func (recorder *ArenaRecorder) Close() error {
+	if recorder == nil {
+		return nil
+	}
+	err := recorder.gzipWriter.Close()
+	if closeErr := recorder.writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (recorder *ArenaRecorder) RecordInfo(info ReplayInfo) {
+	if recorder == nil {
+		return
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		log.Printf("Failed replay info marshaling: %s\n", err)
+		return
+	}
+	recorder.write(ReplayRecord{Kind: REPLAY_RECORD_INFO, Data: data})
+}
+
+func (recorder *ArenaRecorder) RecordJoin(tick uint64, clientId uint32) {
+	if recorder == nil {
+		return
+	}
+	recorder.write(ReplayRecord{Kind: REPLAY_RECORD_JOIN, Tick: tick, ClientId: clientId})
+}
+
+func (recorder *ArenaRecorder) RecordLeave(tick uint64, clientId uint32) {
+	if recorder == nil {
+		return
+	}
+	recorder.write(ReplayRecord{Kind: REPLAY_RECORD_LEAVE, Tick: tick, ClientId: clientId})
+}
+
+func (recorder *ArenaRecorder) RecordTick(tick uint64, delta float64) {
+	if recorder == nil {
+		return
+	}
+	// Сбрасываем предыдущий тик на диск, чтобы запись пережила падение сервера
+	err := recorder.gzipWriter.Flush()
+	if err != nil {
+		log.Printf("Replay flush error: %s\n", err)
+	}
+	recorder.write(ReplayRecord{Kind: REPLAY_RECORD_TICK, Tick: tick, Delta: delta})
+}
+
+func (recorder *ArenaRecorder) RecordCommand(tick uint64, clientId uint32, command *ClientCommand) {
+	if recorder == nil {
+		return
+	}
+	data, err := json.Marshal(command)
+	if err != nil {
+		log.Printf("Failed replay command marshaling: %s\n", err)
+		return
+	}
+	recorder.write(ReplayRecord{Kind: REPLAY_RECORD_COMMAND, Tick: tick, ClientId: clientId, Data: data})
+}
+
+func (recorder *ArenaRecorder) RecordMonsterTimer(tick uint64) {
+	if recorder == nil {
+		return
+	}
+	recorder.write(ReplayRecord{Kind: REPLAY_RECORD_MONSTER, Tick: tick})
+}
+
+func (recorder *ArenaRecorder) RecordComplete(tick uint64) {
+	if recorder == nil {
+		return
+	}
+	recorder.write(ReplayRecord{Kind: REPLAY_RECORD_COMPLETE, Tick: tick})
+}
+
+func (recorder *ArenaRecorder) RecordShutdown(tick uint64) {
+	if recorder == nil {
+		return
+	}
+	recorder.write(ReplayRecord{Kind: REPLAY_RECORD_SHUTDOWN, Tick: tick})
+}
+
+func (recorder *ArenaRecorder) RecordSpawn(tick uint64, monster ServerMonsterState) {
+	if recorder == nil {
+		return
+	}
+	data, err := json.Marshal(monster)
+	if err != nil {
+		log.Printf("Failed replay monster marshaling: %s\n", err)
+		return
+	}
+	recorder.write(ReplayRecord{Kind: REPLAY_RECORD_SPAWN, Tick: tick, Data: data})
+}
+
+func (recorder *ArenaRecorder) RecordMove(tick uint64, monster ServerMonsterState) {
+	if recorder == nil {
+		return
+	}
+	data, err := json.Marshal(monster)
+	if err != nil {
+		log.Printf("Failed replay monster marshaling: %s\n", err)
+		return
+	}
+	recorder.write(ReplayRecord{Kind: REPLAY_RECORD_MOVE, Tick: tick, Data: data})
+}
+
+func (recorder *ArenaRecorder) RecordState(tick uint64, stateData []byte) {
+	if recorder == nil {
+		return
+	}
+	recorder.write(ReplayRecord{Kind: REPLAY_RECORD_STATE, Tick: tick, Data: stateData})
+}
+
+func (recorder *ArenaRecorder) write(record ReplayRecord) {
+	err := recorder.encoder.Encode(record)
+	if err != nil {
+		log.Printf("Replay record write error: %s\n", err)
+	}
+}
+
+// Чтение всех записей из файла, у незакрытого файла читается все до обрыва
+func ReadReplayRecords(reader io.Reader) ([]ReplayRecord, error) {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+
+	records := make([]ReplayRecord, 0)
+	decoder := json.NewDecoder(gzipReader)
+	for {
+		record := ReplayRecord{}
+		err := decoder.Decode(&record)
+		if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,56 @@
+package gameserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Графика уровня и эффекты частиц, которые раньше были только в клиенте. Одинаковы для всех арен уровня,
+// поэтому клиент может их кешировать, свет объектов зависит от арены и приходит в ArenaModel.Lights
+type ArenaResources struct {
+	LevelName string                         `json:"levelName"`
+	Level     *LevelInfo                     `json:"level"`
+	Particles map[string]*ParticleEffectInfo `json:"particles"`
+}
+
+func NewArenaResources(staticInfo *StaticInfo, levelName string) *ArenaResources {
+	resources := &ArenaResources{
+		LevelName: levelName,
+		Level:     staticInfo.Levels[levelName],
+		Particles: staticInfo.Particles,
+	}
+	return resources
+}
+
+// Свет только для объектов, которые есть на арене
+func NewArenaLights(staticInfo *StaticInfo, arena *ArenaModel) map[string][]LightInfo {
+	lights := make(map[string][]LightInfo)
+	addLights := func(objects []PlatformObject) {
+		for _, object := range objects {
+			if objectLights, exists := staticInfo.Lights[object.Id]; exists {
+				lights[object.Id] = objectLights
+			}
+		}
+	}
+	for _, row := range arena.Platforms {
+		for _, platform := range row {
+			if platform != nil {
+				addLights(platform.Objects)
+				addLights(platform.Blocks)
+			}
+		}
+	}
+	return lights
+}
+
+// Хеш содержимого: клиент присылает хеш закешированных ресурсов при входе, и при совпадении
+// сервер их не отправляет. Ключи map в JSON сортируются, поэтому хеш не зависит от порядка обхода
+func (resources *ArenaResources) Hash() (string, error) {
+	data, err := json.Marshal(resources)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
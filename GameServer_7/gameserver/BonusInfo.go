@@ -0,0 +1,88 @@
+package gameserver
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+)
+
+type BonusItemInfo struct {
+	Type     string `json:"type"`     // тип награды: resource, attribute
+	Value    string `json:"value"`    // имя ресурса или атрибута
+	MinCount int32  `json:"mincount"` // минимальное количество
+	MaxCount int32  `json:"maxcount"` // максимальное количество
+	Weight   int32  `json:"weight"`   // вес выбора, 0 - выпадает всегда
+	Order    int16  `json:"ord"`      // порядок
+}
+
+type BonusInfo struct {
+	Name  string          `json:"-"`     // имя таблицы (ключ в файле)
+	Items []BonusItemInfo `json:"items"` // возможные награды
+}
+
+// Выпавшая награда
+type LootItem struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Count int32  `json:"count"`
+}
+
+// Бросок по таблице: все награды с весом 0 и одна из награды с весом по их весам
+func (bonus *BonusInfo) Roll(random *rand.Rand) []LootItem {
+	loot := make([]LootItem, 0)
+	totalWeight := int32(0)
+	for _, item := range bonus.Items {
+		if item.Weight > 0 {
+			totalWeight += item.Weight
+		}
+	}
+
+	weightRoll := int32(-1)
+	if totalWeight > 0 {
+		weightRoll = random.Int31n(totalWeight)
+	}
+	for _, item := range bonus.Items {
+		if item.Weight > 0 {
+			if (weightRoll < 0) || (weightRoll >= item.Weight) {
+				weightRoll -= item.Weight
+				continue
+			}
+			weightRoll = -1
+		}
+
+		count := item.MinCount
+		if item.MaxCount > item.MinCount {
+			count += random.Int31n(item.MaxCount - item.MinCount + 1)
+		}
+		if count > 0 {
+			loot = append(loot, LootItem{Type: item.Type, Value: item.Value, Count: count})
+		}
+	}
+	return loot
+}
+
+func NewBonusesFromReader(reader io.Reader) (map[string]*BonusInfo, error) {
+	result := make(map[string]*BonusInfo)
+	decoder := json.NewDecoder(reader)
+	err := decoder.Decode(&result)
+	if err == nil {
+		for name, info := range result {
+			info.Name = name
+		}
+	}
+	return result, err
+}
+
+func NewBonusesFromFile(filePath string) (map[string]*BonusInfo, error) {
+	// Загрузка таблиц бонусов из файла
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Println(err)
+		return make(map[string]*BonusInfo), err
+	}
+	defer f.Close()
+
+	return NewBonusesFromReader(f)
+}
@@ -5,8 +5,13 @@ import (
 )
 
 const (
-	CLIENT_COMMAND_TYPE_MOVE uint8 = 0
-	CLIENT_COMMAND_TYPE_HIT  uint8 = 1
+	CLIENT_COMMAND_TYPE_MOVE        uint8 = 0
+	CLIENT_COMMAND_TYPE_HIT         uint8 = 1
+	CLIENT_COMMAND_TYPE_PROFILE     uint8 = 2 // профиль игрока для таблицы рекордов, в симуляцию не попадает
+	CLIENT_COMMAND_TYPE_LEADERBOARD uint8 = 3 // запрос таблицы рекордов, в симуляцию не попадает
+	CLIENT_COMMAND_TYPE_REATTACH    uint8 = 4 // возвращение в сессию восстановленной арены, в симуляцию не попадает
+	CLIENT_COMMAND_TYPE_PONG        uint8 = 5 // ответ на Ping сервера, в симуляцию не попадает
+	CLIENT_COMMAND_TYPE_JOIN        uint8 = 6 // первая команда игрока с хешем закешированных ресурсов, в симуляцию не попадает
 )
 
 type ClientCommandHitInfo struct {
@@ -16,6 +21,8 @@ type ClientCommandHitInfo struct {
 
 type ClientCommand struct {
 	ID             uint32                 `json:"id"`
+	Seq            uint32                 `json:"seq,omitempty"`  // возрастающий номер ввода, 0 - клиент без номеров
+	Tick           uint64                 `json:"tick,omitempty"` // тик последнего полученного состояния арены, для ударов с учетом задержки
 	CommandType    uint8                  `json:"type"`
 	RotationX      float64                `json:"rx"`
 	RotationY      float64                `json:"ry"`
@@ -29,6 +36,12 @@ type ClientCommand struct {
 	AnimName       string                 `json:"animName"`
 	StartSkillName string                 `json:"startSkillName"`
 	HitMonsters    []ClientCommandHitInfo `json:"hitMonsters"`
+	Profile        string                 `json:"profile,omitempty"`       // CLIENT_COMMAND_TYPE_PROFILE
+	Name           string                 `json:"name,omitempty"`          // CLIENT_COMMAND_TYPE_PROFILE
+	Leaderboard    *LeaderboardQuery      `json:"leaderboard,omitempty"`   // CLIENT_COMMAND_TYPE_LEADERBOARD
+	Session        string                 `json:"session,omitempty"`       // CLIENT_COMMAND_TYPE_REATTACH
+	PingID         uint32                 `json:"pingId,omitempty"`        // CLIENT_COMMAND_TYPE_PONG
+	ResourcesHash  string                 `json:"resourcesHash,omitempty"` // CLIENT_COMMAND_TYPE_JOIN и CLIENT_COMMAND_TYPE_REATTACH
 }
 
 func NewClientCommand(data []byte) (*ClientCommand, error) {
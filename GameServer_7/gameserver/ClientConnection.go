@@ -0,0 +1,95 @@
+package gameserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const CLIENT_MAX_MESSAGE_SIZE = 1024 * 1024 // больше от клиента не принимаем
+
+// Соединение клиента, передающее сообщения целиком. ServerClient работает только через него,
+// поэтому на одной арене могут быть клиенты с разными транспортами.
+// Чтение и запись могут идти из двух разных горутин, но каждая - только из одной
+type ClientConnection interface {
+	ReadMessage() ([]byte, error) // io.EOF - клиент закрыл соединение
+	WriteMessage(data []byte) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Поток байт (TCP, KCP): перед каждым сообщением 4 байта длины big endian
+type StreamConnection struct {
+	conn        net.Conn
+	bodyTimeout time.Duration // тело после длины должно прийти за это время, 0 - без ограничения
+}
+
+func NewStreamConnection(conn net.Conn, bodyTimeout time.Duration) *StreamConnection {
+	return &StreamConnection{
+		conn:        conn,
+		bodyTimeout: bodyTimeout,
+	}
+}
+
+func (connection *StreamConnection) ReadMessage() ([]byte, error) {
+	// Размер данных
+	dataSizeBytes := make([]byte, 4)
+	_, err := io.ReadFull(connection.conn, dataSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	dataSize := binary.BigEndian.Uint32(dataSizeBytes)
+	if dataSize > CLIENT_MAX_MESSAGE_SIZE {
+		return nil, fmt.Errorf("Message size %d is too big", dataSize)
+	}
+
+	// Ожидается, что данные придут быстро - иначе отвал
+	if connection.bodyTimeout > 0 {
+		connection.conn.SetReadDeadline(time.Now().Add(connection.bodyTimeout))
+	}
+
+	// Данные могут прийти несколькими частями
+	data := make([]byte, dataSize)
+	_, err = io.ReadFull(connection.conn, data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (connection *StreamConnection) WriteMessage(data []byte) error {
+	sendData := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(sendData, uint32(len(data)))
+	sendData = append(sendData, data...)
+
+	writenCount, err := connection.conn.Write(sendData)
+	if (err == nil) && (writenCount < len(sendData)) {
+		err = fmt.Errorf("Written %d bytes from %d", writenCount, len(sendData))
+	}
+	return err
+}
+
+func (connection *StreamConnection) SetReadDeadline(t time.Time) error {
+	return connection.conn.SetReadDeadline(t)
+}
+
+func (connection *StreamConnection) SetWriteDeadline(t time.Time) error {
+	return connection.conn.SetWriteDeadline(t)
+}
+
+func (connection *StreamConnection) RemoteAddr() net.Addr {
+	return connection.conn.RemoteAddr()
+}
+
+func (connection *StreamConnection) Close() error {
+	return connection.conn.Close()
+}
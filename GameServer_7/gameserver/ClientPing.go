@@ -0,0 +1,131 @@
+package gameserver
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	CLIENT_PING_PERIOD = 1 * time.Second        // по умолчанию, задается в Config
+	CLIENT_INITIAL_RTT = 100 * time.Millisecond // по умолчанию, задается в Config
+)
+
+// Сглаживание замеров как у RTO в TCP (RFC 6298)
+const (
+	PING_RTT_GAIN    = 1.0 / 8
+	PING_JITTER_GAIN = 1.0 / 4
+)
+
+const LAG_JITTER_FACTOR = 2 // окно учета задержки: RTT плюс столько разбросов
+
+// Синхронизация времени: сервер шлет Ping с временем и тиком, клиент сразу отвечает командой
+// CLIENT_COMMAND_TYPE_PONG с тем же id. Время сервера у клиента сейчас - ServerTime + RTT/2.
+// Времена в секундах, как Duration в командах
+type ServerPing struct {
+	Type       string  `json:"type"`
+	ID         uint32  `json:"id"`
+	ServerTime float64 `json:"serverTime"` // unix время отправки
+	Tick       uint64  `json:"tick"`
+	SimTime    float64 `json:"simTime"`    // время симуляции арены на этом тике
+	TickPeriod float64 `json:"tickPeriod"` // длина тика
+	TimeLeft   float64 `json:"timeLeft"`   // до конца таймера подземелья
+	RTT        float64 `json:"rtt"`        // оценка сервера по прошлым ответам, 0 - замеров еще нет
+	Jitter     float64 `json:"jitter"`
+}
+
+// id и оценки задержки заполняет клиент при отправке
+func NewServerPing(serverTime time.Time) ServerPing {
+	ping := ServerPing{
+		Type:       "Ping",
+		ServerTime: float64(serverTime.UnixNano()) / float64(time.Second),
+	}
+	return ping
+}
+
+func (ping *ServerPing) ToBytes() ([]byte, error) {
+	return json.Marshal(ping)
+}
+
+// Задержка клиента по ответам на Ping, используется под mutex клиента
+type clientLatency struct {
+	pingId   uint32
+	pingTime time.Time // отправка последнего Ping без ответа, нулевое - ответ получен
+	rtt      time.Duration
+	jitter   time.Duration
+	samples  uint32
+}
+
+// Ответ на Ping, возвращается замер. Ответ на старый Ping не учитывается: его время уже забыто
+func (latency *clientLatency) pong(pingId uint32, now time.Time) (time.Duration, bool) {
+	if (pingId != latency.pingId) || latency.pingTime.IsZero() {
+		return 0, false
+	}
+	sample := now.Sub(latency.pingTime)
+	latency.pingTime = time.Time{}
+	if latency.samples == 0 {
+		latency.rtt = sample
+		latency.jitter = sample / 2
+	} else {
+		deviation := sample - latency.rtt
+		if deviation < 0 {
+			deviation = -deviation
+		}
+		latency.jitter += time.Duration(PING_JITTER_GAIN * float64(deviation-latency.jitter))
+		latency.rtt += time.Duration(PING_RTT_GAIN * float64(sample-latency.rtt))
+	}
+	latency.samples++
+	return sample, true
+}
+
+// Ping всем игрокам арены с текущими временем и тиком, средние RTT и разброс игроков - в метрики арены.
+// Наблюдателям Ping не нужен: они ничего не отправляют
+func (arena *ServerArena) sendPings(now time.Time) {
+	var rttSum, jitterSum time.Duration
+	measured := 0
+	for _, client := range arena.clients {
+		if client.isDetached() {
+			continue
+		}
+		sendTime := time.Now()
+		ping := NewServerPing(sendTime)
+		ping.Tick = arena.tick
+		ping.SimTime = arena.simTime
+		ping.TickPeriod = arena.config.Arena.UpdatePeriod.Duration().Seconds()
+		ping.TimeLeft = timeLeft(arena.dungeonDeadline, now).Seconds()
+		client.queuePing(ping, sendTime)
+
+		if rtt, jitter, ok := client.GetLatency(); ok {
+			rttSum += rtt
+			jitterSum += jitter
+			measured++
+		}
+	}
+	if measured > 0 {
+		arena.metrics.SetLatency(rttSum/time.Duration(measured), jitterSum/time.Duration(measured))
+	} else {
+		arena.metrics.SetLatency(0, 0)
+	}
+}
+
+// Тик, который видел клиент, с учетом его задержки: клиент не может видеть состояние старше, чем
+// RTT плюс LAG_JITTER_FACTOR разбросов и тик на отправку. Более старый тик поднимается до границы окна,
+// так удар с подставным старым тиком не откатывает монстров на весь MaxRewind.
+// До первого ответа на Ping вместо RTT берется InitialRTT из Config без разброса: клиент,
+// который не отвечает на Ping, не получает окно шире
+func (arena *ServerArena) limitViewTick(client *ServerClient, viewTick uint64) uint64 {
+	if viewTick == 0 {
+		return viewTick
+	}
+	rtt, jitter, ok := client.GetLatency()
+	if ok == false {
+		rtt = arena.config.Client.InitialRTT.Duration()
+		jitter = 0
+	}
+	step := arena.config.Arena.UpdatePeriod.Duration()
+	window := rtt + LAG_JITTER_FACTOR*jitter + step
+	ticks := uint64((window + step - 1) / step)
+	if (arena.tick > ticks) && (viewTick < arena.tick-ticks) {
+		return arena.tick - ticks
+	}
+	return viewTick
+}
@@ -0,0 +1,60 @@
+package gameserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientLatencyPong(t *testing.T) {
+	base := time.Now()
+	latency := clientLatency{pingId: 1, pingTime: base}
+	if _, ok := latency.pong(2, base.Add(time.Second)); ok {
+		t.Fatal("Pong with unknown id accepted")
+	}
+	sample, ok := latency.pong(1, base.Add(100*time.Millisecond))
+	if (ok == false) || (sample != 100*time.Millisecond) || (latency.rtt != sample) || (latency.jitter != 50*time.Millisecond) {
+		t.Fatalf("First sample %s: %+v", sample, latency)
+	}
+	if _, ok := latency.pong(1, base.Add(time.Second)); ok {
+		t.Fatal("Repeated pong accepted")
+	}
+	latency.pingId = 2
+	latency.pingTime = base
+	latency.pong(2, base.Add(180*time.Millisecond))
+	if (latency.rtt != 110*time.Millisecond) || (latency.jitter != 57500*time.Microsecond) {
+		t.Fatalf("Smoothed sample: %+v", latency)
+	}
+}
+
+func TestLimitViewTick(t *testing.T) {
+	measured := clientLatency{rtt: 100 * time.Millisecond, jitter: 10 * time.Millisecond, samples: 1}
+	tests := []struct {
+		name     string
+		latency  clientLatency
+		tick     uint64
+		viewTick uint64
+		expected uint64
+	}{
+		// окно 100 + 2*10 + 50 мс - 4 тика
+		{"stale tick", measured, 100, 3, 96},
+		{"inside window", measured, 100, 97, 97},
+		{"current tick", measured, 100, 0, 0},
+		{"arena start", measured, 2, 1, 1},
+		// до ответа на Ping окно InitialRTT 100 + 50 мс - 3 тика
+		{"no pongs stale tick", clientLatency{}, 100, 3, 97},
+		{"no pongs inside window", clientLatency{}, 100, 98, 98},
+		{"no pongs current tick", clientLatency{}, 100, 0, 0},
+	}
+	config := NewDefaultConfig()
+	config.Arena.UpdatePeriod = ConfigDuration(50 * time.Millisecond)
+	config.Client.InitialRTT = ConfigDuration(100 * time.Millisecond)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			arena := &ServerArena{config: config, tick: test.tick}
+			client := &ServerClient{latency: test.latency}
+			if got := arena.limitViewTick(client, test.viewTick); got != test.expected {
+				t.Fatalf("limitViewTick(%d) at tick %d = %d, expected %d", test.viewTick, test.tick, got, test.expected)
+			}
+		})
+	}
+}
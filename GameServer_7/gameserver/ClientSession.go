@@ -0,0 +1,41 @@
+package gameserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+)
+
+const SESSION_TOKEN_SIZE = 16 // байт случайных данных в токене
+
+// Токен сессии игрока, приходит только ему самому. С ним игрок возвращается
+// на арену, восстановленную из снимка после падения сервера
+type ClientSession struct {
+	Type     string `json:"type"`
+	ArenaID  uint32 `json:"arenaId"`
+	ClientID uint32 `json:"clientId"`
+	Token    string `json:"token"`
+}
+
+func NewClientSession(arenaId uint32, clientId uint32, token string) ClientSession {
+	session := ClientSession{
+		Type:     "Session",
+		ArenaID:  arenaId,
+		ClientID: clientId,
+		Token:    token,
+	}
+	return session
+}
+
+func (session *ClientSession) ToBytes() ([]byte, error) {
+	return json.Marshal(session)
+}
+
+func newSessionToken() string {
+	data := make([]byte, SESSION_TOKEN_SIZE)
+	_, err := rand.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(data)
+}
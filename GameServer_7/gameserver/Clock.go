@@ -0,0 +1,145 @@
+package gameserver
+
+import (
+	"sync"
+	"time"
+)
+
+// Источник времени цикла арены: системный на сервере, ручной в тестах для пошаговой симуляции
+type Clock interface {
+	Now() time.Time
+	NewTimer(duration time.Duration) ClockTimer
+}
+
+// Таймер часов с поведением time.Timer: одно срабатывание, после Reset - снова
+type ClockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(duration time.Duration) bool
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type systemClock struct{}
+
+type systemTimer struct {
+	timer *time.Timer
+}
+
+func NewSystemClock() Clock {
+	return systemClock{}
+}
+
+func (clock systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (clock systemClock) NewTimer(duration time.Duration) ClockTimer {
+	return &systemTimer{timer: time.NewTimer(duration)}
+}
+
+func (timer *systemTimer) C() <-chan time.Time {
+	return timer.timer.C
+}
+
+func (timer *systemTimer) Stop() bool {
+	return timer.timer.Stop()
+}
+
+func (timer *systemTimer) Reset(duration time.Duration) bool {
+	return timer.timer.Reset(duration)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Часы, время которых двигает только Advance. Таймеры срабатывают внутри Advance
+type ManualClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+type manualTimer struct {
+	clock    *ManualClock
+	channel  chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	clock := &ManualClock{
+		now:    start,
+		timers: make([]*manualTimer, 0),
+	}
+	return clock
+}
+
+func (clock *ManualClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *ManualClock) NewTimer(duration time.Duration) ClockTimer {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	timer := &manualTimer{
+		clock:    clock,
+		channel:  make(chan time.Time, 1),
+		deadline: clock.now.Add(duration),
+		active:   true,
+	}
+	clock.timers = append(clock.timers, timer)
+	clock.fireLocked()
+	return timer
+}
+
+// Сдвиг времени, наступившие таймеры отправляют время в свой канал, если он пуст
+func (clock *ManualClock) Advance(duration time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = clock.now.Add(duration)
+	clock.fireLocked()
+}
+
+func (clock *ManualClock) fireLocked() {
+	activeTimers := clock.timers[:0]
+	for _, timer := range clock.timers {
+		if timer.active && (timer.deadline.After(clock.now) == false) {
+			timer.active = false
+			select {
+			case timer.channel <- clock.now:
+			default:
+			}
+		}
+		if timer.active {
+			activeTimers = append(activeTimers, timer)
+		}
+	}
+	clock.timers = activeTimers
+}
+
+func (timer *manualTimer) C() <-chan time.Time {
+	return timer.channel
+}
+
+func (timer *manualTimer) Stop() bool {
+	timer.clock.mutex.Lock()
+	defer timer.clock.mutex.Unlock()
+	wasActive := timer.active
+	timer.active = false
+	return wasActive
+}
+
+func (timer *manualTimer) Reset(duration time.Duration) bool {
+	timer.clock.mutex.Lock()
+	defer timer.clock.mutex.Unlock()
+	wasActive := timer.active
+	timer.deadline = timer.clock.now.Add(duration)
+	if wasActive == false {
+		timer.active = true
+		timer.clock.timers = append(timer.clock.timers, timer)
+	}
+	timer.clock.fireLocked()
+	return wasActive
+}
@@ -0,0 +1,536 @@
+package gameserver
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Значения по умолчанию
+const (
+	CONFIG_DATA_DIR             = "data"
+	CONFIG_CLIENT_IDLE_TIMEOUT  = 10 * time.Minute // за это время от клиента должно что-то прийти, иначе - отвал
+	CONFIG_CLIENT_READ_TIMEOUT  = 30 * time.Second // чтение тела сообщения после размера
+	CONFIG_CLIENT_WRITE_TIMEOUT = 30 * time.Second
+	CONFIG_CLIENT_JOIN_WAIT     = 0               // без ожидания JOIN ArenaInfo с ресурсами отправляется сразу
+	CONFIG_SHUTDOWN_TIMEOUT     = 5 * time.Second // сколько ждем отправки очередей клиентам при остановке
+	CONFIG_ENV_PREFIX           = "GAMESERVER_"
+)
+
+// Длительность в конфиге записывается строкой: "50ms", "20s"
+type ConfigDuration time.Duration
+
+func (duration ConfigDuration) Duration() time.Duration {
+	return time.Duration(duration)
+}
+
+func (duration ConfigDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(duration).String())
+}
+
+func (duration *ConfigDuration) UnmarshalJSON(data []byte) error {
+	var text string
+	err := json.Unmarshal(data, &text)
+	if err != nil {
+		return errors.New("duration must be a string like \"50ms\"")
+	}
+	value, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*duration = ConfigDuration(value)
+	return nil
+}
+
+type ServerConfig struct {
+	ListenAddress          string         `json:"listenAddress"`
+	SpectatorListenAddress string         `json:"spectatorListenAddress"`
+	WebSocketListenAddress string         `json:"webSocketListenAddress"` // пустой - WebSocket выключен
+	KCPListenAddress       string         `json:"kcpListenAddress"`       // пустой - KCP выключен
+	TLSCertFile            string         `json:"tlsCertFile"`            // вместе с tlsKeyFile, пустые - TLS выключен
+	TLSKeyFile             string         `json:"tlsKeyFile"`
+	TLSClientCAFile        string         `json:"tlsClientCAFile"`    // пустой - клиентские сертификаты не проверяются, иначе присланный сертификат должен быть подписан этим CA
+	TLSClientAuth          string         `json:"tlsClientAuth"`      // optional - сертификат необязателен, require - обязателен
+	TLSReloadPeriod        ConfigDuration `json:"tlsReloadPeriod"`    // проверка изменения файлов, 0 - только через админку
+	AdminListenAddress     string         `json:"adminListenAddress"` // пустой - админка выключена
+	AdminToken             string         `json:"adminToken"`
+	MetricsListenAddress   string         `json:"metricsListenAddress"` // пустой - метрики выключены
+	ReplaysDir             string         `json:"replaysDir"`           // пустой - запись арен выключена
+	CheckpointsDir         string         `json:"checkpointsDir"`       // пустой - снимки арен для восстановления выключены
+	CheckpointPeriod       ConfigDuration `json:"checkpointPeriod"`     // снимок пишется из цикла арены
+	ReattachTimeout        ConfigDuration `json:"reattachTimeout"`      // сколько восстановленная арена ждет возвращения игрока
+	LeaderboardFile        string         `json:"leaderboardFile"`      // пустой - таблица рекордов только в памяти
+	DataDir                string         `json:"dataDir"`
+	ShutdownTimeout        ConfigDuration `json:"shutdownTimeout"`
+}
+
+type ArenaConfig struct {
+	Dungeon        string         `json:"dungeon"`
+	MaxClients     int            `json:"maxClients"`
+	IdleTimeout    ConfigDuration `json:"idleTimeout"`
+	UpdatePeriod   ConfigDuration `json:"updatePeriod"` // длина тика симуляции
+	MaxCatchUp     int            `json:"maxCatchUp"`   // предел тиков за одно срабатывание таймера, когда арена отстала
+	MaxRewind      ConfigDuration `json:"maxRewind"`    // окно учета задержки клиента при проверке ударов, 0 - без учета
+	MonsterStart   ConfigDuration `json:"monsterStart"`
+	MonsterPeriod  ConfigDuration `json:"monsterPeriod"`
+	MonsterLinger  ConfigDuration `json:"monsterLinger"`  // мертвый монстр виден клиентам
+	MonsterRespawn ConfigDuration `json:"monsterRespawn"` // 0 - убитые монстры не возрождаются
+	MonstersAlive  int            `json:"monstersAlive"`  // предел живых монстров для таймера создания
+	PlayerRevive   ConfigDuration `json:"playerRevive"`   // 0 - поверженный игрок не возрождается
+	// Проверка ударов
+	HitDistanceTolerance float64 `json:"hitDistanceTolerance"` // запас к радиусу атаки
+	HitRateTolerance     float64 `json:"hitRateTolerance"`     // доля интервала между ударами, на которую удар может быть раньше
+	HitDamageTolerance   float64 `json:"hitDamageTolerance"`   // множитель к наибольшему урону
+	HitBurst             int     `json:"hitBurst"`             // ударов одного вида подряд без паузы, средняя частота не меняется
+	HitViolationsLimit   int     `json:"hitViolationsLimit"`   // после скольких нарушений срабатывает действие
+	HitViolationAction   string  `json:"hitViolationAction"`   // kick - отключить, flag - только пометить
+}
+
+type ClientConfig struct {
+	UpdateQueueSize int            `json:"updateQueueSize"`
+	IdleTimeout     ConfigDuration `json:"idleTimeout"`
+	ReadTimeout     ConfigDuration `json:"readTimeout"`
+	WriteTimeout    ConfigDuration `json:"writeTimeout"`
+	StatePolicy     string         `json:"statePolicy"`    // состояния арены и игрока: coalesce, drop или keep
+	ReliablePolicy  string         `json:"reliablePolicy"` // ArenaInfo, MonsterKilled, сообщения сервера
+	ReplyPolicy     string         `json:"replyPolicy"`    // ответы на запросы клиента
+	SlowTimeout     ConfigDuration `json:"slowTimeout"`    // клиент, который столько не успевает получать сообщения, отключается
+	PingPeriod      ConfigDuration `json:"pingPeriod"`     // Ping для замера задержки, 0 - выключен
+	InitialRTT      ConfigDuration `json:"initialRtt"`     // задержка клиента до первого ответа на Ping
+	JoinWait        ConfigDuration `json:"joinWait"`       // ожидание JOIN с хешем ресурсов, 0 - ArenaInfo с ресурсами сразу
+}
+
+// Параметры сессий KCP, на клиенте должны быть такие же
+type KCPConfig struct {
+	NoDelay       int            `json:"noDelay"`       // 1 - быстрый режим: меньше минимальный RTO, без удвоения при потерях
+	Interval      ConfigDuration `json:"interval"`      // период внутреннего обновления, с точностью до миллисекунд
+	Resend        int            `json:"resend"`        // быстрый повтор после стольких пропущенных ACK, 0 - выключен
+	NoCongestion  int            `json:"noCongestion"`  // 1 - без контроля перегрузки
+	SendWindow    int            `json:"sendWindow"`    // в пакетах
+	ReceiveWindow int            `json:"receiveWindow"` // в пакетах
+}
+
+// Настройки сервера: значения по умолчанию, затем файл, затем переменные окружения, затем флаги
+type Config struct {
+	Server ServerConfig `json:"server"`
+	Arena  ArenaConfig  `json:"arena"`
+	Client ClientConfig `json:"client"`
+	KCP    KCPConfig    `json:"kcp"`
+}
+
+func NewDefaultConfig() *Config {
+	config := &Config{
+		Server: ServerConfig{
+			ListenAddress:          SERVER_LISTEN_ADDRESS,
+			SpectatorListenAddress: SERVER_SPECTATOR_LISTEN_ADDRESS,
+			WebSocketListenAddress: "",
+			KCPListenAddress:       "",
+			TLSCertFile:            "",
+			TLSKeyFile:             "",
+			TLSClientCAFile:        "",
+			TLSClientAuth:          TLS_CLIENT_AUTH_OPTIONAL,
+			TLSReloadPeriod:        ConfigDuration(TLS_RELOAD_PERIOD),
+			AdminListenAddress:     "",
+			AdminToken:             "",
+			MetricsListenAddress:   "",
+			ReplaysDir:             "",
+			CheckpointsDir:         "",
+			CheckpointPeriod:       ConfigDuration(CHECKPOINT_PERIOD),
+			ReattachTimeout:        ConfigDuration(CHECKPOINT_REATTACH_TIMEOUT),
+			LeaderboardFile:        "",
+			DataDir:                CONFIG_DATA_DIR,
+			ShutdownTimeout:        ConfigDuration(CONFIG_SHUTDOWN_TIMEOUT),
+		},
+		Arena: ArenaConfig{
+			Dungeon:              ARENA_DEFAULT_DUNGEON,
+			MaxClients:           ARENA_MAX_CLIENTS,
+			IdleTimeout:          ConfigDuration(ARENA_IDLE_TIMEOUT),
+			UpdatePeriod:         ConfigDuration(ARENA_UPDATE_PERIOD),
+			MaxCatchUp:           ARENA_MAX_CATCH_UP,
+			MaxRewind:            ConfigDuration(ARENA_MAX_REWIND),
+			MonsterStart:         ConfigDuration(ARENA_MONSTER_START),
+			MonsterPeriod:        ConfigDuration(ARENA_MONSTER_PERIOD),
+			MonsterLinger:        ConfigDuration(ARENA_MONSTER_LINGER),
+			MonsterRespawn:       ConfigDuration(ARENA_MONSTER_RESPAWN),
+			MonstersAlive:        ARENA_MONSTERS_ALIVE,
+			PlayerRevive:         ConfigDuration(ARENA_PLAYER_REVIVE),
+			HitDistanceTolerance: HIT_DISTANCE_TOLERANCE,
+			HitRateTolerance:     HIT_RATE_TOLERANCE,
+			HitDamageTolerance:   HIT_DAMAGE_TOLERANCE,
+			HitBurst:             HIT_BURST,
+			HitViolationsLimit:   HIT_VIOLATIONS_LIMIT,
+			HitViolationAction:   HIT_VIOLATION_ACTION,
+		},
+		Client: ClientConfig{
+			UpdateQueueSize: UPDATE_QUEUE_SIZE,
+			IdleTimeout:     ConfigDuration(CONFIG_CLIENT_IDLE_TIMEOUT),
+			ReadTimeout:     ConfigDuration(CONFIG_CLIENT_READ_TIMEOUT),
+			WriteTimeout:    ConfigDuration(CONFIG_CLIENT_WRITE_TIMEOUT),
+			StatePolicy:     SEND_POLICY_COALESCE,
+			ReliablePolicy:  SEND_POLICY_KEEP,
+			ReplyPolicy:     SEND_POLICY_DROP,
+			SlowTimeout:     ConfigDuration(CLIENT_SLOW_TIMEOUT),
+			PingPeriod:      ConfigDuration(CLIENT_PING_PERIOD),
+			InitialRTT:      ConfigDuration(CLIENT_INITIAL_RTT),
+			JoinWait:        ConfigDuration(CONFIG_CLIENT_JOIN_WAIT),
+		},
+		KCP: KCPConfig{
+			NoDelay:       KCP_NO_DELAY,
+			Interval:      ConfigDuration(KCP_INTERVAL),
+			Resend:        KCP_RESEND,
+			NoCongestion:  KCP_NO_CONGESTION,
+			SendWindow:    KCP_SEND_WINDOW,
+			ReceiveWindow: KCP_RECEIVE_WINDOW,
+		},
+	}
+	return config
+}
+
+// Загрузка настроек из аргументов командной строки, файл задается флагом -config или GAMESERVER_CONFIG
+func LoadConfig(args []string) (*Config, error) {
+	// Флаги разбираем заранее, чтобы узнать путь к файлу, применяем их последними
+	flagSet := flag.NewFlagSet("gameserver", flag.ContinueOnError)
+	configPath := flagSet.String("config", os.Getenv(CONFIG_ENV_PREFIX+"CONFIG"), "path to JSON config file")
+	flagsConfig := NewDefaultConfig()
+	for _, option := range configOptions {
+		flagSet.Var(option.value(flagsConfig), option.name, option.usage)
+	}
+	err := flagSet.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	config := NewDefaultConfig()
+	if *configPath != "" {
+		err = config.LoadFile(*configPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = config.ApplyEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	flagSet.Visit(func(f *flag.Flag) {
+		option := findConfigOption(f.Name)
+		if (option != nil) && (err == nil) {
+			err = option.value(config).Set(f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (config *Config) LoadFile(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(config)
+	if err != nil {
+		return fmt.Errorf("config %s: %s", filePath, err)
+	}
+	return nil
+}
+
+// Переменные окружения: GAMESERVER_ + имя флага в верхнем регистре, "-" заменяется на "_"
+func (config *Config) ApplyEnv(lookupEnv func(string) (string, bool)) error {
+	for _, option := range configOptions {
+		text, exists := lookupEnv(option.envName())
+		if exists == false {
+			continue
+		}
+		err := option.value(config).Set(text)
+		if err != nil {
+			return fmt.Errorf("%s: %s", option.envName(), err)
+		}
+	}
+	return nil
+}
+
+func (config *Config) Validate() error {
+	problems := make([]string, 0)
+	checkAddress := func(name string, address string, required bool) {
+		if address == "" {
+			if required {
+				problems = append(problems, name+" is empty")
+			}
+			return
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	checkPositive := func(name string, value time.Duration) {
+		if value <= 0 {
+			problems = append(problems, name+" must be positive")
+		}
+	}
+	checkNotNegative := func(name string, value time.Duration) {
+		if value < 0 {
+			problems = append(problems, name+" must not be negative")
+		}
+	}
+
+	checkAddress("server.listenAddress", config.Server.ListenAddress, true)
+	checkAddress("server.spectatorListenAddress", config.Server.SpectatorListenAddress, true)
+	checkAddress("server.webSocketListenAddress", config.Server.WebSocketListenAddress, false)
+	checkAddress("server.kcpListenAddress", config.Server.KCPListenAddress, false)
+	checkAddress("server.adminListenAddress", config.Server.AdminListenAddress, false)
+	checkAddress("server.metricsListenAddress", config.Server.MetricsListenAddress, false)
+	if (config.Server.AdminListenAddress != "") && (config.Server.AdminToken == "") {
+		problems = append(problems, "server.adminToken is required when admin is enabled")
+	}
+	if (config.Server.TLSCertFile == "") != (config.Server.TLSKeyFile == "") {
+		problems = append(problems, "server.tlsCertFile and server.tlsKeyFile must be set together")
+	}
+	if (config.Server.TLSClientCAFile != "") && (config.Server.TLSCertFile == "") {
+		problems = append(problems, "server.tlsClientCAFile requires server.tlsCertFile")
+	}
+	if IsValidTLSClientAuth(config.Server.TLSClientAuth) == false {
+		problems = append(problems, fmt.Sprintf("server.tlsClientAuth: unknown mode %q", config.Server.TLSClientAuth))
+	}
+	if (config.Server.TLSClientAuth == TLS_CLIENT_AUTH_REQUIRE) && (config.Server.TLSClientCAFile == "") {
+		problems = append(problems, "server.tlsClientAuth require needs server.tlsClientCAFile")
+	}
+	checkNotNegative("server.tlsReloadPeriod", config.Server.TLSReloadPeriod.Duration())
+	if info, err := os.Stat(config.Server.DataDir); (err != nil) || (info.IsDir() == false) {
+		problems = append(problems, fmt.Sprintf("server.dataDir %s is not a directory", config.Server.DataDir))
+	}
+
+	checkPositive("server.shutdownTimeout", config.Server.ShutdownTimeout.Duration())
+	checkPositive("server.checkpointPeriod", config.Server.CheckpointPeriod.Duration())
+	checkPositive("server.reattachTimeout", config.Server.ReattachTimeout.Duration())
+
+	if config.Arena.Dungeon == "" {
+		problems = append(problems, "arena.dungeon is empty")
+	}
+	if config.Arena.MaxClients < 1 {
+		problems = append(problems, "arena.maxClients must be at least 1")
+	}
+	checkPositive("arena.idleTimeout", config.Arena.IdleTimeout.Duration())
+	checkPositive("arena.updatePeriod", config.Arena.UpdatePeriod.Duration())
+	if config.Arena.MaxCatchUp < 1 {
+		problems = append(problems, "arena.maxCatchUp must be at least 1")
+	}
+	checkPositive("arena.monsterStart", config.Arena.MonsterStart.Duration())
+	checkPositive("arena.monsterPeriod", config.Arena.MonsterPeriod.Duration())
+	checkNotNegative("arena.maxRewind", config.Arena.MaxRewind.Duration())
+	checkNotNegative("arena.monsterLinger", config.Arena.MonsterLinger.Duration())
+	checkNotNegative("arena.monsterRespawn", config.Arena.MonsterRespawn.Duration())
+	checkNotNegative("arena.playerRevive", config.Arena.PlayerRevive.Duration())
+	if config.Arena.MonstersAlive < 1 {
+		problems = append(problems, "arena.monstersAlive must be at least 1")
+	}
+	if config.Arena.HitDistanceTolerance < 0 {
+		problems = append(problems, "arena.hitDistanceTolerance must not be negative")
+	}
+	if (config.Arena.HitRateTolerance < 0) || (config.Arena.HitRateTolerance >= 1) {
+		problems = append(problems, "arena.hitRateTolerance must be at least 0 and less than 1")
+	}
+	if config.Arena.HitDamageTolerance < 1 {
+		problems = append(problems, "arena.hitDamageTolerance must be at least 1")
+	}
+	if config.Arena.HitBurst < 1 {
+		problems = append(problems, "arena.hitBurst must be at least 1")
+	}
+	if config.Arena.HitViolationsLimit < 1 {
+		problems = append(problems, "arena.hitViolationsLimit must be at least 1")
+	}
+	if IsValidHitViolationAction(config.Arena.HitViolationAction) == false {
+		problems = append(problems, fmt.Sprintf("arena.hitViolationAction: unknown action %q", config.Arena.HitViolationAction))
+	}
+
+	if config.Client.UpdateQueueSize < 1 {
+		problems = append(problems, "client.updateQueueSize must be at least 1")
+	}
+	checkPositive("client.idleTimeout", config.Client.IdleTimeout.Duration())
+	checkPositive("client.readTimeout", config.Client.ReadTimeout.Duration())
+	checkPositive("client.writeTimeout", config.Client.WriteTimeout.Duration())
+	checkPositive("client.slowTimeout", config.Client.SlowTimeout.Duration())
+	checkNotNegative("client.pingPeriod", config.Client.PingPeriod.Duration())
+	checkNotNegative("client.initialRtt", config.Client.InitialRTT.Duration())
+	checkNotNegative("client.joinWait", config.Client.JoinWait.Duration())
+	checkPolicy := func(name string, policy string) {
+		if IsValidSendPolicy(policy) == false {
+			problems = append(problems, fmt.Sprintf("%s: unknown policy %q", name, policy))
+		}
+	}
+	checkPolicy("client.statePolicy", config.Client.StatePolicy)
+	checkPolicy("client.reliablePolicy", config.Client.ReliablePolicy)
+	checkPolicy("client.replyPolicy", config.Client.ReplyPolicy)
+
+	checkFlag := func(name string, value int) {
+		if (value != 0) && (value != 1) {
+			problems = append(problems, name+" must be 0 or 1")
+		}
+	}
+	checkFlag("kcp.noDelay", config.KCP.NoDelay)
+	checkFlag("kcp.noCongestion", config.KCP.NoCongestion)
+	if config.KCP.Interval.Duration() < time.Millisecond {
+		problems = append(problems, "kcp.interval must be at least 1ms")
+	}
+	if config.KCP.Resend < 0 {
+		problems = append(problems, "kcp.resend must not be negative")
+	}
+	if (config.KCP.SendWindow < 1) || (config.KCP.ReceiveWindow < 1) {
+		problems = append(problems, "kcp.sendWindow and kcp.receiveWindow must be at least 1")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Параметр, который можно задать флагом или переменной окружения
+type configOption struct {
+	name  string
+	usage string
+	value func(config *Config) flag.Value
+}
+
+func (option *configOption) envName() string {
+	return CONFIG_ENV_PREFIX + strings.ToUpper(strings.Replace(option.name, "-", "_", -1))
+}
+
+var configOptions = []configOption{
+	{"listen", "player listen address", func(c *Config) flag.Value { return (*configString)(&c.Server.ListenAddress) }},
+	{"spectator-listen", "spectator listen address", func(c *Config) flag.Value { return (*configString)(&c.Server.SpectatorListenAddress) }},
+	{"ws-listen", "WebSocket listen address for players (/ws) and spectators (/spectator), empty - WebSocket disabled", func(c *Config) flag.Value { return (*configString)(&c.Server.WebSocketListenAddress) }},
+	{"kcp-listen", "KCP (reliable UDP) listen address for players, empty - KCP disabled", func(c *Config) flag.Value { return (*configString)(&c.Server.KCPListenAddress) }},
+	{"tls-cert", "TLS certificate file for player, spectator and WebSocket listeners, empty - TLS disabled", func(c *Config) flag.Value { return (*configString)(&c.Server.TLSCertFile) }},
+	{"tls-key", "TLS private key file", func(c *Config) flag.Value { return (*configString)(&c.Server.TLSKeyFile) }},
+	{"tls-client-ca", "CA file to verify client certificates when presented, empty - client certificates not checked", func(c *Config) flag.Value { return (*configString)(&c.Server.TLSClientCAFile) }},
+	{"tls-client-auth", "client certificates with tls-client-ca: optional - checked when presented, require - connections without one are rejected", func(c *Config) flag.Value { return (*configString)(&c.Server.TLSClientAuth) }},
+	{"tls-reload-period", "how often TLS files are checked for changes, 0 - reload only from admin", func(c *Config) flag.Value { return &c.Server.TLSReloadPeriod }},
+	{"admin", "admin HTTP listen address, empty - admin disabled", func(c *Config) flag.Value { return (*configString)(&c.Server.AdminListenAddress) }},
+	{"admin-token", "admin HTTP token", func(c *Config) flag.Value { return (*configString)(&c.Server.AdminToken) }},
+	{"metrics", "Prometheus metrics listen address, empty - metrics disabled", func(c *Config) flag.Value { return (*configString)(&c.Server.MetricsListenAddress) }},
+	{"replays", "directory for arena replays, empty - recording disabled", func(c *Config) flag.Value { return (*configString)(&c.Server.ReplaysDir) }},
+	{"checkpoints", "directory for arena checkpoints restored on startup, empty - checkpoints disabled", func(c *Config) flag.Value { return (*configString)(&c.Server.CheckpointsDir) }},
+	{"checkpoint-period", "how often arenas write checkpoints", func(c *Config) flag.Value { return &c.Server.CheckpointPeriod }},
+	{"reattach-timeout", "how long a restored arena keeps a player waiting for reattach", func(c *Config) flag.Value { return &c.Server.ReattachTimeout }},
+	{"leaderboard", "file for leaderboard results, empty - keep results in memory only", func(c *Config) flag.Value { return (*configString)(&c.Server.LeaderboardFile) }},
+	{"data", "static data directory", func(c *Config) flag.Value { return (*configString)(&c.Server.DataDir) }},
+	{"shutdown-timeout", "max wait for client queues on shutdown", func(c *Config) flag.Value { return &c.Server.ShutdownTimeout }},
+	{"dungeon", "dungeon started on new arenas", func(c *Config) flag.Value { return (*configString)(&c.Arena.Dungeon) }},
+	{"arena-max-clients", "max players per arena", func(c *Config) flag.Value { return (*configInt)(&c.Arena.MaxClients) }},
+	{"arena-idle-timeout", "arena lifetime without players", func(c *Config) flag.Value { return &c.Arena.IdleTimeout }},
+	{"tick", "arena update period", func(c *Config) flag.Value { return &c.Arena.UpdatePeriod }},
+	{"max-catch-up", "max ticks an arena runs at once when behind", func(c *Config) flag.Value { return (*configInt)(&c.Arena.MaxCatchUp) }},
+	{"max-rewind", "how far back hit checks look at monster positions, 0 - no lag compensation", func(c *Config) flag.Value { return &c.Arena.MaxRewind }},
+	{"monster-start", "delay before the first monster", func(c *Config) flag.Value { return &c.Arena.MonsterStart }},
+	{"monster-period", "monster spawn period", func(c *Config) flag.Value { return &c.Arena.MonsterPeriod }},
+	{"monster-linger", "how long a dead monster stays in arena state", func(c *Config) flag.Value { return &c.Arena.MonsterLinger }},
+	{"monster-respawn", "respawn delay after monster death, 0 - no respawn", func(c *Config) flag.Value { return &c.Arena.MonsterRespawn }},
+	{"monsters-alive", "max alive monsters created by spawn timer", func(c *Config) flag.Value { return (*configInt)(&c.Arena.MonstersAlive) }},
+	{"player-revive", "revive delay for a defeated player, 0 - no revive", func(c *Config) flag.Value { return &c.Arena.PlayerRevive }},
+	{"hit-distance-tolerance", "extra distance allowed for a hit", func(c *Config) flag.Value { return (*configFloat)(&c.Arena.HitDistanceTolerance) }},
+	{"hit-rate-tolerance", "fraction of the attack interval a hit may come early", func(c *Config) flag.Value { return (*configFloat)(&c.Arena.HitRateTolerance) }},
+	{"hit-damage-tolerance", "multiplier for the max hit damage", func(c *Config) flag.Value { return (*configFloat)(&c.Arena.HitDamageTolerance) }},
+	{"hit-burst", "hits of one kind allowed back to back before the rate check applies", func(c *Config) flag.Value { return (*configInt)(&c.Arena.HitBurst) }},
+	{"hit-violations-limit", "hit violations before the violation action", func(c *Config) flag.Value { return (*configInt)(&c.Arena.HitViolationsLimit) }},
+	{"hit-violation-action", "action for a client over the violations limit: kick or flag", func(c *Config) flag.Value { return (*configString)(&c.Arena.HitViolationAction) }},
+	{"update-queue-size", "max queued messages per client", func(c *Config) flag.Value { return (*configInt)(&c.Client.UpdateQueueSize) }},
+	{"client-idle-timeout", "disconnect a client that sends nothing for this long", func(c *Config) flag.Value { return &c.Client.IdleTimeout }},
+	{"client-read-timeout", "read deadline for a message body", func(c *Config) flag.Value { return &c.Client.ReadTimeout }},
+	{"client-write-timeout", "write deadline for a message", func(c *Config) flag.Value { return &c.Client.WriteTimeout }},
+	{"state-policy", "slow client policy for state messages: coalesce, drop or keep", func(c *Config) flag.Value { return (*configString)(&c.Client.StatePolicy) }},
+	{"reliable-policy", "slow client policy for reliable messages: coalesce, drop or keep", func(c *Config) flag.Value { return (*configString)(&c.Client.ReliablePolicy) }},
+	{"reply-policy", "slow client policy for request replies: coalesce, drop or keep", func(c *Config) flag.Value { return (*configString)(&c.Client.ReplyPolicy) }},
+	{"slow-timeout", "disconnect a client that cannot keep up with its messages for this long", func(c *Config) flag.Value { return &c.Client.SlowTimeout }},
+	{"ping-period", "ping period for client RTT and clock sync, 0 - off", func(c *Config) flag.Value { return &c.Client.PingPeriod }},
+	{"initial-rtt", "client RTT assumed for lag compensation until the first ping reply", func(c *Config) flag.Value { return &c.Client.InitialRTT }},
+	{"join-wait", "wait for a JOIN with the cached resources hash before sending ArenaInfo with resources, 0 - do not wait", func(c *Config) flag.Value { return &c.Client.JoinWait }},
+	{"kcp-nodelay", "KCP nodelay mode: 1 - fast retransmission, 0 - normal", func(c *Config) flag.Value { return (*configInt)(&c.KCP.NoDelay) }},
+	{"kcp-interval", "KCP internal update interval", func(c *Config) flag.Value { return &c.KCP.Interval }},
+	{"kcp-resend", "KCP fast resend after this many skipped ACKs, 0 - off", func(c *Config) flag.Value { return (*configInt)(&c.KCP.Resend) }},
+	{"kcp-nc", "KCP congestion control: 1 - off, 0 - on", func(c *Config) flag.Value { return (*configInt)(&c.KCP.NoCongestion) }},
+	{"kcp-send-window", "KCP send window in packets", func(c *Config) flag.Value { return (*configInt)(&c.KCP.SendWindow) }},
+	{"kcp-receive-window", "KCP receive window in packets", func(c *Config) flag.Value { return (*configInt)(&c.KCP.ReceiveWindow) }},
+}
+
+func findConfigOption(name string) *configOption {
+	for i := range configOptions {
+		if configOptions[i].name == name {
+			return &configOptions[i]
+		}
+	}
+	return nil
+}
+
+type configString string
+
+func (value *configString) String() string {
+	return string(*value)
+}
+
+func (value *configString) Set(text string) error {
+	*value = configString(text)
+	return nil
+}
+
+type configInt int
+
+func (value *configInt) String() string {
+	return strconv.Itoa(int(*value))
+}
+
+func (value *configInt) Set(text string) error {
+	parsed, err := strconv.Atoi(text)
+	if err != nil {
+		return err
+	}
+	*value = configInt(parsed)
+	return nil
+}
+
+type configFloat float64
+
+func (value *configFloat) String() string {
+	return strconv.FormatFloat(float64(*value), 'g', -1, 64)
+}
+
+func (value *configFloat) Set(text string) error {
+	parsed, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return err
+	}
+	*value = configFloat(parsed)
+	return nil
+}
+
+func (duration *ConfigDuration) String() string {
+	return time.Duration(*duration).String()
+}
+
+func (duration *ConfigDuration) Set(text string) error {
+	value, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*duration = ConfigDuration(value)
+	return nil
+}
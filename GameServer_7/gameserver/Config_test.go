@@ -0,0 +1,102 @@
+package gameserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Настройки применяются по порядку: значения по умолчанию, файл, окружение, флаги
+func TestLoadConfigPrecedence(t *testing.T) {
+	defaults := NewDefaultConfig()
+	file := `{"arena": {"maxClients": 7, "monstersAlive": 3}, "client": {"pingPeriod": "2s"}}`
+	tests := []struct {
+		name          string
+		file          string
+		env           map[string]string
+		args          []string
+		maxClients    int
+		monstersAlive int
+		pingPeriod    time.Duration
+		valid         bool
+	}{
+		{"defaults", "", nil, nil, defaults.Arena.MaxClients, defaults.Arena.MonstersAlive, defaults.Client.PingPeriod.Duration(), true},
+		{"file", file, nil, nil, 7, 3, 2 * time.Second, true},
+		{"env over file", file, map[string]string{"GAMESERVER_ARENA_MAX_CLIENTS": "8", "GAMESERVER_PING_PERIOD": "3s"}, nil, 8, 3, 3 * time.Second, true},
+		{"flags over env", file, map[string]string{"GAMESERVER_ARENA_MAX_CLIENTS": "8"}, []string{"-arena-max-clients", "9"}, 9, 3, 2 * time.Second, true},
+		{"flags over file", file, nil, []string{"-ping-period", "4s"}, 7, 3, 4 * time.Second, true},
+		{"unknown file field", `{"arena": {"maxClient": 7}}`, nil, nil, 0, 0, 0, false},
+		{"bad env value", "", map[string]string{"GAMESERVER_ARENA_MAX_CLIENTS": "many"}, nil, 0, 0, 0, false},
+		{"bad flag value", "", nil, []string{"-ping-period", "soon"}, 0, 0, 0, false},
+		{"invalid value", "", nil, []string{"-arena-max-clients", "0"}, 0, 0, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Переменные окружения, не заданные в тесте, не должны влиять на результат
+			for _, option := range configOptions {
+				if _, exists := os.LookupEnv(option.envName()); exists {
+					t.Setenv(option.envName(), "")
+					os.Unsetenv(option.envName())
+				}
+			}
+			t.Setenv(CONFIG_ENV_PREFIX+"CONFIG", "")
+			args := []string{"-data", filepath.Join("..", CONFIG_DATA_DIR)}
+			if test.file != "" {
+				path := filepath.Join(t.TempDir(), "config.json")
+				err := ioutil.WriteFile(path, []byte(test.file), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Путь к файлу тоже из окружения
+				t.Setenv(CONFIG_ENV_PREFIX+"CONFIG", path)
+			}
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			args = append(args, test.args...)
+
+			config, err := LoadConfig(args)
+			if test.valid == false {
+				if err == nil {
+					t.Fatal("Config loaded without error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (config.Arena.MaxClients != test.maxClients) || (config.Arena.MonstersAlive != test.monstersAlive) || (config.Client.PingPeriod.Duration() != test.pingPeriod) {
+				t.Fatalf("maxClients %d, monstersAlive %d, pingPeriod %s, expected %d, %d, %s", config.Arena.MaxClients, config.Arena.MonstersAlive,
+					config.Client.PingPeriod.Duration(), test.maxClients, test.monstersAlive, test.pingPeriod)
+			}
+		})
+	}
+}
+
+// Имя переменной - GAMESERVER_ и имя флага, незаданные переменные не меняют настройки
+func TestConfigApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"GAMESERVER_DUNGEON":      "other_dungeon",
+		"GAMESERVER_STATE_POLICY": SEND_POLICY_DROP,
+		"GAMESERVER_JOIN_WAIT":    "150ms",
+		"GAMESERVER_UNKNOWN":      "1",
+	}
+	config := NewDefaultConfig()
+	err := config.ApplyEnv(func(name string) (string, bool) {
+		value, exists := env[name]
+		return value, exists
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := NewDefaultConfig()
+	expected.Arena.Dungeon = "other_dungeon"
+	expected.Client.StatePolicy = SEND_POLICY_DROP
+	expected.Client.JoinWait = ConfigDuration(150 * time.Millisecond)
+	if reflect.DeepEqual(config, expected) == false {
+		t.Fatalf("Config after env %+v, expected %+v", *config, *expected)
+	}
+}
@@ -0,0 +1,42 @@
+package gameserver
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+type DungeonInfo struct {
+	Name                string   `json:"-"`                    // имя подземелья (ключ в файле)
+	AmplificationFactor float64  `json:"amplification_factor"` // усиление монстров
+	KillsForImprove     uint32   `json:"kills_for_improve"`    // убийств до усиления
+	Timer               float64  `json:"timer"`                // длительность подземелья в секундах
+	TimeForKill         float64  `json:"time_for_kill"`        // добавка времени за убийство
+	Level               string   `json:"level"`                // имя уровня из level_graphics.json
+	Platforms           []string `json:"platforms"`            // платформы подземелья
+}
+
+func NewDungeonsFromReader(reader io.Reader) (map[string]*DungeonInfo, error) {
+	result := make(map[string]*DungeonInfo)
+	decoder := json.NewDecoder(reader)
+	err := decoder.Decode(&result)
+	if err == nil {
+		for name, info := range result {
+			info.Name = name
+		}
+	}
+	return result, err
+}
+
+func NewDungeonsFromFile(filePath string) (map[string]*DungeonInfo, error) {
+	// Загрузка подземелий из файла
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Println(err)
+		return make(map[string]*DungeonInfo), err
+	}
+	defer f.Close()
+
+	return NewDungeonsFromReader(f)
+}
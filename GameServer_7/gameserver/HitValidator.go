@@ -0,0 +1,204 @@
+package gameserver
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	HIT_VIOLATION_ACTION_FLAG = "flag" // нарушителя только помечаем
+	HIT_VIOLATION_ACTION_KICK = "kick" // нарушителя отключаем
+)
+
+const HIT_DAMAGE_DIVIDER = 10 // урон от клиента приходит умноженным на 10
+
+// Значения по умолчанию, на арене используются настройки из Config
+const (
+	HIT_DISTANCE_TOLERANCE = 5.0 // допуск по дистанции удара
+	HIT_RATE_TOLERANCE     = 0.2 // допуск по частоте ударов (доля от интервала)
+	HIT_DAMAGE_TOLERANCE   = 1.5 // допуск по урону (множитель к силе удара)
+	HIT_BURST              = 2   // сколько ударов одного вида может прийти подряд без паузы
+	HIT_VIOLATIONS_LIMIT   = 10  // после скольких нарушений срабатывает действие
+	HIT_VIOLATION_ACTION   = HIT_VIOLATION_ACTION_KICK
+)
+
+// Сколько разных монстров задевает одна атака, если в статических данных не задано
+const (
+	HIT_PLAYER_MAX_TARGETS = 3  // обычный удар игрока, max_targets игрока
+	HIT_SKILL_MAX_TARGETS  = 10 // навык, max_targets в параметрах навыка
+)
+
+func IsValidHitViolationAction(action string) bool {
+	return (action == HIT_VIOLATION_ACTION_FLAG) || (action == HIT_VIOLATION_ACTION_KICK)
+}
+
+type HitViolationType uint8
+
+const (
+	HIT_VIOLATION_NO_MONSTER HitViolationType = 0 // нет такого монстра
+	HIT_VIOLATION_DISTANCE   HitViolationType = 1 // монстр слишком далеко
+	HIT_VIOLATION_RATE       HitViolationType = 2 // удары чаще, чем позволяет attack_speed
+	HIT_VIOLATION_SKILL      HitViolationType = 3 // навык не существует, не наносит урон или на перезарядке
+	HIT_VIOLATION_DAMAGE     HitViolationType = 4 // урон больше возможного
+	HIT_VIOLATION_DUPLICATE  HitViolationType = 5 // монстр повторяется в одной атаке
+	HIT_VIOLATION_TARGETS    HitViolationType = 6 // монстров в атаке больше, чем задевает удар или навык
+)
+
+// Атака клиента: все попадания из одной команды считаются одним ударом
+type ClientAttack struct {
+	Skill    string
+	Time     time.Time
+	ViewTick uint64 // тик арены, который видел клиент, 0 - текущий
+	Hits     []ClientCommandHitInfo
+}
+
+// Запас ударов одного вида (обычный удар или навык). Пополняется с разрешенной частотой до HitBurst,
+// поэтому честные удары, которые сеть принесла в один тик, не считаются нарушением,
+// а средняя частота все равно не выше разрешенной
+type HitBucket struct {
+	Tokens float64   `json:"tokens"`
+	Time   time.Time `json:"time"` // последнее пополнение
+}
+
+type HitValidator struct {
+	config     *ArenaConfig
+	playerInfo *UnitInfo
+	units      map[string]*UnitInfo
+	skills     map[string]*SkillInfo
+}
+
+func NewHitValidator(staticInfo *StaticInfo, config *ArenaConfig) *HitValidator {
+	return &HitValidator{
+		config:     config,
+		playerInfo: staticInfo.Units[UNIT_NAME_PLAYER],
+		units:      staticInfo.Units,
+		skills:     staticInfo.Skills,
+	}
+}
+
+// Проверка атаки, возвращает допустимые попадания и найденные нарушения. Дистанция и статус монстров
+// берутся из rewind - снимка тика, который видел клиент, без снимка - текущие.
+// Вызывается только из цикла арены, поэтому поля клиента для проверки частоты не защищены мьютексом
+func (validator *HitValidator) ValidateAttack(client *ServerClient, attack ClientAttack, monsters []ServerMonsterState, rewind *MonsterSnapshot) ([]ClientCommandHitInfo, []HitViolationType) {
+	violations := make([]HitViolationType, 0)
+
+	// Частота ударов и навык, у обычного удара ключ запаса пустой
+	damageFactor := 1.0
+	maxTargets := validator.playerInfo.MaxTargets
+	if maxTargets <= 0 {
+		maxTargets = HIT_PLAYER_MAX_TARGETS
+	}
+	if attack.Skill != "" {
+		skill, exists := validator.skills[attack.Skill]
+		if (exists == false) || (skill.IsDamaging() == false) {
+			return nil, append(violations, HIT_VIOLATION_SKILL)
+		}
+		cooldown := time.Duration(skill.MinCooldown() * (1.0 - validator.config.HitRateTolerance) * float64(time.Second))
+		if validator.takeHit(client, attack.Skill, cooldown, attack.Time) == false {
+			return nil, append(violations, HIT_VIOLATION_SKILL)
+		}
+		damageFactor = skill.MaxDamageFactor()
+		maxTargets = skill.MaxTargets()
+		if maxTargets <= 0 {
+			maxTargets = HIT_SKILL_MAX_TARGETS
+		}
+	} else if validator.playerInfo.AttackSpeed > 0 {
+		interval := time.Duration((1.0 - validator.config.HitRateTolerance) / validator.playerInfo.AttackSpeed * float64(time.Second))
+		if validator.takeHit(client, "", interval, attack.Time) == false {
+			return nil, append(violations, HIT_VIOLATION_RATE)
+		}
+	}
+
+	// Запас списан один раз на команду, поэтому монстр засчитывается один раз,
+	// а целей не больше, чем задевает удар
+	hits := make([]ClientCommandHitInfo, 0, len(attack.Hits))
+	hitIds := make(map[uint32]bool, len(attack.Hits))
+	for _, hit := range attack.Hits {
+		if hitIds[hit.ID] {
+			violations = append(violations, HIT_VIOLATION_DUPLICATE)
+			continue
+		}
+		hitIds[hit.ID] = true
+		hits = append(hits, hit)
+	}
+	if len(hits) > maxTargets {
+		violations = append(violations, HIT_VIOLATION_TARGETS)
+		hits = hits[:maxTargets]
+	}
+
+	// Попадания по монстрам
+	clientPos := client.GetPosition()
+	maxDamage := validator.playerInfo.Power * damageFactor * validator.config.HitDamageTolerance
+	validHits := make([]ClientCommandHitInfo, 0, len(hits))
+	for _, hit := range hits {
+		// Удар по монстру, который уже умер или уже убран, но был в состоянии, которое видел клиент, -
+		// обычная гонка в совместной игре: удар не засчитывается, но и нарушением не считается.
+		// Так же и удар по монстру, который был мертв на тике клиента
+		monster := findMonster(monsters, hit.ID)
+		seen, wasSeen := rewind.Find(hit.ID)
+		if monster == nil {
+			if wasSeen == false {
+				violations = append(violations, HIT_VIOLATION_NO_MONSTER)
+			}
+			continue
+		}
+		if (monster.Status != MONSTER_STATE_STATUS_ALIVE) || (wasSeen && (seen.Alive == false)) {
+			continue
+		}
+
+		monsterRadius := 0.0
+		if monsterInfo, exists := validator.units[monster.Name]; exists {
+			monsterRadius = monsterInfo.BoundingRadius
+		}
+		monsterPos := NewPointFloat(monster.X, monster.Y)
+		if wasSeen {
+			monsterPos = NewPointFloat(seen.X, seen.Y)
+		}
+		distance := clientPos.Distance(monsterPos)
+		if distance-monsterRadius > validator.playerInfo.AttackRadius+validator.config.HitDistanceTolerance {
+			violations = append(violations, HIT_VIOLATION_DISTANCE)
+			continue
+		}
+
+		damage := float64(hit.Damage) / HIT_DAMAGE_DIVIDER
+		if (damage < 0) || (damage > maxDamage) {
+			violations = append(violations, HIT_VIOLATION_DAMAGE)
+			continue
+		}
+
+		validHits = append(validHits, hit)
+	}
+	return validHits, violations
+}
+
+// Удар из запаса вида key, запас пополняется на один удар за interval. false - запас исчерпан
+func (validator *HitValidator) takeHit(client *ServerClient, key string, interval time.Duration, now time.Time) bool {
+	if interval <= 0 {
+		return true
+	}
+	burst := float64(validator.config.HitBurst)
+	bucket, exists := client.hitBuckets[key]
+	if exists == false {
+		bucket = HitBucket{Tokens: burst, Time: now}
+	}
+	if now.After(bucket.Time) {
+		bucket.Tokens = math.Min(burst, bucket.Tokens+float64(now.Sub(bucket.Time))/float64(interval))
+		bucket.Time = now
+	}
+	if bucket.Tokens < 1 {
+		client.hitBuckets[key] = bucket
+		return false
+	}
+	bucket.Tokens--
+	client.hitBuckets[key] = bucket
+	return true
+}
+
+func findMonster(monsters []ServerMonsterState, id uint32) *ServerMonsterState {
+	for i := range monsters {
+		if monsters[i].ID == id {
+			return &monsters[i]
+		}
+	}
+	return nil
+}
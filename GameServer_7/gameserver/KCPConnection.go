@@ -0,0 +1,48 @@
+package gameserver
+
+import (
+	"time"
+
+	"github.com/xtaci/kcp-go"
+)
+
+// По умолчанию, задается в Config. Быстрый режим как в KCPExample
+const (
+	KCP_NO_DELAY       = 1
+	KCP_INTERVAL       = 10 * time.Millisecond
+	KCP_RESEND         = 2
+	KCP_NO_CONGESTION  = 1
+	KCP_SEND_WINDOW    = 128
+	KCP_RECEIVE_WINDOW = 128
+)
+
+// FEC выключен: потерянные пакеты закрывает повтор KCP
+const (
+	KCP_DATA_SHARDS   = 0
+	KCP_PARITY_SHARDS = 0
+)
+
+// Настройка сессии: поток байт под общую рамку сообщений с длиной, задержки и окна из настроек
+func ConfigureKCPSession(session *kcp.UDPSession, config KCPConfig) {
+	session.SetStreamMode(true)
+	session.SetWriteDelay(false)
+	session.SetNoDelay(config.NoDelay, int(config.Interval.Duration()/time.Millisecond), config.Resend, config.NoCongestion)
+	session.SetWindowSize(config.SendWindow, config.ReceiveWindow)
+}
+
+// Подключение клиента по KCP. Соединения в UDP нет, сервер узнает о клиенте по первому пакету,
+// поэтому сразу отправляется пустое сообщение - сервер его пропускает
+func DialKCP(address string, config KCPConfig) (*kcp.UDPSession, error) {
+	session, err := kcp.DialWithOptions(address, nil, KCP_DATA_SHARDS, KCP_PARITY_SHARDS)
+	if err != nil {
+		return nil, err
+	}
+	ConfigureKCPSession(session, config)
+
+	err = NewStreamConnection(session, 0).WriteMessage([]byte{})
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
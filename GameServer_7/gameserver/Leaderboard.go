@@ -0,0 +1,290 @@
+package gameserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	LEADERBOARD_WINDOW_DAILY  = "daily"  // с начала текущих суток UTC
+	LEADERBOARD_WINDOW_WEEKLY = "weekly" // с понедельника текущей недели UTC
+	LEADERBOARD_WINDOW_ALL    = "all"    // за все время
+)
+
+const (
+	LEADERBOARD_DEFAULT_LIMIT = 10
+	LEADERBOARD_MAX_LIMIT     = 100
+	LEADERBOARD_MAX_PROFILE   = 64 // максимальная длина id профиля и имени
+)
+
+// Итог одного забега игрока на арене
+type LeaderboardResult struct {
+	Profile    string    `json:"profile"`
+	Name       string    `json:"name,omitempty"`
+	Dungeon    string    `json:"dungeon"`
+	ArenaId    uint32    `json:"arenaId"`
+	Damage     uint32    `json:"damage"`
+	Kills      uint32    `json:"kills"`
+	Time       float64   `json:"time"`   // секунды на арене по времени симуляции
+	Status     int8      `json:"status"` // статус клиента в конце забега
+	FinishTime time.Time `json:"finishTime"`
+}
+
+// Запрос таблицы: Around - id профиля, вокруг которого нужны места, пустой - лучшие Limit
+type LeaderboardQuery struct {
+	Window  string `json:"window"`            // daily, weekly или all, пустой - all
+	Dungeon string `json:"dungeon,omitempty"` // пустой - все подземелья
+	Limit   int    `json:"limit,omitempty"`
+	Around  string `json:"around,omitempty"`
+}
+
+// Место профиля в таблице: лучший забег за период и количество забегов
+type LeaderboardEntry struct {
+	Rank int `json:"rank"`
+	LeaderboardResult
+	Runs int `json:"runs"`
+}
+
+// Ответ на запрос таблицы, отправляется клиенту и админке
+type LeaderboardMessage struct {
+	Type    string             `json:"type"`
+	Window  string             `json:"window"`
+	Dungeon string             `json:"dungeon,omitempty"`
+	Total   int                `json:"total"` // профилей в таблице
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// Таблица рекордов всех арен: результаты в памяти и, если задан файл, дописываются в него строками JSON
+type Leaderboard struct {
+	mutex   sync.RWMutex
+	results []LeaderboardResult
+	writer  io.WriteCloser
+}
+
+func NewLeaderboard() *Leaderboard {
+	return &Leaderboard{
+		results: make([]LeaderboardResult, 0),
+		writer:  nil,
+	}
+}
+
+// Чтение результатов, битые строки пропускаются
+func NewLeaderboardFromReader(reader io.Reader) (*Leaderboard, error) {
+	leaderboard := NewLeaderboard()
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		result := LeaderboardResult{}
+		err := json.Unmarshal(scanner.Bytes(), &result)
+		if err != nil {
+			log.Printf("Leaderboard line %d skipped: %s\n", line, err)
+			continue
+		}
+		leaderboard.results = append(leaderboard.results, result)
+	}
+	return leaderboard, scanner.Err()
+}
+
+// Загрузка результатов из файла, новые результаты дописываются в него же
+func NewLeaderboardFromFile(filePath string) (*Leaderboard, error) {
+	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	leaderboard, err := NewLeaderboardFromReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	leaderboard.writer = f
+	log.Printf("Leaderboard loaded %d results from %s\n", len(leaderboard.results), filePath)
+	return leaderboard, nil
+}
+
+func (leaderboard *Leaderboard) Close() error {
+	if leaderboard == nil {
+		return nil
+	}
+	leaderboard.mutex.Lock()
+	defer leaderboard.mutex.Unlock()
+	if leaderboard.writer == nil {
+		return nil
+	}
+	err := leaderboard.writer.Close()
+	leaderboard.writer = nil
+	return err
+}
+
+// Добавление результата, без профиля результат не учитывается
+func (leaderboard *Leaderboard) AddResult(result LeaderboardResult) error {
+	if (leaderboard == nil) || (result.Profile == "") {
+		return nil
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	leaderboard.mutex.Lock()
+	defer leaderboard.mutex.Unlock()
+	leaderboard.results = append(leaderboard.results, result)
+	if leaderboard.writer != nil {
+		_, err = leaderboard.writer.Write(append(data, '\n'))
+	}
+	return err
+}
+
+func (leaderboard *Leaderboard) GetResultsCount() int {
+	if leaderboard == nil {
+		return 0
+	}
+	leaderboard.mutex.RLock()
+	defer leaderboard.mutex.RUnlock()
+	return len(leaderboard.results)
+}
+
+// Начало периода таблицы для момента now, нулевое время - без ограничения
+func LeaderboardWindowStart(window string, now time.Time) (time.Time, error) {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch window {
+	case LEADERBOARD_WINDOW_DAILY:
+		return day, nil
+	case LEADERBOARD_WINDOW_WEEKLY:
+		// Неделя с понедельника
+		daysFromMonday := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -daysFromMonday), nil
+	case LEADERBOARD_WINDOW_ALL, "":
+		return time.Time{}, nil
+	default:
+		return time.Time{}, errors.New("Unknown leaderboard window")
+	}
+}
+
+// Лучший забег выше: больше урона, затем больше убийств, затем быстрее, затем раньше
+func leaderboardResultBetter(a, b *LeaderboardResult) bool {
+	if a.Damage != b.Damage {
+		return a.Damage > b.Damage
+	}
+	if a.Kills != b.Kills {
+		return a.Kills > b.Kills
+	}
+	if a.Time != b.Time {
+		return a.Time < b.Time
+	}
+	if a.FinishTime.Equal(b.FinishTime) == false {
+		return a.FinishTime.Before(b.FinishTime)
+	}
+	return a.Profile < b.Profile
+}
+
+// Таблица на момент now: по лучшему забегу каждого профиля за период
+func (leaderboard *Leaderboard) Board(window, dungeon string, now time.Time) ([]LeaderboardEntry, error) {
+	windowStart, err := LeaderboardWindowStart(window, now)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]LeaderboardEntry, 0)
+	if leaderboard == nil {
+		return entries, nil
+	}
+
+	byProfile := make(map[string]int)
+	leaderboard.mutex.RLock()
+	for i := range leaderboard.results {
+		result := &leaderboard.results[i]
+		if (dungeon != "") && (result.Dungeon != dungeon) {
+			continue
+		}
+		if result.FinishTime.Before(windowStart) {
+			continue
+		}
+		index, exists := byProfile[result.Profile]
+		if exists == false {
+			byProfile[result.Profile] = len(entries)
+			entries = append(entries, LeaderboardEntry{LeaderboardResult: *result, Runs: 1})
+			continue
+		}
+		entries[index].Runs++
+		if leaderboardResultBetter(result, &entries[index].LeaderboardResult) {
+			entries[index].LeaderboardResult = *result
+		}
+	}
+	leaderboard.mutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return leaderboardResultBetter(&entries[i].LeaderboardResult, &entries[j].LeaderboardResult)
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries, nil
+}
+
+// Ответ на запрос: лучшие Limit мест или Limit мест вокруг профиля Around
+func (leaderboard *Leaderboard) Query(query LeaderboardQuery, now time.Time) (LeaderboardMessage, error) {
+	if query.Window == "" {
+		query.Window = LEADERBOARD_WINDOW_ALL
+	}
+	if query.Limit <= 0 {
+		query.Limit = LEADERBOARD_DEFAULT_LIMIT
+	}
+	if query.Limit > LEADERBOARD_MAX_LIMIT {
+		query.Limit = LEADERBOARD_MAX_LIMIT
+	}
+
+	message := LeaderboardMessage{
+		Type:    "Leaderboard",
+		Window:  query.Window,
+		Dungeon: query.Dungeon,
+		Entries: make([]LeaderboardEntry, 0),
+	}
+	entries, err := leaderboard.Board(query.Window, query.Dungeon, now)
+	if err != nil {
+		return message, err
+	}
+	message.Total = len(entries)
+
+	begin := 0
+	if query.Around != "" {
+		index := -1
+		for i := range entries {
+			if entries[i].Profile == query.Around {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return message, nil
+		}
+		begin = index - query.Limit/2
+		if begin > len(entries)-query.Limit {
+			begin = len(entries) - query.Limit
+		}
+		if begin < 0 {
+			begin = 0
+		}
+	}
+	end := begin + query.Limit
+	if end > len(entries) {
+		end = len(entries)
+	}
+	message.Entries = append(message.Entries, entries[begin:end]...)
+	return message, nil
+}
+
+func (message *LeaderboardMessage) ToBytes() ([]byte, error) {
+	return json.Marshal(message)
+}
@@ -0,0 +1,122 @@
+package gameserver
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Периоды таблицы по часам: сутки и неделя UTC начинаются в полночь и в понедельник
+func TestLeaderboardWindows(t *testing.T) {
+	// Среда 21.10.2026, 12:00 UTC
+	clock := NewManualClock(time.Date(2026, 10, 21, 12, 0, 0, 0, time.UTC))
+	leaderboard := NewLeaderboard()
+	results := []struct {
+		profile string
+		finish  time.Time
+		damage  uint32
+	}{
+		{"sunday_end", time.Date(2026, 10, 18, 23, 59, 59, 0, time.UTC), 50},
+		{"monday_start", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), 40},
+		{"tuesday_end", time.Date(2026, 10, 20, 23, 59, 59, 0, time.UTC), 30},
+		{"wednesday_start", time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC), 20},
+		{"wednesday_now", clock.Now(), 10},
+	}
+	for _, result := range results {
+		leaderboard.AddResult(LeaderboardResult{Profile: result.profile, Dungeon: "d", Damage: result.damage, FinishTime: result.finish})
+	}
+
+	tests := []struct {
+		name     string
+		advance  time.Duration
+		window   string
+		expected []string
+	}{
+		{"daily", 0, LEADERBOARD_WINDOW_DAILY, []string{"wednesday_start", "wednesday_now"}},
+		{"weekly", 0, LEADERBOARD_WINDOW_WEEKLY, []string{"monday_start", "tuesday_end", "wednesday_start", "wednesday_now"}},
+		{"all", 0, LEADERBOARD_WINDOW_ALL, []string{"sunday_end", "monday_start", "tuesday_end", "wednesday_start", "wednesday_now"}},
+		{"empty window is all", 0, "", []string{"sunday_end", "monday_start", "tuesday_end", "wednesday_start", "wednesday_now"}},
+		{"daily before midnight", 12*time.Hour - time.Second, LEADERBOARD_WINDOW_DAILY, []string{"wednesday_start", "wednesday_now"}},
+		{"daily after midnight", time.Second, LEADERBOARD_WINDOW_DAILY, []string{}},
+		{"weekly on thursday", 0, LEADERBOARD_WINDOW_WEEKLY, []string{"monday_start", "tuesday_end", "wednesday_start", "wednesday_now"}},
+		{"weekly on next monday", 4 * 24 * time.Hour, LEADERBOARD_WINDOW_WEEKLY, []string{}},
+	}
+	for _, test := range tests {
+		// Часы сдвигаются от случая к случаю
+		clock.Advance(test.advance)
+		t.Run(test.name, func(t *testing.T) {
+			message, err := leaderboard.Query(LeaderboardQuery{Window: test.window}, clock.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.Total != len(test.expected) {
+				t.Fatalf("Total %d at %s, expected %d", message.Total, clock.Now(), len(test.expected))
+			}
+			for i, entry := range message.Entries {
+				if (entry.Profile != test.expected[i]) || (entry.Rank != i+1) {
+					t.Fatalf("Entry %d at %s: %s rank %d, expected %s", i, clock.Now(), entry.Profile, entry.Rank, test.expected[i])
+				}
+			}
+		})
+	}
+
+	if _, err := leaderboard.Query(LeaderboardQuery{Window: "monthly"}, clock.Now()); err == nil {
+		t.Fatal("Unknown window accepted")
+	}
+}
+
+// Результаты из файла, лучший забег профиля, фильтр подземелья и места вокруг профиля
+func TestLeaderboardQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leaderboard.jsonl")
+	leaderboard, err := NewLeaderboardFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 21, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		leaderboard.AddResult(LeaderboardResult{Profile: fmt.Sprintf("p%02d", i), Dungeon: "d", Damage: uint32(i * 10), FinishTime: now})
+	}
+	leaderboard.AddResult(LeaderboardResult{Profile: "p01", Dungeon: "e", Damage: 1000, FinishTime: now})
+	leaderboard.Close()
+	leaderboard, err = NewLeaderboardFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leaderboard.Close()
+	if leaderboard.GetResultsCount() != 21 {
+		t.Fatalf("Results loaded %d, expected 21", leaderboard.GetResultsCount())
+	}
+
+	tests := []struct {
+		name    string
+		query   LeaderboardQuery
+		total   int
+		first   string
+		runs    int
+		entries int
+	}{
+		{"top", LeaderboardQuery{}, 20, "p01", 2, LEADERBOARD_DEFAULT_LIMIT},
+		{"dungeon", LeaderboardQuery{Dungeon: "d"}, 20, "p19", 1, LEADERBOARD_DEFAULT_LIMIT},
+		{"other dungeon", LeaderboardQuery{Dungeon: "e"}, 1, "p01", 1, 1},
+		{"around", LeaderboardQuery{Dungeon: "d", Around: "p10", Limit: 5}, 20, "p12", 1, 5},
+		{"around leader", LeaderboardQuery{Dungeon: "d", Around: "p19", Limit: 5}, 20, "p19", 1, 5},
+		{"around last", LeaderboardQuery{Dungeon: "d", Around: "p00", Limit: 5}, 20, "p04", 1, 5},
+		{"around unknown", LeaderboardQuery{Around: "nobody"}, 20, "", 0, 0},
+		{"limit capped", LeaderboardQuery{Limit: LEADERBOARD_MAX_LIMIT + 1}, 20, "p01", 2, 20},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message, err := leaderboard.Query(test.query, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (message.Total != test.total) || (len(message.Entries) != test.entries) {
+				t.Fatalf("Total %d, entries %d, expected %d and %d", message.Total, len(message.Entries), test.total, test.entries)
+			}
+			if (test.entries > 0) && ((message.Entries[0].Profile != test.first) || (message.Entries[0].Runs != test.runs)) {
+				t.Fatalf("First entry %+v, expected %s with %d runs", message.Entries[0], test.first, test.runs)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package gameserver
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+type Vector3Float struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// Точечный источник света объекта платформы
+type LightInfo struct {
+	Position Vector3Float `json:"position"` // смещение от объекта
+	Color    Vector3Float `json:"color"`    // цвет RGB, может быть больше 1
+	Radius   float64      `json:"radius"`   // радиус
+	Intense  float64      `json:"intense"`  // яркость
+}
+
+// Источники света по имени объекта
+func NewLightsFromReader(reader io.Reader) (map[string][]LightInfo, error) {
+	result := make(map[string][]LightInfo)
+	decoder := json.NewDecoder(reader)
+	err := decoder.Decode(&result)
+	return result, err
+}
+
+func NewLightsFromFile(filePath string) (map[string][]LightInfo, error) {
+	// Загрузка источников света из файла
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Println(err)
+		return make(map[string][]LightInfo), err
+	}
+	defer f.Close()
+
+	return NewLightsFromReader(f)
+}
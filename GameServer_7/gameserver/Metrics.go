@@ -0,0 +1,436 @@
+package gameserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Границы корзин гистограммы длительности тика в секундах
+var METRICS_TICK_BUCKETS = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25}
+
+// Границы корзин гистограммы RTT клиентов в секундах
+var METRICS_RTT_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.15, 0.25, 0.5, 1}
+
+const (
+	METRICS_LISTENER_PLAYER    = "player"
+	METRICS_LISTENER_SPECTATOR = "spectator"
+)
+
+// Гистограмма в формате Prometheus, корзины накопительные при выводе
+type MetricsHistogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func NewMetricsHistogram(buckets []float64) *MetricsHistogram {
+	histogram := &MetricsHistogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+	return histogram
+}
+
+func (histogram *MetricsHistogram) Observe(value float64) {
+	histogram.mutex.Lock()
+	for i, bound := range histogram.buckets {
+		if value <= bound {
+			histogram.counts[i]++
+			break
+		}
+	}
+	histogram.count++
+	histogram.sum += value
+	histogram.mutex.Unlock()
+}
+
+func (histogram *MetricsHistogram) write(buffer *bytes.Buffer, name string, labels string) {
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+
+	separator := ""
+	if labels != "" {
+		separator = ","
+	}
+	cumulative := uint64(0)
+	for i, bound := range histogram.buckets {
+		cumulative += histogram.counts[i]
+		fmt.Fprintf(buffer, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, separator, formatMetricsFloat(bound), cumulative)
+	}
+	fmt.Fprintf(buffer, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, separator, histogram.count)
+	fmt.Fprintf(buffer, "%s_sum%s %s\n", name, wrapMetricsLabels(labels), formatMetricsFloat(histogram.sum))
+	fmt.Fprintf(buffer, "%s_count%s %d\n", name, wrapMetricsLabels(labels), histogram.count)
+}
+
+// Метрики одной арены. Все методы допускают nil, у арены при воспроизведении метрик нет
+type ArenaMetrics struct {
+	arenaId        uint32
+	tickDuration   *MetricsHistogram
+	ticks          uint64
+	clients        int32
+	spectators     int32
+	monsters       int32
+	bytesSent      uint64
+	messagesSent   uint64
+	queueFullDrops uint64
+	coalesced      uint64
+	rtt            int64 // среднее сглаженное RTT игроков в наносекундах
+	jitter         int64
+}
+
+func (metrics *ArenaMetrics) ObserveTick(duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.ticks, 1)
+	metrics.tickDuration.Observe(duration.Seconds())
+}
+
+func (metrics *ArenaMetrics) SetCounts(clients, spectators, monsters int) {
+	if metrics == nil {
+		return
+	}
+	atomic.StoreInt32(&metrics.clients, int32(clients))
+	atomic.StoreInt32(&metrics.spectators, int32(spectators))
+	atomic.StoreInt32(&metrics.monsters, int32(monsters))
+}
+
+func (metrics *ArenaMetrics) SetLatency(rtt, jitter time.Duration) {
+	if metrics == nil {
+		return
+	}
+	atomic.StoreInt64(&metrics.rtt, int64(rtt))
+	atomic.StoreInt64(&metrics.jitter, int64(jitter))
+}
+
+func (metrics *ArenaMetrics) addSent(bytesCount int) {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.bytesSent, uint64(bytesCount))
+	atomic.AddUint64(&metrics.messagesSent, 1)
+}
+
+func (metrics *ArenaMetrics) addQueueFullDrop() {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.queueFullDrops, 1)
+}
+
+func (metrics *ArenaMetrics) addCoalesced() {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.coalesced, 1)
+}
+
+// Метрики сервера, отдаются в текстовом формате Prometheus. Все методы допускают nil
+type ServerMetrics struct {
+	startTime          time.Time
+	tickDuration       *MetricsHistogram
+	clientRTT          *MetricsHistogram
+	arenasCreated      uint64
+	arenasClosed       uint64
+	playersAccepted    uint64
+	spectatorsAccepted uint64
+	bytesSent          uint64
+	bytesReceived      uint64
+	messagesSent       uint64
+	messagesReceived   uint64
+	queueFullDrops     uint64
+	coalesced          uint64
+	slowDisconnects    uint64
+	readErrors         uint64
+	writeErrors        uint64
+	inputDrops         uint64
+	skippedTicks       uint64
+	tlsHandshakeErrors uint64
+	arenasMutex        sync.RWMutex
+	arenas             map[uint32]*ArenaMetrics
+}
+
+func NewServerMetrics() *ServerMetrics {
+	metrics := &ServerMetrics{
+		startTime:    time.Now(),
+		tickDuration: NewMetricsHistogram(METRICS_TICK_BUCKETS),
+		clientRTT:    NewMetricsHistogram(METRICS_RTT_BUCKETS),
+		arenas:       make(map[uint32]*ArenaMetrics),
+	}
+	return metrics
+}
+
+func (metrics *ServerMetrics) RegisterArena(arenaId uint32) *ArenaMetrics {
+	if metrics == nil {
+		return nil
+	}
+	arenaMetrics := &ArenaMetrics{
+		arenaId:      arenaId,
+		tickDuration: NewMetricsHistogram(METRICS_TICK_BUCKETS),
+	}
+	metrics.arenasMutex.Lock()
+	metrics.arenas[arenaId] = arenaMetrics
+	metrics.arenasMutex.Unlock()
+	atomic.AddUint64(&metrics.arenasCreated, 1)
+	return arenaMetrics
+}
+
+func (metrics *ServerMetrics) UnregisterArena(arenaMetrics *ArenaMetrics) {
+	if (metrics == nil) || (arenaMetrics == nil) {
+		return
+	}
+	metrics.arenasMutex.Lock()
+	delete(metrics.arenas, arenaMetrics.arenaId)
+	metrics.arenasMutex.Unlock()
+	atomic.AddUint64(&metrics.arenasClosed, 1)
+}
+
+func (metrics *ServerMetrics) ObserveTick(arenaMetrics *ArenaMetrics, duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+	metrics.tickDuration.Observe(duration.Seconds())
+	arenaMetrics.ObserveTick(duration)
+}
+
+func (metrics *ServerMetrics) ObserveRTT(sample time.Duration) {
+	if metrics == nil {
+		return
+	}
+	metrics.clientRTT.Observe(sample.Seconds())
+}
+
+func (metrics *ServerMetrics) AddAccepted(listenerName string) {
+	if metrics == nil {
+		return
+	}
+	if listenerName == METRICS_LISTENER_SPECTATOR {
+		atomic.AddUint64(&metrics.spectatorsAccepted, 1)
+	} else {
+		atomic.AddUint64(&metrics.playersAccepted, 1)
+	}
+}
+
+func (metrics *ServerMetrics) AddSent(arenaMetrics *ArenaMetrics, bytesCount int) {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.bytesSent, uint64(bytesCount))
+	atomic.AddUint64(&metrics.messagesSent, 1)
+	arenaMetrics.addSent(bytesCount)
+}
+
+func (metrics *ServerMetrics) AddReceived(bytesCount int) {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.bytesReceived, uint64(bytesCount))
+	atomic.AddUint64(&metrics.messagesReceived, 1)
+}
+
+func (metrics *ServerMetrics) AddQueueFullDrop(arenaMetrics *ArenaMetrics) {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.queueFullDrops, 1)
+	arenaMetrics.addQueueFullDrop()
+}
+
+func (metrics *ServerMetrics) AddQueueCoalesced(arenaMetrics *ArenaMetrics) {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.coalesced, 1)
+	arenaMetrics.addCoalesced()
+}
+
+func (metrics *ServerMetrics) AddSlowDisconnect() {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.slowDisconnects, 1)
+}
+
+func (metrics *ServerMetrics) AddReadError() {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.readErrors, 1)
+}
+
+func (metrics *ServerMetrics) AddInputDrop() {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.inputDrops, 1)
+}
+
+func (metrics *ServerMetrics) AddSkippedTicks(count uint64) {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.skippedTicks, count)
+}
+
+func (metrics *ServerMetrics) AddTLSHandshakeError() {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.tlsHandshakeErrors, 1)
+}
+
+func (metrics *ServerMetrics) AddWriteError() {
+	if metrics == nil {
+		return
+	}
+	atomic.AddUint64(&metrics.writeErrors, 1)
+}
+
+// Текстовый формат Prometheus 0.0.4
+func (metrics *ServerMetrics) WriteText(buffer *bytes.Buffer) {
+	metrics.arenasMutex.RLock()
+	arenas := make([]*ArenaMetrics, 0, len(metrics.arenas))
+	for _, arenaMetrics := range metrics.arenas {
+		arenas = append(arenas, arenaMetrics)
+	}
+	metrics.arenasMutex.RUnlock()
+	sort.Slice(arenas, func(i, j int) bool {
+		return arenas[i].arenaId < arenas[j].arenaId
+	})
+
+	clients := int64(0)
+	spectators := int64(0)
+	for _, arenaMetrics := range arenas {
+		clients += int64(atomic.LoadInt32(&arenaMetrics.clients))
+		spectators += int64(atomic.LoadInt32(&arenaMetrics.spectators))
+	}
+
+	writeMetricsHeader(buffer, "gameserver_uptime_seconds", "gauge", "Seconds since server start.")
+	fmt.Fprintf(buffer, "gameserver_uptime_seconds %s\n", formatMetricsFloat(time.Since(metrics.startTime).Seconds()))
+
+	writeMetricsHeader(buffer, "gameserver_arenas_live", "gauge", "Arenas currently running.")
+	fmt.Fprintf(buffer, "gameserver_arenas_live %d\n", len(arenas))
+	writeMetricsHeader(buffer, "gameserver_arenas_created_total", "counter", "Arenas created since start.")
+	fmt.Fprintf(buffer, "gameserver_arenas_created_total %d\n", atomic.LoadUint64(&metrics.arenasCreated))
+	writeMetricsHeader(buffer, "gameserver_arenas_closed_total", "counter", "Arenas closed since start.")
+	fmt.Fprintf(buffer, "gameserver_arenas_closed_total %d\n", atomic.LoadUint64(&metrics.arenasClosed))
+
+	writeMetricsHeader(buffer, "gameserver_clients_live", "gauge", "Players currently in arenas.")
+	fmt.Fprintf(buffer, "gameserver_clients_live %d\n", clients)
+	writeMetricsHeader(buffer, "gameserver_spectators_live", "gauge", "Spectators currently watching arenas.")
+	fmt.Fprintf(buffer, "gameserver_spectators_live %d\n", spectators)
+
+	writeMetricsHeader(buffer, "gameserver_connections_accepted_total", "counter", "Accepted connections by listener.")
+	fmt.Fprintf(buffer, "gameserver_connections_accepted_total{listener=\"%s\"} %d\n", METRICS_LISTENER_PLAYER, atomic.LoadUint64(&metrics.playersAccepted))
+	fmt.Fprintf(buffer, "gameserver_connections_accepted_total{listener=\"%s\"} %d\n", METRICS_LISTENER_SPECTATOR, atomic.LoadUint64(&metrics.spectatorsAccepted))
+
+	writeMetricsHeader(buffer, "gameserver_bytes_sent_total", "counter", "Bytes written to client sockets.")
+	fmt.Fprintf(buffer, "gameserver_bytes_sent_total %d\n", atomic.LoadUint64(&metrics.bytesSent))
+	writeMetricsHeader(buffer, "gameserver_bytes_received_total", "counter", "Bytes read from client sockets.")
+	fmt.Fprintf(buffer, "gameserver_bytes_received_total %d\n", atomic.LoadUint64(&metrics.bytesReceived))
+	writeMetricsHeader(buffer, "gameserver_messages_sent_total", "counter", "Messages written to client sockets.")
+	fmt.Fprintf(buffer, "gameserver_messages_sent_total %d\n", atomic.LoadUint64(&metrics.messagesSent))
+	writeMetricsHeader(buffer, "gameserver_messages_received_total", "counter", "Messages read from client sockets.")
+	fmt.Fprintf(buffer, "gameserver_messages_received_total %d\n", atomic.LoadUint64(&metrics.messagesReceived))
+	writeMetricsHeader(buffer, "gameserver_queue_full_drops_total", "counter", "Messages dropped because the client send queue was full.")
+	fmt.Fprintf(buffer, "gameserver_queue_full_drops_total %d\n", atomic.LoadUint64(&metrics.queueFullDrops))
+	writeMetricsHeader(buffer, "gameserver_queue_coalesced_total", "counter", "State messages replaced by a newer state before they were sent.")
+	fmt.Fprintf(buffer, "gameserver_queue_coalesced_total %d\n", atomic.LoadUint64(&metrics.coalesced))
+	writeMetricsHeader(buffer, "gameserver_slow_disconnects_total", "counter", "Clients disconnected for staying behind their send queue.")
+	fmt.Fprintf(buffer, "gameserver_slow_disconnects_total %d\n", atomic.LoadUint64(&metrics.slowDisconnects))
+	writeMetricsHeader(buffer, "gameserver_read_errors_total", "counter", "Client connections closed by read errors.")
+	fmt.Fprintf(buffer, "gameserver_read_errors_total %d\n", atomic.LoadUint64(&metrics.readErrors))
+	writeMetricsHeader(buffer, "gameserver_write_errors_total", "counter", "Client connections closed by write errors.")
+	fmt.Fprintf(buffer, "gameserver_write_errors_total %d\n", atomic.LoadUint64(&metrics.writeErrors))
+	writeMetricsHeader(buffer, "gameserver_input_drops_total", "counter", "Client commands dropped as duplicate or out of order.")
+	fmt.Fprintf(buffer, "gameserver_input_drops_total %d\n", atomic.LoadUint64(&metrics.inputDrops))
+	writeMetricsHeader(buffer, "gameserver_skipped_ticks_total", "counter", "Ticks skipped by arenas that fell behind the catch-up limit.")
+	fmt.Fprintf(buffer, "gameserver_skipped_ticks_total %d\n", atomic.LoadUint64(&metrics.skippedTicks))
+	writeMetricsHeader(buffer, "gameserver_tls_handshake_errors_total", "counter", "Player and spectator connections closed by a failed TLS handshake.")
+	fmt.Fprintf(buffer, "gameserver_tls_handshake_errors_total %d\n", atomic.LoadUint64(&metrics.tlsHandshakeErrors))
+
+	writeMetricsHeader(buffer, "gameserver_tick_duration_seconds", "histogram", "Arena tick duration for all arenas.")
+	metrics.tickDuration.write(buffer, "gameserver_tick_duration_seconds", "")
+	writeMetricsHeader(buffer, "gameserver_client_rtt_seconds", "histogram", "Player round trip time measured by ping.")
+	metrics.clientRTT.write(buffer, "gameserver_client_rtt_seconds", "")
+
+	// Разбивка по аренам
+	writeMetricsHeader(buffer, "gameserver_arena_tick_duration_seconds", "histogram", "Arena tick duration.")
+	for _, arenaMetrics := range arenas {
+		arenaMetrics.tickDuration.write(buffer, "gameserver_arena_tick_duration_seconds", arenaMetrics.label())
+	}
+	writeMetricsHeader(buffer, "gameserver_arena_ticks_total", "counter", "Arena ticks.")
+	for _, arenaMetrics := range arenas {
+		fmt.Fprintf(buffer, "gameserver_arena_ticks_total{%s} %d\n", arenaMetrics.label(), atomic.LoadUint64(&arenaMetrics.ticks))
+	}
+	writeMetricsHeader(buffer, "gameserver_arena_clients", "gauge", "Players in arena.")
+	for _, arenaMetrics := range arenas {
+		fmt.Fprintf(buffer, "gameserver_arena_clients{%s} %d\n", arenaMetrics.label(), atomic.LoadInt32(&arenaMetrics.clients))
+	}
+	writeMetricsHeader(buffer, "gameserver_arena_spectators", "gauge", "Spectators in arena.")
+	for _, arenaMetrics := range arenas {
+		fmt.Fprintf(buffer, "gameserver_arena_spectators{%s} %d\n", arenaMetrics.label(), atomic.LoadInt32(&arenaMetrics.spectators))
+	}
+	writeMetricsHeader(buffer, "gameserver_arena_monsters", "gauge", "Monsters in arena.")
+	for _, arenaMetrics := range arenas {
+		fmt.Fprintf(buffer, "gameserver_arena_monsters{%s} %d\n", arenaMetrics.label(), atomic.LoadInt32(&arenaMetrics.monsters))
+	}
+	writeMetricsHeader(buffer, "gameserver_arena_bytes_sent_total", "counter", "Bytes sent to arena players and spectators.")
+	for _, arenaMetrics := range arenas {
+		fmt.Fprintf(buffer, "gameserver_arena_bytes_sent_total{%s} %d\n", arenaMetrics.label(), atomic.LoadUint64(&arenaMetrics.bytesSent))
+	}
+	writeMetricsHeader(buffer, "gameserver_arena_messages_sent_total", "counter", "Messages sent to arena players and spectators.")
+	for _, arenaMetrics := range arenas {
+		fmt.Fprintf(buffer, "gameserver_arena_messages_sent_total{%s} %d\n", arenaMetrics.label(), atomic.LoadUint64(&arenaMetrics.messagesSent))
+	}
+	writeMetricsHeader(buffer, "gameserver_arena_queue_full_drops_total", "counter", "Messages dropped for arena clients with a full send queue.")
+	for _, arenaMetrics := range arenas {
+		fmt.Fprintf(buffer, "gameserver_arena_queue_full_drops_total{%s} %d\n", arenaMetrics.label(), atomic.LoadUint64(&arenaMetrics.queueFullDrops))
+	}
+	writeMetricsHeader(buffer, "gameserver_arena_queue_coalesced_total", "counter", "State messages replaced before sending to arena clients.")
+	for _, arenaMetrics := range arenas {
+		fmt.Fprintf(buffer, "gameserver_arena_queue_coalesced_total{%s} %d\n", arenaMetrics.label(), atomic.LoadUint64(&arenaMetrics.coalesced))
+	}
+	writeMetricsHeader(buffer, "gameserver_arena_rtt_seconds", "gauge", "Average smoothed round trip time of arena players.")
+	for _, arenaMetrics := range arenas {
+		fmt.Fprintf(buffer, "gameserver_arena_rtt_seconds{%s} %s\n", arenaMetrics.label(), formatMetricsFloat(time.Duration(atomic.LoadInt64(&arenaMetrics.rtt)).Seconds()))
+	}
+	writeMetricsHeader(buffer, "gameserver_arena_jitter_seconds", "gauge", "Average round trip time deviation of arena players.")
+	for _, arenaMetrics := range arenas {
+		fmt.Fprintf(buffer, "gameserver_arena_jitter_seconds{%s} %s\n", arenaMetrics.label(), formatMetricsFloat(time.Duration(atomic.LoadInt64(&arenaMetrics.jitter)).Seconds()))
+	}
+}
+
+func (metrics *ServerMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buffer := &bytes.Buffer{}
+	metrics.WriteText(buffer)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buffer.Bytes())
+}
+
+func (metrics *ArenaMetrics) label() string {
+	return "arena=\"" + strconv.FormatUint(uint64(metrics.arenaId), 10) + "\""
+}
+
+func writeMetricsHeader(buffer *bytes.Buffer, name string, metricType string, help string) {
+	fmt.Fprintf(buffer, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func wrapMetricsLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatMetricsFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
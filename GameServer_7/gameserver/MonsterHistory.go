@@ -0,0 +1,88 @@
+package gameserver
+
+// Позиция и статус монстра на конце тика: убитый монстр еще виден клиентам до уборки
+type MonsterPosition struct {
+	ID    uint32
+	X     float64
+	Y     float64
+	Alive bool
+}
+
+// Позиции и статусы всех монстров, которые клиенты получили после тика Tick
+type MonsterSnapshot struct {
+	Tick      uint64
+	Positions []MonsterPosition
+}
+
+// История позиций и статусов монстров за последние тики для проверки ударов с учетом задержки клиента.
+// Кольцевой буфер, используется только из цикла арены
+type MonsterHistory struct {
+	snapshots []MonsterSnapshot
+	next      int // куда пишется следующий снимок
+	count     int
+}
+
+// size - сколько последних тиков хранится, не меньше одного
+func NewMonsterHistory(size int) *MonsterHistory {
+	if size < 1 {
+		size = 1
+	}
+	history := &MonsterHistory{
+		snapshots: make([]MonsterSnapshot, size),
+		next:      0,
+		count:     0,
+	}
+	return history
+}
+
+// Снимок позиций после тика, память старого снимка переиспользуется
+func (history *MonsterHistory) Record(tick uint64, monsters []ServerMonsterState) {
+	snapshot := &history.snapshots[history.next]
+	snapshot.Tick = tick
+	snapshot.Positions = snapshot.Positions[:0]
+	for i := range monsters {
+		position := MonsterPosition{
+			ID:    monsters[i].ID,
+			X:     monsters[i].X,
+			Y:     monsters[i].Y,
+			Alive: monsters[i].Status == MONSTER_STATE_STATUS_ALIVE,
+		}
+		snapshot.Positions = append(snapshot.Positions, position)
+	}
+	history.next = (history.next + 1) % len(history.snapshots)
+	if history.count < len(history.snapshots) {
+		history.count++
+	}
+}
+
+// Снимок для тика, который видел клиент. Тик старше истории ограничивается самым старым снимком,
+// для 0 и последнего тика возвращается nil - проверка идет по текущему состоянию
+func (history *MonsterHistory) Rewind(tick uint64) *MonsterSnapshot {
+	if (history == nil) || (history.count == 0) || (tick == 0) {
+		return nil
+	}
+	size := len(history.snapshots)
+	latest := &history.snapshots[(history.next-1+size)%size]
+	if tick >= latest.Tick {
+		return nil
+	}
+	oldest := &history.snapshots[(history.next-history.count+size)%size]
+	if tick <= oldest.Tick {
+		return oldest
+	}
+	// Снимки пишутся каждый тик подряд
+	return &history.snapshots[(history.next-1-int(latest.Tick-tick)+size)%size]
+}
+
+// Монстр в снимке, false - монстра тогда не было или снимка нет
+func (snapshot *MonsterSnapshot) Find(id uint32) (MonsterPosition, bool) {
+	if snapshot == nil {
+		return MonsterPosition{}, false
+	}
+	for i := range snapshot.Positions {
+		if snapshot.Positions[i].ID == id {
+			return snapshot.Positions[i], true
+		}
+	}
+	return MonsterPosition{}, false
+}
@@ -0,0 +1,74 @@
+package gameserver
+
+import "testing"
+
+func TestMonsterHistoryRewind(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		ticks    uint64 // записаны тики 1..ticks, монстр 7 на тике i стоит в x = i
+		tick     uint64
+		expected uint64 // тик снимка, 0 - nil
+	}{
+		{"empty history", 3, 0, 5, 0},
+		{"current tick", 3, 5, 0, 0},
+		{"latest tick", 3, 5, 5, 0},
+		{"future tick", 3, 5, 9, 0},
+		{"previous tick", 3, 5, 4, 4},
+		{"oldest kept tick", 3, 5, 3, 3},
+		{"older than history", 3, 5, 1, 3},
+		{"before wraparound", 5, 4, 2, 2},
+		{"size below one", 0, 2, 1, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history := NewMonsterHistory(test.size)
+			for tick := uint64(1); tick <= test.ticks; tick++ {
+				history.Record(tick, []ServerMonsterState{{ID: 7, X: float64(tick)}})
+			}
+			snapshot := history.Rewind(test.tick)
+			if test.expected == 0 {
+				if snapshot != nil {
+					t.Fatalf("Rewind(%d) = tick %d, expected nil", test.tick, snapshot.Tick)
+				}
+				return
+			}
+			if (snapshot == nil) || (snapshot.Tick != test.expected) {
+				t.Fatalf("Rewind(%d) = %+v, expected tick %d", test.tick, snapshot, test.expected)
+			}
+			position, ok := snapshot.Find(7)
+			if (ok == false) || (position.X != float64(test.expected)) {
+				t.Fatalf("Monster in snapshot %d: %+v", snapshot.Tick, position)
+			}
+			if _, ok := snapshot.Find(8); ok {
+				t.Fatal("Unknown monster found in snapshot")
+			}
+		})
+	}
+}
+
+// Статус монстра на тике сохраняется в снимке: убитый монстр еще виден, убранного уже нет
+func TestMonsterHistoryStatus(t *testing.T) {
+	history := NewMonsterHistory(4)
+	monster := NewServerMonsterState(5)
+	history.Record(1, []ServerMonsterState{monster})
+	monster.Status = MONSTER_STATE_STATUS_DEAD
+	history.Record(2, []ServerMonsterState{monster})
+	history.Record(3, nil)
+	history.Record(4, nil)
+	tests := []struct {
+		tick  uint64
+		found bool
+		alive bool
+	}{
+		{1, true, true},
+		{2, true, false},
+		{3, false, false},
+	}
+	for _, test := range tests {
+		position, ok := history.Rewind(test.tick).Find(5)
+		if (ok != test.found) || (position.Alive != test.alive) {
+			t.Fatalf("Monster at tick %d: %+v, found %t", test.tick, position, ok)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package gameserver
+
+import (
+	"encoding/json"
+)
+
+// Урон игрока по монстру
+type MonsterKillContributor struct {
+	ID     uint32 `json:"id"`
+	Damage uint32 `json:"damage"`
+}
+
+// Событие убийства монстра: убийца, все нанесшие урон и выпавшая добыча
+type MonsterKilled struct {
+	Type         string                   `json:"type"`
+	ArenaID      uint32                   `json:"arenaId"`
+	MonsterID    uint32                   `json:"monsterId"`
+	Name         string                   `json:"name"`
+	X            float64                  `json:"x"`
+	Y            float64                  `json:"y"`
+	KillerID     uint32                   `json:"killerId"`
+	Contributors []MonsterKillContributor `json:"contributors"`
+	Reward       uint32                   `json:"reward"`
+	Loot         []LootItem               `json:"loot"`
+}
+
+func NewMonsterKilled(arenaId uint32, monster *ServerMonsterState, killerId uint32) MonsterKilled {
+	event := MonsterKilled{
+		Type:         "MonsterKilled",
+		ArenaID:      arenaId,
+		MonsterID:    monster.ID,
+		Name:         monster.Name,
+		X:            monster.X,
+		Y:            monster.Y,
+		KillerID:     killerId,
+		Contributors: []MonsterKillContributor{},
+		Loot:         []LootItem{},
+	}
+	return event
+}
+
+func (event *MonsterKilled) ToBytes() ([]byte, error) {
+	return json.Marshal(event)
+}
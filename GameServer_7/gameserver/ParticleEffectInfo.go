@@ -0,0 +1,33 @@
+package gameserver
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+type ParticleEffectInfo struct {
+	Path  string  `json:"path"`  // путь к описанию эффекта на клиенте
+	Name  string  `json:"name"`  // имя эффекта
+	Scale float64 `json:"scale"` // масштаб
+}
+
+func NewParticleEffectsFromReader(reader io.Reader) (map[string]*ParticleEffectInfo, error) {
+	result := make(map[string]*ParticleEffectInfo)
+	decoder := json.NewDecoder(reader)
+	err := decoder.Decode(&result)
+	return result, err
+}
+
+func NewParticleEffectsFromFile(filePath string) (map[string]*ParticleEffectInfo, error) {
+	// Загрузка эффектов частиц из файла
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Println(err)
+		return make(map[string]*ParticleEffectInfo), err
+	}
+	defer f.Close()
+
+	return NewParticleEffectsFromReader(f)
+}
@@ -2,6 +2,7 @@ package gameserver
 
 import (
 	"log"
+	"math"
 	"math/rand"
 )
 
@@ -19,14 +20,25 @@ type PlatformMonster struct {
 }
 
 type PlatformObject struct {
-	Id  string  `json:"id"`
-	X   float64 `json:"x"`
-	Y   float64 `json:"y"`
-	Rot int8    `json:"r"`
+	Uid   uint32              `json:"uid"` // номер объекта на арене, при одинаковом seed одинаковый
+	Id    string              `json:"id"`
+	X     float64             `json:"x"`
+	Y     float64             `json:"y"`
+	Rot   int8                `json:"r"`
+	info  *PlatformObjectInfo // описание выбранного варианта
+	cells []Point16           // занятые ячейки платформы
+}
+
+// Ячейка платформы под объектом и тип ячейки из описания объекта
+type PlatformObjectCell struct {
+	X    int16
+	Y    int16
+	Type PlatformCellType
 }
 
 type Platform struct {
-	Info *PlatformInfo `json:"-"`
+	Info   *PlatformInfo `json:"-"`
+	random *rand.Rand    // генератор, общий для всей арены
 	// Pos and size
 	PosX   int16  `json:"x"`
 	PosY   int16  `json:"y"`
@@ -50,16 +62,19 @@ type Platform struct {
 	// Cells
 	Cells []PlatformCellType `json:"cells,omitempty"`
 	// Items
-	Objects   []PlatformObject `json:"objects,omitempty"` // TODO: ???
-	Blocks    []PlatformObject `json:"blocks,omitempty"`  // TODO: ???
-	HaveDecor bool             `json:"withDecor"`
+	Objects     []PlatformObject `json:"objects,omitempty"` // объекты на полу: стены, арки, столбы, гробы, свечи, декор
+	Blocks      []PlatformObject `json:"blocks,omitempty"`  // блоки пола
+	HaveDecor   bool             `json:"withDecor"`
+	objectCells []bool           // ячейки, занятые объектами
+	blockCells  []bool           // ячейки, занятые блоками
 }
 
-func NewPlatform(info *PlatformInfo, posX, posY int16, exits [4]int16, isBridge bool) *Platform {
+func NewPlatform(info *PlatformInfo, posX, posY int16, exits [4]int16, isBridge bool, random *rand.Rand) *Platform {
 	platform := &Platform{}
 
 	// Info
 	platform.Info = info
+	platform.random = random
 
 	// Pos and size
 	platform.PosX = posX
@@ -68,7 +83,7 @@ func NewPlatform(info *PlatformInfo, posX, posY int16, exits [4]int16, isBridge
 	platform.Height = info.Height
 
 	// Exit and enter
-    platform.ExitCoord = exits
+	platform.ExitCoord = exits
 	for i, coord := range platform.ExitCoord {
 		if coord != -1 {
 			dir := PlatformDir(i)
@@ -92,9 +107,9 @@ func NewPlatform(info *PlatformInfo, posX, posY int16, exits [4]int16, isBridge
 	platform.MonsterSpawnMin = info.SpawnMin
 	platform.MonsterSpawnMax = info.SpawnMax
 
-    // Monster names list
-    //platform.PossibleMonsters = make([]string, len(info.MonstersNames))
-    //copy(platform.PossibleMonsters, info.MonstersNames)
+	// Monster names list
+	//platform.PossibleMonsters = make([]string, len(info.MonstersNames))
+	//copy(platform.PossibleMonsters, info.MonstersNames)
 	platform.PossibleMonsters = append(platform.PossibleMonsters, info.MonstersNames...)
 
 	// Cells and walls
@@ -120,11 +135,10 @@ func getPortalCoord(dir PlatformDir, exit [4]int16) Point16 {
 	default:
 		return Point16{-1, -1}
 	}
-
-	return Point16{-1, -1}
 }
 
 func createCells(platform *Platform, isBridge bool) {
+	platform.clearObjects()
 	// TODO: разделить??
 	if isBridge {
 		makeBridgeCells(platform)
@@ -189,7 +203,7 @@ func makeBridgeCells(platform *Platform) {
 			}
 
 			if haveBlock {
-				platform.Blocks, _ = appendObjects(platform.Blocks, block3x3,
+				platform.appendBlock(block3x3,
 					float64(x), float64(y),
 					int8((x+y)&3), 3)
 			}
@@ -226,7 +240,7 @@ func makeBattleCells(platform *Platform) {
 	}
 
 	// Info
-    cellsCount := w*h
+	cellsCount := w * h
 	cellsInfo := make([]PlatformCellType, cellsCount)
 	cellsWalls := make([]PlatformCellType, cellsCount)
 	for i := uint16(0); i < cellsCount; i++ {
@@ -244,8 +258,7 @@ func makeBattleCells(platform *Platform) {
 	foundPath := false
 	for foundPath == false {
 		// Clear arrays
-		platform.Blocks = make([]PlatformObject, 0)
-		platform.Objects = make([]PlatformObject, 0)
+		platform.clearObjects()
 
 		// Clear info
 		for i := 0; i < PLATFORM_SIDE_SIZE*PLATFORM_SIDE_SIZE; i++ {
@@ -367,34 +380,33 @@ func createBlocks6x6(platform *Platform, cellInfo, cellWalls []PlatformCellType,
 			}
 
 			posTest := (y == PLATFORM_WORK_SIZE/2-PLATFORM_BLOCK_SIZE_3x3) && (x == PLATFORM_WORK_SIZE/2-PLATFORM_BLOCK_SIZE_3x3)
-			if (rand.Int()%2 == 0) || posTest || ((rand.Int()%2 == 0) && isExit) {
+			if (platform.random.Int()%2 == 0) || posTest || ((platform.random.Int()%2 == 0) && isExit) {
 				// TODO: править тут
-				newArray, item := appendObjects(platform.Blocks,
+				_, cells := platform.appendBlock(
 					block6x6,
 					float64(x), float64(y),
 					int8((x+y)&3), 3)
-				platform.Blocks = newArray
-
-				for yy := int16(0); yy < item.Height; yy++ {
-					for xx := int16(0); xx < item.Width; xx++ {
-						cellInfo[(y+yy)*PLATFORM_WORK_SIZE+(x+xx)] = CELL_TYPE_SPACE
-						if item.Cells[yy*item.Width+xx] == CELL_TYPE_BLOCK {
-							cellWalls[(y+yy)*PLATFORM_WORK_SIZE+(x+xx)] = CELL_TYPE_PIT
-						}
+
+				// под блоком пол, непроходимые ячейки блока - ямы
+				for _, cell := range cells {
+					index := cell.Y*int16(platform.Width) + cell.X
+					cellInfo[index] = CELL_TYPE_SPACE
+					if cell.Type == CELL_TYPE_BLOCK {
+						cellWalls[index] = CELL_TYPE_PIT
 					}
 				}
 
 				// если можем, то применяем декор
 				if ((y == PLATFORM_WORK_SIZE/2-PLATFORM_BLOCK_SIZE_3x3) &&
 					(x == PLATFORM_WORK_SIZE/2-PLATFORM_BLOCK_SIZE_3x3)) &&
-					(rand.Int()%3 == 0) {
+					(platform.random.Int()%3 == 0) {
 
-					platform.Objects, _ = appendObjects(platform.Objects,
+					item, _ := platform.appendObject(
 						platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_DECOR],
 						float64(x), float64(y),
 						0, 3)
 
-					platform.HaveDecor = true
+					platform.HaveDecor = item != nil
 				}
 			}
 		}
@@ -421,7 +433,7 @@ func createBlocks3x3(platform *Platform, cellInfo []PlatformCellType, block3x3 [
 	}
 	// Перемешивание
 	for i := range edges {
-		j := rand.Intn(i + 1)
+		j := platform.random.Intn(i + 1)
 		edges[i], edges[j] = edges[j], edges[i]
 	}
 
@@ -442,19 +454,19 @@ func createBlocks3x3(platform *Platform, cellInfo []PlatformCellType, block3x3 [
 					cellInfo[index] = CELL_TYPE_SPACE
 				}
 			}
-			if (rand.Int()%3 == 0) && (dir == DIR_EAST || dir == DIR_SOUTH) {
+			if (platform.random.Int()%3 == 0) && (dir == DIR_EAST || dir == DIR_SOUTH) {
 				direction := int8(1)
 				if (i & 1) != 0 {
 					direction = 0
 				}
-				platform.Blocks, _ = appendObjects(platform.Blocks,
+				platform.appendBlock(
 					platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_FLOOR],
 					float64(exit.X), float64(exit.Y),
 					direction,
 					3)
 			} else {
 				direction := int8((exit.X + exit.Y) & 3)
-				platform.Blocks, _ = appendObjects(platform.Blocks,
+				platform.appendBlock(
 					block3x3,
 					float64(exit.X), float64(exit.Y),
 					direction,
@@ -484,7 +496,7 @@ func createBlocks3x3(platform *Platform, cellInfo []PlatformCellType, block3x3 [
 		for searchComplete {
 			// Check1
 			check1 := false
-			check1 = check1 || (rand.Int()%2 == 0)
+			check1 = check1 || (platform.random.Int()%2 == 0)
 			check1 = check1 || (point.Y/PLATFORM_BLOCK_SIZE_6x6 == center.Y/PLATFORM_BLOCK_SIZE_6x6)
 			check1 = check1 || (point.X >= (PLATFORM_WORK_SIZE - PLATFORM_BLOCK_SIZE_3x3))
 			// Check2
@@ -526,7 +538,7 @@ func createBlocks3x3(platform *Platform, cellInfo []PlatformCellType, block3x3 [
 // TODO: Пробрасывается ли указатель в cellInfo + cellsWals??
 func createArches(platform *Platform, cellInfo, cellsWalls []PlatformCellType) {
 	for i := 0; i < 4; i++ {
-		if rand.Int()%2 == 0 {
+		if platform.random.Int()%2 == 0 {
 			continue
 		}
 
@@ -550,68 +562,72 @@ func createArches(platform *Platform, cellInfo, cellsWalls []PlatformCellType) {
 		check1 := (y > 1) && (cellInfo[(y-4)*int16(platform.Width)+x] == CELL_TYPE_SPACE)
 		check2 := (y != (PLATFORM_WORK_SIZE - 2)) && (cellInfo[(y+2)*int16(platform.Width)+x] == CELL_TYPE_SPACE)
 		if (dir == DIR_WEST) && check1 && check2 {
-			platform.Objects, _ = appendObjects(platform.Objects,
+			item, _ := platform.appendObject(
 				platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_ARCHE],
 				float64(x), float64(y)-2.5,
 				int8(DIR_NORTH), 3)
-
-			cellsWalls[(y-4)*int16(platform.Width)+x] = CELL_TYPE_WALL
-			cellsWalls[(y-3)*int16(platform.Width)+x] = CELL_TYPE_WALL
-			cellsWalls[(y-2)*int16(platform.Width)+x] = CELL_TYPE_WALL
-			cellsWalls[(y+2)*int16(platform.Width)+x] = CELL_TYPE_WALL
-			cellsWalls[(y+3)*int16(platform.Width)+x] = CELL_TYPE_WALL
-			cellsWalls[(y+4)*int16(platform.Width)+x] = CELL_TYPE_WALL
+			if item != nil {
+				cellsWalls[(y-4)*int16(platform.Width)+x] = CELL_TYPE_WALL
+				cellsWalls[(y-3)*int16(platform.Width)+x] = CELL_TYPE_WALL
+				cellsWalls[(y-2)*int16(platform.Width)+x] = CELL_TYPE_WALL
+				cellsWalls[(y+2)*int16(platform.Width)+x] = CELL_TYPE_WALL
+				cellsWalls[(y+3)*int16(platform.Width)+x] = CELL_TYPE_WALL
+				cellsWalls[(y+4)*int16(platform.Width)+x] = CELL_TYPE_WALL
+			}
 			continue
 		}
 
 		//check1 = (y > 1) && (cellInfo[(y-4)*int16(platform.Width) + x] == CELL_TYPE_SPACE)
 		//check2 = (y != (PLATFORM_WORK_SIZE-2)) && (cellInfo[(y + 2)*int16(platform.Width)+x] == CELL_TYPE_SPACE)
 		if (dir == DIR_EAST) && check1 && check2 {
-			platform.Objects, _ = appendObjects(platform.Objects,
+			item, _ := platform.appendObject(
 				platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_ARCHE],
 				float64(x)-2, float64(y)+0.5,
 				int8(DIR_SOUTH), 3)
-
-			cellsWalls[(y-4)*int16(platform.Width)+x] = CELL_TYPE_WALL
-			cellsWalls[(y-3)*int16(platform.Width)+x] = CELL_TYPE_WALL
-			cellsWalls[(y-2)*int16(platform.Width)+x] = CELL_TYPE_WALL
-			cellsWalls[(y+2)*int16(platform.Width)+x] = CELL_TYPE_WALL
-			cellsWalls[(y+3)*int16(platform.Width)+x] = CELL_TYPE_WALL
-			cellsWalls[(y+4)*int16(platform.Width)+x] = CELL_TYPE_WALL
+			if item != nil {
+				cellsWalls[(y-4)*int16(platform.Width)+x] = CELL_TYPE_WALL
+				cellsWalls[(y-3)*int16(platform.Width)+x] = CELL_TYPE_WALL
+				cellsWalls[(y-2)*int16(platform.Width)+x] = CELL_TYPE_WALL
+				cellsWalls[(y+2)*int16(platform.Width)+x] = CELL_TYPE_WALL
+				cellsWalls[(y+3)*int16(platform.Width)+x] = CELL_TYPE_WALL
+				cellsWalls[(y+4)*int16(platform.Width)+x] = CELL_TYPE_WALL
+			}
 			continue
 		}
 
 		check1 = (x > 1) && (cellInfo[y*int16(platform.Width)+(x-4)] == CELL_TYPE_SPACE)
 		check2 = (x != (PLATFORM_WORK_SIZE - 2)) && (cellInfo[y*int16(platform.Width)+(x+2)] == CELL_TYPE_SPACE)
 		if (dir == DIR_NORTH) && check1 && check2 {
-			platform.Objects, _ = appendObjects(platform.Objects,
+			item, _ := platform.appendObject(
 				platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_ARCHE],
 				float64(x)-2.5, float64(y)-1.5,
 				int8(DIR_EAST), 3)
-
-			cellsWalls[y*int16(platform.Width)+(x-4)] = CELL_TYPE_WALL
-			cellsWalls[y*int16(platform.Width)+(x-3)] = CELL_TYPE_WALL
-			cellsWalls[y*int16(platform.Width)+(x-2)] = CELL_TYPE_WALL
-			cellsWalls[y*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
-			cellsWalls[y*int16(platform.Width)+(x+3)] = CELL_TYPE_WALL
-			cellsWalls[y*int16(platform.Width)+(x+4)] = CELL_TYPE_WALL
+			if item != nil {
+				cellsWalls[y*int16(platform.Width)+(x-4)] = CELL_TYPE_WALL
+				cellsWalls[y*int16(platform.Width)+(x-3)] = CELL_TYPE_WALL
+				cellsWalls[y*int16(platform.Width)+(x-2)] = CELL_TYPE_WALL
+				cellsWalls[y*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+				cellsWalls[y*int16(platform.Width)+(x+3)] = CELL_TYPE_WALL
+				cellsWalls[y*int16(platform.Width)+(x+4)] = CELL_TYPE_WALL
+			}
 			continue
 		}
 
 		//check1 = (x > 1) && (cellInfo[y*int16(platform.Width) + (x-4)] == CELL_TYPE_SPACE)
 		//check2 = (x != (PLATFORM_WORK_SIZE-2)) && (cellInfo[y*int16(platform.Width)+(x+2)] == CELL_TYPE_SPACE)
 		if (dir == DIR_SOUTH) && check1 && check2 {
-			platform.Objects, _ = appendObjects(platform.Objects,
+			item, _ := platform.appendObject(
 				platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_ARCHE],
 				float64(x)+0.5, float64(y)-0.5,
 				int8(DIR_WEST), 3)
-
-			cellsWalls[y*int16(platform.Width)+(x-4)] = CELL_TYPE_WALL
-			cellsWalls[y*int16(platform.Width)+(x-3)] = CELL_TYPE_WALL
-			cellsWalls[y*int16(platform.Width)+(x-2)] = CELL_TYPE_WALL
-			cellsWalls[y*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
-			cellsWalls[y*int16(platform.Width)+(x+3)] = CELL_TYPE_WALL
-			cellsWalls[y*int16(platform.Width)+(x+4)] = CELL_TYPE_WALL
+			if item != nil {
+				cellsWalls[y*int16(platform.Width)+(x-4)] = CELL_TYPE_WALL
+				cellsWalls[y*int16(platform.Width)+(x-3)] = CELL_TYPE_WALL
+				cellsWalls[y*int16(platform.Width)+(x-2)] = CELL_TYPE_WALL
+				cellsWalls[y*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+				cellsWalls[y*int16(platform.Width)+(x+3)] = CELL_TYPE_WALL
+				cellsWalls[y*int16(platform.Width)+(x+4)] = CELL_TYPE_WALL
+			}
 			continue
 		}
 	}
@@ -662,15 +678,16 @@ func createWalls(platform *Platform, cellInfo, cellsWalls []PlatformCellType) {
 				test3 := (y == 0) || (cellInfo[(y-PLATFORM_BLOCK_SIZE_3x3)*int16(platform.Width)+x] == CELL_TYPE_BLOCK)
 
 				if test1 && test2 && test3 {
-					platform.Objects, _ = appendObjects(platform.Objects,
+					item, _ := platform.appendObject(
 						platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_CORNER],
 						float64(x), float64(y), 0, 3)
-
-					cellsWalls[(y+0)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
-					cellsWalls[(y+1)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
-					cellsWalls[(y+0)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
-					cellsWalls[(y+0)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+					if item != nil {
+						cellsWalls[(y+0)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
+						cellsWalls[(y+1)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
+						cellsWalls[(y+0)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
+						cellsWalls[(y+0)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+					}
 					continue
 				}
 			}
@@ -683,16 +700,17 @@ func createWalls(platform *Platform, cellInfo, cellsWalls []PlatformCellType) {
 					(cellInfo[(y-PLATFORM_BLOCK_SIZE_3x3)*int16(platform.Width)+x] == CELL_TYPE_BLOCK)
 
 				if test1 && test2 && test3 {
-					platform.Objects, _ = appendObjects(platform.Objects,
+					item, _ := platform.appendObject(
 						platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_CORNER],
 						float64(x), float64(y),
 						3, 3)
-
-					cellsWalls[(y+0)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
-					cellsWalls[(y+1)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
-					cellsWalls[(y+0)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
-					cellsWalls[(y+0)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
+					if item != nil {
+						cellsWalls[(y+0)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+						cellsWalls[(y+1)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+						cellsWalls[(y+0)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
+						cellsWalls[(y+0)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
+					}
 
 					continue
 				}
@@ -706,16 +724,17 @@ func createWalls(platform *Platform, cellInfo, cellsWalls []PlatformCellType) {
 					(cellInfo[(y+PLATFORM_BLOCK_SIZE_3x3)*int16(platform.Width)+x] == CELL_TYPE_BLOCK)
 
 				if test1 && test2 && test3 {
-					platform.Objects, _ = appendObjects(platform.Objects,
+					item, _ := platform.appendObject(
 						platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_CORNER],
 						float64(x), float64(y),
 						2, 3)
-
-					cellsWalls[(y+0)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
-					cellsWalls[(y+1)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
+					if item != nil {
+						cellsWalls[(y+0)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+						cellsWalls[(y+1)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
+					}
 
 					continue
 				}
@@ -729,16 +748,17 @@ func createWalls(platform *Platform, cellInfo, cellsWalls []PlatformCellType) {
 					(cellInfo[(y+PLATFORM_BLOCK_SIZE_3x3)*int16(platform.Width)+x] == CELL_TYPE_BLOCK)
 
 				if test1 && test2 && test3 {
-					platform.Objects, _ = appendObjects(platform.Objects,
+					item, _ := platform.appendObject(
 						platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_CORNER],
 						float64(x), float64(y),
 						1, 3)
-
-					cellsWalls[(y+0)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
-					cellsWalls[(y+1)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+					if item != nil {
+						cellsWalls[(y+0)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
+						cellsWalls[(y+1)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+					}
 
 					continue
 				}
@@ -754,14 +774,15 @@ func createWalls(platform *Platform, cellInfo, cellsWalls []PlatformCellType) {
 					(cellInfo[(y+PLATFORM_BLOCK_SIZE_3x3)*int16(platform.Width)+x] == CELL_TYPE_SPACE)
 
 				if test1 && test2 && test3 && test4 {
-					platform.Objects, _ = appendObjects(platform.Objects,
+					item, _ := platform.appendObject(
 						platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_WALL],
 						float64(x), float64(y),
 						0, 3)
-
-					cellsWalls[(y+0)*int16(platform.Width)+x] = CELL_TYPE_WALL
-					cellsWalls[(y+1)*int16(platform.Width)+x] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+x] = CELL_TYPE_WALL
+					if item != nil {
+						cellsWalls[(y+0)*int16(platform.Width)+x] = CELL_TYPE_WALL
+						cellsWalls[(y+1)*int16(platform.Width)+x] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+x] = CELL_TYPE_WALL
+					}
 
 					continue
 				}
@@ -777,14 +798,15 @@ func createWalls(platform *Platform, cellInfo, cellsWalls []PlatformCellType) {
 					(cellInfo[(y+PLATFORM_BLOCK_SIZE_3x3)*int16(platform.Width)+x] == CELL_TYPE_SPACE)
 
 				if test1 && test2 && test3 && test4 {
-					platform.Objects, _ = appendObjects(platform.Objects,
+					item, _ := platform.appendObject(
 						platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_WALL],
 						float64(x), float64(y),
 						2, 3)
-
-					cellsWalls[(y+0)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
-					cellsWalls[(y+1)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+					if item != nil {
+						cellsWalls[(y+0)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+						cellsWalls[(y+1)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+					}
 
 					continue
 				}
@@ -800,14 +822,15 @@ func createWalls(platform *Platform, cellInfo, cellsWalls []PlatformCellType) {
 					(cellInfo[y*int16(platform.Width)+(x+PLATFORM_BLOCK_SIZE_3x3)] == CELL_TYPE_SPACE)
 
 				if test1 && test2 && test3 && test4 {
-					platform.Objects, _ = appendObjects(platform.Objects,
+					item, _ := platform.appendObject(
 						platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_WALL],
 						float64(x), float64(y),
 						3, 3)
-
-					cellsWalls[(y+0)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
-					cellsWalls[(y+0)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
-					cellsWalls[(y+0)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+					if item != nil {
+						cellsWalls[(y+0)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
+						cellsWalls[(y+0)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
+						cellsWalls[(y+0)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+					}
 
 					continue
 				}
@@ -823,14 +846,15 @@ func createWalls(platform *Platform, cellInfo, cellsWalls []PlatformCellType) {
 					(cellInfo[y*int16(platform.Width)+(x+PLATFORM_BLOCK_SIZE_3x3)] == CELL_TYPE_SPACE)
 
 				if test1 && test2 && test3 && test4 {
-					platform.Objects, _ = appendObjects(platform.Objects,
+					item, _ := platform.appendObject(
 						platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_WALL],
 						float64(x), float64(y),
 						1, 3)
-
-					cellsWalls[(y+2)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
-					cellsWalls[(y+2)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+					if item != nil {
+						cellsWalls[(y+2)*int16(platform.Width)+(x+0)] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+(x+1)] = CELL_TYPE_WALL
+						cellsWalls[(y+2)*int16(platform.Width)+(x+2)] = CELL_TYPE_WALL
+					}
 				}
 			}
 
@@ -894,13 +918,13 @@ func createPlatformElements(platform *Platform, cellInfo []PlatformCellType) {
 
 	// Shuffle
 	for i := range empty {
-		j := rand.Intn(i + 1)
+		j := platform.random.Intn(i + 1)
 		empty[i], empty[j] = empty[j], empty[i]
 	}
 
-	pills := rand.Int() % 5
-	coffs := 1 + rand.Int()%2
-	env := rand.Int()%3 + 1
+	pills := platform.random.Int() % 5
+	coffs := 1 + platform.random.Int()%2
+	env := platform.random.Int()%3 + 1
 
 	is := 0
 	if len(empty) < (pills + env) {
@@ -936,7 +960,7 @@ func createCoffins(platform *Platform, point Point16, cellInfo []PlatformCellTyp
 	x := point.X
 	y := point.Y
 
-	// TODO: Test
+	// соседние блоки проверяются слева и сверху, они должны быть на платформе
 	if (y < PLATFORM_BLOCK_SIZE_3x3) || (x < PLATFORM_BLOCK_SIZE_3x3) {
 		return false
 	}
@@ -953,23 +977,14 @@ func createCoffins(platform *Platform, point Point16, cellInfo []PlatformCellTyp
 		// ничего не делаем
 		return false
 	} else {
-		if len(platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_COFFIN]) > 0 {
-			objects, item := appendObjects(platform.Objects,
-				platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_COFFIN],
-				float64(x), float64(y),
-				int8(rand.Int()%4),
-				1.0)
-			platform.Objects = objects
-
-			for yy := int16(0); yy < item.Width; yy++ {
-				for xx := int16(0); xx < item.Height; xx++ {
-					cellInfo[(y+yy)*w+(x+xx)] = CELL_TYPE_WALL
-				}
-			}
-		}
-		return true
+		item, cells := platform.appendObject(
+			platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_COFFIN],
+			float64(x), float64(y),
+			int8(platform.random.Int()%4),
+			1.0)
+		fillCells(platform, cellInfo, cells, CELL_TYPE_WALL)
+		return item != nil
 	}
-	return false
 }
 
 func createPillars(platform *Platform, point Point16, cellInfo []PlatformCellType) bool {
@@ -979,7 +994,7 @@ func createPillars(platform *Platform, point Point16, cellInfo []PlatformCellTyp
 	ww := int16(PLATFORM_WORK_SIZE)
 	hh := int16(PLATFORM_WORK_SIZE)
 
-	// TODO: Test
+	// соседние блоки проверяются слева и сверху, они должны быть на платформе
 	if (y < PLATFORM_BLOCK_SIZE_3x3) || (x < PLATFORM_BLOCK_SIZE_3x3) {
 		return false
 	}
@@ -993,16 +1008,12 @@ func createPillars(platform *Platform, point Point16, cellInfo []PlatformCellTyp
 		// ничего не делаем
 		return false
 	} else {
-		platform.Objects, _ = appendObjects(platform.Objects,
+		item, cells := platform.appendObject(
 			platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_PILLAR],
-			float64(x), float64(y), int8(rand.Int()%4), 2.0)
-		cellInfo[(y+0)*w+x+0] = CELL_TYPE_WALL
-		cellInfo[(y+0)*w+x+1] = CELL_TYPE_WALL
-		cellInfo[(y+1)*w+x+0] = CELL_TYPE_WALL
-		cellInfo[(y+1)*w+x+1] = CELL_TYPE_WALL
-		return true
+			float64(x), float64(y), int8(platform.random.Int()%4), 2.0)
+		fillCells(platform, cellInfo, cells, CELL_TYPE_WALL)
+		return item != nil
 	}
-	return false
 }
 
 func createEnvironment(platform *Platform, point Point16, cellInfo []PlatformCellType) bool {
@@ -1012,7 +1023,7 @@ func createEnvironment(platform *Platform, point Point16, cellInfo []PlatformCel
 	ww := int16(PLATFORM_WORK_SIZE)
 	hh := int16(PLATFORM_WORK_SIZE)
 
-	// TODO: Test
+	// соседние блоки проверяются слева и сверху, они должны быть на платформе
 	if (y < PLATFORM_BLOCK_SIZE_3x3) || (x < PLATFORM_BLOCK_SIZE_3x3) {
 		return false
 	}
@@ -1042,50 +1053,76 @@ func createEnvironment(platform *Platform, point Point16, cellInfo []PlatformCel
 		if nearWall == false {
 			offset.X += 0.5
 			offset.Y += 0.5
-			cellInfo[(y+1)*w+x+1] = CELL_TYPE_WALL
 		}
 
-		x = x + int16(offset.X)
-		y = y + int16(offset.Y)
-
-		platform.Objects, _ = appendObjects(platform.Objects,
+		item, _ := platform.appendObject(
 			platform.Info.ObjectsByType[PLATFORM_OBJ_TYPE_ENVIRONMENT],
-			float64(x), float64(y), 0,
+			float64(x)+offset.X, float64(y)+offset.Y, 0,
 			3)
+		if item == nil {
+			return false
+		}
+		if nearWall == false {
+			cellInfo[(y+1)*w+x+1] = CELL_TYPE_WALL
+		}
 		return true
 	}
-	return false
 }
 
-// TODO: В качестве параметра float x,y???
-func appendObjects(container []PlatformObject, objects []*PlatformObjectInfo, x, y float64, rot int8, size int16) ([]PlatformObject, *PlatformObjectInfo) {
-	if len(objects) == 0 {
-		return container, nil
+// Ячейки объекта на платформе: слева сверху от (x, y) в квадрате size x size, повернутые на rot
+func objectCells(info *PlatformObjectInfo, x, y float64, rot int8, size int16) []PlatformObjectCell {
+	baseX := int16(math.Floor(x))
+	baseY := int16(math.Floor(y))
+	cells := make([]PlatformObjectCell, 0, info.Width*info.Height)
+	for ly := int16(0); ly < info.Height; ly++ {
+		for lx := int16(0); lx < info.Width; lx++ {
+			cell := PlatformObjectCell{Type: info.Cells[ly*info.Width+lx]}
+			switch rot & 3 {
+			case 0:
+				cell.X, cell.Y = lx, ly
+			case 1:
+				cell.X, cell.Y = ly, size-1-lx
+			case 2:
+				cell.X, cell.Y = size-1-lx, size-1-ly
+			case 3:
+				cell.X, cell.Y = size-1-ly, lx
+			}
+			cell.X += baseX
+			cell.Y += baseY
+			cells = append(cells, cell)
+		}
 	}
+	return cells
+}
 
-	var selectedItem *PlatformObjectInfo = nil
-
-	// Random probability
+// Выбор варианта по вероятностям: вероятность - доля варианта, если сумма меньше 1, то остаток - шанс не ставить ничего
+func selectObject(random *rand.Rand, objects []*PlatformObjectInfo) *PlatformObjectInfo {
+	if len(objects) == 0 {
+		return nil
+	}
 	sumProb := 0
 	for i := range objects {
 		sumProb += int(objects[i].Probability * 100)
 	}
-	randVal := rand.Int() % sumProb
+	randVal := random.Int() % int(math.Max(float64(sumProb), 100))
 
-	// Select random item
 	variant := 0
 	for i := range objects {
-		selected := (variant <= randVal) && (variant+int(objects[i].Probability*100) > randVal)
-		if selected {
-			selectedItem = objects[i]
-			break
-		} else {
-			variant += int(objects[i].Probability * 100)
+		prob := int(objects[i].Probability * 100)
+		if (variant <= randVal) && (variant+prob > randVal) {
+			return objects[i]
 		}
+		variant += prob
 	}
+	return nil
+}
 
+// Размещение случайного варианта объекта, если его ячейки на платформе свободны.
+// Ячейки за краем платформы не учитываются: арки стоят частично на мостах.
+func appendObjects(platform *Platform, container *[]PlatformObject, occupied []bool, objects []*PlatformObjectInfo, x, y float64, rot int8, size int16) (*PlatformObjectInfo, []PlatformObjectCell) {
+	selectedItem := selectObject(platform.random, objects)
 	if selectedItem == nil {
-		return container, nil
+		return nil, nil
 	}
 
 	// Max size
@@ -1097,6 +1134,25 @@ func appendObjects(container []PlatformObject, objects []*PlatformObjectInfo, x,
 	}
 	size = maxInt16(maxInt16(selectedItem.Width, selectedItem.Height), size)
 
+	// Footprint
+	w := int16(platform.Width)
+	h := int16(platform.Height)
+	cells := make([]PlatformObjectCell, 0, selectedItem.Width*selectedItem.Height)
+	for _, cell := range objectCells(selectedItem, x, y, rot, size) {
+		if (cell.X < 0) || (cell.Y < 0) || (cell.X >= w) || (cell.Y >= h) {
+			continue
+		}
+		if occupied[cell.Y*w+cell.X] {
+			return nil, nil
+		}
+		cells = append(cells, cell)
+	}
+	points := make([]Point16, len(cells))
+	for i, cell := range cells {
+		occupied[cell.Y*w+cell.X] = true
+		points[i] = NewPoint16(cell.X, cell.Y)
+	}
+
 	// Position
 	if rot == 1 {
 		y += float64(size)
@@ -1109,12 +1165,71 @@ func appendObjects(container []PlatformObject, objects []*PlatformObjectInfo, x,
 
 	// Append
 	object := PlatformObject{
-		Id:  selectedItem.Id,
-		X:   x,
-		Y:   y,
-		Rot: rot,
+		Id:    selectedItem.Id,
+		X:     x,
+		Y:     y,
+		Rot:   rot,
+		info:  selectedItem,
+		cells: points,
+	}
+	*container = append(*container, object)
+	return selectedItem, cells
+}
+
+func (platform *Platform) appendObject(objects []*PlatformObjectInfo, x, y float64, rot int8, size int16) (*PlatformObjectInfo, []PlatformObjectCell) {
+	return appendObjects(platform, &platform.Objects, platform.objectCells, objects, x, y, rot, size)
+}
+
+func (platform *Platform) appendBlock(objects []*PlatformObjectInfo, x, y float64, rot int8, size int16) (*PlatformObjectInfo, []PlatformObjectCell) {
+	return appendObjects(platform, &platform.Blocks, platform.blockCells, objects, x, y, rot, size)
+}
+
+// Ячейки под объектом становятся непроходимыми
+func fillCells(platform *Platform, cellInfo []PlatformCellType, cells []PlatformObjectCell, cellType PlatformCellType) {
+	for _, cell := range cells {
+		cellInfo[cell.Y*int16(platform.Width)+cell.X] = cellType
 	}
+}
+
+// Очистка объектов перед новой попыткой генерации
+func (platform *Platform) clearObjects() {
+	cellsCount := int(platform.Width) * int(platform.Height)
+	platform.Blocks = make([]PlatformObject, 0)
+	platform.Objects = make([]PlatformObject, 0)
+	platform.blockCells = make([]bool, cellsCount)
+	platform.objectCells = make([]bool, cellsCount)
+}
+
+// Точка выхода в координатах платформы, false - выхода в эту сторону нет
+func (platform *Platform) ExitPoint(dir PlatformDir) (Point16, bool) {
+	point := getPortalCoord(dir, platform.ExitCoord)
+	return point, (point.X != -1) && (point.Y != -1)
+}
 
-	container = append(container, object)
-	return container, selectedItem
+// Ячейки платформы под объектом. У загруженных из json объектов ячеек размещения нет,
+// они восстанавливаются по описанию, позиции и повороту, сдвиг поворота - по большей стороне объекта
+func (object *PlatformObject) Cells(info *PlatformObjectInfo) []Point16 {
+	if (len(object.cells) > 0) || (info == nil) {
+		return object.cells
+	}
+	size := info.Width
+	if info.Height > size {
+		size = info.Height
+	}
+	x := object.X
+	y := object.Y
+	if object.Rot == 1 {
+		y -= float64(size)
+	} else if object.Rot == 2 {
+		x -= float64(size)
+		y -= float64(size)
+	} else if object.Rot == 3 {
+		x -= float64(size)
+	}
+	cells := objectCells(info, x, y, object.Rot, size)
+	points := make([]Point16, len(cells))
+	for i, cell := range cells {
+		points[i] = NewPoint16(cell.X, cell.Y)
+	}
+	return points
 }
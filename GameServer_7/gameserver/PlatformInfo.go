@@ -85,29 +85,19 @@ func (info *PlatformInfo) handleLoadedInfo() {
 
 	// Формируем массивы ячеек для каждого объекта и блока
 	for i := range info.Objects {
-		obj := &(info.Objects[i])
-
-		oldCells := obj.Cells
-		obj.Cells = make([]PlatformCellType, obj.Width*obj.Height)
-		for i := int16(0); i < obj.Width*obj.Height; i++ {
-			if i < int16(len(oldCells)) {
-				obj.Cells[i] = oldCells[i]
-			} else {
-				obj.Cells[i] = CELL_TYPE_SPACE
-			}
-		}
+		info.Objects[i].makeCells()
 	}
 	for i := range info.Blocks {
-		obj := &(info.Blocks[i])
+		info.Blocks[i].makeCells()
+	}
+}
 
-		oldCells := obj.Cells
-		obj.Cells = make([]PlatformCellType, obj.Width*obj.Height)
-		for i := int16(0); i < obj.Width*obj.Height; i++ {
-			if i < int16(len(oldCells)) {
-				obj.Cells[i] = oldCells[i]
-			} else {
-				obj.Cells[i] = CELL_TYPE_SPACE
-			}
-		}
+// Размеры объектов из platform_objects_info.json
+func (info *PlatformInfo) ApplyObjectSizes(sizes map[string]*PlatformObjectSizeInfo) {
+	for i := range info.Objects {
+		info.Objects[i].applySize(sizes)
+	}
+	for i := range info.Blocks {
+		info.Blocks[i].applySize(sizes)
 	}
 }
@@ -1,5 +1,12 @@
 package gameserver
 
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
 type PlatformObjectType uint8
 
 const (
@@ -24,3 +31,62 @@ type PlatformObjectInfo struct {
 	Height      int16              `json:"sizeY"`
 	Cells       []PlatformCellType `json:"cells"`
 }
+
+// Размер объекта в ячейках из platform_objects_info.json
+type PlatformObjectSizeInfo struct {
+	Width  int16 `json:"sx"`
+	Height int16 `json:"sy"`
+}
+
+func NewPlatformObjectSizesFromReader(reader io.Reader) (map[string]*PlatformObjectSizeInfo, error) {
+	result := make(map[string]*PlatformObjectSizeInfo)
+	decoder := json.NewDecoder(reader)
+	err := decoder.Decode(&result)
+	return result, err
+}
+
+func NewPlatformObjectSizesFromFile(filePath string) (map[string]*PlatformObjectSizeInfo, error) {
+	// Загрузка размеров объектов из файла
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Println(err)
+		return make(map[string]*PlatformObjectSizeInfo), err
+	}
+	defer f.Close()
+
+	return NewPlatformObjectSizesFromReader(f)
+}
+
+// Ячейки объекта размером Width x Height, недостающие - свободное пространство
+func (obj *PlatformObjectInfo) makeCells() {
+	if obj.Width < 0 {
+		obj.Width = 0
+	}
+	if obj.Height < 0 {
+		obj.Height = 0
+	}
+	oldCells := obj.Cells
+	obj.Cells = make([]PlatformCellType, obj.Width*obj.Height)
+	for i := int16(0); i < obj.Width*obj.Height; i++ {
+		if i < int16(len(oldCells)) {
+			obj.Cells[i] = oldCells[i]
+		} else {
+			obj.Cells[i] = CELL_TYPE_SPACE
+		}
+	}
+}
+
+// Размер из общего списка для объектов, у которых он не задан в платформе
+func (obj *PlatformObjectInfo) applySize(sizes map[string]*PlatformObjectSizeInfo) {
+	size, exists := sizes[obj.Id]
+	if exists == false {
+		return
+	}
+	if (obj.Width == 0) && (obj.Height == 0) {
+		obj.Width = size.Width
+		obj.Height = size.Height
+		obj.makeCells()
+	} else if (obj.Width != size.Width) || (obj.Height != size.Height) {
+		log.Printf("Platform object %s size %dx%d differs from %dx%d\n", obj.Id, obj.Width, obj.Height, size.Width, size.Height)
+	}
+}
@@ -0,0 +1,273 @@
+package gameserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Соединение-заглушка для клиентов при воспроизведении, все отправленное выбрасывается
+type replayConn struct {
+}
+
+func (conn replayConn) ReadMessage() ([]byte, error)       { return nil, io.EOF }
+func (conn replayConn) WriteMessage(data []byte) error     { return nil }
+func (conn replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn replayConn) SetWriteDeadline(t time.Time) error { return nil }
+func (conn replayConn) RemoteAddr() net.Addr               { return nil }
+func (conn replayConn) Close() error                       { return nil }
+
+// Запись в память, состояния при воспроизведении сравниваются с записанными
+type replayBuffer struct {
+	bytes.Buffer
+}
+
+func (buffer *replayBuffer) Close() error {
+	return nil
+}
+
+// Расхождение состояния при воспроизведении
+type ReplayDiff struct {
+	Index       int
+	Tick        uint64
+	Differences []string
+}
+
+type ReplayResult struct {
+	Info           ReplayInfo
+	Ticks          uint64
+	States         int
+	ReplayedStates int
+	ArenaInfoDiffs []string
+	Diffs          []ReplayDiff
+}
+
+func (result *ReplayResult) IsMatched() bool {
+	return (len(result.ArenaInfoDiffs) == 0) && (len(result.Diffs) == 0) && (result.States == result.ReplayedStates)
+}
+
+// Воспроизведение файла записи через симуляцию арены
+func PlayReplayFile(filePath string) (*ReplayResult, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := ReadReplayRecords(f)
+	if err != nil {
+		return nil, err
+	}
+	return PlayReplay(records)
+}
+
+func PlayReplay(records []ReplayRecord) (*ReplayResult, error) {
+	if (len(records) == 0) || (records[0].Kind != REPLAY_RECORD_INFO) {
+		return nil, errors.New("No replay info record")
+	}
+
+	result := &ReplayResult{}
+	err := json.Unmarshal(records[0].Data, &result.Info)
+	if err != nil {
+		return nil, err
+	}
+
+	dungeon, exists := GetApp().GetStaticInfo().Dungeons[result.Info.Dungeon]
+	if exists == false {
+		return nil, fmt.Errorf("No dungeon with name %s", result.Info.Dungeon)
+	}
+
+	// Арена должна сгенерироваться такой же
+	arenaData, err := makeArenaData(dungeon, result.Info.Seed)
+	if err != nil {
+		return nil, err
+	}
+	result.ArenaInfoDiffs = diffJSON(result.Info.ArenaInfo, arenaData)
+
+	// Симуляция с настройками арены из записи, а не текущего сервера: остальные настройки на нее не влияют
+	config := *GetApp().GetConfig()
+	config.Arena = NewDefaultConfig().Arena
+	if result.Info.Arena != nil {
+		config.Arena = *result.Info.Arena
+	}
+
+	// Арена без сервера и без цикла, события подаются из записи
+	buffer := &replayBuffer{}
+	arena := newServerArena(&config, nil, result.Info.ArenaId, result.Info.Seed, dungeon, arenaData)
+	arena.startTime = result.Info.StartTime
+	arena.recorder = NewArenaRecorder(buffer)
+	defer func() {
+		for _, client := range arena.clients {
+			client.CloseAfterSend()
+		}
+		close(arena.doneCh)
+	}()
+
+	for i := 1; i < len(records); i++ {
+		record := records[i]
+		switch record.Kind {
+		case REPLAY_RECORD_JOIN:
+			client := NewClient(replayConn{}, arena)
+			client.id = record.ClientId
+			client.state.ID = record.ClientId
+			atomic.AddInt32(&arena.clientsCount, 1)
+			arena.addClient(client)
+			go client.loopWrite()
+
+		case REPLAY_RECORD_LEAVE:
+			client := arena.findClient(record.ClientId)
+			if client != nil {
+				arena.recorder.RecordLeave(arena.tick, client.id)
+				arena.removeClient(client)
+				client.CloseAfterSend()
+			}
+
+		case REPLAY_RECORD_TICK:
+			// Команды тика идут сразу за ним
+			commands := make([]arenaCommand, 0)
+			for (i+1 < len(records)) && (records[i+1].Kind == REPLAY_RECORD_COMMAND) {
+				i++
+				command, err := NewClientCommand(records[i].Data)
+				if err != nil {
+					return nil, err
+				}
+				commands = append(commands, arenaCommand{clientId: records[i].ClientId, command: command})
+			}
+			arena.runTick(record.Delta, commands)
+			result.Ticks++
+
+		case REPLAY_RECORD_MONSTER:
+			arena.recorder.RecordMonsterTimer(arena.tick)
+			arena.createMonster()
+
+		case REPLAY_RECORD_SPAWN:
+			monster := ServerMonsterState{}
+			err := json.Unmarshal(record.Data, &monster)
+			if err != nil {
+				return nil, err
+			}
+			monster = arena.spawnMonster(monster.Name, monster.Health, monster.X, monster.Y)
+			arena.recorder.RecordSpawn(arena.tick, monster)
+
+		case REPLAY_RECORD_MOVE:
+			monster := ServerMonsterState{}
+			err := json.Unmarshal(record.Data, &monster)
+			if err != nil {
+				return nil, err
+			}
+			monster, err = arena.moveMonster(monster.ID, monster.X, monster.Y)
+			if err != nil {
+				return nil, err
+			}
+			arena.recorder.RecordMove(arena.tick, monster)
+
+		case REPLAY_RECORD_COMPLETE:
+			arena.recorder.RecordComplete(arena.tick)
+			arena.completeDungeon()
+
+		case REPLAY_RECORD_SHUTDOWN:
+			arena.recorder.RecordShutdown(arena.tick)
+			arena.notifyShutdown()
+
+		case REPLAY_RECORD_STATE:
+			result.States++
+		}
+	}
+
+	// Сравнение разосланных состояний
+	err = arena.recorder.Close()
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := ReadReplayRecords(&buffer.Buffer)
+	if err != nil {
+		return nil, err
+	}
+	expected := filterReplayStates(records)
+	actual := filterReplayStates(replayed)
+	result.ReplayedStates = len(actual)
+
+	for i := 0; (i < len(expected)) && (i < len(actual)); i++ {
+		differences := diffJSON(expected[i].Data, actual[i].Data)
+		if expected[i].Tick != actual[i].Tick {
+			differences = append(differences, fmt.Sprintf("tick: %d != %d", expected[i].Tick, actual[i].Tick))
+		}
+		if len(differences) > 0 {
+			result.Diffs = append(result.Diffs, ReplayDiff{Index: i, Tick: expected[i].Tick, Differences: differences})
+		}
+	}
+
+	return result, nil
+}
+
+func filterReplayStates(records []ReplayRecord) []ReplayRecord {
+	states := make([]ReplayRecord, 0)
+	for _, record := range records {
+		if record.Kind == REPLAY_RECORD_STATE {
+			states = append(states, record)
+		}
+	}
+	return states
+}
+
+// Список различий двух JSON документов в виде "путь: ожидалось != получено"
+func diffJSON(expectedData, actualData []byte) []string {
+	var expected, actual interface{}
+	if err := json.Unmarshal(expectedData, &expected); err != nil {
+		return []string{fmt.Sprintf("expected: %s", err)}
+	}
+	if err := json.Unmarshal(actualData, &actual); err != nil {
+		return []string{fmt.Sprintf("actual: %s", err)}
+	}
+	return diffValues("", expected, actual, make([]string, 0))
+}
+
+func diffValues(path string, expected, actual interface{}, differences []string) []string {
+	switch expectedValue := expected.(type) {
+	case map[string]interface{}:
+		actualValue, ok := actual.(map[string]interface{})
+		if ok == false {
+			break
+		}
+		keys := make([]string, 0, len(expectedValue))
+		for key := range expectedValue {
+			keys = append(keys, key)
+		}
+		for key := range actualValue {
+			if _, exists := expectedValue[key]; exists == false {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			differences = diffValues(path+"."+key, expectedValue[key], actualValue[key], differences)
+		}
+		return differences
+
+	case []interface{}:
+		actualValue, ok := actual.([]interface{})
+		if ok == false {
+			break
+		}
+		if len(expectedValue) != len(actualValue) {
+			return append(differences, fmt.Sprintf("%s: len %d != %d", path, len(expectedValue), len(actualValue)))
+		}
+		for i := range expectedValue {
+			differences = diffValues(fmt.Sprintf("%s[%d]", path, i), expectedValue[i], actualValue[i], differences)
+		}
+		return differences
+	}
+
+	if reflect.DeepEqual(expected, actual) == false {
+		differences = append(differences, fmt.Sprintf("%s: %v != %v", path, expected, actual))
+	}
+	return differences
+}
@@ -0,0 +1,171 @@
+package gameserver
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Виды исходящих сообщений, для каждого своя политика при медленном клиенте
+type SendKind uint8
+
+const (
+	SEND_KIND_ARENA_STATE  SendKind = 0 // ArenaState
+	SEND_KIND_CLIENT_STATE SendKind = 1 // ClientState
+	SEND_KIND_RELIABLE     SendKind = 2 // ArenaInfo, MonsterKilled, ServerMessage об остановке
+	SEND_KIND_REPLY        SendKind = 3 // ответы на запросы: таблица рекордов, список арен; Ping
+	SEND_KIND_COUNT                 = 4
+)
+
+const (
+	SEND_POLICY_COALESCE = "coalesce" // в очереди остается только последнее сообщение этого вида
+	SEND_POLICY_DROP     = "drop"     // при полной очереди сообщение отбрасывается
+	SEND_POLICY_KEEP     = "keep"     // сообщение не отбрасывается, очередь может превысить предел
+)
+
+const CLIENT_SLOW_TIMEOUT = 5 * time.Second // по умолчанию, задается в Config
+
+type SendResult uint8
+
+const (
+	SEND_RESULT_QUEUED    SendResult = 0
+	SEND_RESULT_COALESCED SendResult = 1 // заменило неотправленное сообщение того же вида
+	SEND_RESULT_DROPPED   SendResult = 2
+	SEND_RESULT_SLOW      SendResult = 3 // клиент слишком долго не успевает, его нужно отключить
+	SEND_RESULT_CLOSED    SendResult = 4 // очередь закрывается, сообщение не нужно
+)
+
+func IsValidSendPolicy(policy string) bool {
+	return (policy == SEND_POLICY_COALESCE) || (policy == SEND_POLICY_DROP) || (policy == SEND_POLICY_KEEP)
+}
+
+type sendItem struct {
+	kind SendKind
+	data []byte
+}
+
+// Очередь отправки клиенту. Клиент отстает, если очередь больше предела или в ней заменялись
+// неотправленные сообщения, и перестает отставать, когда очередь опустела. Отстающий дольше
+// slowTimeout клиент считается медленным
+type SendQueue struct {
+	mutex       sync.Mutex
+	items       []sendItem
+	policies    [SEND_KIND_COUNT]string
+	limit       int
+	slowTimeout time.Duration
+	lagSince    time.Time // начало отставания, нулевое - клиент успевает
+	closing     bool      // после отправки очереди соединение закрывается
+	slow        bool
+	readyCh     chan struct{}
+	// Счетчики для админки
+	dropped   uint32
+	coalesced uint32
+}
+
+func NewSendQueue(config ClientConfig) *SendQueue {
+	queue := &SendQueue{
+		items:       make([]sendItem, 0, config.UpdateQueueSize),
+		limit:       config.UpdateQueueSize,
+		slowTimeout: config.SlowTimeout.Duration(),
+		readyCh:     make(chan struct{}, 1),
+	}
+	queue.policies[SEND_KIND_ARENA_STATE] = config.StatePolicy
+	queue.policies[SEND_KIND_CLIENT_STATE] = config.StatePolicy
+	queue.policies[SEND_KIND_RELIABLE] = config.ReliablePolicy
+	queue.policies[SEND_KIND_REPLY] = config.ReplyPolicy
+	return queue
+}
+
+// Постановка сообщения в очередь по политике его вида, now - для отсчета отставания
+func (queue *SendQueue) Push(kind SendKind, data []byte, now time.Time) SendResult {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.closing || queue.slow {
+		return SEND_RESULT_CLOSED
+	}
+
+	result := SEND_RESULT_QUEUED
+	switch queue.policies[kind] {
+	case SEND_POLICY_COALESCE:
+		// Старое сообщение убираем, новое ставим в конец, чтобы не обогнать сообщения между ними
+		for i := range queue.items {
+			if queue.items[i].kind == kind {
+				queue.items = append(queue.items[:i], queue.items[i+1:]...)
+				atomic.AddUint32(&queue.coalesced, 1)
+				result = SEND_RESULT_COALESCED
+				break
+			}
+		}
+	case SEND_POLICY_DROP:
+		if len(queue.items) >= queue.limit {
+			atomic.AddUint32(&queue.dropped, 1)
+			result = SEND_RESULT_DROPPED
+		}
+	}
+
+	if result != SEND_RESULT_DROPPED {
+		queue.items = append(queue.items, sendItem{kind: kind, data: data})
+		select {
+		case queue.readyCh <- struct{}{}:
+		default:
+		}
+	}
+
+	if (result != SEND_RESULT_QUEUED) || (len(queue.items) > queue.limit) {
+		if queue.lagSince.IsZero() {
+			queue.lagSince = now
+		}
+	}
+	if (queue.lagSince.IsZero() == false) && (now.Sub(queue.lagSince) >= queue.slowTimeout) {
+		queue.slow = true
+		return SEND_RESULT_SLOW
+	}
+	return result
+}
+
+// Следующее сообщение для отправки. Если сообщений нет и closing - очередь отправлена и соединение нужно закрыть
+func (queue *SendQueue) Pop() (data []byte, exists bool, closing bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if len(queue.items) == 0 {
+		queue.lagSince = time.Time{}
+		return nil, false, queue.closing
+	}
+	data = queue.items[0].data
+	queue.items[0].data = nil
+	queue.items = queue.items[1:]
+	if len(queue.items) == 0 {
+		queue.lagSince = time.Time{}
+	}
+	return data, true, false
+}
+
+// Сигнал писателю, что в очереди появились сообщения
+func (queue *SendQueue) ReadyCh() <-chan struct{} {
+	return queue.readyCh
+}
+
+// Закрытие соединения после отправки всего, что уже стоит в очереди
+func (queue *SendQueue) CloseAfterSend() {
+	queue.mutex.Lock()
+	queue.closing = true
+	queue.mutex.Unlock()
+	select {
+	case queue.readyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (queue *SendQueue) Len() int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return len(queue.items)
+}
+
+func (queue *SendQueue) GetDropped() uint32 {
+	return atomic.LoadUint32(&queue.dropped)
+}
+
+func (queue *SendQueue) GetCoalesced() uint32 {
+	return atomic.LoadUint32(&queue.coalesced)
+}
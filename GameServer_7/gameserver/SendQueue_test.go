@@ -0,0 +1,136 @@
+package gameserver
+
+import (
+	"testing"
+	"time"
+)
+
+type sendQueuePush struct {
+	kind     SendKind
+	data     string
+	expected SendResult
+}
+
+func TestSendQueuePolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		policy    string // для всех видов сообщений
+		pushes    []sendQueuePush
+		sent      []string
+		dropped   uint32
+		coalesced uint32
+	}{
+		{
+			name: "coalesce keeps last message of a kind", limit: 10, policy: SEND_POLICY_COALESCE,
+			pushes: []sendQueuePush{
+				{SEND_KIND_ARENA_STATE, "s1", SEND_RESULT_QUEUED},
+				{SEND_KIND_RELIABLE, "r1", SEND_RESULT_QUEUED},
+				{SEND_KIND_ARENA_STATE, "s2", SEND_RESULT_COALESCED},
+				{SEND_KIND_CLIENT_STATE, "c1", SEND_RESULT_QUEUED},
+			},
+			// новое состояние встает в конец и не обгоняет сообщения, пришедшие после старого
+			sent:      []string{"r1", "s2", "c1"},
+			coalesced: 1,
+		},
+		{
+			name: "drop when full", limit: 2, policy: SEND_POLICY_DROP,
+			pushes: []sendQueuePush{
+				{SEND_KIND_REPLY, "x1", SEND_RESULT_QUEUED},
+				{SEND_KIND_REPLY, "x2", SEND_RESULT_QUEUED},
+				{SEND_KIND_REPLY, "x3", SEND_RESULT_DROPPED},
+				{SEND_KIND_RELIABLE, "r1", SEND_RESULT_DROPPED},
+			},
+			sent:    []string{"x1", "x2"},
+			dropped: 2,
+		},
+		{
+			name: "keep over limit", limit: 1, policy: SEND_POLICY_KEEP,
+			pushes: []sendQueuePush{
+				{SEND_KIND_RELIABLE, "r1", SEND_RESULT_QUEUED},
+				{SEND_KIND_RELIABLE, "r2", SEND_RESULT_QUEUED},
+				{SEND_KIND_ARENA_STATE, "s1", SEND_RESULT_QUEUED},
+			},
+			sent: []string{"r1", "r2", "s1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultConfig().Client
+			config.UpdateQueueSize = test.limit
+			config.StatePolicy = test.policy
+			config.ReliablePolicy = test.policy
+			config.ReplyPolicy = test.policy
+			queue := NewSendQueue(config)
+			now := time.Now()
+			for _, push := range test.pushes {
+				if result := queue.Push(push.kind, []byte(push.data), now); result != push.expected {
+					t.Fatalf("Push %s: result %d, expected %d", push.data, result, push.expected)
+				}
+			}
+			for _, expected := range test.sent {
+				data, exists, _ := queue.Pop()
+				if (exists == false) || (string(data) != expected) {
+					t.Fatalf("Pop %q, expected %q", data, expected)
+				}
+			}
+			if _, exists, closing := queue.Pop(); exists || closing {
+				t.Fatal("Queue not empty after expected messages")
+			}
+			if (queue.GetDropped() != test.dropped) || (queue.GetCoalesced() != test.coalesced) {
+				t.Fatalf("Dropped %d, coalesced %d, expected %d and %d", queue.GetDropped(), queue.GetCoalesced(), test.dropped, test.coalesced)
+			}
+		})
+	}
+}
+
+// Отставание отсчитывается от переполнения и сбрасывается, когда очередь отправлена
+func TestSendQueueSlowTimeout(t *testing.T) {
+	const slowTimeout = time.Second
+	config := NewDefaultConfig().Client
+	config.UpdateQueueSize = 1
+	config.ReliablePolicy = SEND_POLICY_KEEP
+	config.SlowTimeout = ConfigDuration(slowTimeout)
+	queue := NewSendQueue(config)
+	clock := NewManualClock(time.Now())
+
+	steps := []struct {
+		advance  time.Duration
+		pops     int
+		expected SendResult
+	}{
+		{0, 0, SEND_RESULT_QUEUED},
+		{0, 0, SEND_RESULT_QUEUED}, // очередь больше предела, отставание с этого момента
+		{slowTimeout / 2, 0, SEND_RESULT_QUEUED},
+		{0, 3, SEND_RESULT_QUEUED},           // очередь отправлена, отставания нет
+		{slowTimeout, 0, SEND_RESULT_QUEUED}, // новое отставание
+		{0, 0, SEND_RESULT_QUEUED},
+		{slowTimeout - time.Millisecond, 0, SEND_RESULT_QUEUED},
+		{time.Millisecond, 0, SEND_RESULT_SLOW},
+		{0, 0, SEND_RESULT_CLOSED},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		for j := 0; j < step.pops; j++ {
+			queue.Pop()
+		}
+		if result := queue.Push(SEND_KIND_RELIABLE, []byte("r"), clock.Now()); result != step.expected {
+			t.Fatalf("Step %d: result %d, expected %d", i, result, step.expected)
+		}
+	}
+}
+
+func TestSendQueueCloseAfterSend(t *testing.T) {
+	queue := NewSendQueue(NewDefaultConfig().Client)
+	queue.Push(SEND_KIND_RELIABLE, []byte("r1"), time.Now())
+	queue.CloseAfterSend()
+	if result := queue.Push(SEND_KIND_RELIABLE, []byte("r2"), time.Now()); result != SEND_RESULT_CLOSED {
+		t.Fatalf("Push after close: result %d", result)
+	}
+	if data, exists, _ := queue.Pop(); (exists == false) || (string(data) != "r1") {
+		t.Fatalf("Pop %q after close, expected queued message", data)
+	}
+	if _, exists, closing := queue.Pop(); exists || (closing == false) {
+		t.Fatal("Queue not closing after sent messages")
+	}
+}
@@ -1,56 +1,293 @@
 package gameserver
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"log"
 	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xtaci/kcp-go"
+)
+
+const (
+	SERVER_LISTEN_ADDRESS           = ":9999" // адрес для игроков по умолчанию
+	SERVER_SPECTATOR_LISTEN_ADDRESS = ":9998" // адрес для наблюдателей по умолчанию
 )
 
+const (
+	SERVER_WEBSOCKET_PLAYER_PATH    = "/ws"        // путь WebSocket для игроков
+	SERVER_WEBSOCKET_SPECTATOR_PATH = "/spectator" // путь WebSocket для наблюдателей
+	SERVER_WEBSOCKET_HANDSHAKE      = 10 * time.Second
+)
+
+// Запрос наблюдателя на подключение к арене
+type spectateRequest struct {
+	client  *ServerClient
+	arenaId uint32
+}
+
+// Поиск восстановленной арены по токену сессии игрока
+type sessionRequest struct {
+	token    string
+	resultCh chan *ServerArena
+}
+
 type Server struct {
-	isActive       bool
-	listener       *net.TCPListener
-	listenerExitCh chan bool
-	loopExitCh     chan bool
-	gameRooms      map[uint32]*ServerArena
-	removeRoomCh   chan *ServerArena
-	makeClientCh   chan *net.TCPConn
+	config            *Config
+	isActive          bool
+	listener          *net.TCPListener
+	spectatorListener *net.TCPListener
+	webSocketListener net.Listener // nil - WebSocket выключен
+	webSocketServer   *http.Server
+	kcpListener       *kcp.Listener    // nil - KCP выключен
+	tlsCertificates   *TLSCertificates // nil - TLS выключен
+	loopExitCh        chan bool
+	loopDoneCh        chan struct{}
+	gameRooms         map[uint32]*ServerArena
+	removeRoomCh      chan *ServerArena
+	makeClientCh      chan ClientConnection
+	makeSpectatorCh   chan ClientConnection
+	spectateCh        chan spectateRequest
+	arenasRequestCh   chan chan []*ServerArena
+	sessionRequestCh  chan sessionRequest
+	sessions          map[string]*ServerArena // токены игроков восстановленных арен, только из mainLoop
+	metrics           *ServerMetrics
+	leaderboard       *Leaderboard   // nil - результаты не сохраняются
+	clock             Clock          // время циклов арен
+	shutdownCh        chan struct{}  // закрывается в начале остановки сервера
+	waitGroup         sync.WaitGroup // все горутины сервера, арен и клиентов
+	clientsMutex      sync.Mutex
+	liveClients       map[*ServerClient]struct{}
 }
 
 // Создание нового сервера
-func NewServer() *Server {
+func NewServer(config *Config) *Server {
 	server := Server{
-		isActive:       false,
-		listener:       nil,
-		listenerExitCh: make(chan bool),
-		loopExitCh:     make(chan bool),
-		gameRooms:      make(map[uint32]*ServerArena),
-		removeRoomCh:   make(chan *ServerArena),
-		makeClientCh:   make(chan *net.TCPConn),
+		config:            config,
+		isActive:          false,
+		listener:          nil,
+		spectatorListener: nil,
+		webSocketListener: nil,
+		webSocketServer:   nil,
+		kcpListener:       nil,
+		tlsCertificates:   nil,
+		loopExitCh:        make(chan bool),
+		loopDoneCh:        make(chan struct{}),
+		gameRooms:         make(map[uint32]*ServerArena),
+		removeRoomCh:      make(chan *ServerArena),
+		makeClientCh:      make(chan ClientConnection),
+		makeSpectatorCh:   make(chan ClientConnection),
+		spectateCh:        make(chan spectateRequest),
+		arenasRequestCh:   make(chan chan []*ServerArena),
+		sessionRequestCh:  make(chan sessionRequest),
+		sessions:          make(map[string]*ServerArena),
+		metrics:           NewServerMetrics(),
+		clock:             NewSystemClock(),
+		shutdownCh:        make(chan struct{}),
+		liveClients:       make(map[*ServerClient]struct{}),
 	}
 	return &server
 }
 
-func (server *Server) ExitServer() error {
+func (server *Server) GetMetrics() *ServerMetrics {
+	return server.metrics
+}
+
+func (server *Server) GetLeaderboard() *Leaderboard {
+	return server.leaderboard
+}
+
+// Часы для арен, менять можно только до запуска сервера
+func (server *Server) SetClock(clock Clock) {
+	server.clock = clock
+}
+
+// Фактический адрес игроков, nil если сервер не слушает. С портом 0 в настройках тут выбранный системой порт
+func (server *Server) GetAddress() net.Addr {
+	if server.listener == nil {
+		return nil
+	}
+	return server.listener.Addr()
+}
+
+// Фактический адрес наблюдателей, nil если сервер не слушает
+func (server *Server) GetSpectatorAddress() net.Addr {
+	if server.spectatorListener == nil {
+		return nil
+	}
+	return server.spectatorListener.Addr()
+}
+
+// Фактический адрес WebSocket, nil если WebSocket выключен
+func (server *Server) GetWebSocketAddress() net.Addr {
+	if server.webSocketListener == nil {
+		return nil
+	}
+	return server.webSocketListener.Addr()
+}
+
+// Перечитать сертификаты TLS, новые соединения пойдут с новым сертификатом
+func (server *Server) ReloadTLS() error {
+	if server.tlsCertificates == nil {
+		return errors.New("TLS is disabled")
+	}
+	return server.tlsCertificates.Reload()
+}
+
+// Фактический UDP адрес KCP, nil если KCP выключен
+func (server *Server) GetKCPAddress() net.Addr {
+	if server.kcpListener == nil {
+		return nil
+	}
+	return server.kcpListener.Addr()
+}
+
+// Остановка сервера: новые подключения не принимаются, арены рассылают сообщение об остановке
+// и финальное состояние, очереди клиентов дописываются до истечения ctx, затем соединения закрываются
+func (server *Server) Shutdown(ctx context.Context) error {
 	// TODO: Atomic???
-	if server.isActive == true {
-		server.exitAsyncSocketListener()
-		server.exitMainLoop()
-		server.isActive = false
+	if server.isActive == false {
+		return errors.New("Server already stopped")
+	}
+	server.isActive = false
+
+	log.Printf("Server shutdown\n")
+	close(server.shutdownCh)
+	server.exitAsyncSocketListener()
+
+	// Арены сами видят shutdownCh и завершаются
+	for _, arena := range server.GetArenas() {
+		select {
+		case <-arena.doneCh:
+		case <-ctx.Done():
+		}
+	}
+
+	// Наблюдатели остаются подключенными к серверу, отключаем их
+	messageData := newShutdownMessageData()
+	for _, client := range server.getLiveClients() {
+		if client.IsSpectator() {
+			client.QueueSendData(SEND_KIND_RELIABLE, messageData)
+			client.CloseAfterSend()
+		}
+	}
+	server.exitMainLoop()
+
+	// Ждем все горутины, по истечении ctx закрываем соединения, не успевшие дописать очередь
+	waitCh := make(chan struct{})
+	go func() {
+		server.waitGroup.Wait()
+		close(waitCh)
+	}()
+	select {
+	case <-waitCh:
+		log.Printf("Server stopped\n")
 		return nil
+	case <-ctx.Done():
+		clients := server.getLiveClients()
+		log.Printf("Server shutdown timeout, closing %d connections\n", len(clients))
+		for _, client := range clients {
+			client.Close()
+		}
+		<-waitCh
+		return ctx.Err()
+	}
+}
+
+func (server *Server) isShuttingDown() bool {
+	select {
+	case <-server.shutdownCh:
+		return true
+	default:
+		return false
 	}
-	return errors.New("Server already stopped")
+}
+
+// Запуск горутины, которую дожидается Shutdown
+func (server *Server) startGoroutine(function func()) {
+	server.waitGroup.Add(1)
+	go func() {
+		defer server.waitGroup.Done()
+		function()
+	}()
+}
+
+func (server *Server) trackClient(client *ServerClient) {
+	server.clientsMutex.Lock()
+	server.liveClients[client] = struct{}{}
+	server.clientsMutex.Unlock()
+}
+
+func (server *Server) untrackClient(client *ServerClient) {
+	server.clientsMutex.Lock()
+	delete(server.liveClients, client)
+	server.clientsMutex.Unlock()
+}
+
+func (server *Server) getLiveClients() []*ServerClient {
+	server.clientsMutex.Lock()
+	clients := make([]*ServerClient, 0, len(server.liveClients))
+	for client := range server.liveClients {
+		clients = append(clients, client)
+	}
+	server.clientsMutex.Unlock()
+	return clients
 }
 
 func (server *Server) StartListen() error {
 	// TODO: Atomic???
 	if server.isActive == false {
-		// Listener
-		err := server.asyncSocketAcceptListener()
+		server.shutdownCh = make(chan struct{})
+		// Сертификаты до листенеров: без них TLS соединения принимать нельзя
+		if server.config.Server.TLSCertFile != "" {
+			certificates, err := NewTLSCertificates(server.config.Server)
+			if err != nil {
+				return err
+			}
+			server.tlsCertificates = certificates
+		}
+		// Listeners
+		listener, err := server.asyncSocketAcceptListener(server.config.Server.ListenAddress, server.makeClientCh)
+		if err != nil {
+			return err
+		}
+		server.listener = listener
+
+		spectatorListener, err := server.asyncSocketAcceptListener(server.config.Server.SpectatorListenAddress, server.makeSpectatorCh)
 		if err != nil {
+			server.exitAsyncSocketListener()
 			return err
 		}
+		server.spectatorListener = spectatorListener
+
+		if server.config.Server.WebSocketListenAddress != "" {
+			err = server.asyncWebSocketListener(server.config.Server.WebSocketListenAddress)
+			if err != nil {
+				server.exitAsyncSocketListener()
+				return err
+			}
+		}
+
+		if server.config.Server.KCPListenAddress != "" {
+			kcpListener, err := server.asyncKCPAcceptListener(server.config.Server.KCPListenAddress, server.makeClientCh)
+			if err != nil {
+				server.exitAsyncSocketListener()
+				return err
+			}
+			server.kcpListener = kcpListener
+		}
+		// Арены из снимков появляются до первого подключения, циклы запускаются вместе с mainLoop
+		restoredArenas := server.restoreArenas()
 		// Loop
 		server.mainLoop()
+		for _, arena := range restoredArenas {
+			arena.StartLoop()
+		}
 		// Flag
 		server.isActive = true
 		return nil
@@ -58,120 +295,412 @@ func (server *Server) StartListen() error {
 	return errors.New("Server already active")
 }
 
+// Переключение наблюдателя на арену, arenaId = 0 - только запрос списка арен
+func (server *Server) SpectateArena(client *ServerClient, arenaId uint32) {
+	request := spectateRequest{
+		client:  client,
+		arenaId: arenaId,
+	}
+	select {
+	case server.spectateCh <- request:
+	case <-server.loopDoneCh:
+	}
+}
+
+// Список активных арен, пустой если сервер остановлен
+func (server *Server) GetArenas() []*ServerArena {
+	resultCh := make(chan []*ServerArena, 1)
+	select {
+	case server.arenasRequestCh <- resultCh:
+		return <-resultCh
+	case <-server.loopDoneCh:
+		return []*ServerArena{}
+	}
+}
+
+func (server *Server) FindArena(arenaId uint32) *ServerArena {
+	for _, arena := range server.GetArenas() {
+		if arena.arenaId == arenaId {
+			return arena
+		}
+	}
+	return nil
+}
+
+// Восстановленная арена, где ждет игрок с этим токеном, nil - такой сессии нет.
+// Токен действует один раз
+func (server *Server) findSession(token string) *ServerArena {
+	request := sessionRequest{
+		token:    token,
+		resultCh: make(chan *ServerArena, 1),
+	}
+	select {
+	case server.sessionRequestCh <- request:
+		return <-request.resultCh
+	case <-server.loopDoneCh:
+		return nil
+	}
+}
+
+// Арены из снимков в CheckpointsDir (вызывается до запуска mainLoop)
+func (server *Server) restoreArenas() []*ServerArena {
+	arenas := make([]*ServerArena, 0)
+	if server.config.Server.CheckpointsDir == "" {
+		return arenas
+	}
+	checkpoints, err := ReadArenaCheckpoints(server.config.Server.CheckpointsDir)
+	if err != nil {
+		log.Printf("Failed checkpoints read: %s\n", err)
+		return arenas
+	}
+	for _, checkpoint := range checkpoints {
+		arena, err := RestoreServerArena(server, checkpoint)
+		if err != nil {
+			log.Printf("Failed arena %d restore: %s\n", checkpoint.Info.ArenaId, err)
+			continue
+		}
+		server.gameRooms[arena.arenaId] = arena
+		for _, client := range arena.clients {
+			server.sessions[client.session] = arena
+		}
+		arenas = append(arenas, arena)
+	}
+	return arenas
+}
+
 func (server *Server) DeleteRoom(room *ServerArena) {
-	server.removeRoomCh <- room
+	select {
+	case server.removeRoomCh <- room:
+	case <-server.loopDoneCh:
+	}
 }
 
-// Обработка входящих подключений
-func (server *Server) asyncSocketAcceptListener() error {
-	address, err := net.ResolveTCPAddr("tcp", ":9999")
+// Обработка входящих подключений, новые соединения уходят в connectionCh
+func (server *Server) asyncSocketAcceptListener(listenAddress string, connectionCh chan ClientConnection) (*net.TCPListener, error) {
+	address, err := net.ResolveTCPAddr("tcp", listenAddress)
 	if err != nil {
 		log.Println("Server address resolve error")
-		server.ExitServer()
-		return err
+		return nil, err
 	}
 
 	// Создание листенера
 	createdListener, err := net.ListenTCP("tcp", address)
 	if err != nil {
 		log.Printf("Server listener start error: %s\n", err)
-		server.ExitServer()
-		return err
+		return nil, err
 	}
 
-	// Сохраняем листенер для возможности закрытия
-	server.listener = createdListener
+	var tlsConfig *tls.Config = nil
+	if server.tlsCertificates != nil {
+		tlsConfig = server.tlsCertificates.ServerConfig()
+	}
 
-	// Функция-цикл обработки входящих подключений
+	// Функция-цикл обработки входящих подключений, завершается при закрытии листенера
 	loopFunction := func() {
 		for {
+			// Ожидаем новое подключение
+			c, err := createdListener.AcceptTCP()
+			if err != nil {
+				log.Printf("Accept error: %s\n", err) // Наш лиснер закрылся и надо будет выйти из цикла
+				return
+			}
+
+			log.Printf("Connection accepted\n")
+
+			err = c.SetKeepAlive(true)
+			if err != nil {
+				log.Printf("Set keep alive error: %s\n", err)
+				c.Close()
+				continue
+			}
+
+			err = c.SetNoDelay(true)
+			if err != nil {
+				log.Printf("Set no delay error: %s\n", err)
+				c.Close()
+				continue
+			}
+
+			// Рукопожатие в отдельной горутине, чтобы медленный клиент не задерживал прием остальных
+			if tlsConfig != nil {
+				server.startGoroutine(func() {
+					server.handshakeTLS(c, tlsConfig, connectionCh)
+				})
+				continue
+			}
+
+			// Раз появилось новое соединение - запускаем его в работу с отдельной горутине
 			select {
-			case <-server.listenerExitCh:
-				server.listener.Close()
-				log.Print("Socket listener exit") // Наш лиснер закрылся и надо будет выйти из цикла
+			case connectionCh <- NewStreamConnection(c, server.config.Client.ReadTimeout.Duration()):
+			case <-server.shutdownCh:
+				c.Close()
 				return
+			}
+		}
+	}
 
-			default:
-				// Ожидаем новое подключение
-				c, err := server.listener.AcceptTCP()
-				if err != nil {
-					log.Printf("Accept error: %s\n", err) // Наш лиснер закрылся и надо будет выйти из цикла
-					return
-				}
+	server.startGoroutine(loopFunction)
+	return createdListener, nil
+}
 
-				log.Printf("Connection accepted\n")
+// Рукопожатие TLS принятого соединения, при успехе соединение уходит в connectionCh
+func (server *Server) handshakeTLS(c net.Conn, tlsConfig *tls.Config, connectionCh chan ClientConnection) {
+	tlsConn := tls.Server(c, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+	err := tlsConn.Handshake()
+	if err != nil {
+		server.metrics.AddTLSHandshakeError()
+		log.Printf("TLS handshake error: %s\n", err)
+		tlsConn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
 
-				err = c.SetKeepAlive(true)
-				if err != nil {
-					log.Printf("Set keep alive error: %s\n", err) // Наш лиснер закрылся и надо будет выйти из цикла
-					return
-				}
+	select {
+	case connectionCh <- NewStreamConnection(tlsConn, server.config.Client.ReadTimeout.Duration()):
+	case <-server.shutdownCh:
+		tlsConn.Close()
+	}
+}
 
-				c.SetNoDelay(true)
-				if err != nil {
-					log.Printf("Set no delay error: %s\n", err) // Наш лиснер закрылся и надо будет выйти из цикла
-					return
-				}
+// Обработка входящих сессий KCP, только для игроков. Рамка сообщений та же, что у TCP, TLS не используется.
+// Отключение клиента KCP не передает, такие сессии закрываются по IdleTimeout
+func (server *Server) asyncKCPAcceptListener(listenAddress string, connectionCh chan ClientConnection) (*kcp.Listener, error) {
+	createdListener, err := kcp.ListenWithOptions(listenAddress, nil, KCP_DATA_SHARDS, KCP_PARITY_SHARDS)
+	if err != nil {
+		log.Printf("KCP listener start error: %s\n", err)
+		return nil, err
+	}
+
+	// Функция-цикл обработки входящих сессий, завершается при закрытии листенера
+	loopFunction := func() {
+		for {
+			session, err := createdListener.AcceptKCP()
+			if err != nil {
+				log.Printf("KCP accept error: %s\n", err)
+				return
+			}
+
+			log.Printf("KCP session accepted\n")
+			ConfigureKCPSession(session, server.config.KCP)
 
-				// Раз появилось новое соединение - запускаем его в работу с отдельной горутине
-				server.makeClientCh <- c
+			select {
+			case connectionCh <- NewStreamConnection(session, server.config.Client.ReadTimeout.Duration()):
+			case <-server.shutdownCh:
+				session.Close()
+				return
 			}
 		}
 	}
 
-	go loopFunction()
+	server.startGoroutine(loopFunction)
+	return createdListener, nil
+}
+
+// HTTP сервер для WebSocket: игроки и наблюдатели на разных путях, после апгрейда
+// соединения уходят в те же каналы, что и TCP
+func (server *Server) asyncWebSocketListener(listenAddress string) error {
+	listener, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		log.Printf("WebSocket listener start error: %s\n", err)
+		return err
+	}
+	if server.tlsCertificates != nil {
+		listener = tls.NewListener(listener, server.tlsCertificates.ServerConfig())
+	}
+
+	upgrader := websocket.Upgrader{
+		HandshakeTimeout: SERVER_WEBSOCKET_HANDSHAKE,
+		// Клиенты - не браузерные страницы с нашего домена, Origin не проверяем
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	makeHandler := func(connectionCh chan ClientConnection) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			c, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				log.Printf("WebSocket upgrade error: %s\n", err) // ответ с ошибкой уже отправлен
+				return
+			}
+
+			log.Printf("WebSocket connection accepted\n")
+
+			select {
+			case connectionCh <- NewWebSocketConnection(c):
+			case <-server.shutdownCh:
+				c.Close()
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(SERVER_WEBSOCKET_PLAYER_PATH, makeHandler(server.makeClientCh))
+	mux.HandleFunc(SERVER_WEBSOCKET_SPECTATOR_PATH, makeHandler(server.makeSpectatorCh))
+
+	httpServer := &http.Server{
+		Handler: mux,
+	}
+	server.webSocketListener = listener
+	server.webSocketServer = httpServer
+
+	// Функция обработки, завершается при закрытии сервера
+	loopFunction := func() {
+		err := httpServer.Serve(listener)
+		if err != http.ErrServerClosed {
+			log.Printf("WebSocket server error: %s\n", err)
+		}
+	}
+	server.startGoroutine(loopFunction)
 	return nil
 }
 
-// Выход из листенера
+// Выход из листенеров
 func (server *Server) exitAsyncSocketListener() {
 	if server.listener != nil {
 		server.listener.Close()
+		server.listener = nil
+	}
+	if server.spectatorListener != nil {
+		server.spectatorListener.Close()
+		server.spectatorListener = nil
+	}
+	// Close, а не Shutdown: апгрейднутые соединения сервер уже не отслеживает, их закрывают клиенты
+	if server.webSocketServer != nil {
+		server.webSocketServer.Close()
+		server.webSocketServer = nil
+		server.webSocketListener = nil
+	}
+	if server.kcpListener != nil {
+		server.kcpListener.Close()
+		server.kcpListener = nil
 	}
-	server.listenerExitCh <- true
 }
 
 // Основная функция прослушивания
 func (server *Server) mainLoop() {
+	server.loopDoneCh = make(chan struct{})
+
 	loopFunction := func() {
 		for {
 			select {
 			// Обрабатываем новое подключение
 			case connection := <-server.makeClientCh:
 				log.Printf("Make client call\n")
+				server.metrics.AddAccepted(METRICS_LISTENER_PLAYER)
+				if server.isShuttingDown() {
+					connection.Close()
+					continue
+				}
+				server.addClientToRoom(connection)
 
-				roomFound := false
-				for _, gameRoom := range server.gameRooms {
-					if gameRoom.GetIsFull() == false {
-						gameRoom.AddClientForConnection(connection)
-						roomFound = true
-						break
-					}
+			// Новый наблюдатель пока не подключен ни к одной арене
+			case connection := <-server.makeSpectatorCh:
+				server.metrics.AddAccepted(METRICS_LISTENER_SPECTATOR)
+				if server.isShuttingDown() {
+					connection.Close()
+					continue
 				}
-				// Не нашли подходящей свободной комнаты
-				if roomFound == false {
-					newGameRoom, err := NewServerArena(server)
-					if err != nil {
-						log.Printf("Failed server create: %s\n", err)
-					} else {
-						server.gameRooms[newGameRoom.arenaId] = newGameRoom
-						newGameRoom.StartLoop()
-						newGameRoom.AddClientForConnection(connection)
-					}
+				spectator := NewSpectator(connection, server)
+				spectator.StartLoop()
+				server.sendArenasList(spectator)
+
+			case request := <-server.spectateCh:
+				server.switchSpectatorArena(request.client, request.arenaId)
+
+			case resultCh := <-server.arenasRequestCh:
+				arenas := make([]*ServerArena, 0, len(server.gameRooms))
+				for _, gameRoom := range server.gameRooms {
+					arenas = append(arenas, gameRoom)
 				}
+				resultCh <- arenas
+
+			case request := <-server.sessionRequestCh:
+				arena := server.sessions[request.token]
+				delete(server.sessions, request.token)
+				request.resultCh <- arena
 
 			// Обработка удаления комнаты
 			case room := <-server.removeRoomCh:
 				delete(server.gameRooms, room.arenaId)
+				for token, arena := range server.sessions {
+					if arena == room {
+						delete(server.sessions, token)
+					}
+				}
+				log.Printf("Room %d removed, rooms count = %d\n", room.arenaId, len(server.gameRooms))
 
 			// Завершение работы
 			case <-server.loopExitCh:
 				log.Print("Main loop exit") // Наш лиснер закрылся и надо будет выйти из цикла
+				close(server.loopDoneCh)
 				return
 			}
 		}
 	}
-	go loopFunction()
+	server.startGoroutine(loopFunction)
+}
+
+// Поиск свободной комнаты для нового подключения (вызывается только из mainLoop)
+func (server *Server) addClientToRoom(connection ClientConnection) {
+	for _, gameRoom := range server.gameRooms {
+		if gameRoom.GetIsFull() == false {
+			if gameRoom.AddClientForConnection(connection) {
+				return
+			}
+			// Комната успела закрыться, удаление придет через removeRoomCh
+			delete(server.gameRooms, gameRoom.arenaId)
+		}
+	}
+
+	// Не нашли подходящей свободной комнаты
+	newGameRoom, err := NewServerArena(server)
+	if err != nil {
+		log.Printf("Failed server create: %s\n", err)
+		connection.Close()
+		return
+	}
+	server.gameRooms[newGameRoom.arenaId] = newGameRoom
+	newGameRoom.StartLoop()
+	if newGameRoom.AddClientForConnection(connection) == false {
+		connection.Close()
+	}
+}
+
+// Переключение наблюдателя между аренами (вызывается только из mainLoop)
+func (server *Server) switchSpectatorArena(client *ServerClient, arenaId uint32) {
+	newArena, exists := server.gameRooms[arenaId]
+	if (exists == false) || newArena.GetIsClosed() {
+		server.sendArenasList(client)
+		return
+	}
+
+	oldArena := client.getArena()
+	if oldArena == newArena {
+		return
+	}
+	if oldArena != nil {
+		oldArena.DeleteSpectator(client)
+		client.setArena(nil)
+	}
+	if newArena.AddSpectator(client) == false {
+		server.sendArenasList(client)
+	}
+}
+
+func (server *Server) sendArenasList(client *ServerClient) {
+	list := NewArenasList()
+	for _, gameRoom := range server.gameRooms {
+		item := ArenasListItem{
+			ID:      gameRoom.arenaId,
+			Clients: gameRoom.GetClientsCount(),
+		}
+		list.Arenas = append(list.Arenas, item)
+	}
+	data, err := list.ToBytes()
+	if err != nil {
+		log.Printf("Failed arenas list marshaling: %s\n", err)
+		return
+	}
+	client.QueueSendData(SEND_KIND_REPLY, data)
 }
 
 func (server *Server) exitMainLoop() {
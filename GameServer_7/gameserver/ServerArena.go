@@ -3,105 +3,411 @@ package gameserver
 import (
 	"errors"
 	"log"
-	//"math"
-	"net"
+	"math"
+	"math/rand"
+	"sort"
 	"sync/atomic"
 	"time"
-    "math/rand"
+)
+
+// Значения по умолчанию, на арене используются настройки из Config
+const (
+	ARENA_MAX_CLIENTS     = 4                      // максимальное количество игроков на арене
+	ARENA_IDLE_TIMEOUT    = 30 * time.Second       // сколько живет арена без игроков
+	ARENA_DEFAULT_DUNGEON = "mvp_dungeon_time"     // подземелье, которое запускается на арене
+	ARENA_UPDATE_PERIOD   = 50 * time.Millisecond  // длина тика симуляции
+	ARENA_MAX_CATCH_UP    = 5                      // сколько тиков арена догоняет за раз, если отстала
+	ARENA_MAX_REWIND      = 250 * time.Millisecond // на сколько назад проверка удара смотрит позиции монстров
+	ARENA_MONSTER_START   = 3 * time.Second
+	ARENA_MONSTER_PERIOD  = 20 * time.Second
+	ARENA_MONSTER_LINGER  = 3 * time.Second  // сколько мертвый монстр остается в состоянии арены
+	ARENA_MONSTER_RESPAWN = 10 * time.Second // через сколько после смерти монстр возрождается, 0 - не возрождается
+	ARENA_MONSTERS_ALIVE  = 1                // сколько живых монстров создает таймер
+	ARENA_PLAYER_REVIVE   = 10 * time.Second // через сколько поверженный игрок возрождается, 0 - не возрождается
 )
 
 var LAST_ID uint32 = 0
-var LAST_MONSTER_ID uint32 = 0
+
+// Проверенное попадание вместе с нанесшим его клиентом
+type arenaHit struct {
+	client *ServerClient
+	info   ClientCommandHitInfo
+}
+
+// Возрождение убитого монстра по времени симуляции
+type monsterRespawn struct {
+	name   string
+	health int16
+	time   float64
+}
+
+// Команда клиента, применяемая в тике арены
+type arenaCommand struct {
+	clientId uint32
+	command  *ClientCommand
+}
+
+// Игрок, ожидающий JOIN до deadline
+type arenaJoining struct {
+	client   *ServerClient
+	deadline time.Time
+}
 
 type ServerArena struct {
 	arenaId uint32
 	server  *Server
+	config  *Config
+	seed    int64
+	random  *rand.Rand // генератор симуляции, арена генерируется отдельным генератором с тем же seed
 	clients []*ServerClient
+	// Наблюдатели получают все состояния, но не участвуют в игре
+	spectators []*ServerClient
 	//arenaData            ArenaModel
 	arenaData         []byte
+	arenaDataCached   []byte // ArenaInfo без ресурсов для игроков, у которых они уже есть, nil - всегда с ресурсами
+	resourcesHash     string
+	joining           []arenaJoining // подключились, но еще не вошли на арену, только из цикла арены
 	arenaState        GameArenaState
-	isFull            uint32
+	dungeon           *DungeonInfo
+	hitValidator      *HitValidator
+	monsterHistory    *MonsterHistory // позиции и статусы монстров за окно MaxRewind для проверки ударов
+	recorder          *ArenaRecorder
+	metrics           *ArenaMetrics
+	tick              uint64
+	startTime         time.Time
+	simTime           float64 // время симуляции в секундах, сумма delta всех тиков
+	lastMonsterId     uint32
+	respawns          []monsterRespawn
+	randomSource      *countingSource // источник random, по количеству выборок генератор восстанавливается из снимка
+	dungeonTimeLeft   time.Duration   // таймеры подземелья и монстров при запуске цикла, у восстановленной арены - из снимка
+	monsterTimeLeft   time.Duration
+	dungeonDeadline   time.Time // сроки срабатывания таймеров, только из цикла арены
+	monsterDeadline   time.Time
+	clientsCount      int32
+	isClosed          uint32
 	needSendAll       uint32
-	addClientByConnCh chan *net.TCPConn
+	addClientByConnCh chan ClientConnection
 	deleteClientCh    chan *ServerClient
+	addSpectatorCh    chan *ServerClient
+	deleteSpectatorCh chan *ServerClient
 	forceSendAll      chan bool
+	callCh            chan func()
 	exitLoopCh        chan bool
+	doneCh            chan struct{}
 }
 
 func NewServerArena(server *Server) (*ServerArena, error) {
 	newArenaId := atomic.AddUint32(&LAST_ID, 1)
+	seed := time.Now().UnixNano()
 
-	// State
-	state := NewServerArenaState(newArenaId)
-
-	// Формируем список платформ для данной арены
-	item, exists := GetApp().GetStaticInfo().Levels["egypt"]
+	// Подземелье, которое проходится на арене
+	dungeon, exists := GetApp().GetStaticInfo().Dungeons[server.config.Arena.Dungeon]
 	if exists == false {
-		return nil, errors.New("No level with name")
+		return nil, errors.New("No dungeon with name")
 	}
-	platformsForArena := make([]*PlatformInfo, 0)
-	for _, key := range item.Platforms {
-		value, ok := GetApp().GetStaticInfo().Platforms[key]
-		if ok {
-			platformsForArena = append(platformsForArena, value)
-		}
-	}
-	if len(platformsForArena) == 0 {
-		return nil, errors.New("No platforms for arena")
+
+	arenaData, err := makeArenaData(dungeon, seed)
+	if err != nil {
+		return nil, err
 	}
-    arenaModel := NewArenaModel(platformsForArena)
-	arenaData, err := arenaModel.ToBytes()
-    if err != nil {
-        return nil, err
-    }
 
 	//arenaData := GetApp().GetStaticInfo().TestArenaData
 
-	// Server arena
+	arena := newServerArena(server.config, server, newArenaId, seed, dungeon, arenaData)
+	arena.startTime = server.clock.Now()
+	arena.metrics = server.metrics.RegisterArena(newArenaId)
+
+	// Запись для воспроизведения
+	if server.config.Server.ReplaysDir != "" {
+		recorder, err := NewArenaRecorderFile(server.config.Server.ReplaysDir, newArenaId)
+		if err != nil {
+			log.Printf("Failed replay recorder create: %s\n", err)
+		} else {
+			arena.recorder = recorder
+			arena.recorder.RecordInfo(arena.GetReplayInfo())
+		}
+	}
+
+	return arena, nil
+}
+
+// Генерация модели арены для подземелья, при одинаковом seed данные совпадают
+func makeArenaData(dungeon *DungeonInfo, seed int64) ([]byte, error) {
+	arenaModel, err := NewArenaModelForLevel(GetApp().GetStaticInfo(), dungeon.Level, seed)
+	if err != nil {
+		return nil, err
+	}
+	arenaModel.Lights = NewArenaLights(GetApp().GetStaticInfo(), &arenaModel)
+	err = arenaModel.SetResources(NewArenaResources(GetApp().GetStaticInfo(), dungeon.Level))
+	if err != nil {
+		return nil, err
+	}
+	return arenaModel.ToBytes()
+}
+
+func newServerArena(config *Config, server *Server, arenaId uint32, seed int64, dungeon *DungeonInfo, arenaData []byte) *ServerArena {
+	randomSource := newCountingSource(seed + 1)
 	arena := &ServerArena{
-		arenaId:           newArenaId,
+		arenaId:           arenaId,
 		server:            server,
+		config:            config,
+		seed:              seed,
+		random:            rand.New(randomSource),
+		randomSource:      randomSource,
 		clients:           make([]*ServerClient, 0),
+		joining:           make([]arenaJoining, 0),
+		spectators:        make([]*ServerClient, 0),
 		arenaData:         arenaData,
-		arenaState:        state,
-		isFull:            0,
+		arenaState:        NewServerArenaState(arenaId),
+		dungeon:           dungeon,
+		hitValidator:      NewHitValidator(GetApp().GetStaticInfo(), &config.Arena),
+		monsterHistory:    NewMonsterHistory(int(config.Arena.MaxRewind/config.Arena.UpdatePeriod) + 1),
+		recorder:          nil,
+		metrics:           nil,
+		tick:              0,
+		startTime:         time.Now(),
+		simTime:           0.0,
+		lastMonsterId:     0,
+		respawns:          make([]monsterRespawn, 0),
+		dungeonTimeLeft:   time.Duration(dungeon.Timer * float64(time.Second)),
+		monsterTimeLeft:   config.Arena.MonsterStart.Duration(),
+		clientsCount:      0,
+		isClosed:          0,
 		needSendAll:       0,
-		addClientByConnCh: make(chan *net.TCPConn),
+		addClientByConnCh: make(chan ClientConnection),
 		deleteClientCh:    make(chan *ServerClient),
+		addSpectatorCh:    make(chan *ServerClient),
+		deleteSpectatorCh: make(chan *ServerClient),
 		forceSendAll:      make(chan bool),
+		callCh:            make(chan func()),
 		exitLoopCh:        make(chan bool),
+		doneCh:            make(chan struct{}),
 	}
-	return arena, nil
+
+	cachedData, resourcesHash, err := arenaDataWithoutResources(arenaData)
+	if err != nil {
+		log.Printf("ArenaInfo without resources not created for arena %d: %s\n", arenaId, err)
+	} else if resourcesHash != "" {
+		arena.arenaDataCached = cachedData
+		arena.resourcesHash = resourcesHash
+	}
+	return arena
+}
+
+func (arena *ServerArena) GetReplayInfo() ReplayInfo {
+	info := ReplayInfo{
+		ArenaId:   arena.arenaId,
+		Seed:      arena.seed,
+		Dungeon:   arena.dungeon.Name,
+		StartTime: arena.startTime,
+		ArenaInfo: arena.arenaData,
+		Arena:     &arena.config.Arena,
+	}
+	return info
 }
 
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 func (arena *ServerArena) StartLoop() {
-	go arena.mainLoop()
+	arena.server.startGoroutine(arena.mainLoop)
 }
 
 func (arena *ServerArena) Exit() {
-	arena.exitLoopCh <- true
+	select {
+	case arena.exitLoopCh <- true:
+	case <-arena.doneCh:
+	}
 }
 
-func (arena *ServerArena) AddClientForConnection(connection *net.TCPConn) {
-	arena.addClientByConnCh <- connection
+// Добавление нового игрока, false - если арена уже завершает работу
+func (arena *ServerArena) AddClientForConnection(connection ClientConnection) bool {
+	atomic.AddInt32(&arena.clientsCount, 1)
+	select {
+	case arena.addClientByConnCh <- connection:
+		return true
+	case <-arena.doneCh:
+		atomic.AddInt32(&arena.clientsCount, -1)
+		return false
+	}
 }
 
 func (arena *ServerArena) DeleteClient(client *ServerClient) {
-	arena.deleteClientCh <- client
+	select {
+	case arena.deleteClientCh <- client:
+	case <-arena.doneCh:
+	}
+}
+
+// Подключение наблюдателя, false - если арена уже завершает работу
+func (arena *ServerArena) AddSpectator(client *ServerClient) bool {
+	select {
+	case arena.addSpectatorCh <- client:
+		return true
+	case <-arena.doneCh:
+		return false
+	}
+}
+
+func (arena *ServerArena) DeleteSpectator(client *ServerClient) {
+	select {
+	case arena.deleteSpectatorCh <- client:
+	case <-arena.doneCh:
+	}
+}
+
+func (arena *ServerArena) GetClientsCount() int32 {
+	return atomic.LoadInt32(&arena.clientsCount)
 }
 
 func (arena *ServerArena) ClientStateUpdated(client *ServerClient, force bool) {
 	if force {
-		arena.forceSendAll <- true
+		select {
+		case arena.forceSendAll <- true:
+		case <-arena.doneCh:
+		}
 	} else {
 		atomic.StoreUint32(&arena.needSendAll, 1)
 	}
 }
 
 func (arena *ServerArena) GetIsFull() bool {
-	return bool(atomic.LoadUint32(&arena.isFull) > 0)
+	return arena.GetIsClosed() || (atomic.LoadInt32(&arena.clientsCount) >= int32(arena.config.Arena.MaxClients))
+}
+
+func (arena *ServerArena) GetIsClosed() bool {
+	return atomic.LoadUint32(&arena.isClosed) > 0
+}
+
+// Выполнение функции в цикле арены с ожиданием, false - если арена уже завершила работу
+func (arena *ServerArena) callInLoop(function func()) bool {
+	doneCh := make(chan struct{})
+	callFunction := func() {
+		function()
+		close(doneCh)
+	}
+	select {
+	case arena.callCh <- callFunction:
+		<-doneCh
+		return true
+	case <-arena.doneCh:
+		return false
+	}
+}
+
+// Снимок арены для админки
+func (arena *ServerArena) GetAdminInfo() (AdminArenaInfo, bool) {
+	info := AdminArenaInfo{}
+	ok := arena.callInLoop(func() {
+		info.ID = arena.arenaId
+		info.Dungeon = arena.dungeon.Name
+		info.Status = arena.arenaState.Status
+		info.Tick = arena.tick
+		info.SimTime = arena.simTime
+		info.Spectators = len(arena.spectators)
+		info.Joining = len(arena.joining)
+		info.Clients = make([]AdminClientInfo, 0, len(arena.clients))
+		for _, client := range arena.clients {
+			info.Clients = append(info.Clients, client.getAdminInfo())
+		}
+		info.Monsters = make([]ServerMonsterState, len(arena.arenaState.Monsters))
+		copy(info.Monsters, arena.arenaState.Monsters)
+	})
+	return info, ok
+}
+
+// Отключение игрока, false - если игрока нет на арене
+func (arena *ServerArena) KickClient(clientId uint32) bool {
+	kicked := false
+	arena.callInLoop(func() {
+		client := arena.findClient(clientId)
+		if client == nil {
+			return
+		}
+		log.Printf("Client %d kicked from arena %d\n", clientId, arena.arenaId)
+		arena.recorder.RecordLeave(arena.tick, clientId)
+		if arena.removeClient(client) {
+			client.CloseAfterSend()
+		}
+		kicked = true
+	})
+	return kicked
+}
+
+// Есть ли на арене игрок без соединения с этим токеном сессии
+func (arena *ServerArena) hasSession(token string) bool {
+	exists := false
+	arena.callInLoop(func() {
+		exists = arena.findSession(token) != nil
+	})
+	return exists
+}
+
+// Возвращение игрока восстановленной арены с новым соединением, false - сессии уже нет
+func (arena *ServerArena) attachSession(token string, connection ClientConnection, resourcesHash string) bool {
+	attached := false
+	arena.callInLoop(func() {
+		client := arena.findSession(token)
+		if client == nil {
+			return
+		}
+		log.Printf("Client %d reattached to arena %d\n", client.id, arena.arenaId)
+		client.setConnection(connection)
+		client.StartLoop()
+		client.QueueSendData(SEND_KIND_RELIABLE, arena.arenaInfoFor(resourcesHash))
+		client.QueueSendSession(arena.arenaId)
+		client.QueueSendCurrentClientState()
+		atomic.StoreUint32(&arena.needSendAll, 1)
+		attached = true
+	})
+	return attached
+}
+
+func (arena *ServerArena) findSession(token string) *ServerClient {
+	for _, client := range arena.clients {
+		if client.isDetached() && (client.session == token) {
+			return client
+		}
+	}
+	return nil
+}
+
+// Создание монстра с параметрами из units.json
+func (arena *ServerArena) SpawnMonster(name string, x, y float64) (ServerMonsterState, error) {
+	unitInfo, exists := GetApp().GetStaticInfo().Units[name]
+	if (exists == false) || (name == UNIT_NAME_PLAYER) {
+		return ServerMonsterState{}, errors.New("No monster with name")
+	}
+	health := int16(math.MaxInt16)
+	if unitInfo.Health < math.MaxInt16 {
+		health = int16(unitInfo.Health)
+	}
+
+	monster := ServerMonsterState{}
+	ok := arena.callInLoop(func() {
+		monster = arena.spawnMonster(name, health, x, y)
+		arena.recorder.RecordSpawn(arena.tick, monster)
+	})
+	if ok == false {
+		return ServerMonsterState{}, errors.New("Arena closed")
+	}
+	return monster, nil
+}
+
+// Перемещение живого монстра, например сценарием или через админку. Удары проверяются по позиции
+// на тике, который видел клиент, поэтому перемещенный монстр еще какое-то время уязвим на старом месте
+func (arena *ServerArena) MoveMonster(id uint32, x, y float64) (ServerMonsterState, error) {
+	monster := ServerMonsterState{}
+	var err error = nil
+	ok := arena.callInLoop(func() {
+		monster, err = arena.moveMonster(id, x, y)
+		if err == nil {
+			arena.recorder.RecordMove(arena.tick, monster)
+		}
+	})
+	if ok == false {
+		return ServerMonsterState{}, errors.New("Arena closed")
+	}
+	return monster, err
 }
 
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -122,38 +428,218 @@ func (arena *ServerArena) sendAllNewState() {
 		log.Printf("Failed arena state marshaling: %s\n", err)
 		return
 	}
+	arena.sendAll(SEND_KIND_ARENA_STATE, data)
+}
+
+// Рассылка игрокам и наблюдателям, все разосланное попадает в запись.
+// Игроки восстановленной арены без соединения получат ArenaInfo и состояние при возвращении
+func (arena *ServerArena) sendAll(kind SendKind, data []byte) {
+	arena.recorder.RecordState(arena.tick, data)
+
+	for _, client := range arena.clients {
+		if client.isDetached() {
+			continue
+		}
+		client.QueueSendData(kind, data)
+	}
+	for _, spectator := range arena.spectators {
+		spectator.QueueSendData(kind, data)
+	}
+}
+
+// Время симуляции, по нему проверяются интервалы между ударами
+func (arena *ServerArena) getSimTime() time.Time {
+	return arena.startTime.Add(time.Duration(arena.simTime * float64(time.Second)))
+}
+
+func (arena *ServerArena) findClient(clientId uint32) *ServerClient {
+	for _, client := range arena.clients {
+		if client.id == clientId {
+			return client
+		}
+	}
+	return nil
+}
+
+func (arena *ServerArena) addClient(client *ServerClient) {
+	client.joinTime = arena.simTime
+	arena.clients = append(arena.clients, client)
+	arena.recorder.RecordJoin(arena.tick, client.id)
+}
+
+// Новый игрок входит на арену после JOIN с хешем закешированных ресурсов, а если клиент JOIN не присылает -
+// через JoinWait по часам арены, срок проверяется на тиках. До входа он не получает состояний и не участвует в игре
+func (arena *ServerArena) startJoin(client *ServerClient) {
+	wait := arena.config.Client.JoinWait.Duration()
+	if wait <= 0 {
+		arena.joinClient(client, "")
+		return
+	}
+	arena.joining = append(arena.joining, arenaJoining{client: client, deadline: arena.server.clock.Now().Add(wait)})
+}
+
+// Игроки, которые не прислали JOIN за JoinWait, входят с полной ArenaInfo
+func (arena *ServerArena) expireJoining(now time.Time) {
+	for i := 0; i < len(arena.joining); {
+		joining := arena.joining[i]
+		if now.Before(joining.deadline) {
+			i++
+			continue
+		}
+		arena.joining = append(arena.joining[:i], arena.joining[i+1:]...)
+		arena.joinClient(joining.client, "")
+	}
+}
+
+// JOIN игрока, вызывается из цикла чтения клиента
+func (arena *ServerArena) JoinClient(client *ServerClient, resourcesHash string) {
+	arena.callInLoop(func() {
+		arena.finishJoin(client, resourcesHash)
+	})
+}
+
+// Вход ожидающего игрока, повторный JOIN и JOIN после ожидания ничего не меняют
+func (arena *ServerArena) finishJoin(client *ServerClient, resourcesHash string) {
+	if arena.removeJoining(client) {
+		arena.joinClient(client, resourcesHash)
+	}
+}
+
+// Команды, пришедшие до входа, не применяются
+func (arena *ServerArena) joinClient(client *ServerClient, resourcesHash string) {
+	client.GetCommandsWithReset()
+	arena.addClient(client)
+	client.QueueSendData(SEND_KIND_RELIABLE, arena.arenaInfoFor(resourcesHash))
+	client.QueueSendSession(arena.arenaId)
+	client.QueueSendCurrentClientState()
+}
+
+func (arena *ServerArena) removeJoining(client *ServerClient) bool {
+	for i := range arena.joining {
+		if arena.joining[i].client.id == client.id {
+			arena.joining = append(arena.joining[:i], arena.joining[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ArenaInfo для игрока: без ресурсов, если у него закешированы ресурсы с тем же хешем
+func (arena *ServerArena) arenaInfoFor(resourcesHash string) []byte {
+	if (arena.arenaDataCached != nil) && (resourcesHash == arena.resourcesHash) {
+		return arena.arenaDataCached
+	}
+	return arena.arenaData
+}
+
+// Забираем все пришедшие с прошлого тика команды
+func (arena *ServerArena) collectClientCommands() []arenaCommand {
+	commands := make([]arenaCommand, 0)
+	for _, client := range arena.clients {
+		for _, command := range client.GetCommandsWithReset() {
+			commands = append(commands, arenaCommand{clientId: client.id, command: command})
+		}
+	}
+	return commands
+}
 
-	// Send all
+// Один тик симуляции: команды клиентов, обновление мира и рассылка состояния
+func (arena *ServerArena) runTick(delta float64, commands []arenaCommand) {
+	arena.tick++
+	arena.simTime += delta
+	arena.arenaState.Tick = arena.tick
+	arena.recorder.RecordTick(arena.tick, delta)
 	for _, client := range arena.clients {
-		client.QueueSendData(data)
+		client.setTick(arena.tick)
+	}
+
+	for _, item := range commands {
+		client := arena.findClient(item.clientId)
+		if client == nil {
+			continue
+		}
+		// В запись идет уже ограниченный тик, воспроизведение от задержки не зависит
+		item.command.Tick = arena.limitViewTick(client, item.command.Tick)
+		if client.applyCommand(item.command, arena.getSimTime()) == false {
+			serverMetrics, _ := client.getMetrics()
+			serverMetrics.AddInputDrop()
+			continue
+		}
+		arena.recorder.RecordCommand(arena.tick, item.clientId, item.command)
+		atomic.StoreUint32(&arena.needSendAll, 1)
+	}
+
+	arena.worldTick(delta)
+	arena.monsterHistory.Record(arena.tick, arena.arenaState.Monsters)
+
+	if atomic.LoadUint32(&arena.needSendAll) > 0 {
+		atomic.StoreUint32(&arena.needSendAll, 0)
+		arena.sendAllNewState()
 	}
 }
 
 func (arena *ServerArena) worldTick(delta float64) {
-	if len(arena.arenaState.Monsters) > 0 {
-		hits := []ClientCommandHitInfo{}
-		for _, client := range arena.clients {
-			hits = append(hits, client.GetCurrentHitsWithReset()...)
+	// Удары проверяем даже без монстров, чтобы учесть нарушения
+	hits := []arenaHit{}
+	kickClients := []*ServerClient{}
+	for _, client := range arena.clients {
+		// Удары поверженного игрока отбрасываются без нарушений
+		attacks := client.GetCurrentAttacksWithReset()
+		if client.IsDefeated() {
+			continue
+		}
+		for _, attack := range attacks {
+			rewind := arena.monsterHistory.Rewind(attack.ViewTick)
+			validHits, violations := arena.hitValidator.ValidateAttack(client, attack, arena.arenaState.Monsters, rewind)
+			if (len(violations) > 0) && arena.handleHitViolations(client, violations) {
+				kickClients = append(kickClients, client)
+				break
+			}
+			for _, hit := range validHits {
+				hits = append(hits, arenaHit{client: client, info: hit})
+			}
 		}
+	}
+	for _, client := range kickClients {
+		if arena.removeClient(client) {
+			client.CloseAfterSend()
+		}
+	}
 
+	if len(arena.arenaState.Monsters) > 0 {
 		// TODO: Optimize
 		validMonsters := make([]ServerMonsterState, 0)
 		haveUpdates := false
 		for i, _ := range arena.arenaState.Monsters {
+			monster := &arena.arenaState.Monsters[i]
 			for _, hit := range hits {
-				if arena.arenaState.Monsters[i].ID == hit.ID {
-					arena.arenaState.Monsters[i].Health -= hit.Damage / 10
+				if (monster.ID != hit.info.ID) || (monster.Status != MONSTER_STATE_STATUS_ALIVE) {
+					continue
+				}
+				// Урон сверх оставшегося здоровья не засчитывается
+				damage := hit.info.Damage / HIT_DAMAGE_DIVIDER
+				if damage > monster.Health {
+					damage = monster.Health
+				}
+				monster.Health -= damage
+				hit.client.AddTotalDamage(uint32(damage))
+				monster.damage[hit.client.id] += uint32(damage)
 
-					log.Printf("Hit monster %d: damage = %d, health = %d\n", hit.ID, hit.Damage, arena.arenaState.Monsters[i].Health)
+				log.Printf("Hit monster %d: damage = %d, health = %d\n", hit.info.ID, hit.info.Damage, monster.Health)
 
-					haveUpdates = true
+				if monster.Health <= 0 {
+					arena.killMonster(monster, hit.client)
 				}
+				haveUpdates = true
 			}
 
-			if arena.arenaState.Monsters[i].Health > 0 {
-				//arena.arenaState.Monsters[i].Health = int16(math.Max(float64(arena.arenaState.Monsters[i].Health), 0.0))
-				validMonsters = append(validMonsters, arena.arenaState.Monsters[i])
+			// Мертвый монстр остается в состоянии, пока клиенты показывают смерть
+			if (monster.Status == MONSTER_STATE_STATUS_DEAD) && (arena.simTime-monster.deathTime >= arena.config.Arena.MonsterLinger.Duration().Seconds()) {
+				arena.scheduleRespawn(monster)
+				haveUpdates = true
+				continue
 			}
+			validMonsters = append(validMonsters, *monster)
 		}
 		arena.arenaState.Monsters = validMonsters
 
@@ -161,54 +647,490 @@ func (arena *ServerArena) worldTick(delta float64) {
 			atomic.StoreUint32(&arena.needSendAll, 1)
 		}
 	}
+
+	arena.processRespawns()
+	arena.monstersAttack()
+	arena.updatePlayers(delta)
+}
+
+// Живые монстры бьют ближайшего игрока в радиусе атаки с частотой attack_speed
+func (arena *ServerArena) monstersAttack() {
+	staticInfo := GetApp().GetStaticInfo()
+	playerInfo := staticInfo.Units[UNIT_NAME_PLAYER]
+	for i := range arena.arenaState.Monsters {
+		monster := &arena.arenaState.Monsters[i]
+		if (monster.Status != MONSTER_STATE_STATUS_ALIVE) || (arena.simTime < monster.nextAttackTime) {
+			continue
+		}
+		monsterInfo, exists := staticInfo.Units[monster.Name]
+		if (exists == false) || (monsterInfo.AttackSpeed <= 0) {
+			continue
+		}
+
+		var target *ServerClient = nil
+		targetDistance := 0.0
+		monsterPos := NewPointFloat(monster.X, monster.Y)
+		for _, client := range arena.clients {
+			// Игрок без соединения не может защититься, монстры его не трогают до возвращения
+			if (client.IsValidState() == false) || client.IsDefeated() || client.isDetached() {
+				continue
+			}
+			clientPos := client.GetPosition()
+			distance := clientPos.Distance(monsterPos) - playerInfo.BoundingRadius
+			if (distance <= monsterInfo.AttackRadius) && ((target == nil) || (distance < targetDistance)) {
+				target = client
+				targetDistance = distance
+			}
+		}
+		if target == nil {
+			continue
+		}
+
+		monster.nextAttackTime = arena.simTime + 1.0/monsterInfo.AttackSpeed
+		if target.applyDamage(monsterInfo.Power, arena.simTime) {
+			log.Printf("Client %d defeated by monster %d on arena %d\n", target.id, monster.ID, arena.arenaId)
+		}
+		atomic.StoreUint32(&arena.needSendAll, 1)
+	}
+}
+
+// Восстановление здоровья, возрождение поверженных и поражение, когда повержены все
+func (arena *ServerArena) updatePlayers(delta float64) {
+	if len(arena.clients) == 0 {
+		return
+	}
+	reviveDelay := arena.config.Arena.PlayerRevive.Duration().Seconds()
+	allDefeated := true
+	for _, client := range arena.clients {
+		if client.IsDefeated() == false {
+			allDefeated = false
+			// Игрок без соединения не меняется до возвращения
+			if client.isDetached() {
+				continue
+			}
+			if client.regenerate(delta) {
+				atomic.StoreUint32(&arena.needSendAll, 1)
+			}
+		}
+	}
+	if allDefeated {
+		arena.failDungeon()
+		return
+	}
+
+	if reviveDelay <= 0 {
+		return
+	}
+	for _, client := range arena.clients {
+		if client.IsDefeated() && (arena.simTime-client.defeatTime >= reviveDelay) {
+			log.Printf("Client %d revived on arena %d\n", client.id, arena.arenaId)
+			client.revive()
+			atomic.StoreUint32(&arena.needSendAll, 1)
+		}
+	}
 }
 
+// Смерть монстра: статус для анимации, убийство игроку, событие с участниками и добычей
+func (arena *ServerArena) killMonster(monster *ServerMonsterState, killer *ServerClient) {
+	monster.Status = MONSTER_STATE_STATUS_DEAD
+	monster.Health = 0
+	monster.deathTime = arena.simTime
+	killer.AddKill()
+
+	event := NewMonsterKilled(arena.arenaId, monster, killer.id)
+	for clientId, damage := range monster.damage {
+		event.Contributors = append(event.Contributors, MonsterKillContributor{ID: clientId, Damage: damage})
+	}
+	// Порядок не должен зависеть от обхода map, иначе запись не воспроизведется
+	sort.Slice(event.Contributors, func(i, j int) bool {
+		if event.Contributors[i].Damage != event.Contributors[j].Damage {
+			return event.Contributors[i].Damage > event.Contributors[j].Damage
+		}
+		return event.Contributors[i].ID < event.Contributors[j].ID
+	})
+
+	staticInfo := GetApp().GetStaticInfo()
+	if unitInfo, exists := staticInfo.Units[monster.Name]; exists {
+		event.Reward = unitInfo.Reward
+		if bonus, exists := staticInfo.Bonuses[unitInfo.Bonus]; exists {
+			event.Loot = bonus.Roll(arena.random)
+		}
+	}
+
+	log.Printf("Monster %d killed by client %d on arena %d\n", monster.ID, killer.id, arena.arenaId)
+
+	data, err := event.ToBytes()
+	if err != nil {
+		log.Printf("Failed monster killed marshaling: %s\n", err)
+		return
+	}
+	arena.sendAll(SEND_KIND_RELIABLE, data)
+}
+
+// Монстр убран из состояния, возрождаем его позже, если это включено
+func (arena *ServerArena) scheduleRespawn(monster *ServerMonsterState) {
+	delay := arena.config.Arena.MonsterRespawn.Duration()
+	if delay <= 0 {
+		return
+	}
+	respawn := monsterRespawn{
+		name:   monster.Name,
+		health: monster.maxHealth,
+		time:   monster.deathTime + delay.Seconds(),
+	}
+	arena.respawns = append(arena.respawns, respawn)
+}
+
+func (arena *ServerArena) processRespawns() {
+	waiting := arena.respawns[:0]
+	for _, respawn := range arena.respawns {
+		if arena.simTime < respawn.time {
+			waiting = append(waiting, respawn)
+			continue
+		}
+		point := arena.randomSpawnPoint()
+		arena.spawnMonster(respawn.name, respawn.health, float64(point.X), float64(point.Y))
+	}
+	arena.respawns = waiting
+}
+
+func (arena *ServerArena) getAliveMonstersCount() int {
+	count := 0
+	for _, monster := range arena.arenaState.Monsters {
+		if monster.Status == MONSTER_STATE_STATUS_ALIVE {
+			count++
+		}
+	}
+	return count
+}
+
+// Учет нарушений при ударах, true - если клиента надо отключить
+func (arena *ServerArena) handleHitViolations(client *ServerClient, violations []HitViolationType) bool {
+	client.hitViolations += uint32(len(violations))
+	log.Printf("Hit violations %v for client %d, total = %d\n", violations, client.id, client.hitViolations)
+
+	if client.hitViolations < uint32(arena.config.Arena.HitViolationsLimit) {
+		return false
+	}
+
+	switch arena.config.Arena.HitViolationAction {
+	case HIT_VIOLATION_ACTION_KICK:
+		log.Printf("Client %d kicked for hit violations\n", client.id)
+		return true
+	case HIT_VIOLATION_ACTION_FLAG:
+		if client.isFlagged == false {
+			log.Printf("Client %d flagged for hit violations\n", client.id)
+			client.isFlagged = true
+		}
+	}
+	return false
+}
+
+// Таймер создает монстров, пока живых и ожидающих возрождения меньше предела
 func (arena *ServerArena) createMonster() {
-	if len(arena.arenaState.Monsters) == 0 {
-		newMonsterId := atomic.AddUint32(&LAST_MONSTER_ID, 1)
+	if arena.getAliveMonstersCount()+len(arena.respawns) < arena.config.Arena.MonstersAlive {
+		point := arena.randomSpawnPoint()
+		arena.spawnMonster("angry_cat", 1000, float64(point.X), float64(point.Y))
+	}
+}
 
-        points := [5]Point16{
-            NewPoint16(10, 2),
-            NewPoint16(2, 2),
-            NewPoint16(2, 10),
-            NewPoint16(10, 10),
-            NewPoint16(4, 5),
-        }
+func (arena *ServerArena) randomSpawnPoint() Point16 {
+	points := [5]Point16{
+		NewPoint16(10, 2),
+		NewPoint16(2, 2),
+		NewPoint16(2, 10),
+		NewPoint16(10, 10),
+		NewPoint16(4, 5),
+	}
+	return points[arena.random.Int()%len(points)]
+}
 
-        point := points[rand.Int() % len(points)]
+func (arena *ServerArena) spawnMonster(name string, health int16, x, y float64) ServerMonsterState {
+	arena.lastMonsterId++
+	newMonsterId := arena.lastMonsterId
 
-		monsterState := NewServerMonsterState(newMonsterId)
-		monsterState.Name = "angry_cat"
-		monsterState.Health = 1000
-		monsterState.X = float64(point.X)
-		monsterState.Y = float64(point.Y)
+	monsterState := NewServerMonsterState(newMonsterId)
+	monsterState.Name = name
+	monsterState.Health = health
+	monsterState.maxHealth = health
+	monsterState.damage = make(map[uint32]uint32)
+	monsterState.X = x
+	monsterState.Y = y
 
-		arena.arenaState.Monsters = append(arena.arenaState.Monsters, monsterState)
+	arena.arenaState.Monsters = append(arena.arenaState.Monsters, monsterState)
 
-		log.Printf("Generated monster %d", newMonsterId)
+	log.Printf("Generated monster %d", newMonsterId)
 
-		atomic.StoreUint32(&arena.needSendAll, 1)
+	atomic.StoreUint32(&arena.needSendAll, 1)
+	return monsterState
+}
+
+func (arena *ServerArena) moveMonster(id uint32, x, y float64) (ServerMonsterState, error) {
+	monster := findMonster(arena.arenaState.Monsters, id)
+	if (monster == nil) || (monster.Status != MONSTER_STATE_STATUS_ALIVE) {
+		return ServerMonsterState{}, errors.New("No alive monster with id")
 	}
+	monster.X = x
+	monster.Y = y
+	atomic.StoreUint32(&arena.needSendAll, 1)
+	return *monster, nil
+}
+
+// Удаление клиента из арены, false - если клиента на арене уже нет
+func (arena *ServerArena) removeClient(client *ServerClient) bool {
+	deleteIndex := -1
+	for i := range arena.clients {
+		if arena.clients[i].id == client.id {
+			deleteIndex = i
+			break
+		}
+	}
+	if deleteIndex < 0 {
+		return false
+	}
+	arena.clients = append(arena.clients[:deleteIndex], arena.clients[deleteIndex+1:]...)
+	atomic.AddInt32(&arena.clientsCount, -1)
+	arena.recordResult(client)
+	arena.sendAllNewState()
+	return true
+}
+
+func (arena *ServerArena) getDetachedCount() int {
+	count := 0
+	for _, client := range arena.clients {
+		if client.isDetached() {
+			count++
+		}
+	}
+	return count
+}
+
+// Истекло ожидание возвращения игроков восстановленной арены
+func (arena *ServerArena) removeDetachedClients() {
+	detached := make([]*ServerClient, 0)
+	for _, client := range arena.clients {
+		if client.isDetached() {
+			detached = append(detached, client)
+		}
+	}
+	for _, client := range detached {
+		log.Printf("Client %d did not reattach to arena %d\n", client.id, arena.arenaId)
+		arena.recorder.RecordLeave(arena.tick, client.id)
+		arena.removeClient(client)
+	}
+}
+
+// Итог забега игрока в таблицу рекордов, при воспроизведении записи сервера нет и итог не пишется.
+// Игрок, ушедший в сессию восстановленной арены, забег не закончил
+func (arena *ServerArena) recordResult(client *ServerClient) {
+	if (arena.server == nil) || client.isReleased() {
+		return
+	}
+	result := client.getResult(arena.dungeon.Name, arena.arenaId, arena.simTime)
+	err := arena.server.leaderboard.AddResult(result)
+	if err != nil {
+		log.Printf("Leaderboard result error for client %d on arena %d: %s\n", client.id, arena.arenaId, err)
+	}
+}
+
+// Подземелье пройдено - рассылаем финальное состояние
+func (arena *ServerArena) completeDungeon() {
+	log.Printf("Arena %d completed dungeon %s\n", arena.arenaId, arena.dungeon.Name)
+
+	arena.arenaState.Status = GAME_ROOM_STATUS_COMPLETED
+	for _, client := range arena.clients {
+		client.SetStatus(CLIENT_STATUS_WIN)
+	}
+	atomic.StoreUint32(&arena.needSendAll, 0)
+	arena.sendAllNewState()
+}
+
+// Все игроки повержены - рассылаем финальное состояние, цикл арены завершается после тика
+func (arena *ServerArena) failDungeon() {
+	log.Printf("Arena %d failed dungeon %s\n", arena.arenaId, arena.dungeon.Name)
+
+	arena.arenaState.Status = GAME_ROOM_STATUS_FAILED
+	atomic.StoreUint32(&arena.needSendAll, 0)
+	arena.sendAllNewState()
+}
+
+func (arena *ServerArena) isFinished() bool {
+	return arena.arenaState.Status != GAME_ROOM_STATUS_ACTIVE
+}
+
+// Сервер останавливается - игрокам сообщение, всем финальное состояние
+func (arena *ServerArena) notifyShutdown() {
+	log.Printf("Arena %d closed by server shutdown\n", arena.arenaId)
+
+	messageData := newShutdownMessageData()
+	for _, client := range arena.clients {
+		if client.isDetached() == false {
+			client.QueueSendData(SEND_KIND_RELIABLE, messageData)
+		}
+	}
+	arena.arenaState.Status = GAME_ROOM_STATUS_CLOSED
+	atomic.StoreUint32(&arena.needSendAll, 0)
+	arena.sendAllNewState()
+}
+
+// Завершение работы арены: клиенты отключаются после отправки очереди, сервер забывает арену
+func (arena *ServerArena) shutdown() {
+	atomic.StoreUint32(&arena.isClosed, 1)
+	close(arena.doneCh)
+
+	// Clients
+	for _, client := range arena.clients {
+		arena.recordResult(client)
+		client.CloseAfterSend()
+	}
+	arena.clients = arena.clients[:0]
+	for _, joining := range arena.joining {
+		joining.client.CloseAfterSend()
+	}
+	arena.joining = arena.joining[:0]
+
+	// Spectators остаются подключенными и получают список арен, при остановке сервера их отключает сервер
+	serverShuttingDown := arena.server.isShuttingDown()
+	for _, spectator := range arena.spectators {
+		spectator.setArena(nil)
+		if serverShuttingDown == false {
+			arena.server.SpectateArena(spectator, 0)
+		}
+	}
+	arena.spectators = arena.spectators[:0]
+
+	// Replay
+	err := arena.recorder.Close()
+	if err != nil {
+		log.Printf("Replay close error for arena %d: %s\n", arena.arenaId, err)
+	}
+
+	// Checkpoint, завершенную арену восстанавливать не нужно
+	if arena.config.Server.CheckpointsDir != "" {
+		err = RemoveArenaCheckpoint(arena.config.Server.CheckpointsDir, arena.arenaId)
+		if err != nil {
+			log.Printf("Checkpoint remove error for arena %d: %s\n", arena.arenaId, err)
+		}
+	}
+
+	// Server
+	arena.server.metrics.UnregisterArena(arena.metrics)
+	arena.server.DeleteRoom(arena)
+
+	log.Printf("Arena %d exit\n", arena.arenaId)
+}
+
+// Тики фиксированной длины step за накопленное время accumulator, возвращается остаток.
+// За раз выполняется не больше MaxCatchUp тиков, если сервер отстал сильнее - лишнее время отбрасывается
+func (arena *ServerArena) runFixedTicks(accumulator time.Duration, step time.Duration) time.Duration {
+	ticksCount := 0
+	for (accumulator >= step) && (ticksCount < arena.config.Arena.MaxCatchUp) {
+		// Команды клиентов применяются в первом тике, догоняющие тики только двигают мир
+		commands := []arenaCommand{}
+		if ticksCount == 0 {
+			commands = arena.collectClientCommands()
+		}
+
+		tickStartTime := time.Now()
+		arena.runTick(step.Seconds(), commands)
+		arena.server.metrics.ObserveTick(arena.metrics, time.Since(tickStartTime))
+		accumulator -= step
+		ticksCount++
+		if arena.isFinished() {
+			break
+		}
+	}
+
+	if accumulator >= step {
+		skipped := accumulator / step
+		log.Printf("Arena %d is behind, skipped %d ticks\n", arena.arenaId, skipped)
+		arena.server.metrics.AddSkippedTicks(uint64(skipped))
+		accumulator -= skipped * step
+	}
+	arena.metrics.SetCounts(len(arena.clients), len(arena.spectators), len(arena.arenaState.Monsters))
+	return accumulator
 }
 
 func (arena *ServerArena) mainLoop() {
-	updatePeriodMS := time.Millisecond * 50
-	updateTimer := time.NewTimer(updatePeriodMS)
-	lastTickTime := time.Now()
+	clock := arena.server.clock
+	step := arena.config.Arena.UpdatePeriod.Duration()
+	updateTimer := clock.NewTimer(step)
+	lastTickTime := clock.Now()
+	var accumulator time.Duration = 0 // прошедшее время, на которое еще не выполнены тики
+
+	newMonsterTimer := clock.NewTimer(arena.monsterTimeLeft)
+	arena.monsterDeadline = lastTickTime.Add(arena.monsterTimeLeft)
+
+	dungeonTimer := clock.NewTimer(arena.dungeonTimeLeft)
+	arena.dungeonDeadline = lastTickTime.Add(arena.dungeonTimeLeft)
+
+	// Снимки для восстановления пишутся, только если задана папка
+	var checkpointTimer ClockTimer = nil
+	var checkpointTimerCh <-chan time.Time = nil
+	if arena.config.Server.CheckpointsDir != "" {
+		checkpointTimer = clock.NewTimer(arena.config.Server.CheckpointPeriod.Duration())
+		checkpointTimerCh = checkpointTimer.C()
+	}
+
+	// Игроки восстановленной арены, не вернувшиеся за ReattachTimeout, удаляются
+	var reattachTimer ClockTimer = nil
+	var reattachTimerCh <-chan time.Time = nil
+	if arena.getDetachedCount() > 0 {
+		reattachTimer = clock.NewTimer(arena.config.Server.ReattachTimeout.Duration())
+		reattachTimerCh = reattachTimer.C()
+	}
+
+	// Ping игрокам для синхронизации времени и замера задержки, 0 - выключен
+	var pingTimer ClockTimer = nil
+	var pingTimerCh <-chan time.Time = nil
+	if arena.config.Client.PingPeriod > 0 {
+		pingTimer = clock.NewTimer(arena.config.Client.PingPeriod.Duration())
+		pingTimerCh = pingTimer.C()
+	}
+
+	// Таймер простоя запускается только когда на арене не осталось игроков
+	var idleTimer ClockTimer = nil
+	var idleTimerCh <-chan time.Time = nil
+	stopIdleTimer := func() {
+		if idleTimer != nil {
+			idleTimer.Stop()
+			idleTimer = nil
+			idleTimerCh = nil
+		}
+	}
+	startIdleTimer := func() {
+		if (len(arena.clients) == 0) && (len(arena.joining) == 0) && (idleTimer == nil) {
+			idleTimer = clock.NewTimer(arena.config.Arena.IdleTimeout.Duration())
+			idleTimerCh = idleTimer.C()
+		}
+	}
 
-	monsterGeneratePeriod := time.Second * 3
-	newMonsterTimer := time.NewTimer(monsterGeneratePeriod)
+	defer func() {
+		updateTimer.Stop()
+		newMonsterTimer.Stop()
+		dungeonTimer.Stop()
+		if checkpointTimer != nil {
+			checkpointTimer.Stop()
+		}
+		if reattachTimer != nil {
+			reattachTimer.Stop()
+		}
+		if pingTimer != nil {
+			pingTimer.Stop()
+		}
+		stopIdleTimer()
+		arena.shutdown()
+	}()
 
 	for {
 		select {
 		// Канал добавления нового юзера
 		case connection := <-arena.addClientByConnCh:
+			stopIdleTimer()
+
 			client := NewClient(connection, arena)
-			arena.clients = append(arena.clients, client)
 			client.StartLoop()
-
-			client.QueueSendData(arena.arenaData)
-			client.QueueSendCurrentClientState()
+			arena.startJoin(client)
 
 			/*arenaMapData, err := arena.arenaData.ToBytes()
 			if err == nil {
@@ -216,51 +1138,95 @@ func (arena *ServerArena) mainLoop() {
 			}*/
 			// TODO: Send arena
 
-		// Основной серверный таймер, который обновляет серверный мир
-		case <-updateTimer.C:
-			updateTimer.Reset(updatePeriodMS)
-			delta := time.Now().Sub(lastTickTime).Seconds()
-			lastTickTime = time.Now()
+		// Новый наблюдатель получает арену и текущее состояние
+		case spectator := <-arena.addSpectatorCh:
+			arena.spectators = append(arena.spectators, spectator)
+			spectator.setArena(arena)
+			spectator.QueueSendData(SEND_KIND_RELIABLE, arena.arenaData)
+			stateData, err := arena.arenaState.ToBytes()
+			if err == nil {
+				spectator.QueueSendData(SEND_KIND_ARENA_STATE, stateData)
+			}
 
-			arena.worldTick(delta)
+		case spectator := <-arena.deleteSpectatorCh:
+			for i := range arena.spectators {
+				if arena.spectators[i].id == spectator.id {
+					arena.spectators = append(arena.spectators[:i], arena.spectators[i+1:]...)
+					break
+				}
+			}
 
-			if atomic.LoadUint32(&arena.needSendAll) > 0 {
-				atomic.StoreUint32(&arena.needSendAll, 0)
-				arena.sendAllNewState()
+		// Основной серверный таймер, который обновляет серверный мир тиками фиксированной длины.
+		// Следующее срабатывание - к границе следующего тика, поэтому тики не уплывают
+		case <-updateTimer.C():
+			now := clock.Now()
+			arena.expireJoining(now)
+			accumulator += now.Sub(lastTickTime)
+			lastTickTime = now
+			accumulator = arena.runFixedTicks(accumulator, step)
+			if arena.isFinished() {
+				return
 			}
+			updateTimer.Reset(step - accumulator)
 
-		case <-newMonsterTimer.C:
-			newMonsterTimer.Reset(time.Second * 20)
+		case <-newMonsterTimer.C():
+			newMonsterTimer.Reset(arena.config.Arena.MonsterPeriod.Duration())
+			arena.monsterDeadline = clock.Now().Add(arena.config.Arena.MonsterPeriod.Duration())
+			arena.recorder.RecordMonsterTimer(arena.tick)
 			arena.createMonster()
 
+		// Время подземелья вышло
+		case <-dungeonTimer.C():
+			arena.recorder.RecordComplete(arena.tick)
+			arena.completeDungeon()
+			return
+
+		case <-checkpointTimerCh:
+			checkpointTimer.Reset(arena.config.Server.CheckpointPeriod.Duration())
+			arena.writeCheckpoint(clock.Now())
+
+		case <-pingTimerCh:
+			pingTimer.Reset(arena.config.Client.PingPeriod.Duration())
+			arena.sendPings(clock.Now())
+
+		case <-reattachTimerCh:
+			reattachTimer = nil
+			reattachTimerCh = nil
+			arena.removeDetachedClients()
+			startIdleTimer()
+
+		// На арене долго никого нет
+		case <-idleTimerCh:
+			log.Printf("Arena %d idle timeout\n", arena.arenaId)
+			return
+
+		// Запросы админки
+		case function := <-arena.callCh:
+			function()
+
 		case <-arena.forceSendAll:
 			atomic.StoreUint32(&arena.needSendAll, 0)
 			arena.sendAllNewState()
 
 		// Канал удаления нового юзера
 		case client := <-arena.deleteClientCh:
-			deleteIndex := -1
-			for i := range arena.clients {
-				if arena.clients[i].id == client.id {
-					deleteIndex = i
-					break
-				}
-			}
-			if deleteIndex >= 0 {
-				arena.clients = append(arena.clients[:deleteIndex], arena.clients[deleteIndex+1:]...)
-				arena.sendAllNewState()
+			// Выход записываем до удаления, чтобы порядок состояний в записи совпадал
+			if arena.findClient(client.id) != nil {
+				arena.recorder.RecordLeave(arena.tick, client.id)
+				arena.removeClient(client)
+			} else if arena.removeJoining(client) {
+				atomic.AddInt32(&arena.clientsCount, -1)
 			}
+			startIdleTimer()
 
 		// Выход из цикла обработки событий
 		case <-arena.exitLoopCh:
-			updateTimer.Stop()
-			newMonsterTimer.Stop()
-			// Clients
-			for _, client := range arena.clients {
-				client.Close()
-			}
-			// Server
-			arena.server.DeleteRoom(arena)
+			return
+
+		// Остановка сервера
+		case <-arena.server.shutdownCh:
+			arena.recorder.RecordShutdown(arena.tick)
+			arena.notifyShutdown()
 			return
 		}
 	}
@@ -7,12 +7,15 @@ import (
 const (
 	GAME_ROOM_STATUS_ACTIVE    = 0
 	GAME_ROOM_STATUS_COMPLETED = 1
+	GAME_ROOM_STATUS_CLOSED    = 2 // арена закрыта остановкой сервера
+	GAME_ROOM_STATUS_FAILED    = 3 // все игроки повержены
 )
 
 type GameArenaState struct {
 	Type     string               `json:"type"`
 	ID       uint32               `json:"id"`
 	Status   int8                 `json:"status"`
+	Tick     uint64               `json:"tick"` // номер тика арены, с которого получено состояние
 	Clients  []ServerClientState  `json:"clients"`
 	Monsters []ServerMonsterState `json:"monsters"`
 }
@@ -0,0 +1,28 @@
+package gameserver
+
+import (
+	"encoding/json"
+)
+
+type ArenasListItem struct {
+	ID      uint32 `json:"id"`
+	Clients int32  `json:"clients"`
+}
+
+// Список арен, отправляется наблюдателю
+type ArenasList struct {
+	Type   string           `json:"type"`
+	Arenas []ArenasListItem `json:"arenas"`
+}
+
+func NewArenasList() ArenasList {
+	list := ArenasList{
+		Type:   "ArenasList",
+		Arenas: []ArenasListItem{},
+	}
+	return list
+}
+
+func (list *ArenasList) ToBytes() ([]byte, error) {
+	return json.Marshal(list)
+}
@@ -1,36 +1,67 @@
 package gameserver
 
 import (
-	"encoding/binary"
 	"io"
 	"log"
-	"net"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-const UPDATE_QUEUE_SIZE = 100
+const UPDATE_QUEUE_SIZE = 100 // по умолчанию, задается в Config
+
+const PLAYER_REGENERATION_PERIOD = 60.0 // regeneration в units.json - восстановление здоровья за минуту
+
+const (
+	CLIENT_ROLE_PLAYER    = 0 // игрок на арене
+	CLIENT_ROLE_SPECTATOR = 1 // наблюдатель, не участвует в игре
+)
 
 // Variables
 var MAX_ID uint32 = 0
 
 // Структура клиента
 type ServerClient struct {
-	serverArena  *ServerArena
-	connection   *net.TCPConn
-	id           uint32
-	mutex        sync.RWMutex
-	stateValid   bool
-	state        ServerClientState
-	hits         []ClientCommandHitInfo
-	uploadDataCh chan []byte
-	exitReadCh   chan bool
-	exitWriteCh  chan bool
+	config      ClientConfig
+	server      *Server
+	serverArena *ServerArena
+	connection  ClientConnection // nil - игрок восстановленной арены еще не вернулся, меняется под mutex
+	id          uint32
+	session     string // токен для возвращения на арену после восстановления из снимка
+	role        uint8
+	mutex       sync.RWMutex
+	stateValid  bool
+	state       ServerClientState
+	commands    []*ClientCommand
+	attacks     []ClientAttack
+	latency     clientLatency
+	sendQueue   *SendQueue
+	exitReadCh  chan bool
+	exitWriteCh chan bool
+	writeDoneCh chan struct{} // закрывается при выходе из loopWrite
+	// Данные проверки ударов, используются только из цикла арены
+	hitBuckets    map[string]HitBucket // запас ударов по навыку, пустой ключ - обычный удар
+	hitViolations uint32
+	isFlagged     bool
+	// Здоровье игрока из units.json, меняется только из цикла арены, в state копируется под mutex
+	health       float64
+	maxHealth    float64
+	defence      float64
+	regeneration float64
+	defeatTime   float64 // время поражения по времени симуляции арены
+	joinTime     float64 // время входа на арену по времени симуляции арены
+	// Профиль для таблицы рекордов, задается командой клиента
+	profile     string
+	profileName string
+	// Количество работающих циклов чтения и записи
+	loopsCount int32
+	// Игрок ушел с арены в сессию восстановленной арены, итог забега не записывается
+	released uint32
 }
 
 // Конструктор
-func NewClient(connection *net.TCPConn, serverArena *ServerArena) *ServerClient {
+func NewClient(connection ClientConnection, serverArena *ServerArena) *ServerClient {
 	if connection == nil {
 		panic("No connection")
 	}
@@ -45,25 +76,147 @@ func NewClient(connection *net.TCPConn, serverArena *ServerArena) *ServerClient
 	clientState := NewServerClientState(curId)
 	clientState.Status = CLIENT_STATUS_IN_GAME
 
+	// Здоровье и защита игрока
+	playerInfo := GetApp().GetStaticInfo().Units[UNIT_NAME_PLAYER]
+	clientState.Health = int32(math.Ceil(playerInfo.Health))
+	clientState.MaxHealth = clientState.Health
+
+	return &ServerClient{
+		config:      serverArena.config.Client,
+		server:      serverArena.server,
+		serverArena: serverArena,
+		connection:  connection,
+		id:          curId,
+		session:     newSessionToken(),
+		role:        CLIENT_ROLE_PLAYER,
+		mutex:       sync.RWMutex{},
+		stateValid:  false,
+		state:       clientState,
+		commands:    make([]*ClientCommand, 0),
+		attacks:     make([]ClientAttack, 0),
+		sendQueue:   NewSendQueue(serverArena.config.Client),
+		exitReadCh:  make(chan bool, 1),
+		exitWriteCh: make(chan bool, 1),
+		writeDoneCh: make(chan struct{}),
+		// Hits validation
+		hitBuckets:    make(map[string]HitBucket),
+		hitViolations: 0,
+		isFlagged:     false,
+		// Vitals
+		health:       playerInfo.Health,
+		maxHealth:    playerInfo.Health,
+		defence:      playerInfo.Defence,
+		regeneration: playerInfo.Regeneration,
+	}
+}
+
+// Конструктор наблюдателя, к арене подключается командой SpectatorCommand
+func NewSpectator(connection ClientConnection, server *Server) *ServerClient {
+	if connection == nil {
+		panic("No connection")
+	}
+	if server == nil {
+		panic("No server")
+	}
+
+	curId := atomic.AddUint32(&MAX_ID, 1)
+
 	return &ServerClient{
-		serverArena:  serverArena,
-		connection:   connection,
-		id:           curId,
-		mutex:        sync.RWMutex{},
-		stateValid:   false,
-		state:        clientState,
-		hits:         make([]ClientCommandHitInfo, 0),
-		uploadDataCh: make(chan []byte, UPDATE_QUEUE_SIZE), // В канале апдейтов может накапливаться максимум 1000 апдейтов
-		exitReadCh:   make(chan bool, 1),
-		exitWriteCh:  make(chan bool, 1),
+		config:      server.config.Client,
+		server:      server,
+		serverArena: nil,
+		connection:  connection,
+		id:          curId,
+		role:        CLIENT_ROLE_SPECTATOR,
+		mutex:       sync.RWMutex{},
+		stateValid:  false,
+		state:       NewServerClientState(curId),
+		commands:    make([]*ClientCommand, 0),
+		attacks:     make([]ClientAttack, 0),
+		sendQueue:   NewSendQueue(server.config.Client),
+		exitReadCh:  make(chan bool, 1),
+		exitWriteCh: make(chan bool, 1),
+		writeDoneCh: make(chan struct{}),
+		hitBuckets:  make(map[string]HitBucket),
 	}
 }
 
+func (client *ServerClient) IsSpectator() bool {
+	return client.role == CLIENT_ROLE_SPECTATOR
+}
+
+func (client *ServerClient) getArena() *ServerArena {
+	client.mutex.RLock()
+	arena := client.serverArena
+	client.mutex.RUnlock()
+	return arena
+}
+
+// Арена меняется только у наблюдателей
+func (client *ServerClient) setArena(arena *ServerArena) {
+	client.mutex.Lock()
+	client.serverArena = arena
+	client.mutex.Unlock()
+}
+
+// Отключение от текущей арены при обрыве соединения
+func (client *ServerClient) detachFromArena() {
+	arena := client.getArena()
+	if arena == nil {
+		return
+	}
+	if client.IsSpectator() {
+		arena.DeleteSpectator(client)
+	} else {
+		arena.DeleteClient(client)
+	}
+}
+
+// Игрок восстановленной арены без соединения
+func (client *ServerClient) isDetached() bool {
+	return client.getConnection() == nil
+}
+
+// Соединение меняется, когда игрок возвращается на восстановленную арену,
+// а читают его циклы клиента, Close и админка из других горутин
+func (client *ServerClient) getConnection() ClientConnection {
+	client.mutex.RLock()
+	connection := client.connection
+	client.mutex.RUnlock()
+	return connection
+}
+
+// Соединение вернувшегося игрока, вызывается до StartLoop
+func (client *ServerClient) setConnection(connection ClientConnection) {
+	client.mutex.Lock()
+	client.connection = connection
+	client.mutex.Unlock()
+}
+
+func (client *ServerClient) isReleased() bool {
+	return atomic.LoadUint32(&client.released) > 0
+}
+
 func (client *ServerClient) Close() {
-	client.connection.Close()
+	connection := client.getConnection()
+	if connection == nil {
+		return
+	}
+	connection.Close()
 	log.Printf("Connection closed for client %d", client.id)
 }
 
+// Закрытие соединения после отправки всего, что уже стоит в очереди
+func (client *ServerClient) CloseAfterSend() {
+	client.sendQueue.CloseAfterSend()
+}
+
+func (client *ServerClient) SetStatus(status int8) {
+	client.mutex.Lock()
+	client.state.Status = status
+	client.mutex.Unlock()
+}
+
 func (client *ServerClient) IsValidState() bool {
 	client.mutex.RLock()
 	validCopy := client.stateValid
@@ -102,39 +255,351 @@ func (client *ServerClient) GetCurrentStateData(withReset bool) []byte {
 		}
 		return stateData
 	}
+}
+
+// Полученные команды применяются в тике арены, чтобы симуляцию можно было воспроизвести
+func (client *ServerClient) GetCommandsWithReset() []*ClientCommand {
+	client.mutex.Lock()
+	commands := client.commands
+	client.commands = make([]*ClientCommand, 0)
+	client.mutex.Unlock()
+	return commands
+}
+
+// Применение команды к состоянию клиента, time - время симуляции арены.
+// Команды с номером не больше уже примененного - повтор или пришли не по порядку, они отбрасываются
+func (client *ServerClient) applyCommand(command *ClientCommand, time time.Time) bool {
+	client.mutex.Lock()
+	if (command.Seq != 0) && (command.Seq <= client.state.LastSeq) {
+		client.mutex.Unlock()
+		return false
+	}
+	{
+		client.stateValid = true
+		if command.Seq != 0 {
+			client.state.LastSeq = command.Seq
+		}
+		// State
+		client.state.RotationX = command.RotationX
+		client.state.RotationY = command.RotationY
+		client.state.RotationZ = command.RotationZ
+		client.state.X = command.X
+		client.state.Y = command.Y
+		client.state.VX = command.VX
+		client.state.VY = command.VY
+		client.state.Duration += command.Duration // Специально + для накопления
+		client.state.VisualState = command.VisualState
+		client.state.AnimName = command.AnimName
+		client.state.StartSkillName = command.StartSkillName
+		// Attacks, проверяются и применяются в цикле арены
+		if len(command.HitMonsters) > 0 {
+			attack := ClientAttack{
+				Skill:    command.StartSkillName,
+				Time:     time,
+				ViewTick: command.Tick,
+				Hits:     command.HitMonsters,
+			}
+			client.attacks = append(client.attacks, attack)
+		}
+	}
+	client.mutex.Unlock()
+	return true
+}
 
-	return []byte{}
+func (client *ServerClient) setTick(tick uint64) {
+	client.mutex.Lock()
+	client.state.Tick = tick
+	client.mutex.Unlock()
 }
 
-func (client *ServerClient) GetCurrentHitsWithReset() []ClientCommandHitInfo {
+func (client *ServerClient) GetCurrentAttacksWithReset() []ClientAttack {
 	client.mutex.Lock()
-	hits := client.hits
-	client.hits = make([]ClientCommandHitInfo, 0)
+	attacks := client.attacks
+	client.attacks = make([]ClientAttack, 0)
 	client.mutex.Unlock()
-	return hits
+	return attacks
 }
 
-// Пишем сообщение клиенту
-func (client *ServerClient) QueueSendData(data []byte) {
-	// Если очередь превышена - считаем, что юзер отвалился
-	if len(client.uploadDataCh)+1 > UPDATE_QUEUE_SIZE {
-		log.Printf("Queue full for client %d", client.id)
+func (client *ServerClient) GetPosition() PointFloat {
+	client.mutex.RLock()
+	position := NewPointFloat(client.state.X, client.state.Y)
+	client.mutex.RUnlock()
+	return position
+}
+
+// Данные клиента для админки, вызывается из цикла арены
+func (client *ServerClient) getAdminInfo() AdminClientInfo {
+	info := AdminClientInfo{
+		State:         client.GetCurrentState(false),
+		Detached:      client.isDetached(),
+		HitViolations: client.hitViolations,
+		IsFlagged:     client.isFlagged,
+		QueueSize:     client.sendQueue.Len(),
+		Dropped:       client.sendQueue.GetDropped(),
+		Coalesced:     client.sendQueue.GetCoalesced(),
+	}
+	if rtt, jitter, ok := client.GetLatency(); ok {
+		info.RTT = rtt.Seconds()
+		info.Jitter = jitter.Seconds()
+	}
+	connection := client.getConnection()
+	if connection == nil {
+		return info
+	}
+	if address := connection.RemoteAddr(); address != nil {
+		info.RemoteAddress = address.String()
+	}
+	return info
+}
+
+// Профиль игрока, под которым записываются его результаты, слишком длинные значения обрезаются
+func (client *ServerClient) setProfile(profile, name string) {
+	if len(profile) > LEADERBOARD_MAX_PROFILE {
+		profile = profile[:LEADERBOARD_MAX_PROFILE]
+	}
+	if len(name) > LEADERBOARD_MAX_PROFILE {
+		name = name[:LEADERBOARD_MAX_PROFILE]
+	}
+	client.mutex.Lock()
+	client.profile = profile
+	client.profileName = name
+	client.mutex.Unlock()
+}
+
+// Ответ на запрос таблицы рекордов, при ошибке запроса приходит ServerMessage
+func (client *ServerClient) sendLeaderboard(query *LeaderboardQuery) {
+	if query == nil {
+		query = &LeaderboardQuery{}
+	}
+	var leaderboard *Leaderboard = nil
+	if client.server != nil {
+		leaderboard = client.server.leaderboard
+	}
+	message, err := leaderboard.Query(*query, time.Now())
+	if err != nil {
+		client.QueueSendData(SEND_KIND_REPLY, newServerErrorMessageData(err.Error()))
+		return
+	}
+	data, err := message.ToBytes()
+	if err != nil {
+		log.Printf("Leaderboard data make error for client %d: %s\n", client.id, err)
 		return
-	} else {
-		client.uploadDataCh <- data
+	}
+	client.QueueSendData(SEND_KIND_REPLY, data)
+}
+
+// Итог забега для таблицы рекордов, вызывается из цикла арены
+func (client *ServerClient) getResult(dungeon string, arenaId uint32, simTime float64) LeaderboardResult {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return LeaderboardResult{
+		Profile:    client.profile,
+		Name:       client.profileName,
+		Dungeon:    dungeon,
+		ArenaId:    arenaId,
+		Damage:     client.state.TotalDamage,
+		Kills:      client.state.Kills,
+		Time:       simTime - client.joinTime,
+		Status:     client.state.Status,
+		FinishTime: time.Now(),
+	}
+}
+
+// Учитываем урон только по прошедшим проверку ударам
+func (client *ServerClient) AddTotalDamage(damage uint32) {
+	client.mutex.Lock()
+	client.state.TotalDamage += damage
+	client.mutex.Unlock()
+}
+
+// Монстр добит ударом этого клиента
+func (client *ServerClient) AddKill() {
+	client.mutex.Lock()
+	client.state.Kills++
+	client.mutex.Unlock()
+}
+
+// Игрок повержен и ждет возрождения или конца арены
+func (client *ServerClient) IsDefeated() bool {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.state.Status == CLIENT_STATUS_FAIL
+}
+
+// Урон от монстра с учетом защиты, true - если игрок повержен этим ударом
+func (client *ServerClient) applyDamage(power float64, time float64) bool {
+	if client.IsDefeated() || (power <= 0) {
+		return false
+	}
+	client.health -= power * power / (power + client.defence)
+	defeated := client.health <= 0
+	if defeated {
+		client.health = 0
+		client.defeatTime = time
+	}
+
+	client.mutex.Lock()
+	client.state.Health = int32(math.Ceil(client.health))
+	if defeated {
+		client.state.Status = CLIENT_STATUS_FAIL
+	}
+	client.mutex.Unlock()
+	return defeated
+}
+
+// Восстановление здоровья за тик, true - если здоровье изменилось
+func (client *ServerClient) regenerate(delta float64) bool {
+	if client.IsDefeated() || (client.health >= client.maxHealth) || (client.regeneration <= 0) {
+		return false
+	}
+	client.health = math.Min(client.health+client.regeneration*delta/PLAYER_REGENERATION_PERIOD, client.maxHealth)
+
+	client.mutex.Lock()
+	client.state.Health = int32(math.Ceil(client.health))
+	client.mutex.Unlock()
+	return true
+}
+
+// Возрождение с полным здоровьем
+func (client *ServerClient) revive() {
+	client.health = client.maxHealth
+
+	client.mutex.Lock()
+	client.state.Health = int32(math.Ceil(client.health))
+	client.state.Status = CLIENT_STATUS_IN_GAME
+	client.mutex.Unlock()
+}
+
+// Метрики сервера и текущей арены, любые могут быть nil
+func (client *ServerClient) getMetrics() (*ServerMetrics, *ArenaMetrics) {
+	var serverMetrics *ServerMetrics = nil
+	if client.server != nil {
+		serverMetrics = client.server.metrics
+	}
+	var arenaMetrics *ArenaMetrics = nil
+	if arena := client.getArena(); arena != nil {
+		arenaMetrics = arena.metrics
+	}
+	return serverMetrics, arenaMetrics
+}
+
+// Пишем сообщение клиенту, при медленном клиенте сообщение обрабатывается по политике своего вида
+func (client *ServerClient) QueueSendData(kind SendKind, data []byte) {
+	result := client.sendQueue.Push(kind, data, time.Now())
+	switch result {
+	case SEND_RESULT_DROPPED:
+		log.Printf("Queue full for client %d", client.id)
+		serverMetrics, arenaMetrics := client.getMetrics()
+		serverMetrics.AddQueueFullDrop(arenaMetrics)
+	case SEND_RESULT_COALESCED:
+		serverMetrics, arenaMetrics := client.getMetrics()
+		serverMetrics.AddQueueCoalesced(arenaMetrics)
+	case SEND_RESULT_SLOW:
+		// Соединение закрываем, циклы чтения и записи завершатся с ошибкой и отключат клиента от арены
+		log.Printf("Client %d is too slow, disconnecting\n", client.id)
+		serverMetrics, _ := client.getMetrics()
+		serverMetrics.AddSlowDisconnect()
+		client.Close()
 	}
 }
 
 // Пишем сообщение клиенту только с его состоянием
 func (client *ServerClient) QueueSendCurrentClientState() {
 	data := client.GetCurrentStateData(false)
-	client.QueueSendData(data)
+	client.QueueSendData(SEND_KIND_CLIENT_STATE, data)
+}
+
+// Токен сессии только этому игроку, в запись арены не попадает
+func (client *ServerClient) QueueSendSession(arenaId uint32) {
+	session := NewClientSession(arenaId, client.id, client.session)
+	data, err := session.ToBytes()
+	if err != nil {
+		log.Printf("Session data make error for client %d: %s\n", client.id, err)
+		return
+	}
+	client.QueueSendData(SEND_KIND_RELIABLE, data)
+}
+
+// Ping для замера задержки, в ping уже заполнены время и тик арены. Ping без ответа заменяется новым
+func (client *ServerClient) queuePing(ping ServerPing, now time.Time) {
+	client.mutex.Lock()
+	client.latency.pingId++
+	client.latency.pingTime = now
+	ping.ID = client.latency.pingId
+	if client.latency.samples > 0 {
+		ping.RTT = client.latency.rtt.Seconds()
+		ping.Jitter = client.latency.jitter.Seconds()
+	}
+	client.mutex.Unlock()
+
+	data, err := ping.ToBytes()
+	if err != nil {
+		log.Printf("Ping data make error for client %d: %s\n", client.id, err)
+		return
+	}
+	client.QueueSendData(SEND_KIND_REPLY, data)
+}
+
+// Ответ клиента на Ping, вызывается из цикла чтения
+func (client *ServerClient) handlePong(pingId uint32, now time.Time) {
+	client.mutex.Lock()
+	sample, ok := client.latency.pong(pingId, now)
+	client.mutex.Unlock()
+	if ok {
+		serverMetrics, _ := client.getMetrics()
+		serverMetrics.ObserveRTT(sample)
+	}
+}
+
+// Сглаженные RTT и разброс, false - ответов на Ping еще не было
+func (client *ServerClient) GetLatency() (time.Duration, time.Duration, bool) {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.latency.rtt, client.latency.jitter, client.latency.samples > 0
+}
+
+// Переход соединения в сессию восстановленной арены. Текущая арена отпускает игрока без итога,
+// цикл записи отправляет очередь и завершается, дальше соединение обслуживает игрок из снимка.
+// true - соединение передано или закрыто, циклы этого клиента должны завершиться
+func (client *ServerClient) reattach(token string, resourcesHash string) bool {
+	target := client.server.findSession(token)
+	if (target == nil) || (target.hasSession(token) == false) {
+		client.QueueSendData(SEND_KIND_REPLY, newServerErrorMessageData("unknown session"))
+		return false
+	}
+
+	atomic.StoreUint32(&client.released, 1)
+	client.detachFromArena()
+	client.exitWriteCh <- true
+	<-client.writeDoneCh
+
+	if target.attachSession(token, client.getConnection(), resourcesHash) == false {
+		log.Printf("Reattach failed for client %d, arena %d closed\n", client.id, target.arenaId)
+		client.Close()
+	}
+	return true
 }
 
 // Запускаем ожидания записи и чтения (блокирующая функция)
 func (client *ServerClient) StartLoop() {
-	go client.loopWrite() // в отдельной горутине
-	go client.loopRead()
+	// Сервер отслеживает клиента, пока работает хотя бы один цикл
+	client.server.trackClient(client)
+	atomic.StoreInt32(&client.loopsCount, 2)
+	loopDone := func() {
+		if atomic.AddInt32(&client.loopsCount, -1) == 0 {
+			client.server.untrackClient(client)
+		}
+	}
+
+	client.server.startGoroutine(func() { // в отдельной горутине
+		client.loopWrite()
+		close(client.writeDoneCh)
+		loopDone()
+	})
+	client.server.startGoroutine(func() {
+		client.loopRead()
+		loopDone()
+	})
 }
 
 func (client *ServerClient) StopLoop() {
@@ -146,46 +611,51 @@ func (client *ServerClient) StopLoop() {
 // Ожидание записи
 func (client *ServerClient) loopWrite() {
 	//log.Println("StartSyncListenLoop write to client:", client.id)
+	connection := client.getConnection()
 	for {
-		select {
-		// Отправка записи клиенту
-		case payloadData := <-client.uploadDataCh:
-			// Размер данных
-			dataBytes := make([]byte, 4)
-			binary.BigEndian.PutUint32(dataBytes, uint32(len(payloadData)))
-
-			// Данные для отправки
-			sendData := append(dataBytes, payloadData...)
-
-			// Таймаут
-			timeout := time.Now().Add(30 * time.Second)
-			(*client.connection).SetWriteDeadline(timeout)
-
-			// Отсылаем
-			writenCount, err := (*client.connection).Write(sendData)
-			if (err != nil) || (writenCount < len(sendData)) {
-				client.serverArena.DeleteClient(client)
+		payloadData, exists, closing := client.sendQueue.Pop()
+		if exists == false {
+			// Очередь отправлена, закрываем соединение
+			if closing {
 				client.Close()
-				client.exitReadCh <- true // Выход из loopRead
-				if err != nil {
-					log.Printf("LoopWrite exit by ERROR (%s), clientId = %d\n", err, client.id)
-				} else if writenCount < len(sendData) {
-					log.Printf("LoopWrite exit by less bytes - %d from %d, clientId = %d\n", writenCount, len(sendData), client.id)
-				}
+				log.Println("LoopWrite exit after send, clientId =", client.id)
+				return
+			}
+
+			select {
+			// Новые сообщения в очереди
+			case <-client.sendQueue.ReadyCh():
+				continue
+			// Получение флага выхода из функции
+			case <-client.exitWriteCh:
+				log.Println("LoopWrite exit, clientId =", client.id)
 				return
 			}
+		}
+
+		// Таймаут
+		timeout := time.Now().Add(client.config.WriteTimeout.Duration())
+		connection.SetWriteDeadline(timeout)
 
-		// Получение флага выхода из функции
-		case <-client.exitWriteCh:
-			log.Println("LoopWrite exit, clientId =", client.id)
+		// Отсылаем, рамку сообщения добавляет транспорт
+		err := connection.WriteMessage(payloadData)
+		serverMetrics, arenaMetrics := client.getMetrics()
+		if err != nil {
+			serverMetrics.AddWriteError()
+			client.detachFromArena()
+			client.Close()
+			client.exitReadCh <- true // Выход из loopRead
+			log.Printf("LoopWrite exit by ERROR (%s), clientId = %d\n", err, client.id)
 			return
 		}
+		serverMetrics.AddSent(arenaMetrics, len(payloadData))
 	}
 }
 
 // Ожидание чтения
 func (client *ServerClient) loopRead() {
 	//log.Println("Listening read from client")
+	connection := client.getConnection()
 	for {
 		select {
 		// Получение флага выхода
@@ -195,59 +665,45 @@ func (client *ServerClient) loopRead() {
 
 		// Чтение данных из сокета
 		default:
-			// Ожидается, что за 10 минут что-то придет, иначе - это отвал
-			timeout := time.Now().Add(10 * time.Minute)
-			(*client.connection).SetReadDeadline(timeout)
+			// Ожидается, что за это время что-то придет, иначе - это отвал
+			timeout := time.Now().Add(client.config.IdleTimeout.Duration())
+			connection.SetReadDeadline(timeout)
 
-			// Размер данных
-			dataSizeBytes := make([]byte, 4)
-			readCount, err := (*client.connection).Read(dataSizeBytes)
+			// Сообщение целиком, рамку разбирает транспорт
+			data, err := connection.ReadMessage()
 
 			// Ошибка чтения данных
-			if (err != nil) || (readCount < 4) {
-				client.serverArena.DeleteClient(client)
+			if err != nil {
+				client.detachFromArena()
 				client.Close()
 				client.exitWriteCh <- true // для метода loopWrite, чтобы выйти из него
 
 				if err == io.EOF {
 					log.Printf("LoopRead exit by disconnect, clientId = %d\n", client.id)
-				} else if err != nil {
+				} else {
+					serverMetrics, _ := client.getMetrics()
+					serverMetrics.AddReadError()
 					log.Printf("LoopRead exit by ERROR (%s), clientId = %d\n", err, client.id)
-				} else if readCount < 4 {
-					log.Printf("LoopRead exit - read less 8 bytes (%d bytes), clientId = %d\n", readCount, client.id)
 				}
 				return
 			}
-			dataSize := binary.BigEndian.Uint32(dataSizeBytes)
+			serverMetrics, _ := client.getMetrics()
+			serverMetrics.AddReceived(len(data))
 
-			// Ожидается, что будут данные в течении 30 секунд - иначе отвал
-			timeout = time.Now().Add(30 * time.Second)
-			(*client.connection).SetReadDeadline(timeout)
-
-			// Данные
-			data := make([]byte, dataSize)
-			readCount, err = (*client.connection).Read(data)
-
-			// Ошибка чтения данных
-			if (err != nil) || (uint32(readCount) < dataSize) {
-				client.serverArena.DeleteClient(client)
-				client.Close()
-				client.exitWriteCh <- true // для метода loopWrite, чтобы выйти из него
-
-				if err == io.EOF {
-					log.Printf("LoopRead exit by disconnect, clientId = %d\n", client.id)
-				} else if err != nil {
-					log.Printf("LoopRead exit by ERROR (%s), clientId = %d\n", err, client.id)
-				} else if uint32(readCount) < dataSize {
-					log.Printf("LoopRead exit - read less %d bytes (%d bytes), clientId = %d\n", dataSize, readCount, client.id)
+			if (len(data) > 0) && client.IsSpectator() {
+				command, err := NewSpectatorCommand(data)
+				if err != nil {
+					log.Printf("Error read spectator command, clientId = %d, command = %s\n", client.id, string(data))
+					continue
 				}
-				return
+				client.server.SpectateArena(client, command.ArenaId)
+				continue
 			}
 
-			if readCount > 0 {
+			if len(data) > 0 {
 				command, err := NewClientCommand(data)
 				if err != nil {
-					client.serverArena.DeleteClient(client)
+					client.detachFromArena()
 					client.Close()
 					client.exitWriteCh <- true // для метода loopWrite, чтобы выйти из него
 
@@ -255,32 +711,34 @@ func (client *ServerClient) loopRead() {
 					return
 				}
 
+				// Профиль и таблица рекордов не относятся к симуляции арены
+				switch command.CommandType {
+				case CLIENT_COMMAND_TYPE_PROFILE:
+					client.setProfile(command.Profile, command.Name)
+					continue
+				case CLIENT_COMMAND_TYPE_LEADERBOARD:
+					client.sendLeaderboard(command.Leaderboard)
+					continue
+				case CLIENT_COMMAND_TYPE_PONG:
+					client.handlePong(command.PingID, time.Now())
+					continue
+				case CLIENT_COMMAND_TYPE_JOIN:
+					if arena := client.getArena(); arena != nil {
+						arena.JoinClient(client, command.ResourcesHash)
+					}
+					continue
+				case CLIENT_COMMAND_TYPE_REATTACH:
+					if client.reattach(command.Session, command.ResourcesHash) {
+						log.Printf("LoopRead exit by reattach, clientId = %d\n", client.id)
+						return
+					}
+					continue
+				}
+
+				// ставим в очередь, команда применится в следующем тике арены
 				client.mutex.Lock()
-                {
-                    client.stateValid = true
-                    // State
-                    client.state.RotationX = command.RotationX
-                    client.state.RotationY = command.RotationY
-                    client.state.RotationZ = command.RotationZ
-                    client.state.X = command.X
-                    client.state.Y = command.Y
-                    client.state.VX = command.VX
-                    client.state.VY = command.VY
-                    client.state.Duration += command.Duration // Специально + для накопления
-                    client.state.VisualState = command.VisualState
-                    client.state.AnimName = command.AnimName
-                    client.state.StartSkillName = command.StartSkillName
-                    // Damage
-                    for i, _ := range command.HitMonsters{
-                        client.state.TotalDamage += uint32(command.HitMonsters[i].Damage) / 10
-                    }
-                    // Hits
-                    client.hits = append(client.hits, command.HitMonsters...)
-                }
+				client.commands = append(client.commands, command)
 				client.mutex.Unlock()
-
-				// ставим в очередь обновление
-				client.serverArena.ClientStateUpdated(client, false)
 			}
 		}
 	}
@@ -27,6 +27,11 @@ type ServerClientState struct {
 	AnimName       string  `json:"animName"`
 	StartSkillName string  `json:"startSkillName"`
 	TotalDamage    uint32  `json:"totalDamage"`
+	Kills          uint32  `json:"kills"`
+	Health         int32   `json:"health"`
+	MaxHealth      int32   `json:"maxHealth"`
+	LastSeq        uint32  `json:"lastSeq"` // номер последней примененной команды клиента
+	Tick           uint64  `json:"tick"`    // тик арены, на котором получено состояние
 }
 
 func NewServerClientState(id uint32) ServerClientState {
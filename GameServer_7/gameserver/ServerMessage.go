@@ -0,0 +1,42 @@
+package gameserver
+
+import (
+	"encoding/json"
+)
+
+const (
+	SERVER_MESSAGE_SHUTDOWN = "shutdown" // сервер останавливается, соединение будет закрыто
+	SERVER_MESSAGE_ERROR    = "error"    // команда клиента не выполнена
+)
+
+// Служебное сообщение сервера клиенту
+type ServerMessage struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func NewServerMessage(code string, message string) ServerMessage {
+	serverMessage := ServerMessage{
+		Type:    "ServerMessage",
+		Code:    code,
+		Message: message,
+	}
+	return serverMessage
+}
+
+func (serverMessage *ServerMessage) ToBytes() ([]byte, error) {
+	return json.Marshal(serverMessage)
+}
+
+func newShutdownMessageData() []byte {
+	serverMessage := NewServerMessage(SERVER_MESSAGE_SHUTDOWN, "server shutting down")
+	data, _ := serverMessage.ToBytes()
+	return data
+}
+
+func newServerErrorMessageData(message string) []byte {
+	serverMessage := NewServerMessage(SERVER_MESSAGE_ERROR, message)
+	data, _ := serverMessage.ToBytes()
+	return data
+}
@@ -18,6 +18,11 @@ type ServerMonsterState struct {
 	Health        int16   `json:"health"`
 	VisualState   int16   `json:"visualState"`
 	AnimationName string  `json:"animName"`
+	// Только для сервера
+	maxHealth      int16             // здоровье при появлении, с ним монстр возрождается
+	deathTime      float64           // время смерти по времени симуляции арены
+	damage         map[uint32]uint32 // урон от игроков по id, для списка участников убийства
+	nextAttackTime float64           // время следующей атаки по времени симуляции
 }
 
 func NewServerMonsterState(id uint32) ServerMonsterState {
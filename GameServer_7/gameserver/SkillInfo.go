@@ -0,0 +1,95 @@
+package gameserver
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+const (
+	SKILL_PARAM_DAMAGE      = "damage"
+	SKILL_PARAM_MAX_TARGETS = "max_targets"
+)
+
+type SkillParamsInfo struct {
+	Level       int16              `json:"level"`        // уровень навыка
+	Cooldown    float64            `json:"cooldown"`     // перезарядка в секундах
+	ActionParam map[string]float64 `json:"action_param"` // параметры действия
+}
+
+type SkillInfo struct {
+	Name   string            `json:"-"`      // имя навыка (ключ в файле)
+	Icon   string            `json:"icon"`   // иконка
+	Card   string            `json:"card"`   // карта навыка
+	Order  int16             `json:"ord"`    // порядок
+	Type   string            `json:"type"`   // тип навыка
+	Params []SkillParamsInfo `json:"params"` // параметры по уровням
+}
+
+func NewSkillsFromReader(reader io.Reader) (map[string]*SkillInfo, error) {
+	result := make(map[string]*SkillInfo)
+	decoder := json.NewDecoder(reader)
+	err := decoder.Decode(&result)
+	if err == nil {
+		for name, info := range result {
+			info.Name = name
+		}
+	}
+	return result, err
+}
+
+func NewSkillsFromFile(filePath string) (map[string]*SkillInfo, error) {
+	// Загрузка навыков из файла
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Println(err)
+		return make(map[string]*SkillInfo), err
+	}
+	defer f.Close()
+
+	return NewSkillsFromReader(f)
+}
+
+// Наносит ли навык урон
+func (info *SkillInfo) IsDamaging() bool {
+	for _, params := range info.Params {
+		if _, exists := params.ActionParam[SKILL_PARAM_DAMAGE]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// Максимальный множитель урона среди всех уровней навыка
+func (info *SkillInfo) MaxDamageFactor() float64 {
+	result := 0.0
+	for _, params := range info.Params {
+		if value := params.ActionParam[SKILL_PARAM_DAMAGE]; value > result {
+			result = value
+		}
+	}
+	return result
+}
+
+// Наибольшее число целей среди всех уровней навыка, 0 - не задано
+func (info *SkillInfo) MaxTargets() int {
+	result := 0.0
+	for _, params := range info.Params {
+		if value := params.ActionParam[SKILL_PARAM_MAX_TARGETS]; value > result {
+			result = value
+		}
+	}
+	return int(result)
+}
+
+// Минимальная перезарядка среди всех уровней навыка
+func (info *SkillInfo) MinCooldown() float64 {
+	result := 0.0
+	for i, params := range info.Params {
+		if (i == 0) || (params.Cooldown < result) {
+			result = params.Cooldown
+		}
+	}
+	return result
+}
@@ -0,0 +1,16 @@
+package gameserver
+
+import (
+	"encoding/json"
+)
+
+// Команда наблюдателя: подключиться к арене с указанным id
+type SpectatorCommand struct {
+	ArenaId uint32 `json:"arenaId"`
+}
+
+func NewSpectatorCommand(data []byte) (*SpectatorCommand, error) {
+	command := &SpectatorCommand{}
+	err := json.Unmarshal(data, command)
+	return command, err
+}
@@ -1,42 +1,113 @@
 package gameserver
 
 import (
+	"errors"
 	"io/ioutil"
 	"log"
+	"path/filepath"
 )
 
 type StaticInfo struct {
-	Platforms     map[string]*PlatformInfo
-	Levels        map[string]*LevelInfo
-	TestArenaData []byte
+	Platforms       map[string]*PlatformInfo
+	PlatformObjects map[string]*PlatformObjectSizeInfo
+	Levels          map[string]*LevelInfo
+	Dungeons        map[string]*DungeonInfo
+	Units           map[string]*UnitInfo
+	Skills          map[string]*SkillInfo
+	Bonuses         map[string]*BonusInfo
+	Lights          map[string][]LightInfo
+	Particles       map[string]*ParticleEffectInfo
+	TestArenaData   []byte
 }
 
-func NewStaticInfo() (*StaticInfo, error) {
+func NewStaticInfo(dataDir string) (*StaticInfo, error) {
 	// Load platforms
-	platforms, err := NewPlatformsFromFile("data/platforms.json")
+	platforms, err := NewPlatformsFromFile(filepath.Join(dataDir, "platforms.json"))
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
+	// Load platform object sizes
+	platformObjects, err := NewPlatformObjectSizesFromFile(filepath.Join(dataDir, "platform_objects_info.json"))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for _, info := range platforms {
+		info.ApplyObjectSizes(platformObjects)
+	}
+
 	// Load levels
-	levels, err := NewLevelsFromFile("data/level_graphics.json")
+	levels, err := NewLevelsFromFile(filepath.Join(dataDir, "level_graphics.json"))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	// Load dungeons
+	dungeons, err := NewDungeonsFromFile(filepath.Join(dataDir, "dungeons.json"))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	// Load units
+	units, err := NewUnitsFromFile(filepath.Join(dataDir, "units.json"))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	if _, exists := units[UNIT_NAME_PLAYER]; exists == false {
+		return nil, errors.New("No player unit info")
+	}
+
+	// Load skills
+	skills, err := NewSkillsFromFile(filepath.Join(dataDir, "skills.json"))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	// Load bonuses
+	bonuses, err := NewBonusesFromFile(filepath.Join(dataDir, "bonuses.json"))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	// Load lights
+	lights, err := NewLightsFromFile(filepath.Join(dataDir, "objects_statis_lights.json"))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	// Load particle effects
+	particles, err := NewParticleEffectsFromFile(filepath.Join(dataDir, "particle_effects.json"))
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
 	// Test arena
-	testArenaData, err := ioutil.ReadFile("data/arenaDump2x2.json")
+	testArenaData, err := ioutil.ReadFile(filepath.Join(dataDir, "arenaDump2x2.json"))
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
 	staticInfo := &StaticInfo{
-		Platforms:     platforms,
-		Levels:        levels,
-		TestArenaData: testArenaData,
+		Platforms:       platforms,
+		PlatformObjects: platformObjects,
+		Levels:          levels,
+		Dungeons:        dungeons,
+		Units:           units,
+		Skills:          skills,
+		Bonuses:         bonuses,
+		Lights:          lights,
+		Particles:       particles,
+		TestArenaData:   testArenaData,
 	}
 	return staticInfo, nil
 }
@@ -0,0 +1,179 @@
+package gameserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	TLS_RELOAD_PERIOD     = 10 * time.Second // по умолчанию, задается в Config
+	TLS_HANDSHAKE_TIMEOUT = 10 * time.Second
+)
+
+// Проверка клиентских сертификатов при заданном CA
+const (
+	TLS_CLIENT_AUTH_OPTIONAL = "optional" // сертификат необязателен, присланный должен быть подписан CA
+	TLS_CLIENT_AUTH_REQUIRE  = "require"  // без сертификата, подписанного CA, соединение обрывается
+)
+
+func IsValidTLSClientAuth(clientAuth string) bool {
+	return (clientAuth == TLS_CLIENT_AUTH_OPTIONAL) || (clientAuth == TLS_CLIENT_AUTH_REQUIRE)
+}
+
+// Сертификат сервера и CA клиентских сертификатов из файлов. Файлы перечитываются без перезапуска:
+// при рукопожатии, если с прошлой проверки прошло reloadPeriod и файлы изменились, или по Reload.
+// Уже открытые соединения остаются на старом сертификате
+type TLSCertificates struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string // пустой - клиентский сертификат не требуется
+	clientAuth   string
+	reloadPeriod time.Duration
+	mutex        sync.Mutex
+	config       *tls.Config
+	modTimes     []time.Time // время изменения файлов при последней загрузке
+	checkTime    time.Time
+}
+
+func NewTLSCertificates(config ServerConfig) (*TLSCertificates, error) {
+	certificates := &TLSCertificates{
+		certFile:     config.TLSCertFile,
+		keyFile:      config.TLSKeyFile,
+		clientCAFile: config.TLSClientCAFile,
+		clientAuth:   config.TLSClientAuth,
+		reloadPeriod: config.TLSReloadPeriod.Duration(),
+	}
+	err := certificates.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
+
+// Настройки для листенеров, каждое рукопожатие берет текущие сертификаты
+func (certificates *TLSCertificates) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			return certificates.getConfig(time.Now()), nil
+		},
+	}
+}
+
+// Чтение файлов, при ошибке остаются прежние сертификаты
+func (certificates *TLSCertificates) Reload() error {
+	modTimes, err := certificates.readModTimes()
+	if err != nil {
+		return err
+	}
+	config, err := certificates.load()
+	if err != nil {
+		return err
+	}
+
+	certificates.mutex.Lock()
+	certificates.config = config
+	certificates.modTimes = modTimes
+	certificates.checkTime = time.Now()
+	certificates.mutex.Unlock()
+	log.Printf("TLS certificates loaded from %s\n", certificates.certFile)
+	return nil
+}
+
+func (certificates *TLSCertificates) load() (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certificates.certFile, certificates.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	if certificates.clientCAFile != "" {
+		data, err := ioutil.ReadFile(certificates.clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(data) == false {
+			return nil, fmt.Errorf("No certificates in %s", certificates.clientCAFile)
+		}
+		// По умолчанию сертификат необязателен: присланный сертификат должен быть подписан этим CA,
+		// иначе рукопожатие обрывается. С require без сертификата не подключиться ни к одному листенеру TLS
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if certificates.clientAuth == TLS_CLIENT_AUTH_REQUIRE {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config, nil
+}
+
+func (certificates *TLSCertificates) files() []string {
+	files := []string{certificates.certFile, certificates.keyFile}
+	if certificates.clientCAFile != "" {
+		files = append(files, certificates.clientCAFile)
+	}
+	return files
+}
+
+func (certificates *TLSCertificates) readModTimes() ([]time.Time, error) {
+	files := certificates.files()
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// Текущие настройки, раз в reloadPeriod проверяется, не изменились ли файлы
+func (certificates *TLSCertificates) getConfig(now time.Time) *tls.Config {
+	certificates.mutex.Lock()
+	config := certificates.config
+	check := (certificates.reloadPeriod > 0) && (now.Sub(certificates.checkTime) >= certificates.reloadPeriod)
+	if check {
+		certificates.checkTime = now
+	}
+	oldModTimes := certificates.modTimes
+	certificates.mutex.Unlock()
+	if check == false {
+		return config
+	}
+
+	modTimes, err := certificates.readModTimes()
+	if (err != nil) || (equalTimes(modTimes, oldModTimes)) {
+		return config
+	}
+	// Файлы могут переписываться не одновременно, тогда загрузится при следующей проверке
+	err = certificates.Reload()
+	if err != nil {
+		log.Printf("TLS certificates reload error: %s\n", err)
+		return config
+	}
+	certificates.mutex.Lock()
+	defer certificates.mutex.Unlock()
+	return certificates.config
+}
+
+func equalTimes(first []time.Time, second []time.Time) bool {
+	if len(first) != len(second) {
+		return false
+	}
+	for i := range first {
+		if first[i].Equal(second[i]) == false {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package gameserver
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+const UNIT_NAME_PLAYER = "player"
+
+type UnitInfo struct {
+	Name            string   `json:"-"`                // имя юнита (ключ в файле)
+	SymbolName      string   `json:"symbol_name"`      // имя символа
+	Mass            float64  `json:"mass"`             // масса
+	AccelerateSpeed float64  `json:"accelerate_speed"` // ускорение
+	MoveSpeed       float64  `json:"move_speed"`       // скорость движения
+	AttackSpeed     float64  `json:"attack_speed"`     // атак в секунду
+	RotateSpeed     float64  `json:"rotate_speed"`     // скорость поворота
+	Power           float64  `json:"power"`            // сила атаки
+	Defence         float64  `json:"defence"`          // защита
+	Health          float64  `json:"health"`           // здоровье
+	Regeneration    float64  `json:"regeneration"`     // восстановление здоровья
+	Reward          uint32   `json:"reward"`           // награда за убийство
+	BoundingRadius  float64  `json:"bounding_radius"`  // радиус юнита
+	AttackRadius    float64  `json:"attack_radius"`    // радиус атаки
+	MaxTargets      int      `json:"max_targets"`      // сколько монстров задевает удар, 0 - HIT_PLAYER_MAX_TARGETS
+	Bonus           string   `json:"bonus,omitempty"`  // таблица выпадения бонусов
+	Items           []string `json:"items,omitempty"`  // предметы
+}
+
+func NewUnitsFromReader(reader io.Reader) (map[string]*UnitInfo, error) {
+	result := make(map[string]*UnitInfo)
+	decoder := json.NewDecoder(reader)
+	err := decoder.Decode(&result)
+	if err == nil {
+		for name, info := range result {
+			info.Name = name
+		}
+	}
+	return result, err
+}
+
+func NewUnitsFromFile(filePath string) (map[string]*UnitInfo, error) {
+	// Загрузка юнитов из файла
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Println(err)
+		return make(map[string]*UnitInfo), err
+	}
+	defer f.Close()
+
+	return NewUnitsFromReader(f)
+}
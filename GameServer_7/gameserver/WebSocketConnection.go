@@ -0,0 +1,54 @@
+package gameserver
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket: одно сообщение - один фрейм, без префикса длины
+type WebSocketConnection struct {
+	conn *websocket.Conn
+}
+
+func NewWebSocketConnection(conn *websocket.Conn) *WebSocketConnection {
+	conn.SetReadLimit(CLIENT_MAX_MESSAGE_SIZE)
+	return &WebSocketConnection{
+		conn: conn,
+	}
+}
+
+func (connection *WebSocketConnection) ReadMessage() ([]byte, error) {
+	// Управляющие фреймы обрабатывает сама библиотека, сюда приходят только текстовые и бинарные
+	_, data, err := connection.conn.ReadMessage()
+	if err != nil {
+		// Закрытие со стороны клиента, в том числе обрыв без фрейма закрытия - как конец TCP соединения
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (connection *WebSocketConnection) WriteMessage(data []byte) error {
+	return connection.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (connection *WebSocketConnection) SetReadDeadline(t time.Time) error {
+	return connection.conn.SetReadDeadline(t)
+}
+
+func (connection *WebSocketConnection) SetWriteDeadline(t time.Time) error {
+	return connection.conn.SetWriteDeadline(t)
+}
+
+func (connection *WebSocketConnection) RemoteAddr() net.Addr {
+	return connection.conn.RemoteAddr()
+}
+
+func (connection *WebSocketConnection) Close() error {
+	return connection.conn.Close()
+}
@@ -0,0 +1,415 @@
+package harness
+
+import (
+	"GoTests/GameServer_7/bot"
+	"GoTests/GameServer_7/gameserver"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	CLIENT_DIAL_TIMEOUT = 2 * time.Second
+	CLIENT_READ_TIMEOUT = 2 * time.Second
+)
+
+// Декодированное сообщение сервера
+type Message struct {
+	Type string
+	Data []byte
+}
+
+// Клиент со сценарием: шлет команды и ждет нужные сообщения сервера
+type Client struct {
+	conn        gameserver.ClientConnection // TCP или WebSocket, как у сервера
+	tlsConn     *tls.Conn                   // nil - без TLS
+	ReadTimeout time.Duration
+	ID          uint32
+	Session     string // токен сессии для возвращения на восстановленную арену
+	ArenaInfo   *gameserver.ArenaModel
+	ArenaState  *gameserver.GameArenaState // последнее полученное состояние арены
+	Ping        *gameserver.ServerPing     // последний полученный Ping
+	// Хеш ресурсов уровня из последнего ArenaInfo с ресурсами, отправляется в JOIN и при возвращении в сессию
+	ResourcesHash string
+}
+
+func Dial(address string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, CLIENT_DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		conn:        gameserver.NewStreamConnection(conn, 0),
+		ReadTimeout: CLIENT_READ_TIMEOUT,
+	}
+	return client, nil
+}
+
+// Подключение по TCP с маленьким буфером чтения, чтобы сервер быстро упирался в клиента, который не читает
+func DialReadBuffer(address string, readBuffer int) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, CLIENT_DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.(*net.TCPConn).SetReadBuffer(readBuffer)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client := &Client{
+		conn:        gameserver.NewStreamConnection(conn, 0),
+		ReadTimeout: CLIENT_READ_TIMEOUT,
+	}
+	return client, nil
+}
+
+// Подключение по TCP с TLS, рукопожатие выполняется сразу
+func DialTLS(address string, config *tls.Config) (*Client, error) {
+	dialer := &net.Dialer{Timeout: CLIENT_DIAL_TIMEOUT}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, config)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		conn:        gameserver.NewStreamConnection(conn, 0),
+		tlsConn:     conn,
+		ReadTimeout: CLIENT_READ_TIMEOUT,
+	}
+	return client, nil
+}
+
+// Подключение по KCP с параметрами сессии по умолчанию
+func DialKCP(address string) (*Client, error) {
+	session, err := gameserver.DialKCP(address, gameserver.NewDefaultConfig().KCP)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		conn:        gameserver.NewStreamConnection(session, 0),
+		ReadTimeout: CLIENT_READ_TIMEOUT,
+	}
+	return client, nil
+}
+
+// Подключение по WebSocket, url вида ws://host:port/ws или wss:// с tlsConfig
+func DialWebSocket(url string, tlsConfig *tls.Config) (*Client, error) {
+	dialer := websocket.Dialer{
+		HandshakeTimeout: CLIENT_DIAL_TIMEOUT,
+		TLSClientConfig:  tlsConfig,
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		conn:        gameserver.NewWebSocketConnection(conn),
+		ReadTimeout: CLIENT_READ_TIMEOUT,
+	}
+	return client, nil
+}
+
+func (client *Client) Close() error {
+	return client.conn.Close()
+}
+
+// Сертификат сервера, nil - подключение без TLS
+func (client *Client) PeerCertificate() *x509.Certificate {
+	if client.tlsConn == nil {
+		return nil
+	}
+	certificates := client.tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return nil
+	}
+	return certificates[0]
+}
+
+// JOIN с хешем закешированных ресурсов, затем первые сообщения: ArenaInfo и состояние клиента с его id
+func (client *Client) Join() error {
+	err := client.Send(gameserver.ClientCommand{CommandType: gameserver.CLIENT_COMMAND_TYPE_JOIN, ResourcesHash: client.ResourcesHash})
+	if err != nil {
+		return err
+	}
+	return client.WaitJoin()
+}
+
+// ArenaInfo и состояние клиента без JOIN: так входит клиент, который не знает про кеш ресурсов
+func (client *Client) WaitJoin() error {
+	data, err := client.Expect("ArenaInfo")
+	if err != nil {
+		return err
+	}
+	return client.joinArena(data)
+}
+
+// Возвращение в сессию восстановленной арены: сервер присылает ArenaInfo, токен и состояние игрока с прежним id.
+// Неизвестный токен - сообщение об ошибке, клиент остается на своей арене
+func (client *Client) Reattach(token string) error {
+	err := client.Send(gameserver.ClientCommand{CommandType: gameserver.CLIENT_COMMAND_TYPE_REATTACH, Session: token, ResourcesHash: client.ResourcesHash})
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(client.ReadTimeout)
+	for time.Now().Before(deadline) {
+		message, err := client.Read()
+		if err != nil {
+			return fmt.Errorf("Waiting reattach: %s", err)
+		}
+		switch message.Type {
+		case "ServerMessage":
+			return fmt.Errorf("Reattach rejected: %s", string(message.Data))
+		case "ArenaInfo":
+			return client.joinArena(message.Data)
+		}
+	}
+	return fmt.Errorf("No reattach answer in %s", client.ReadTimeout)
+}
+
+func (client *Client) joinArena(data []byte) error {
+	arenaInfo := &gameserver.ArenaModel{}
+	err := json.Unmarshal(data, arenaInfo)
+	if err != nil {
+		return err
+	}
+	client.ArenaInfo = arenaInfo
+	if arenaInfo.Resources != nil {
+		client.ResourcesHash = arenaInfo.ResourcesHash
+	}
+
+	data, err = client.Expect("ClientState")
+	if err != nil {
+		return err
+	}
+	state := gameserver.ServerClientState{}
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	if state.ID == 0 {
+		return fmt.Errorf("Client state without id")
+	}
+	client.ID = state.ID
+	return nil
+}
+
+// Чтение одного сообщения с таймаутом, состояние арены запоминается
+func (client *Client) Read() (Message, error) {
+	client.conn.SetReadDeadline(time.Now().Add(client.ReadTimeout))
+	data, err := client.conn.ReadMessage()
+	if err != nil {
+		return Message{}, err
+	}
+	message := Message{
+		Type: bot.MessageType(data),
+		Data: data,
+	}
+	if message.Type == "ArenaState" {
+		state := &gameserver.GameArenaState{}
+		err = json.Unmarshal(data, state)
+		if err != nil {
+			return message, err
+		}
+		client.ArenaState = state
+	}
+	if message.Type == "Session" {
+		session := gameserver.ClientSession{}
+		err = json.Unmarshal(data, &session)
+		if err != nil {
+			return message, err
+		}
+		client.Session = session.Token
+	}
+	if message.Type == "Ping" {
+		ping := &gameserver.ServerPing{}
+		err = json.Unmarshal(data, ping)
+		if err != nil {
+			return message, err
+		}
+		client.Ping = ping
+	}
+	return message, nil
+}
+
+// Пропуск сообщений до первого с нужным типом
+func (client *Client) Expect(messageType string) ([]byte, error) {
+	deadline := time.Now().Add(client.ReadTimeout)
+	for time.Now().Before(deadline) {
+		message, err := client.Read()
+		if err != nil {
+			return nil, fmt.Errorf("Waiting %s: %s", messageType, err)
+		}
+		if message.Type == messageType {
+			return message.Data, nil
+		}
+	}
+	return nil, fmt.Errorf("No %s message in %s", messageType, client.ReadTimeout)
+}
+
+// Ожидание состояния арены, для которого condition вернет true
+func (client *Client) WaitArenaState(condition func(state *gameserver.GameArenaState) bool) (*gameserver.GameArenaState, error) {
+	deadline := time.Now().Add(client.ReadTimeout)
+	for time.Now().Before(deadline) {
+		_, err := client.Expect("ArenaState")
+		if err != nil {
+			return nil, err
+		}
+		if condition(client.ArenaState) {
+			return client.ArenaState, nil
+		}
+	}
+	return nil, fmt.Errorf("No expected arena state in %s", client.ReadTimeout)
+}
+
+func (client *Client) Send(command gameserver.ClientCommand) error {
+	data, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+	client.conn.SetWriteDeadline(time.Now().Add(client.ReadTimeout))
+	return client.conn.WriteMessage(data)
+}
+
+func (client *Client) Move(x, y float64) error {
+	command := gameserver.ClientCommand{
+		CommandType: gameserver.CLIENT_COMMAND_TYPE_MOVE,
+		X:           x,
+		Y:           y,
+	}
+	return client.Send(command)
+}
+
+// Удар по монстрам с текущей позиции, damage уже умножен на HIT_DAMAGE_DIVIDER, как у клиента.
+// Удар отправляется с тиком последнего полученного состояния арены
+func (client *Client) Hit(x, y float64, damage int16, monsterIds ...uint32) error {
+	tick := uint64(0)
+	if client.ArenaState != nil {
+		tick = client.ArenaState.Tick
+	}
+	return client.HitAt(tick, x, y, damage, monsterIds...)
+}
+
+// Удар с заданным тиком арены, который видел клиент, 0 - проверка по текущему состоянию
+func (client *Client) HitAt(tick uint64, x, y float64, damage int16, monsterIds ...uint32) error {
+	command := gameserver.ClientCommand{
+		CommandType: gameserver.CLIENT_COMMAND_TYPE_HIT,
+		Tick:        tick,
+		X:           x,
+		Y:           y,
+		HitMonsters: make([]gameserver.ClientCommandHitInfo, 0, len(monsterIds)),
+	}
+	for _, id := range monsterIds {
+		command.HitMonsters = append(command.HitMonsters, gameserver.ClientCommandHitInfo{ID: id, Damage: damage})
+	}
+	return client.Send(command)
+}
+
+// Ожидание закрытия соединения сервером, сообщения до закрытия пропускаются
+func (client *Client) ExpectClosed() error {
+	deadline := time.Now().Add(client.ReadTimeout)
+	for time.Now().Before(deadline) {
+		_, err := client.Read()
+		if err == nil {
+			continue
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			break
+		}
+		return nil
+	}
+	return fmt.Errorf("Connection not closed in %s", client.ReadTimeout)
+}
+
+// Команда наблюдателя: перейти на арену с arenaId, неизвестная арена - сервер пришлет ArenasList
+func (client *Client) Spectate(arenaId uint32) error {
+	data, err := json.Marshal(gameserver.SpectatorCommand{ArenaId: arenaId})
+	if err != nil {
+		return err
+	}
+	client.conn.SetWriteDeadline(time.Now().Add(client.ReadTimeout))
+	return client.conn.WriteMessage(data)
+}
+
+// Ожидание следующего Ping и ответ на него, возвращается полученный Ping
+func (client *Client) Pong() (*gameserver.ServerPing, error) {
+	_, err := client.Expect("Ping")
+	if err != nil {
+		return nil, err
+	}
+	ping := client.Ping
+	err = client.Send(gameserver.ClientCommand{CommandType: gameserver.CLIENT_COMMAND_TYPE_PONG, PingID: ping.ID})
+	if err != nil {
+		return nil, err
+	}
+	return ping, nil
+}
+
+// Профиль, под которым результат клиента попадет в таблицу рекордов
+func (client *Client) SetProfile(profile, name string) error {
+	command := gameserver.ClientCommand{
+		CommandType: gameserver.CLIENT_COMMAND_TYPE_PROFILE,
+		Profile:     profile,
+		Name:        name,
+	}
+	return client.Send(command)
+}
+
+// Запрос таблицы рекордов и ожидание ответа, ошибка сервера приходит как ServerMessage
+func (client *Client) QueryLeaderboard(query gameserver.LeaderboardQuery) (*gameserver.LeaderboardMessage, error) {
+	command := gameserver.ClientCommand{
+		CommandType: gameserver.CLIENT_COMMAND_TYPE_LEADERBOARD,
+		Leaderboard: &query,
+	}
+	err := client.Send(command)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(client.ReadTimeout)
+	for time.Now().Before(deadline) {
+		message, err := client.Read()
+		if err != nil {
+			return nil, fmt.Errorf("Waiting Leaderboard: %s", err)
+		}
+		switch message.Type {
+		case "Leaderboard":
+			leaderboard := &gameserver.LeaderboardMessage{}
+			err = json.Unmarshal(message.Data, leaderboard)
+			if err != nil {
+				return nil, err
+			}
+			return leaderboard, nil
+		case "ServerMessage":
+			serverMessage := gameserver.ServerMessage{}
+			err = json.Unmarshal(message.Data, &serverMessage)
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("Leaderboard error: %s", serverMessage.Message)
+		}
+	}
+	return nil, fmt.Errorf("No Leaderboard message in %s", client.ReadTimeout)
+}
+
+// Состояние клиента с id из состояния арены, nil если его там нет
+func FindClientState(state *gameserver.GameArenaState, clientId uint32) *gameserver.ServerClientState {
+	for i := range state.Clients {
+		if state.Clients[i].ID == clientId {
+			return &state.Clients[i]
+		}
+	}
+	return nil
+}
+
+func FindMonsterState(state *gameserver.GameArenaState, monsterId uint32) *gameserver.ServerMonsterState {
+	for i := range state.Monsters {
+		if state.Monsters[i].ID == monsterId {
+			return &state.Monsters[i]
+		}
+	}
+	return nil
+}
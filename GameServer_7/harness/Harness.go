@@ -0,0 +1,373 @@
+package harness
+
+import (
+	"GoTests/GameServer_7/gameserver"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	HARNESS_LISTEN_ADDRESS   = "127.0.0.1:0" // порт выбирает система
+	HARNESS_STOP_TIMEOUT     = 5 * time.Second
+	HARNESS_DATA_CHECK_FILE  = "dungeons.json"
+	HARNESS_DATA_SEARCH_UP   = 4
+	HARNESS_DEFAULT_DATA_DIR = "data"
+	HARNESS_STEP_TIMEOUT     = 2 * time.Second // ожидание тика арены после сдвига ручных часов
+	HARNESS_HTTP_TIMEOUT     = 2 * time.Second // запросы к админке и метрикам
+)
+
+var harnessHttpClient = &http.Client{Timeout: HARNESS_HTTP_TIMEOUT}
+
+// Сервер, запущенный в том же процессе, для сценариев с клиентами
+type Harness struct {
+	app           *gameserver.Application
+	config        *gameserver.Config
+	prepare       func(staticInfo *gameserver.StaticInfo)
+	Address       string
+	SpectatorAddr string
+	WebSocketURL  string                  // игроки по WebSocket, пустой - WebSocket выключен
+	KCPAddress    string                  // игроки по KCP, пустой - KCP выключен
+	AdminURL      string                  // http://адрес админки, пустой - админка выключена
+	MetricsURL    string                  // http://адрес метрик, пустой - метрики выключены
+	Clock         *gameserver.ManualClock // nil - арены идут по системным часам
+	TLSConfig     *tls.Config             // для Join и JoinWebSocket, nil - без TLS
+}
+
+// Поиск папки статических данных GameServer_7 от текущей папки вверх,
+// чтобы сценарии работали и из корня сервера, и из папок пакетов
+func FindDataDir() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i <= HARNESS_DATA_SEARCH_UP; i++ {
+		candidates := []string{
+			filepath.Join(dir, HARNESS_DEFAULT_DATA_DIR),
+			filepath.Join(dir, "GameServer_7", HARNESS_DEFAULT_DATA_DIR),
+		}
+		for _, candidate := range candidates {
+			if _, err := os.Stat(filepath.Join(candidate, HARNESS_DATA_CHECK_FILE)); err == nil {
+				return candidate, nil
+			}
+		}
+		dir = filepath.Dir(dir)
+	}
+	return "", errors.New("Static data directory not found")
+}
+
+// Настройки для сценариев: случайные локальные порты, без админки, метрик и записи
+func NewConfig() (*gameserver.Config, error) {
+	dataDir, err := FindDataDir()
+	if err != nil {
+		return nil, err
+	}
+	config := gameserver.NewDefaultConfig()
+	config.Server.ListenAddress = HARNESS_LISTEN_ADDRESS
+	config.Server.SpectatorListenAddress = HARNESS_LISTEN_ADDRESS
+	config.Server.WebSocketListenAddress = HARNESS_LISTEN_ADDRESS
+	config.Server.KCPListenAddress = HARNESS_LISTEN_ADDRESS
+	config.Server.AdminListenAddress = ""
+	config.Server.MetricsListenAddress = ""
+	config.Server.ReplaysDir = ""
+	config.Server.LeaderboardFile = ""
+	config.Server.DataDir = dataDir
+	return config, nil
+}
+
+// Запуск сервера, приложение одно на процесс, поэтому перед следующим Start нужен Stop
+func Start(config *gameserver.Config) (*Harness, error) {
+	return StartPrepared(config, nil)
+}
+
+// Запуск с подготовкой статических данных до начала работы сервера, prepare может быть nil
+func StartPrepared(config *gameserver.Config, prepare func(staticInfo *gameserver.StaticInfo)) (*Harness, error) {
+	return StartClocked(config, prepare, nil)
+}
+
+// Запуск с ручными часами: тики арен выполняются только через Step, clock может быть nil
+func StartClocked(config *gameserver.Config, prepare func(staticInfo *gameserver.StaticInfo), clock *gameserver.ManualClock) (*Harness, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = gameserver.MakeApp(config)
+	if err != nil {
+		return nil, err
+	}
+	app := gameserver.GetApp()
+	if prepare != nil {
+		prepare(app.GetStaticInfo())
+	}
+	if clock != nil {
+		app.GetServer().SetClock(clock)
+	}
+	err = app.RunServer()
+	if err != nil {
+		gameserver.ReleaseApp()
+		return nil, err
+	}
+
+	harness := &Harness{
+		app:           app,
+		config:        config,
+		prepare:       prepare,
+		Address:       app.GetServer().GetAddress().String(),
+		SpectatorAddr: app.GetServer().GetSpectatorAddress().String(),
+		Clock:         clock,
+	}
+	if address := app.GetServer().GetWebSocketAddress(); address != nil {
+		scheme := "ws://"
+		if config.Server.TLSCertFile != "" {
+			scheme = "wss://"
+		}
+		harness.WebSocketURL = scheme + address.String() + gameserver.SERVER_WEBSOCKET_PLAYER_PATH
+	}
+	if address := app.GetServer().GetKCPAddress(); address != nil {
+		harness.KCPAddress = address.String()
+	}
+	if address := app.GetAdminAddress(); address != nil {
+		harness.AdminURL = "http://" + address.String()
+	}
+	if address := app.GetMetricsAddress(); address != nil {
+		harness.MetricsURL = "http://" + address.String()
+	}
+	return harness, nil
+}
+
+func (harness *Harness) GetServer() *gameserver.Server {
+	return harness.app.GetServer()
+}
+
+// Подключение к адресу игроков, с TLS если задан TLSConfig
+func (harness *Harness) Dial() (*Client, error) {
+	if harness.TLSConfig != nil {
+		return DialTLS(harness.Address, harness.TLSConfig)
+	}
+	return Dial(harness.Address)
+}
+
+// Подключение игрока, возвращается после получения ArenaInfo и своего состояния
+func (harness *Harness) Join() (*Client, error) {
+	client, err := harness.Dial()
+	if err != nil {
+		return nil, err
+	}
+	err = client.Join()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Подключение игрока по WebSocket, возвращается после получения ArenaInfo и своего состояния
+func (harness *Harness) JoinWebSocket() (*Client, error) {
+	if harness.WebSocketURL == "" {
+		return nil, errors.New("Harness started without WebSocket")
+	}
+	client, err := DialWebSocket(harness.WebSocketURL, harness.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = client.Join()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Подключение игрока по KCP, возвращается после получения ArenaInfo и своего состояния
+func (harness *Harness) JoinKCP() (*Client, error) {
+	if harness.KCPAddress == "" {
+		return nil, errors.New("Harness started without KCP")
+	}
+	client, err := DialKCP(harness.KCPAddress)
+	if err != nil {
+		return nil, err
+	}
+	err = client.Join()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Снимок арены, на которой играет клиент
+func (harness *Harness) FindClientArena(clientId uint32) (gameserver.AdminArenaInfo, error) {
+	for _, arena := range harness.GetServer().GetArenas() {
+		info, ok := arena.GetAdminInfo()
+		if ok == false {
+			continue
+		}
+		for _, client := range info.Clients {
+			if client.State.ID == clientId {
+				return info, nil
+			}
+		}
+	}
+	return gameserver.AdminArenaInfo{}, fmt.Errorf("No arena with client %d", clientId)
+}
+
+// Создание монстра на арене клиента
+func (harness *Harness) SpawnMonster(arenaId uint32, name string, x, y float64) (gameserver.ServerMonsterState, error) {
+	arena := harness.GetServer().FindArena(arenaId)
+	if arena == nil {
+		return gameserver.ServerMonsterState{}, errors.New("No arena with id")
+	}
+	return arena.SpawnMonster(name, x, y)
+}
+
+// Перемещение живого монстра на арене клиента
+func (harness *Harness) MoveMonster(arenaId uint32, monsterId uint32, x, y float64) (gameserver.ServerMonsterState, error) {
+	arena := harness.GetServer().FindArena(arenaId)
+	if arena == nil {
+		return gameserver.ServerMonsterState{}, errors.New("No arena with id")
+	}
+	return arena.MoveMonster(monsterId, x, y)
+}
+
+// Запрос к админке с токеном из настроек: body и reply в JSON, могут быть nil. Возвращается код ответа
+func (harness *Harness) AdminRequest(method string, path string, body interface{}, reply interface{}) (int, error) {
+	return harness.AdminRequestToken(harness.config.Server.AdminToken, method, path, body, reply)
+}
+
+// Запрос к админке с указанным токеном, reply разбирается только при коде 200
+func (harness *Harness) AdminRequestToken(token string, method string, path string, body interface{}, reply interface{}) (int, error) {
+	if harness.AdminURL == "" {
+		return 0, errors.New("Harness started without admin")
+	}
+	var bodyReader io.Reader = nil
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		bodyReader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequest(method, harness.AdminURL+path, bodyReader)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := harnessHttpClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if (response.StatusCode != http.StatusOK) || (reply == nil) {
+		return response.StatusCode, nil
+	}
+	return response.StatusCode, json.NewDecoder(response.Body).Decode(reply)
+}
+
+// Текст метрик в формате Prometheus
+func (harness *Harness) GetMetricsText() (string, error) {
+	if harness.MetricsURL == "" {
+		return "", errors.New("Harness started without metrics")
+	}
+	response, err := harnessHttpClient.Get(harness.MetricsURL + "/metrics")
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Metrics status %d", response.StatusCode)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Сдвиг ручных часов на duration и ожидание, пока арена дойдет до тика wantTick
+func (harness *Harness) Step(arenaId uint32, duration time.Duration, wantTick uint64) error {
+	if harness.Clock == nil {
+		return errors.New("Harness started without manual clock")
+	}
+	arena := harness.GetServer().FindArena(arenaId)
+	if arena == nil {
+		return errors.New("No arena with id")
+	}
+	harness.Clock.Advance(duration)
+
+	deadline := time.Now().Add(HARNESS_STEP_TIMEOUT)
+	for time.Now().Before(deadline) {
+		info, ok := arena.GetAdminInfo()
+		if ok == false {
+			return errors.New("Arena closed")
+		}
+		if info.Tick == wantTick {
+			return nil
+		}
+		if info.Tick > wantTick {
+			return fmt.Errorf("Arena tick %d, expected %d", info.Tick, wantTick)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return fmt.Errorf("Arena did not reach tick %d in %s", wantTick, HARNESS_STEP_TIMEOUT)
+}
+
+// Перезапуск с теми же настройками как после падения сервера: снимки арен, которые удаляет
+// штатная остановка, возвращаются в CheckpointsDir до запуска. Адреса меняются на новые
+func (harness *Harness) RestartAfterCrash() error {
+	dir := harness.config.Server.CheckpointsDir
+	if dir == "" {
+		return errors.New("Harness started without checkpoints")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"+gameserver.CHECKPOINT_FILE_EXT))
+	if err != nil {
+		return err
+	}
+	saved := make(map[string][]byte, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		saved[file] = data
+	}
+
+	err = harness.Stop()
+	if err != nil {
+		return err
+	}
+	for file, data := range saved {
+		err = ioutil.WriteFile(file, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	restarted, err := StartClocked(harness.config, harness.prepare, harness.Clock)
+	if err != nil {
+		return err
+	}
+	restarted.TLSConfig = harness.TLSConfig
+	*harness = *restarted
+	return nil
+}
+
+// Остановка сервера и сброс приложения
+func (harness *Harness) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), HARNESS_STOP_TIMEOUT)
+	defer cancel()
+	err := harness.app.Shutdown(ctx)
+	releaseErr := gameserver.ReleaseApp()
+	if err != nil {
+		return err
+	}
+	return releaseErr
+}
@@ -0,0 +1,112 @@
+package harness
+
+import (
+	"GoTests/GameServer_7/gameserver"
+	"fmt"
+	"math"
+)
+
+// Ячейки платформы под объектом, посчитанные по описанию объекта без данных генератора:
+// ячейка (lx, ly) описания поворачивается на rot четвертей оборота вокруг угла ячейки, в которой стоит объект
+// (декор стоит в центре ячейки). Ячейки за краем платформы не входят: арки стоят частично на мостах
+func ObjectFootprint(platform *gameserver.Platform, info *gameserver.PlatformObjectInfo, object gameserver.PlatformObject) []gameserver.PlatformObjectCell {
+	originX := math.Floor(object.X)
+	originY := math.Floor(object.Y)
+	cells := make([]gameserver.PlatformObjectCell, 0, info.Width*info.Height)
+	for ly := int16(0); ly < info.Height; ly++ {
+		for lx := int16(0); lx < info.Width; lx++ {
+			// Центр ячейки относительно угла
+			u := float64(lx) + 0.5
+			v := float64(ly) + 0.5
+			switch object.Rot & 3 {
+			case 1:
+				u, v = v, -u
+			case 2:
+				u, v = -u, -v
+			case 3:
+				u, v = -v, u
+			}
+			x := int16(math.Floor(originX + u))
+			y := int16(math.Floor(originY + v))
+			if (x < 0) || (y < 0) || (x >= int16(platform.Width)) || (y >= int16(platform.Height)) {
+				continue
+			}
+			cell := gameserver.PlatformObjectCell{X: x, Y: y, Type: gameserver.CELL_TYPE_SPACE}
+			if int(ly*info.Width+lx) < len(info.Cells) {
+				cell.Type = info.Cells[ly*info.Width+lx]
+			}
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+// Описание объекта платформы по имени: генератор ставит и объекты, и блоки из обоих списков
+func findObjectInfo(platform *gameserver.Platform, id string) *gameserver.PlatformObjectInfo {
+	for _, infos := range [][]gameserver.PlatformObjectInfo{platform.Info.Objects, platform.Info.Blocks} {
+		for i := range infos {
+			if infos[i].Id == id {
+				return &infos[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Проверка объектов платформы по их описаниям: объекты не делят ячейки друг с другом, блоки - с блоками,
+// непроходимые ячейки описания непроходимы на платформе, столбы и гробы непроходимы целиком
+func CheckPlatformObjects(platform *gameserver.Platform) error {
+	w := int16(platform.Width)
+	check := func(name string, objects []gameserver.PlatformObject) error {
+		owners := make(map[gameserver.Point16]int)
+		for i, object := range objects {
+			info := findObjectInfo(platform, object.Id)
+			if info == nil {
+				return fmt.Errorf("%s %d (%s) not in platform info", name, i, object.Id)
+			}
+			solid := (info.Type == gameserver.PLATFORM_OBJ_TYPE_PILLAR) || (info.Type == gameserver.PLATFORM_OBJ_TYPE_COFFIN)
+			for _, cell := range ObjectFootprint(platform, info, object) {
+				point := gameserver.NewPoint16(cell.X, cell.Y)
+				if owner, exists := owners[point]; exists {
+					return fmt.Errorf("%s %d (%s) overlaps %d (%s) at (%d, %d)", name, i, object.Id, owner, objects[owner].Id, cell.X, cell.Y)
+				}
+				owners[point] = i
+				walkable := (platform.Cells[cell.Y*w+cell.X] & gameserver.CELL_TYPE_WALK) != 0
+				if walkable && (solid || ((cell.Type & gameserver.CELL_TYPE_WALK) == 0)) {
+					return fmt.Errorf("%s %d (%s) cell (%d, %d) is walkable", name, i, object.Id, cell.X, cell.Y)
+				}
+			}
+		}
+		return nil
+	}
+	err := check("object", platform.Objects)
+	if err != nil {
+		return err
+	}
+	return check("block", platform.Blocks)
+}
+
+// Проверка объектов всех платформ арены и уникальности их номеров
+func CheckArenaObjects(arena *gameserver.ArenaModel) error {
+	uids := make(map[uint32]bool)
+	for y := range arena.Platforms {
+		for x, platform := range arena.Platforms[y] {
+			if platform == nil {
+				continue
+			}
+			err := CheckPlatformObjects(platform)
+			if err != nil {
+				return fmt.Errorf("Platform %dx%d: %s", y, x, err)
+			}
+			for _, objects := range [][]gameserver.PlatformObject{platform.Blocks, platform.Objects} {
+				for _, object := range objects {
+					if (object.Uid == 0) || uids[object.Uid] {
+						return fmt.Errorf("Platform %dx%d: object %s with bad uid %d", y, x, object.Id, object.Uid)
+					}
+					uids[object.Uid] = true
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,2465 @@
+package harness
+
+import (
+	"GoTests/GameServer_7/bot"
+	"GoTests/GameServer_7/gameserver"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SCENARIO_MONSTER_NAME     = "angry_cat"
+	SCENARIO_ARENA_SEEDS      = 200 // сколько арен каждого подземелья проверяет генератор
+	SCENARIO_PROFILE          = "scenario_hero"
+	SCENARIO_TLS_RELOAD       = 100 * time.Millisecond // проверка файлов сертификатов в сценарии tls
+	SCENARIO_TLS_REJECT       = 300 * time.Millisecond // ожидание ответа от сервера, который должен отказать
+	SCENARIO_CHECKPOINT       = 50 * time.Millisecond  // период снимков арен в сценарии checkpoint
+	SCENARIO_PING             = 50 * time.Millisecond  // период Ping в сценарии ping
+	SCENARIO_JOIN_WAIT        = 250 * time.Millisecond // ожидание JOIN в сценарии resources
+	SCENARIO_PING_COUNT       = 4                      // сколько Ping получает игрок
+	SCENARIO_REPLAY_IDLE      = 100 * time.Millisecond // арена без игроков в сценарии replay
+	SCENARIO_REPLAY_DAMAGE    = 2.0                    // допуск по урону в сценарии replay
+	SCENARIO_REWIND_STEPS     = 3                      // сколько тиков сценарий rewind ждет результата команды
+	SCENARIO_REWIND_READ      = 20 * time.Millisecond  // чтение команды сервером до следующего тика
+	SCENARIO_REWIND_LAG       = 5                      // тики между уходом монстра и ударом в сценарии rewindnopong
+	SCENARIO_REWIND_MAX       = 1 * time.Second        // MaxRewind в сценарии rewindnopong
+	SCENARIO_HIT_TARGETS      = 2                      // сколько монстров задевает удар в сценарии hittargets
+	SCENARIO_UNKNOWN_MONSTER  = 100000                 // id монстра, которого нет на арене
+	SCENARIO_SPECTATOR_IDLE   = 100 * time.Millisecond // арена без игроков в сценарии spectators
+	SCENARIO_ADMIN_TOKEN      = "scenario_token"
+	SCENARIO_METRICS_POLL     = 10 * time.Millisecond
+	SCENARIO_SLOW_TIMEOUT     = 200 * time.Millisecond // отставание до отключения в сценарии slowclient
+	SCENARIO_SLOW_READ_BUFFER = 4096                   // буфер чтения клиента, который не читает
+	SCENARIO_SLOW_TICK        = 10 * time.Millisecond
+	SCENARIO_SLOW_MONSTERS    = 200
+	SCENARIO_SLOW_WAIT        = 20 * time.Second // ожидание отключения медленного клиента
+	SCENARIO_PROXY_LOSS       = 0.1              // доля пакетов KCP, которые теряет прокси в сценарии lossykcp
+	SCENARIO_PROXY_REORDER    = 0.2              // доля пересланных пакетов, которые прокси задерживает
+	SCENARIO_PROXY_SEED       = 1
+	SCENARIO_PROXY_MOVES      = 20
+	SCENARIO_PROXY_READ       = 10 * time.Second // повторы KCP после потерь замедляют вход
+)
+
+// Сценарий получает запущенный сервер, ошибка - сценарий не прошел
+type Scenario struct {
+	Name      string
+	Configure func(config *gameserver.Config) error   // изменение настроек до запуска, может быть nil
+	Prepare   func(staticInfo *gameserver.StaticInfo) // изменение статических данных до запуска, может быть nil
+	Manual    bool                                    // арены идут по ручным часам harness.Clock
+	Run       func(harness *Harness) error
+}
+
+var Scenarios = []Scenario{
+	{Name: "join", Run: ScenarioJoin},
+	{Name: "move", Run: ScenarioMove},
+	{Name: "hit", Run: ScenarioHit},
+	{Name: "kill", Run: ScenarioKill},
+	{Name: "hitrules", Configure: ConfigureHitRules, Prepare: PrepareHitRules, Run: ScenarioHitRules},
+	{Name: "hittargets", Configure: ConfigureHitRules, Prepare: PrepareHitTargets, Run: ScenarioHitTargets},
+	{Name: "defeat", Prepare: PrepareDefeat, Run: ScenarioDefeat},
+	{Name: "leave", Run: ScenarioLeave},
+	{Name: "sequence", Run: ScenarioSequence},
+	{Name: "timestep", Manual: true, Run: ScenarioTimestep},
+	{Name: "objects", Run: ScenarioObjects},
+	{Name: "leaderboard", Run: ScenarioLeaderboard},
+	{Name: "websocket", Run: ScenarioWebSocket},
+	{Name: "kcp", Run: ScenarioKCP},
+	{Name: "tls", Configure: ConfigureTLS, Run: ScenarioTLS},
+	{Name: "tlsrequired", Configure: ConfigureTLSRequired, Run: ScenarioTLSRequired},
+	{Name: "checkpoint", Configure: ConfigureCheckpoints, Run: ScenarioCheckpoint},
+	{Name: "ping", Configure: ConfigurePing, Run: ScenarioPing},
+	{Name: "replay", Configure: ConfigureReplay, Run: ScenarioReplay},
+	{Name: "resources", Configure: ConfigureResources, Run: ScenarioResources},
+	{Name: "rewind", Configure: ConfigureRewind, Manual: true, Run: ScenarioRewind},
+	{Name: "rewindnopong", Configure: ConfigureRewindNoPong, Manual: true, Run: ScenarioRewindNoPong},
+	{Name: "kick", Configure: ConfigureKick, Run: ScenarioKick},
+	{Name: "spectators", Configure: ConfigureSpectators, Run: ScenarioSpectators},
+	{Name: "admin", Configure: ConfigureAdmin, Run: ScenarioAdmin},
+	{Name: "metrics", Configure: ConfigureMetrics, Run: ScenarioMetrics},
+	{Name: "precedence", Configure: ConfigurePrecedence, Run: ScenarioPrecedence},
+	{Name: "slowclient", Configure: ConfigureSlowClient, Prepare: PrepareSlowClient, Run: ScenarioSlowClient},
+	{Name: "lossykcp", Run: ScenarioLossyKCP},
+}
+
+// Запуск сценария на отдельном сервере
+func RunScenario(config *gameserver.Config, scenario Scenario) error {
+	if scenario.Configure != nil {
+		err := scenario.Configure(config)
+		if err != nil {
+			return err
+		}
+	}
+	var clock *gameserver.ManualClock = nil
+	if scenario.Manual {
+		clock = gameserver.NewManualClock(time.Now())
+	}
+	harness, err := StartClocked(config, scenario.Prepare, clock)
+	if err != nil {
+		return err
+	}
+	err = scenario.Run(harness)
+	stopErr := harness.Stop()
+	if err != nil {
+		return err
+	}
+	return stopErr
+}
+
+// Центр проходимой ячейки арены, index выбирает ячейку по кругу
+func WalkablePoint(arenaInfo *gameserver.ArenaModel, index int) (float64, float64, error) {
+	points := make([][2]float64, 0)
+	for _, row := range arenaInfo.Platforms {
+		for _, platform := range row {
+			if platform == nil {
+				continue
+			}
+			for i, cellType := range platform.Cells {
+				if (cellType == gameserver.CELL_TYPE_UNDEF) || (cellType&gameserver.CELL_TYPE_WALK == 0) {
+					continue
+				}
+				x := float64(platform.PosX) + float64(i%int(platform.Width)) + 0.5
+				y := float64(platform.PosY) + float64(i/int(platform.Width)) + 0.5
+				points = append(points, [2]float64{x, y})
+			}
+		}
+	}
+	if len(points) == 0 {
+		return 0, 0, errors.New("No walkable cells in arena")
+	}
+	point := points[index%len(points)]
+	return point[0], point[1], nil
+}
+
+// Игрок получает арену и свой id, сервер видит его на активной арене
+func ScenarioJoin(harness *Harness) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, _, err = WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	arenaInfo, err := harness.FindClientArena(client.ID)
+	if err != nil {
+		return err
+	}
+	if arenaInfo.Status != gameserver.GAME_ROOM_STATUS_ACTIVE {
+		return fmt.Errorf("Arena %d status %d, expected active", arenaInfo.ID, arenaInfo.Status)
+	}
+	if arenaInfo.Dungeon != gameserver.GetApp().GetConfig().Arena.Dungeon {
+		return fmt.Errorf("Arena %d dungeon %s, expected %s", arenaInfo.ID, arenaInfo.Dungeon, gameserver.GetApp().GetConfig().Arena.Dungeon)
+	}
+	return nil
+}
+
+// Позиция из команды движения приходит в состоянии арены
+func ScenarioMove(harness *Harness) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		x, y, err := WalkablePoint(client.ArenaInfo, i*7)
+		if err != nil {
+			return err
+		}
+		err = client.Move(x, y)
+		if err != nil {
+			return err
+		}
+		_, err = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+			clientState := FindClientState(state, client.ID)
+			return (clientState != nil) && (clientState.X == x) && (clientState.Y == y)
+		})
+		if err != nil {
+			return fmt.Errorf("Move %d to (%.1f, %.1f): %s", i, x, y, err)
+		}
+	}
+	return nil
+}
+
+// Удар по монстру рядом снижает его здоровье и засчитывается игроку
+func ScenarioHit(harness *Harness) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	x, y, err := WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		return err
+	}
+	state, err := client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, client.ID) != nil
+	})
+	if err != nil {
+		return err
+	}
+
+	monster, err := harness.SpawnMonster(state.ID, SCENARIO_MONSTER_NAME, x, y)
+	if err != nil {
+		return err
+	}
+	playerInfo := gameserver.GetApp().GetStaticInfo().Units[gameserver.UNIT_NAME_PLAYER]
+	damage := int16(math.Min(playerInfo.Power, float64(monster.Health-1)))
+	if damage <= 0 {
+		return errors.New("Player power too low for hit scenario")
+	}
+
+	err = client.Hit(x, y, damage*gameserver.HIT_DAMAGE_DIVIDER, monster.ID)
+	if err != nil {
+		return err
+	}
+	_, err = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		monsterState := FindMonsterState(state, monster.ID)
+		clientState := FindClientState(state, client.ID)
+		return (monsterState != nil) && (monsterState.Health == monster.Health-damage) &&
+			(clientState != nil) && (clientState.TotalDamage == uint32(damage))
+	})
+	if err != nil {
+		return fmt.Errorf("Hit monster %d with damage %d: %s", monster.ID, damage, err)
+	}
+	return nil
+}
+
+// Убитый монстр остается мертвым на время показа смерти, приходит событие с добычей
+func ScenarioKill(harness *Harness) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	x, y, err := WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		return err
+	}
+	state, err := client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, client.ID) != nil
+	})
+	if err != nil {
+		return err
+	}
+	monster, err := harness.SpawnMonster(state.ID, SCENARIO_MONSTER_NAME, x, y)
+	if err != nil {
+		return err
+	}
+
+	// Максимальный допустимый урон, удары не чаще attack_speed
+	staticInfo := gameserver.GetApp().GetStaticInfo()
+	playerInfo := staticInfo.Units[gameserver.UNIT_NAME_PLAYER]
+	damage := int16(playerInfo.Power)
+	hitInterval := time.Duration(float64(time.Second) / playerInfo.AttackSpeed)
+	hitsCount := int(monster.Health/damage) + 1
+
+	var event *gameserver.MonsterKilled = nil
+	for i := 0; (i < hitsCount) && (event == nil); i++ {
+		if i > 0 {
+			time.Sleep(hitInterval)
+		}
+		err = client.Hit(x, y, damage*gameserver.HIT_DAMAGE_DIVIDER, monster.ID)
+		if err != nil {
+			return err
+		}
+		if i+1 < hitsCount {
+			continue
+		}
+		data, err := client.Expect("MonsterKilled")
+		if err != nil {
+			return err
+		}
+		event = &gameserver.MonsterKilled{}
+		err = json.Unmarshal(data, event)
+		if err != nil {
+			return err
+		}
+	}
+
+	if (event.MonsterID != monster.ID) || (event.KillerID != client.ID) {
+		return fmt.Errorf("Killed event for monster %d by %d, expected %d by %d", event.MonsterID, event.KillerID, monster.ID, client.ID)
+	}
+	if (len(event.Contributors) != 1) || (event.Contributors[0].Damage != uint32(monster.Health)) {
+		return fmt.Errorf("Killed event contributors %v, expected client %d with damage %d", event.Contributors, client.ID, monster.Health)
+	}
+	if unitInfo := staticInfo.Units[monster.Name]; (unitInfo.Bonus != "") && (len(event.Loot) == 0) {
+		return fmt.Errorf("No loot from bonus table %s", unitInfo.Bonus)
+	}
+
+	_, err = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		monsterState := FindMonsterState(state, monster.ID)
+		clientState := FindClientState(state, client.ID)
+		return (monsterState != nil) && (monsterState.Status == gameserver.MONSTER_STATE_STATUS_DEAD) &&
+			(clientState != nil) && (clientState.Kills == 1)
+	})
+	if err != nil {
+		return fmt.Errorf("Dead monster %d in state: %s", monster.ID, err)
+	}
+
+	// После показа смерти монстр убирается из состояния
+	client.ReadTimeout += gameserver.GetApp().GetConfig().Arena.MonsterLinger.Duration()
+	_, err = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindMonsterState(state, monster.ID) == nil
+	})
+	if err != nil {
+		return fmt.Errorf("Dead monster %d not removed: %s", monster.ID, err)
+	}
+	return nil
+}
+
+// Одно нарушение помечает игрока, но не отключает
+func ConfigureHitRules(config *gameserver.Config) error {
+	config.Arena.HitViolationsLimit = 1
+	config.Arena.HitViolationAction = gameserver.HIT_VIOLATION_ACTION_FLAG
+	return nil
+}
+
+// Монстр погибает от двух ударов игрока
+func PrepareHitRules(staticInfo *gameserver.StaticInfo) {
+	staticInfo.Units[SCENARIO_MONSTER_NAME].Health = 2 * staticInfo.Units[gameserver.UNIT_NAME_PLAYER].Power
+}
+
+// Два удара подряд без паузы и удар по уже убитому монстру не нарушения,
+// удар по несуществующему монстру - нарушение, после которого игрок помечен
+func ScenarioHitRules(harness *Harness) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	x, y, err := WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		return err
+	}
+	state, err := client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, client.ID) != nil
+	})
+	if err != nil {
+		return err
+	}
+	monster, err := harness.SpawnMonster(state.ID, SCENARIO_MONSTER_NAME, x, y)
+	if err != nil {
+		return err
+	}
+
+	playerInfo := gameserver.GetApp().GetStaticInfo().Units[gameserver.UNIT_NAME_PLAYER]
+	damage := int16(playerInfo.Power)
+	hitInterval := time.Duration(float64(time.Second) / playerInfo.AttackSpeed)
+	for i := 0; i < 2; i++ {
+		err = client.Hit(x, y, damage*gameserver.HIT_DAMAGE_DIVIDER, monster.ID)
+		if err != nil {
+			return err
+		}
+	}
+	data, err := client.Expect("MonsterKilled")
+	if err != nil {
+		return fmt.Errorf("Two hits back to back: %s", err)
+	}
+	event := gameserver.MonsterKilled{}
+	err = json.Unmarshal(data, &event)
+	if err != nil {
+		return err
+	}
+	if (len(event.Contributors) != 1) || (event.Contributors[0].Damage != uint32(monster.Health)) {
+		return fmt.Errorf("Killed event contributors %v, expected damage %d", event.Contributors, monster.Health)
+	}
+
+	time.Sleep(hitInterval)
+	err = client.Hit(x, y, damage*gameserver.HIT_DAMAGE_DIVIDER, monster.ID)
+	if err != nil {
+		return err
+	}
+	time.Sleep(hitInterval)
+	err = client.Hit(x, y, damage*gameserver.HIT_DAMAGE_DIVIDER, monster.ID+1000)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(client.ReadTimeout)
+	for time.Now().Before(deadline) {
+		arena, err := harness.FindClientArena(client.ID)
+		if err != nil {
+			return err
+		}
+		for _, info := range arena.Clients {
+			if (info.State.ID != client.ID) || (info.HitViolations == 0) {
+				continue
+			}
+			if (info.HitViolations != 1) || (info.IsFlagged == false) {
+				return fmt.Errorf("Hit violations %d, flagged %t, expected one violation and flag", info.HitViolations, info.IsFlagged)
+			}
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return errors.New("Hit on unknown monster not counted")
+}
+
+// Монстр переживает три удара, удар игрока задевает двух монстров
+func PrepareHitTargets(staticInfo *gameserver.StaticInfo) {
+	playerInfo := staticInfo.Units[gameserver.UNIT_NAME_PLAYER]
+	playerInfo.MaxTargets = SCENARIO_HIT_TARGETS
+	staticInfo.Units[SCENARIO_MONSTER_NAME].Health = 4 * playerInfo.Power
+}
+
+// Монстр, повторенный в одной атаке, получает урон один раз, каждый повтор - нарушение.
+// Из атаки по большему числу монстров, чем задевает удар, засчитываются первые, лишние - одно нарушение
+func ScenarioHitTargets(harness *Harness) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	x, y, err := WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		return err
+	}
+	state, err := client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, client.ID) != nil
+	})
+	if err != nil {
+		return err
+	}
+	monsters := make([]gameserver.ServerMonsterState, 0, SCENARIO_HIT_TARGETS+1)
+	for i := 0; i < SCENARIO_HIT_TARGETS+1; i++ {
+		monster, err := harness.SpawnMonster(state.ID, SCENARIO_MONSTER_NAME, x, y)
+		if err != nil {
+			return err
+		}
+		monsters = append(monsters, monster)
+	}
+
+	playerInfo := gameserver.GetApp().GetStaticInfo().Units[gameserver.UNIT_NAME_PLAYER]
+	damage := int16(playerInfo.Power)
+	// Здоровье монстров и нарушения игрока после того, как сервер обработает атаку
+	waitHits := func(what string, health []int16, violations uint32) error {
+		got := make([]int16, len(monsters))
+		gotViolations := uint32(0)
+		deadline := time.Now().Add(client.ReadTimeout)
+		for time.Now().Before(deadline) {
+			arena, err := harness.FindClientArena(client.ID)
+			if err != nil {
+				return err
+			}
+			for i, monster := range monsters {
+				got[i] = 0
+				for _, info := range arena.Monsters {
+					if info.ID == monster.ID {
+						got[i] = info.Health
+					}
+				}
+			}
+			for _, info := range arena.Clients {
+				if info.State.ID == client.ID {
+					gotViolations = info.HitViolations
+				}
+			}
+			if (fmt.Sprint(got) == fmt.Sprint(health)) && (gotViolations == violations) {
+				return nil
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return fmt.Errorf("%s: monsters health %v, violations %d, expected %v and %d", what, got, gotViolations, health, violations)
+	}
+
+	full := monsters[0].Health
+	first := monsters[0].ID
+	err = client.Hit(x, y, damage*gameserver.HIT_DAMAGE_DIVIDER, first, first, first)
+	if err != nil {
+		return err
+	}
+	health := []int16{full - damage, full, full}
+	err = waitHits("Repeated monster", health, 2)
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(time.Duration(float64(time.Second) / playerInfo.AttackSpeed))
+	ids := make([]uint32, 0, len(monsters))
+	for _, monster := range monsters {
+		ids = append(ids, monster.ID)
+	}
+	err = client.Hit(x, y, damage*gameserver.HIT_DAMAGE_DIVIDER, ids...)
+	if err != nil {
+		return err
+	}
+	health = []int16{full - 2*damage, full - damage, full}
+	return waitHits("Too many targets", health, 3)
+}
+
+// Здоровье игрока на несколько атак монстра, чтобы сценарий поражения не ждал минутами
+func PrepareDefeat(staticInfo *gameserver.StaticInfo) {
+	staticInfo.Units[gameserver.UNIT_NAME_PLAYER].Health = staticInfo.Units[SCENARIO_MONSTER_NAME].Power
+}
+
+// Монстр добивает единственного игрока, игрок и арена получают статус поражения
+func ScenarioDefeat(harness *Harness) error {
+	monsterInfo := gameserver.GetApp().GetStaticInfo().Units[SCENARIO_MONSTER_NAME]
+
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	x, y, err := WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		return err
+	}
+	state, err := client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, client.ID) != nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = harness.SpawnMonster(state.ID, SCENARIO_MONSTER_NAME, x, y)
+	if err != nil {
+		return err
+	}
+
+	// Урон меньше силы из-за защиты, нужно несколько атак
+	client.ReadTimeout += time.Duration(float64(time.Second) / monsterInfo.AttackSpeed * 3)
+	state, err = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return state.Status != gameserver.GAME_ROOM_STATUS_ACTIVE
+	})
+	if err != nil {
+		return err
+	}
+	if state.Status != gameserver.GAME_ROOM_STATUS_FAILED {
+		return fmt.Errorf("Arena status %d, expected failed", state.Status)
+	}
+	clientState := FindClientState(state, client.ID)
+	if (clientState == nil) || (clientState.Status != gameserver.CLIENT_STATUS_FAIL) || (clientState.Health != 0) {
+		return fmt.Errorf("Client state %+v, expected fail with zero health", clientState)
+	}
+	return nil
+}
+
+// После отключения игрок пропадает из состояния арены у остальных
+func ScenarioLeave(harness *Harness) error {
+	stayClient, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer stayClient.Close()
+	leaveClient, err := harness.Join()
+	if err != nil {
+		return err
+	}
+
+	for i, client := range []*Client{stayClient, leaveClient} {
+		x, y, err := WalkablePoint(client.ArenaInfo, i)
+		if err != nil {
+			return err
+		}
+		err = client.Move(x, y)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = stayClient.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return (FindClientState(state, stayClient.ID) != nil) && (FindClientState(state, leaveClient.ID) != nil)
+	})
+	if err != nil {
+		return fmt.Errorf("Both clients in arena: %s", err)
+	}
+
+	leaveClient.Close()
+	_, err = stayClient.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, leaveClient.ID) == nil
+	})
+	if err != nil {
+		return fmt.Errorf("Client %d still in arena after leave: %s", leaveClient.ID, err)
+	}
+	return nil
+}
+
+// Сервер подтверждает номер последней команды, повторы и команды не по порядку отбрасываются
+func ScenarioSequence(harness *Harness) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	points := make([]gameserver.PointFloat, 0, 4)
+	for i := 0; i < 4; i++ {
+		x, y, err := WalkablePoint(client.ArenaInfo, i)
+		if err != nil {
+			return err
+		}
+		points = append(points, gameserver.NewPointFloat(x, y))
+	}
+
+	// 1 и 2 применяются, повтор 2 и опоздавшая 1 - нет, затем 3
+	sends := []struct {
+		seq   uint32
+		point int
+	}{{1, 0}, {2, 1}, {2, 2}, {1, 2}, {3, 3}}
+	for _, send := range sends {
+		err = client.Send(gameserver.ClientCommand{
+			Seq:         send.seq,
+			CommandType: gameserver.CLIENT_COMMAND_TYPE_MOVE,
+			X:           points[send.point].X,
+			Y:           points[send.point].Y,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var lastTick uint64 = 0
+	var lastSeq uint32 = 0
+	var checkErr error = nil
+	dropped := points[2]
+	_, err = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		clientState := FindClientState(state, client.ID)
+		if clientState == nil {
+			return false
+		}
+		if (clientState.X == dropped.X) && (clientState.Y == dropped.Y) {
+			checkErr = fmt.Errorf("Dropped command applied, state %+v", *clientState)
+			return true
+		}
+		if (clientState.Tick < lastTick) || (clientState.LastSeq < lastSeq) {
+			checkErr = fmt.Errorf("Tick or seq decreased, state %+v", *clientState)
+			return true
+		}
+		lastTick = clientState.Tick
+		lastSeq = clientState.LastSeq
+		return (clientState.LastSeq == 3) && (clientState.X == points[3].X) && (clientState.Y == points[3].Y)
+	})
+	if err != nil {
+		return fmt.Errorf("Sequence: %s", err)
+	}
+	if checkErr != nil {
+		return checkErr
+	}
+	if lastTick == 0 {
+		return errors.New("State without arena tick")
+	}
+	return nil
+}
+
+// Тики идут фиксированными шагами по ручным часам, отставание догоняется не больше предела
+func ScenarioTimestep(harness *Harness) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	arenaInfo, err := harness.FindClientArena(client.ID)
+	if err != nil {
+		return err
+	}
+	config := gameserver.GetApp().GetConfig().Arena
+	step := config.UpdatePeriod.Duration()
+
+	// Полтора тика дают один тик, остаток переходит в следующий
+	err = harness.Step(arenaInfo.ID, step+step/2, 1)
+	if err != nil {
+		return err
+	}
+	err = harness.Step(arenaInfo.ID, step/2, 2)
+	if err != nil {
+		return err
+	}
+
+	// Состояние после команды приходит с номером тика арены
+	x, y, err := WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		return err
+	}
+	tick := uint64(2)
+	var state *gameserver.GameArenaState = nil
+	for (state == nil) && (tick < 10) {
+		tick++
+		err = harness.Step(arenaInfo.ID, step, tick)
+		if err != nil {
+			return err
+		}
+		client.ReadTimeout = step
+		state, _ = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+			return FindClientState(state, client.ID) != nil
+		})
+	}
+	client.ReadTimeout = CLIENT_READ_TIMEOUT
+	if state == nil {
+		return errors.New("No arena state after move")
+	}
+	if (state.Tick != tick) || (FindClientState(state, client.ID).Tick != tick) {
+		return fmt.Errorf("State tick %d, client tick %d, expected %d", state.Tick, FindClientState(state, client.ID).Tick, tick)
+	}
+
+	// Сильное отставание: выполняется только MaxCatchUp тиков, остальное время отбрасывается
+	tick += uint64(config.MaxCatchUp)
+	err = harness.Step(arenaInfo.ID, step*time.Duration(config.MaxCatchUp*4), tick)
+	if err != nil {
+		return fmt.Errorf("Catch up: %s", err)
+	}
+	err = harness.Step(arenaInfo.ID, step/2, tick)
+	if err != nil {
+		return err
+	}
+	tick++
+	err = harness.Step(arenaInfo.ID, step/2, tick)
+	if err != nil {
+		return err
+	}
+
+	info, err := harness.FindClientArena(client.ID)
+	if err != nil {
+		return err
+	}
+	if math.Abs(info.SimTime-float64(tick)*step.Seconds()) > 1e-9 {
+		return fmt.Errorf("Simulation time %f after %d ticks of %s", info.SimTime, tick, step)
+	}
+	return nil
+}
+
+// Объекты сгенерированных арен, пересчитанные по описаниям, не пересекаются, столбы и гробы непроходимы,
+// у объектов в ArenaInfo разные uid
+func ScenarioObjects(harness *Harness) error {
+	staticInfo := gameserver.GetApp().GetStaticInfo()
+	for name, dungeon := range staticInfo.Dungeons {
+		for seed := int64(1); seed <= SCENARIO_ARENA_SEEDS; seed++ {
+			arena, err := gameserver.NewArenaModelForLevel(staticInfo, dungeon.Level, seed)
+			if err != nil {
+				return fmt.Errorf("Dungeon %s seed %d: %s", name, seed, err)
+			}
+			err = CheckArenaObjects(&arena)
+			if err != nil {
+				return fmt.Errorf("Dungeon %s seed %d: %s", name, seed, err)
+			}
+		}
+	}
+
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	uids := make(map[uint32]bool)
+	for _, row := range client.ArenaInfo.Platforms {
+		for _, platform := range row {
+			if platform == nil {
+				continue
+			}
+			for _, objects := range [][]gameserver.PlatformObject{platform.Blocks, platform.Objects} {
+				for _, object := range objects {
+					if (object.Uid == 0) || uids[object.Uid] {
+						return fmt.Errorf("Object %s with bad uid %d in ArenaInfo", object.Id, object.Uid)
+					}
+					uids[object.Uid] = true
+				}
+			}
+		}
+	}
+	if len(uids) == 0 {
+		return errors.New("No objects in ArenaInfo")
+	}
+	return nil
+}
+
+// Результат вышедшего игрока виден другому игроку в общей таблице, вокруг профиля и по подземелью
+func ScenarioLeaderboard(harness *Harness) error {
+	viewer, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer viewer.Close()
+	player, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer player.Close()
+
+	err = player.SetProfile(SCENARIO_PROFILE, "Hero")
+	if err != nil {
+		return err
+	}
+	x, y, err := WalkablePoint(player.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	err = player.Move(x, y)
+	if err != nil {
+		return err
+	}
+	state, err := player.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, player.ID) != nil
+	})
+	if err != nil {
+		return err
+	}
+	arenaInfo, err := harness.FindClientArena(player.ID)
+	if err != nil {
+		return err
+	}
+
+	monster, err := harness.SpawnMonster(state.ID, SCENARIO_MONSTER_NAME, x, y)
+	if err != nil {
+		return err
+	}
+	playerInfo := gameserver.GetApp().GetStaticInfo().Units[gameserver.UNIT_NAME_PLAYER]
+	damage := int16(math.Min(playerInfo.Power, float64(monster.Health-1)))
+	if damage <= 0 {
+		return errors.New("Player power too low for leaderboard scenario")
+	}
+	err = player.Hit(x, y, damage*gameserver.HIT_DAMAGE_DIVIDER, monster.ID)
+	if err != nil {
+		return err
+	}
+	_, err = player.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		clientState := FindClientState(state, player.ID)
+		return (clientState != nil) && (clientState.TotalDamage == uint32(damage))
+	})
+	if err != nil {
+		return fmt.Errorf("Hit before leave: %s", err)
+	}
+
+	player.Close()
+	_, err = viewer.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, player.ID) == nil
+	})
+	if err != nil {
+		return fmt.Errorf("Client %d still in arena after leave: %s", player.ID, err)
+	}
+
+	queries := []gameserver.LeaderboardQuery{
+		{Window: gameserver.LEADERBOARD_WINDOW_ALL},
+		{Window: gameserver.LEADERBOARD_WINDOW_DAILY, Around: SCENARIO_PROFILE, Limit: 3},
+		{Window: gameserver.LEADERBOARD_WINDOW_WEEKLY, Dungeon: arenaInfo.Dungeon},
+	}
+	for _, query := range queries {
+		board, err := viewer.QueryLeaderboard(query)
+		if err != nil {
+			return fmt.Errorf("Query %+v: %s", query, err)
+		}
+		if (len(board.Entries) != 1) || (board.Total != 1) {
+			return fmt.Errorf("Query %+v: %d entries of %d, expected one", query, len(board.Entries), board.Total)
+		}
+		entry := board.Entries[0]
+		if (entry.Rank != 1) || (entry.Profile != SCENARIO_PROFILE) || (entry.Damage != uint32(damage)) ||
+			(entry.Dungeon != arenaInfo.Dungeon) || (entry.Runs != 1) {
+			return fmt.Errorf("Query %+v: entry %+v", query, entry)
+		}
+	}
+
+	// Подземелья без забегов и неизвестный период
+	board, err := viewer.QueryLeaderboard(gameserver.LeaderboardQuery{Dungeon: arenaInfo.Dungeon + "_none"})
+	if err != nil {
+		return err
+	}
+	if len(board.Entries) != 0 {
+		return fmt.Errorf("Unexpected entries for unknown dungeon: %+v", board.Entries)
+	}
+	_, err = viewer.QueryLeaderboard(gameserver.LeaderboardQuery{Window: "monthly"})
+	if err == nil {
+		return errors.New("Unknown window accepted")
+	}
+	return nil
+}
+
+// Клиенты по TCP и по WebSocket играют на одной арене и видят друг друга
+func ScenarioWebSocket(harness *Harness) error {
+	tcpClient, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer tcpClient.Close()
+	webSocketClient, err := harness.JoinWebSocket()
+	if err != nil {
+		return err
+	}
+
+	err = checkSharedArena(harness, tcpClient, webSocketClient)
+	if err != nil {
+		return err
+	}
+
+	webSocketClient.Close()
+	_, err = tcpClient.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, webSocketClient.ID) == nil
+	})
+	if err != nil {
+		return fmt.Errorf("WebSocket client %d still in arena after leave: %s", webSocketClient.ID, err)
+	}
+	return nil
+}
+
+// Клиенты по TCP и по KCP играют на одной арене и видят друг друга.
+// Уход не проверяется: KCP не сообщает о закрытии, сервер отключает клиента по IdleTimeout
+func ScenarioKCP(harness *Harness) error {
+	tcpClient, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer tcpClient.Close()
+	kcpClient, err := harness.JoinKCP()
+	if err != nil {
+		return err
+	}
+	defer kcpClient.Close()
+
+	return checkSharedArena(harness, tcpClient, kcpClient)
+}
+
+// Оба клиента на одной арене, каждый видит свое движение и движение другого
+func checkSharedArena(harness *Harness, first *Client, second *Client) error {
+	firstArena, err := harness.FindClientArena(first.ID)
+	if err != nil {
+		return err
+	}
+	secondArena, err := harness.FindClientArena(second.ID)
+	if err != nil {
+		return err
+	}
+	if firstArena.ID != secondArena.ID {
+		return fmt.Errorf("Client %d in arena %d, client %d in arena %d", first.ID, firstArena.ID, second.ID, secondArena.ID)
+	}
+
+	firstX, firstY, err := WalkablePoint(first.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	secondX, secondY, err := WalkablePoint(second.ArenaInfo, 5)
+	if err != nil {
+		return err
+	}
+	err = first.Move(firstX, firstY)
+	if err != nil {
+		return err
+	}
+	err = second.Move(secondX, secondY)
+	if err != nil {
+		return err
+	}
+
+	bothMoved := func(state *gameserver.GameArenaState) bool {
+		firstState := FindClientState(state, first.ID)
+		secondState := FindClientState(state, second.ID)
+		return (firstState != nil) && (firstState.X == firstX) && (firstState.Y == firstY) &&
+			(secondState != nil) && (secondState.X == secondX) && (secondState.Y == secondY)
+	}
+	for _, client := range []*Client{first, second} {
+		_, err = client.WaitArenaState(bothMoved)
+		if err != nil {
+			return fmt.Errorf("Client %d waiting moves: %s", client.ID, err)
+		}
+	}
+	return nil
+}
+
+// Тестовый CA во временной папке, TLS с проверкой клиентских сертификатов
+func ConfigureTLS(config *gameserver.Config) error {
+	dir, err := ioutil.TempDir("", "gameserver_tls")
+	if err != nil {
+		return err
+	}
+	pki, err := NewTestPKI(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	config.Server.TLSCertFile = pki.Path(TLS_SERVER_CERT_FILE)
+	config.Server.TLSKeyFile = pki.Path(TLS_SERVER_KEY_FILE)
+	config.Server.TLSClientCAFile = pki.Path(TLS_CA_FILE)
+	config.Server.TLSReloadPeriod = gameserver.ConfigDuration(SCENARIO_TLS_RELOAD)
+	return nil
+}
+
+// Игроки с клиентским сертификатом и без него подключаются по TLS и WebSocket, с сертификатом
+// чужого CA и без TLS - нет.
+// Новый сертификат сервера подхватывается без перезапуска, старые соединения продолжают работать
+func ScenarioTLS(harness *Harness) error {
+	pki, err := LoadTestPKI(filepath.Dir(gameserver.GetApp().GetConfig().Server.TLSCertFile))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(pki.Dir)
+	harness.TLSConfig, err = pki.ClientTLSConfig(true)
+	if err != nil {
+		return err
+	}
+
+	client, err := harness.Join()
+	if err != nil {
+		return fmt.Errorf("Client with certificate: %s", err)
+	}
+	defer client.Close()
+	oldSerial := client.PeerCertificate().SerialNumber
+
+	webSocketClient, err := harness.JoinWebSocket()
+	if err != nil {
+		return fmt.Errorf("WebSocket client with certificate: %s", err)
+	}
+	webSocketClient.Close()
+
+	// Клиентский сертификат необязателен
+	noCertConfig, err := pki.ClientTLSConfig(false)
+	if err != nil {
+		return err
+	}
+	noCertClient, err := DialTLS(harness.Address, noCertConfig)
+	if err != nil {
+		return fmt.Errorf("Client without certificate: %s", err)
+	}
+	err = noCertClient.Join()
+	noCertClient.Close()
+	if err != nil {
+		return fmt.Errorf("Client without certificate: %s", err)
+	}
+
+	// Сертификат, подписанный чужим CA, сервер не принимает
+	foreignDir, err := ioutil.TempDir("", "gameserver_tls_foreign")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(foreignDir)
+	foreignPKI, err := NewTestPKI(foreignDir)
+	if err != nil {
+		return err
+	}
+	foreignConfig, err := foreignPKI.ClientTLSConfig(true)
+	if err != nil {
+		return err
+	}
+	foreignConfig.RootCAs = noCertConfig.RootCAs
+	err = expectRejected(func() (*Client, error) { return DialTLS(harness.Address, foreignConfig) })
+	if err != nil {
+		return fmt.Errorf("Client with foreign certificate: %s", err)
+	}
+	err = expectRejected(func() (*Client, error) { return Dial(harness.Address) })
+	if err != nil {
+		return fmt.Errorf("Client without TLS: %s", err)
+	}
+
+	// Замена файлов: после периода проверки новые соединения получают новый сертификат
+	newSerial, err := pki.IssueServerCert()
+	if err != nil {
+		return err
+	}
+	time.Sleep(2 * SCENARIO_TLS_RELOAD)
+	reloadedClient, err := harness.Join()
+	if err != nil {
+		return fmt.Errorf("Client after reload: %s", err)
+	}
+	defer reloadedClient.Close()
+	if reloadedClient.PeerCertificate().SerialNumber.Cmp(newSerial) != 0 {
+		return fmt.Errorf("Server certificate %s after reload, expected %s", reloadedClient.PeerCertificate().SerialNumber, newSerial)
+	}
+	if newSerial.Cmp(oldSerial) == 0 {
+		return errors.New("New server certificate has old serial")
+	}
+	err = harness.GetServer().ReloadTLS()
+	if err != nil {
+		return fmt.Errorf("Forced reload: %s", err)
+	}
+
+	// Соединение со старым сертификатом продолжает работать
+	x, y, err := WalkablePoint(client.ArenaInfo, 3)
+	if err != nil {
+		return err
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		return err
+	}
+	_, err = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		clientState := FindClientState(state, client.ID)
+		return (clientState != nil) && (clientState.X == x) && (clientState.Y == y)
+	})
+	if err != nil {
+		return fmt.Errorf("Client with old certificate after reload: %s", err)
+	}
+	return nil
+}
+
+// TLS, на который без клиентского сертификата не подключиться
+func ConfigureTLSRequired(config *gameserver.Config) error {
+	err := ConfigureTLS(config)
+	if err != nil {
+		return err
+	}
+	config.Server.TLSClientAuth = gameserver.TLS_CLIENT_AUTH_REQUIRE
+	return nil
+}
+
+// С require игроки с сертификатом CA подключаются по TLS и WebSocket, без сертификата - нет,
+// хотя в сценарии tls такой клиент входит на арену
+func ScenarioTLSRequired(harness *Harness) error {
+	pki, err := LoadTestPKI(filepath.Dir(gameserver.GetApp().GetConfig().Server.TLSCertFile))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(pki.Dir)
+	harness.TLSConfig, err = pki.ClientTLSConfig(true)
+	if err != nil {
+		return err
+	}
+
+	client, err := harness.Join()
+	if err != nil {
+		return fmt.Errorf("Client with certificate: %s", err)
+	}
+	defer client.Close()
+	webSocketClient, err := harness.JoinWebSocket()
+	if err != nil {
+		return fmt.Errorf("WebSocket client with certificate: %s", err)
+	}
+	webSocketClient.Close()
+
+	noCertConfig, err := pki.ClientTLSConfig(false)
+	if err != nil {
+		return err
+	}
+	err = expectRejected(func() (*Client, error) { return DialTLS(harness.Address, noCertConfig) })
+	if err != nil {
+		return fmt.Errorf("Client without certificate: %s", err)
+	}
+	harness.TLSConfig = noCertConfig
+	err = expectRejected(harness.JoinWebSocket)
+	if err != nil {
+		return fmt.Errorf("WebSocket client without certificate: %s", err)
+	}
+	return nil
+}
+
+// Подключение, которое сервер не должен пустить на арену
+func expectRejected(dial func() (*Client, error)) error {
+	client, err := dial()
+	if err != nil {
+		return nil
+	}
+	defer client.Close()
+	client.ReadTimeout = SCENARIO_TLS_REJECT
+	err = client.Join()
+	if err == nil {
+		return fmt.Errorf("Joined as client %d", client.ID)
+	}
+	return nil
+}
+
+// Снимки арен во временной папке с коротким периодом
+func ConfigureCheckpoints(config *gameserver.Config) error {
+	dir, err := ioutil.TempDir("", "gameserver_checkpoints")
+	if err != nil {
+		return err
+	}
+	config.Server.CheckpointsDir = dir
+	config.Server.CheckpointPeriod = gameserver.ConfigDuration(SCENARIO_CHECKPOINT)
+	return nil
+}
+
+// После падения сервер восстанавливает арену из снимка: тики продолжаются, монстр на месте,
+// игрок по токену сессии возвращается с прежним id и позицией, чужой токен не принимается
+func ScenarioCheckpoint(harness *Harness) error {
+	dir := gameserver.GetApp().GetConfig().Server.CheckpointsDir
+	defer os.RemoveAll(dir)
+
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if client.Session == "" {
+		return errors.New("No session token after join")
+	}
+	x, y, err := WalkablePoint(client.ArenaInfo, 2)
+	if err != nil {
+		return err
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		return err
+	}
+	arena, err := harness.FindClientArena(client.ID)
+	if err != nil {
+		return err
+	}
+	monsterX, monsterY, err := WalkablePoint(client.ArenaInfo, 40)
+	if err != nil {
+		return err
+	}
+	monster, err := harness.SpawnMonster(arena.ID, SCENARIO_MONSTER_NAME, monsterX, monsterY)
+	if err != nil {
+		return err
+	}
+
+	// Снимок, в котором уже есть перемещение игрока и монстр
+	var checkpoint *gameserver.ArenaCheckpoint = nil
+	deadline := time.Now().Add(client.ReadTimeout)
+	for (checkpoint == nil) && time.Now().Before(deadline) {
+		time.Sleep(SCENARIO_CHECKPOINT)
+		saved, err := gameserver.ReadArenaCheckpointFile(gameserver.ArenaCheckpointFile(dir, arena.ID))
+		if (err != nil) || (len(saved.Clients) != 1) || (len(saved.Monsters) == 0) {
+			continue
+		}
+		if (saved.Clients[0].State.X == x) && (saved.Clients[0].State.Y == y) && (saved.Clients[0].Session == client.Session) {
+			checkpoint = saved
+		}
+	}
+	if checkpoint == nil {
+		return fmt.Errorf("No checkpoint with client %d and monster %d in %s", client.ID, monster.ID, client.ReadTimeout)
+	}
+
+	client.Close()
+	err = harness.RestartAfterCrash()
+	if err != nil {
+		return fmt.Errorf("Restart: %s", err)
+	}
+
+	restored := harness.GetServer().FindArena(arena.ID)
+	if restored == nil {
+		return fmt.Errorf("Arena %d not restored", arena.ID)
+	}
+	info, ok := restored.GetAdminInfo()
+	if ok == false {
+		return errors.New("Restored arena closed")
+	}
+	if info.Tick < checkpoint.Tick {
+		return fmt.Errorf("Restored arena tick %d, checkpoint tick %d", info.Tick, checkpoint.Tick)
+	}
+	if (len(info.Clients) != 1) || (info.Clients[0].State.ID != client.ID) || (info.Clients[0].Detached == false) {
+		return fmt.Errorf("Restored clients %+v, expected detached client %d", info.Clients, client.ID)
+	}
+
+	returned, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer returned.Close()
+	if returned.ID == client.ID {
+		return fmt.Errorf("New connection got restored id %d", client.ID)
+	}
+	err = returned.Reattach("unknown")
+	if err == nil {
+		return errors.New("Unknown session accepted")
+	}
+	err = returned.Reattach(client.Session)
+	if err != nil {
+		return err
+	}
+	if returned.ID != client.ID {
+		return fmt.Errorf("Reattached as client %d, expected %d", returned.ID, client.ID)
+	}
+	_, err = returned.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		clientState := FindClientState(state, client.ID)
+		monsterState := FindMonsterState(state, monster.ID)
+		return (clientState != nil) && (clientState.X == x) && (clientState.Y == y) && (monsterState != nil) && (state.ID == arena.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("Restored client and monster in arena state: %s", err)
+	}
+
+	info, _ = restored.GetAdminInfo()
+	for _, clientInfo := range info.Clients {
+		if (clientInfo.State.ID == client.ID) && clientInfo.Detached {
+			return fmt.Errorf("Client %d still detached after reattach", client.ID)
+		}
+	}
+	return nil
+}
+
+// Частые Ping, чтобы сценарий не ждал замеров
+func ConfigurePing(config *gameserver.Config) error {
+	config.Client.PingPeriod = gameserver.ConfigDuration(SCENARIO_PING)
+	return nil
+}
+
+// Ping сообщает время и тик сервера, после ответов на него у игрока есть RTT в админке,
+// в следующих Ping и в метриках
+func ScenarioPing(harness *Harness) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	config := gameserver.GetApp().GetConfig()
+
+	var last *gameserver.ServerPing = nil
+	for i := 0; i < SCENARIO_PING_COUNT; i++ {
+		ping, err := client.Pong()
+		if err != nil {
+			return err
+		}
+		serverTime := time.Unix(0, int64(ping.ServerTime*float64(time.Second)))
+		if math.Abs(time.Since(serverTime).Seconds()) > 1 {
+			return fmt.Errorf("Ping server time %s, local time %s", serverTime, time.Now())
+		}
+		if ping.TickPeriod != config.Arena.UpdatePeriod.Duration().Seconds() {
+			return fmt.Errorf("Ping tick period %f, expected %f", ping.TickPeriod, config.Arena.UpdatePeriod.Duration().Seconds())
+		}
+		if ping.TimeLeft <= 0 {
+			return fmt.Errorf("Ping time left %f", ping.TimeLeft)
+		}
+		if last != nil {
+			if (ping.ID <= last.ID) || (ping.Tick < last.Tick) || (ping.ServerTime <= last.ServerTime) || (ping.TimeLeft > last.TimeLeft) {
+				return fmt.Errorf("Ping %+v after %+v", *ping, *last)
+			}
+		}
+		last = ping
+	}
+
+	// Ответ обрабатывается циклом чтения сервера, замер появляется не сразу
+	arena, err := harness.FindClientArena(client.ID)
+	if err != nil {
+		return err
+	}
+	if last.Tick > arena.Tick {
+		return fmt.Errorf("Ping tick %d ahead of arena tick %d", last.Tick, arena.Tick)
+	}
+	var clientInfo *gameserver.AdminClientInfo = nil
+	deadline := time.Now().Add(client.ReadTimeout)
+	for (clientInfo == nil) && time.Now().Before(deadline) {
+		arena, err = harness.FindClientArena(client.ID)
+		if err != nil {
+			return err
+		}
+		for i := range arena.Clients {
+			if (arena.Clients[i].State.ID == client.ID) && (arena.Clients[i].RTT > 0) {
+				clientInfo = &arena.Clients[i]
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if clientInfo == nil {
+		return fmt.Errorf("No RTT for client %d in admin info", client.ID)
+	}
+	if clientInfo.RTT > client.ReadTimeout.Seconds() {
+		return fmt.Errorf("Client %d RTT %f", client.ID, clientInfo.RTT)
+	}
+
+	ping, err := client.Pong()
+	if err != nil {
+		return err
+	}
+	if ping.RTT <= 0 {
+		return fmt.Errorf("Ping after pongs without RTT: %+v", *ping)
+	}
+
+	buffer := &bytes.Buffer{}
+	harness.GetServer().GetMetrics().WriteText(buffer)
+	for _, line := range strings.Split(buffer.String(), "\n") {
+		if strings.HasPrefix(line, "gameserver_client_rtt_seconds_count ") {
+			if line == "gameserver_client_rtt_seconds_count 0" {
+				return errors.New("No RTT samples in metrics")
+			}
+			return nil
+		}
+	}
+	return errors.New("No RTT histogram in metrics")
+}
+
+// Запись арен во временной папке, урон выше допуска по умолчанию, арена закрывается сразу после выхода игрока
+func ConfigureReplay(config *gameserver.Config) error {
+	dir, err := ioutil.TempDir("", "gameserver_replays")
+	if err != nil {
+		return err
+	}
+	config.Server.ReplaysDir = dir
+	config.Arena.IdleTimeout = gameserver.ConfigDuration(SCENARIO_REPLAY_IDLE)
+	config.Arena.HitDamageTolerance = SCENARIO_REPLAY_DAMAGE
+	return nil
+}
+
+// Запись закрытой арены воспроизводится с теми же состояниями. Удар сильнее допуска по умолчанию
+// засчитан только с настройками из записи: без них воспроизведение расходится с записью
+func ScenarioReplay(harness *Harness) error {
+	dir := gameserver.GetApp().GetConfig().Server.ReplaysDir
+	defer os.RemoveAll(dir)
+
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	x, y, err := WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		return err
+	}
+	state, err := client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, client.ID) != nil
+	})
+	if err != nil {
+		return err
+	}
+	arenaId := state.ID
+	monster, err := harness.SpawnMonster(arenaId, SCENARIO_MONSTER_NAME, x, y)
+	if err != nil {
+		return err
+	}
+
+	playerInfo := gameserver.GetApp().GetStaticInfo().Units[gameserver.UNIT_NAME_PLAYER]
+	damage := int16(playerInfo.Power * SCENARIO_REPLAY_DAMAGE)
+	if (float64(damage) <= playerInfo.Power*gameserver.HIT_DAMAGE_TOLERANCE) || (damage >= monster.Health) {
+		return fmt.Errorf("Damage %d does not fit replay scenario", damage)
+	}
+	err = client.Hit(x, y, damage*gameserver.HIT_DAMAGE_DIVIDER, monster.ID)
+	if err != nil {
+		return err
+	}
+	_, err = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		monsterState := FindMonsterState(state, monster.ID)
+		return (monsterState != nil) && (monsterState.Health == monster.Health-damage)
+	})
+	if err != nil {
+		return fmt.Errorf("Hit with damage %d: %s", damage, err)
+	}
+
+	// Арена без игроков закрывается, запись дописана до удаления арены с сервера
+	client.Close()
+	deadline := time.Now().Add(client.ReadTimeout)
+	for harness.GetServer().FindArena(arenaId) != nil {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Arena %d not closed", arenaId)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("Replay files %v, expected one", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		return err
+	}
+	records, err := gameserver.ReadReplayRecords(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	result, err := gameserver.PlayReplay(records)
+	if err != nil {
+		return err
+	}
+	if result.Info.Arena == nil {
+		return errors.New("No arena config in replay info")
+	}
+	if (result.IsMatched() == false) || (result.States == 0) {
+		return fmt.Errorf("Replay not matched: %d states, %d replayed, differences %v %v",
+			result.States, result.ReplayedStates, result.ArenaInfoDiffs, result.Diffs)
+	}
+
+	info := result.Info
+	info.Arena = nil
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	records[0].Data = data
+	result, err = gameserver.PlayReplay(records)
+	if err != nil {
+		return err
+	}
+	if result.IsMatched() {
+		return errors.New("Replay without arena config matched the recording")
+	}
+	return nil
+}
+
+// Клиент без JOIN ждет JoinWait, по умолчанию ожидания нет
+func ConfigureResources(config *gameserver.Config) error {
+	config.Client.JoinWait = gameserver.ConfigDuration(SCENARIO_JOIN_WAIT)
+	return nil
+}
+
+// Ресурсы уровня приходят, пока у клиента нет их копии с тем же хешем. Хеш зависит только от уровня,
+// клиент без JOIN получает ArenaInfo с ресурсами после ожидания
+func ScenarioResources(harness *Harness) error {
+	first, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer first.Close()
+	if (first.ArenaInfo.Resources == nil) || (first.ResourcesHash == "") {
+		return errors.New("No resources in ArenaInfo for client without cache")
+	}
+	staticInfo := gameserver.GetApp().GetStaticInfo()
+	dungeon := staticInfo.Dungeons[gameserver.GetApp().GetConfig().Arena.Dungeon]
+	levelHash, err := gameserver.NewArenaResources(staticInfo, dungeon.Level).Hash()
+	if err != nil {
+		return err
+	}
+	if levelHash != first.ResourcesHash {
+		return fmt.Errorf("Resources hash %s, expected level hash %s", first.ResourcesHash, levelHash)
+	}
+
+	cached, err := harness.Dial()
+	if err != nil {
+		return err
+	}
+	defer cached.Close()
+	cached.ResourcesHash = first.ResourcesHash
+	err = cached.Join()
+	if err != nil {
+		return fmt.Errorf("Client with cached resources: %s", err)
+	}
+	if (cached.ArenaInfo.Resources != nil) || (cached.ArenaInfo.ResourcesHash != first.ResourcesHash) {
+		return fmt.Errorf("Client with cached resources got resources %t, hash %s", cached.ArenaInfo.Resources != nil, cached.ArenaInfo.ResourcesHash)
+	}
+
+	stale, err := harness.Dial()
+	if err != nil {
+		return err
+	}
+	defer stale.Close()
+	stale.ResourcesHash = "stale"
+	err = stale.Join()
+	if err != nil {
+		return fmt.Errorf("Client with stale resources: %s", err)
+	}
+	if (stale.ArenaInfo.Resources == nil) || (stale.ResourcesHash != first.ResourcesHash) {
+		return errors.New("No resources for client with stale cache")
+	}
+
+	legacy, err := harness.Dial()
+	if err != nil {
+		return err
+	}
+	defer legacy.Close()
+	err = legacy.WaitJoin()
+	if err != nil {
+		return fmt.Errorf("Client without JOIN: %s", err)
+	}
+	if legacy.ArenaInfo.Resources == nil {
+		return errors.New("No resources for client without JOIN")
+	}
+	return nil
+}
+
+// Без Ping у игрока нет замеров задержки, окно удара задает InitialRTT
+func ConfigureRewind(config *gameserver.Config) error {
+	config.Client.PingPeriod = 0
+	return nil
+}
+
+// Монстр, которого переместили, уязвим на старом месте для удара с тиком, на котором клиент его там видел.
+// Тот же удар по текущему состоянию - нарушение дистанции
+func ScenarioRewind(harness *Harness) error {
+	return runRewind(harness, 0, true)
+}
+
+// Ping идут, но клиент на них не отвечает. MaxRewind больше, чем окно до первого ответа
+func ConfigureRewindNoPong(config *gameserver.Config) error {
+	config.Client.PingPeriod = gameserver.ConfigDuration(SCENARIO_PING)
+	config.Arena.MaxRewind = gameserver.ConfigDuration(SCENARIO_REWIND_MAX)
+	return nil
+}
+
+// Клиент без ответов на Ping бьет с тиком старше окна InitialRTT, но в пределах MaxRewind:
+// тик поднимается до окна, монстр уже ушел, удар - нарушение дистанции
+func ScenarioRewindNoPong(harness *Harness) error {
+	return runRewind(harness, SCENARIO_REWIND_LAG, false)
+}
+
+// Удар по монстру с тика, на котором он стоял рядом с игроком, после перемещения монстра и еще lag тиков.
+// accepted - удар должен пройти по старому месту, иначе - засчитаться нарушением
+func runRewind(harness *Harness, lag int, accepted bool) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	arena, err := harness.FindClientArena(client.ID)
+	if err != nil {
+		return err
+	}
+	config := gameserver.GetApp().GetConfig().Arena
+	tick := arena.Tick
+	// Тики до выполнения condition, перед каждым тиком сервер успевает прочитать команды
+	stepUntil := func(what string, condition func(arena gameserver.AdminArenaInfo) bool) error {
+		for i := 0; i < SCENARIO_REWIND_STEPS; i++ {
+			time.Sleep(SCENARIO_REWIND_READ)
+			tick++
+			err := harness.Step(arena.ID, config.UpdatePeriod.Duration(), tick)
+			if err != nil {
+				return err
+			}
+			arena, err = harness.FindClientArena(client.ID)
+			if err != nil {
+				return err
+			}
+			if condition(arena) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: not done in %d ticks", what, SCENARIO_REWIND_STEPS)
+	}
+	findClient := func(arena gameserver.AdminArenaInfo) gameserver.AdminClientInfo {
+		for _, info := range arena.Clients {
+			if info.State.ID == client.ID {
+				return info
+			}
+		}
+		return gameserver.AdminClientInfo{}
+	}
+	findMonster := func(arena gameserver.AdminArenaInfo, id uint32) gameserver.ServerMonsterState {
+		for _, monster := range arena.Monsters {
+			if monster.ID == id {
+				return monster
+			}
+		}
+		return gameserver.ServerMonsterState{}
+	}
+
+	x, y, err := WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		return err
+	}
+	err = stepUntil("Move", func(arena gameserver.AdminArenaInfo) bool {
+		state := findClient(arena).State
+		return (state.X == x) && (state.Y == y)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Монстр рядом с игроком на тике seenTick, на следующем тике - за пределами удара
+	monster, err := harness.SpawnMonster(arena.ID, SCENARIO_MONSTER_NAME, x, y)
+	if err != nil {
+		return err
+	}
+	err = stepUntil("Spawn", func(arena gameserver.AdminArenaInfo) bool { return true })
+	if err != nil {
+		return err
+	}
+	seenTick := tick
+	staticInfo := gameserver.GetApp().GetStaticInfo()
+	playerInfo := staticInfo.Units[gameserver.UNIT_NAME_PLAYER]
+	far := playerInfo.AttackRadius + staticInfo.Units[SCENARIO_MONSTER_NAME].BoundingRadius + config.HitDistanceTolerance + 1
+	_, err = harness.MoveMonster(arena.ID, monster.ID, x+far, y)
+	if err != nil {
+		return err
+	}
+	err = stepUntil("Move monster", func(arena gameserver.AdminArenaInfo) bool { return true })
+	if err != nil {
+		return err
+	}
+	for i := 0; i < lag; i++ {
+		err = stepUntil("Lag", func(arena gameserver.AdminArenaInfo) bool { return true })
+		if err != nil {
+			return err
+		}
+	}
+	if (tick-seenTick)*uint64(config.UpdatePeriod) >= uint64(config.MaxRewind) {
+		return fmt.Errorf("Tick %d out of rewind window %s", seenTick, config.MaxRewind.Duration())
+	}
+
+	damage := int16(math.Min(playerInfo.Power, float64(monster.Health-1)))
+	err = client.HitAt(seenTick, x, y, damage*gameserver.HIT_DAMAGE_DIVIDER, monster.ID)
+	if err != nil {
+		return err
+	}
+	if accepted == false {
+		// Окно до первого ответа на Ping: InitialRTT и тик на отправку
+		window := gameserver.GetApp().GetConfig().Client.InitialRTT.Duration() + config.UpdatePeriod.Duration()
+		if time.Duration(tick-seenTick)*config.UpdatePeriod.Duration() <= window {
+			return fmt.Errorf("Tick %d inside initial RTT window %s", seenTick, window)
+		}
+		err = stepUntil("Hit without pongs", func(arena gameserver.AdminArenaInfo) bool {
+			return findClient(arena).HitViolations > 0
+		})
+		if err != nil {
+			return err
+		}
+		if health := findMonster(arena, monster.ID).Health; health != monster.Health {
+			return fmt.Errorf("Monster health %d after hit from stale tick, expected %d", health, monster.Health)
+		}
+		return nil
+	}
+	err = stepUntil("Hit at old tick", func(arena gameserver.AdminArenaInfo) bool {
+		return findMonster(arena, monster.ID).Health == monster.Health-damage
+	})
+	if err != nil {
+		return err
+	}
+
+	err = client.HitAt(0, x, y, damage*gameserver.HIT_DAMAGE_DIVIDER, monster.ID)
+	if err != nil {
+		return err
+	}
+	err = stepUntil("Hit at current tick", func(arena gameserver.AdminArenaInfo) bool {
+		return findClient(arena).HitViolations > 0
+	})
+	if err != nil {
+		return err
+	}
+	if health := findMonster(arena, monster.ID).Health; health != monster.Health-damage {
+		return fmt.Errorf("Monster health %d after rejected hit, expected %d", health, monster.Health-damage)
+	}
+	if violations := findClient(arena).HitViolations; violations != 1 {
+		return fmt.Errorf("Hit violations %d, expected 1", violations)
+	}
+	return nil
+}
+
+// Первое нарушение при ударе отключает игрока
+func ConfigureKick(config *gameserver.Config) error {
+	config.Arena.HitViolationsLimit = 1
+	config.Arena.HitViolationAction = gameserver.HIT_VIOLATION_ACTION_KICK
+	return nil
+}
+
+// Удар по несуществующему монстру отключает нарушителя, второй игрок остается на арене
+func ScenarioKick(harness *Harness) error {
+	cheater, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer cheater.Close()
+	honest, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer honest.Close()
+	err = checkSharedArena(harness, cheater, honest)
+	if err != nil {
+		return err
+	}
+
+	x, y, err := WalkablePoint(cheater.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	err = cheater.Move(x, y)
+	if err != nil {
+		return err
+	}
+	_, err = cheater.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, cheater.ID) != nil
+	})
+	if err != nil {
+		return err
+	}
+	playerInfo := gameserver.GetApp().GetStaticInfo().Units[gameserver.UNIT_NAME_PLAYER]
+	err = cheater.Hit(x, y, int16(playerInfo.Power)*gameserver.HIT_DAMAGE_DIVIDER, SCENARIO_UNKNOWN_MONSTER)
+	if err != nil {
+		return err
+	}
+	err = cheater.ExpectClosed()
+	if err != nil {
+		return fmt.Errorf("Cheater not kicked: %s", err)
+	}
+
+	_, err = honest.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return (FindClientState(state, cheater.ID) == nil) && (FindClientState(state, honest.ID) != nil)
+	})
+	if err != nil {
+		return fmt.Errorf("Cheater %d still in arena state: %s", cheater.ID, err)
+	}
+	arena, err := harness.FindClientArena(honest.ID)
+	if err != nil {
+		return err
+	}
+	if len(arena.Clients) != 1 {
+		return fmt.Errorf("Arena has %d clients after kick, expected 1", len(arena.Clients))
+	}
+	return nil
+}
+
+// По игроку на арену, чтобы наблюдатель переходил между аренами, пустая арена закрывается быстро
+func ConfigureSpectators(config *gameserver.Config) error {
+	config.Arena.MaxClients = 1
+	config.Arena.IdleTimeout = gameserver.ConfigDuration(SCENARIO_SPECTATOR_IDLE)
+	return nil
+}
+
+// Наблюдатель получает список арен, подключается к арене и видит движение игрока, переходит на другую,
+// а после закрытия арены снова получает список без нее
+func ScenarioSpectators(harness *Harness) error {
+	first, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer first.Close()
+	second, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer second.Close()
+	firstArena, err := harness.FindClientArena(first.ID)
+	if err != nil {
+		return err
+	}
+	secondArena, err := harness.FindClientArena(second.ID)
+	if err != nil {
+		return err
+	}
+	if firstArena.ID == secondArena.ID {
+		return fmt.Errorf("Players %d and %d share arena %d with one client limit", first.ID, second.ID, firstArena.ID)
+	}
+
+	spectator, err := Dial(harness.SpectatorAddr)
+	if err != nil {
+		return err
+	}
+	defer spectator.Close()
+	list, err := expectArenasList(spectator)
+	if err != nil {
+		return err
+	}
+	for _, arenaId := range []uint32{firstArena.ID, secondArena.ID} {
+		if clients, exists := list[arenaId]; (exists == false) || (clients != 1) {
+			return fmt.Errorf("Arenas list %v, expected arena %d with one client", list, arenaId)
+		}
+	}
+
+	watch := func(arenaId uint32, player *Client) error {
+		err := spectator.Spectate(arenaId)
+		if err != nil {
+			return err
+		}
+		_, err = spectator.Expect("ArenaInfo")
+		if err != nil {
+			return err
+		}
+		x, y, err := WalkablePoint(player.ArenaInfo, 0)
+		if err != nil {
+			return err
+		}
+		err = player.Move(x, y)
+		if err != nil {
+			return err
+		}
+		_, err = spectator.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+			return (state.ID == arenaId) && (FindClientState(state, player.ID) != nil)
+		})
+		if err != nil {
+			return fmt.Errorf("Spectator in arena %d: %s", arenaId, err)
+		}
+		return waitSpectators(harness, arenaId, 1)
+	}
+	err = watch(firstArena.ID, first)
+	if err != nil {
+		return err
+	}
+	err = watch(secondArena.ID, second)
+	if err != nil {
+		return err
+	}
+	err = waitSpectators(harness, firstArena.ID, 0)
+	if err != nil {
+		return err
+	}
+
+	// Арена без игроков закрывается, наблюдатель остается подключенным и получает список
+	second.Close()
+	_, err = expectArenasList(spectator)
+	if err != nil {
+		return fmt.Errorf("No arenas list after arena %d closed: %s", secondArena.ID, err)
+	}
+	deadline := time.Now().Add(spectator.ReadTimeout)
+	for time.Now().Before(deadline) {
+		err = spectator.Spectate(0)
+		if err != nil {
+			return err
+		}
+		list, err = expectArenasList(spectator)
+		if err != nil {
+			return err
+		}
+		if _, exists := list[secondArena.ID]; exists == false {
+			if _, exists := list[firstArena.ID]; exists == false {
+				return fmt.Errorf("Arenas list %v without live arena %d", list, firstArena.ID)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("Closed arena %d still in arenas list", secondArena.ID)
+}
+
+// Список арен наблюдателя: id арены - число игроков
+func expectArenasList(spectator *Client) (map[uint32]int32, error) {
+	data, err := spectator.Expect("ArenasList")
+	if err != nil {
+		return nil, err
+	}
+	list := gameserver.ArenasList{}
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[uint32]int32, len(list.Arenas))
+	for _, item := range list.Arenas {
+		result[item.ID] = item.Clients
+	}
+	return result, nil
+}
+
+// Ожидание, пока у арены будет count наблюдателей
+func waitSpectators(harness *Harness, arenaId uint32, count int) error {
+	arena := harness.GetServer().FindArena(arenaId)
+	if arena == nil {
+		return fmt.Errorf("No arena %d", arenaId)
+	}
+	spectators := 0
+	deadline := time.Now().Add(CLIENT_READ_TIMEOUT)
+	for time.Now().Before(deadline) {
+		info, ok := arena.GetAdminInfo()
+		if ok == false {
+			return fmt.Errorf("Arena %d closed", arenaId)
+		}
+		spectators = info.Spectators
+		if spectators == count {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return fmt.Errorf("Arena %d has %d spectators, expected %d", arenaId, spectators, count)
+}
+
+// Админка на случайном порту
+func ConfigureAdmin(config *gameserver.Config) error {
+	config.Server.AdminListenAddress = HARNESS_LISTEN_ADDRESS
+	config.Server.AdminToken = SCENARIO_ADMIN_TOKEN
+	return nil
+}
+
+// Запросы админки: без токена отказ, список и состояние арены, создание и перемещение монстра,
+// таблица рекордов, отключение игрока, закрытие арены и запрос остановки сервера
+func ScenarioAdmin(harness *Harness) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	status, err := harness.AdminRequestToken("wrong_"+SCENARIO_ADMIN_TOKEN, http.MethodGet, "/arenas", nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusUnauthorized {
+		return fmt.Errorf("Request with wrong token: status %d, expected %d", status, http.StatusUnauthorized)
+	}
+
+	// request - запрос, который должен вернуть 200
+	request := func(method string, path string, body interface{}, reply interface{}) error {
+		status, err := harness.AdminRequest(method, path, body, reply)
+		if err != nil {
+			return fmt.Errorf("%s %s: %s", method, path, err)
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("%s %s: status %d", method, path, status)
+		}
+		return nil
+	}
+
+	arenas := []gameserver.AdminArenaInfo{}
+	err = request(http.MethodGet, "/arenas", nil, &arenas)
+	if err != nil {
+		return err
+	}
+	arenaId := uint32(0)
+	for _, arena := range arenas {
+		for _, info := range arena.Clients {
+			if info.State.ID == client.ID {
+				arenaId = arena.ID
+			}
+		}
+	}
+	if arenaId == 0 {
+		return fmt.Errorf("No arena with client %d in admin list", client.ID)
+	}
+	arena := gameserver.AdminArenaInfo{}
+	err = request(http.MethodGet, fmt.Sprintf("/arenas/%d", arenaId), nil, &arena)
+	if err != nil {
+		return err
+	}
+	if (arena.ID != arenaId) || (len(arena.Clients) != 1) {
+		return fmt.Errorf("Admin arena %d with %d clients, expected arena %d with one client", arena.ID, len(arena.Clients), arenaId)
+	}
+
+	x, y, err := WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	monster := gameserver.ServerMonsterState{}
+	err = request(http.MethodPost, fmt.Sprintf("/arenas/%d/monsters", arenaId), gameserver.AdminSpawnRequest{Name: SCENARIO_MONSTER_NAME, X: x, Y: y}, &monster)
+	if err != nil {
+		return err
+	}
+	_, err = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindMonsterState(state, monster.ID) != nil
+	})
+	if err != nil {
+		return fmt.Errorf("Admin monster %d not in arena state: %s", monster.ID, err)
+	}
+	newX, newY, err := WalkablePoint(client.ArenaInfo, 1)
+	if err != nil {
+		return err
+	}
+	err = request(http.MethodPost, fmt.Sprintf("/arenas/%d/monsters/%d", arenaId, monster.ID), gameserver.AdminMoveRequest{X: newX, Y: newY}, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		moved := FindMonsterState(state, monster.ID)
+		return (moved != nil) && (moved.X == newX) && (moved.Y == newY)
+	})
+	if err != nil {
+		return fmt.Errorf("Admin monster %d not moved: %s", monster.ID, err)
+	}
+
+	err = request(http.MethodGet, "/leaderboard", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	status, err = harness.AdminRequest(http.MethodPost, fmt.Sprintf("/clients/%d/kick", client.ID+1000), nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNotFound {
+		return fmt.Errorf("Kick unknown client: status %d, expected %d", status, http.StatusNotFound)
+	}
+	err = request(http.MethodPost, fmt.Sprintf("/clients/%d/kick", client.ID), nil, nil)
+	if err != nil {
+		return err
+	}
+	err = client.ExpectClosed()
+	if err != nil {
+		return fmt.Errorf("Kicked client: %s", err)
+	}
+
+	// Закрытая арена отключает игроков и пропадает из админки
+	player, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer player.Close()
+	playerArena, err := harness.FindClientArena(player.ID)
+	if err != nil {
+		return err
+	}
+	err = request(http.MethodPost, fmt.Sprintf("/arenas/%d/close", playerArena.ID), nil, nil)
+	if err != nil {
+		return err
+	}
+	err = player.ExpectClosed()
+	if err != nil {
+		return fmt.Errorf("Player of closed arena: %s", err)
+	}
+	deadline := time.Now().Add(CLIENT_READ_TIMEOUT)
+	for harness.GetServer().FindArena(playerArena.ID) != nil {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Closed arena %d still on server", playerArena.ID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	status, err = harness.AdminRequest(http.MethodGet, fmt.Sprintf("/arenas/%d", playerArena.ID), nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNotFound {
+		return fmt.Errorf("Closed arena: status %d, expected %d", status, http.StatusNotFound)
+	}
+
+	status, err = harness.AdminRequest(http.MethodPost, "/shutdown", nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusAccepted {
+		return fmt.Errorf("Shutdown: status %d, expected %d", status, http.StatusAccepted)
+	}
+	select {
+	case <-gameserver.GetApp().ShutdownRequested():
+		return nil
+	case <-time.After(CLIENT_READ_TIMEOUT):
+		return errors.New("Shutdown not requested by admin")
+	}
+}
+
+// Метрики на случайном порту
+func ConfigureMetrics(config *gameserver.Config) error {
+	config.Server.MetricsListenAddress = HARNESS_LISTEN_ADDRESS
+	return nil
+}
+
+// Метрики считают арену, игрока, подключение и трафик, после отключения игрока живых игроков нет
+func ScenarioMetrics(harness *Harness) error {
+	client, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	// Состояние арены отправляется при изменениях, движение игрока дает трафик в обе стороны
+	x, y, err := WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		return err
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		return err
+	}
+	_, err = client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return FindClientState(state, client.ID) != nil
+	})
+	if err != nil {
+		return err
+	}
+
+	accepted := fmt.Sprintf("gameserver_connections_accepted_total{listener=\"%s\"}", gameserver.METRICS_LISTENER_PLAYER)
+	err = waitMetrics(harness, func(values map[string]float64) bool {
+		return (values["gameserver_arenas_live"] == 1) && (values["gameserver_clients_live"] == 1) &&
+			(values[accepted] == 1) && (values["gameserver_bytes_sent_total"] > 0) &&
+			(values["gameserver_messages_received_total"] > 0)
+	})
+	if err != nil {
+		return err
+	}
+
+	client.Close()
+	return waitMetrics(harness, func(values map[string]float64) bool {
+		return values["gameserver_clients_live"] == 0
+	})
+}
+
+// Разбор текста метрик: имя с метками - значение, строки комментариев пропускаются
+func parseMetrics(text string) map[string]float64 {
+	values := make(map[string]float64)
+	for _, line := range strings.Split(text, "\n") {
+		if (line == "") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		index := strings.LastIndex(line, " ")
+		if index < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[index+1:], 64)
+		if err != nil {
+			continue
+		}
+		values[line[:index]] = value
+	}
+	return values
+}
+
+// Запрос метрик, пока condition не вернет true
+func waitMetrics(harness *Harness, condition func(values map[string]float64) bool) error {
+	text := ""
+	deadline := time.Now().Add(CLIENT_READ_TIMEOUT)
+	for time.Now().Before(deadline) {
+		var err error
+		text, err = harness.GetMetricsText()
+		if err != nil {
+			return err
+		}
+		if condition(parseMetrics(text)) {
+			return nil
+		}
+		time.Sleep(SCENARIO_METRICS_POLL)
+	}
+	return fmt.Errorf("No expected metrics in %s:\n%s", CLIENT_READ_TIMEOUT, text)
+}
+
+// Один параметр в файле, окружении и флагах одновременно: побеждает более поздний источник.
+// Сервер запускается с загруженными настройками
+func ConfigurePrecedence(config *gameserver.Config) error {
+	dir, err := ioutil.TempDir("", "scenario_config")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	defaults := gameserver.NewDefaultConfig().Arena
+	fileData, err := json.Marshal(map[string]interface{}{
+		"server": config.Server,
+		"arena": map[string]interface{}{
+			"monstersAlive": defaults.MonstersAlive + 1,
+			"maxClients":    defaults.MaxClients + 1,
+			"hitBurst":      defaults.HitBurst + 1,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	filePath := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(filePath, fileData, 0644)
+	if err != nil {
+		return err
+	}
+
+	env := map[string]string{
+		gameserver.CONFIG_ENV_PREFIX + "ARENA_MAX_CLIENTS": strconv.Itoa(defaults.MaxClients + 2),
+		gameserver.CONFIG_ENV_PREFIX + "HIT_BURST":         strconv.Itoa(defaults.HitBurst + 2),
+	}
+	for name, value := range env {
+		previous, exists := os.LookupEnv(name)
+		os.Setenv(name, value)
+		if exists {
+			defer os.Setenv(name, previous)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+
+	loaded, err := gameserver.LoadConfig([]string{"-config", filePath, "-hit-burst", strconv.Itoa(defaults.HitBurst + 3)})
+	if err != nil {
+		return err
+	}
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"monsterPeriod (default)", loaded.Arena.MonsterPeriod, defaults.MonsterPeriod},
+		{"monstersAlive (file)", loaded.Arena.MonstersAlive, defaults.MonstersAlive + 1},
+		{"maxClients (env over file)", loaded.Arena.MaxClients, defaults.MaxClients + 2},
+		{"hitBurst (flag over env and file)", loaded.Arena.HitBurst, defaults.HitBurst + 3},
+		{"listen (file)", loaded.Server.ListenAddress, config.Server.ListenAddress},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			return fmt.Errorf("Config %s = %v, expected %v", check.name, check.got, check.want)
+		}
+	}
+	*config = *loaded
+	return nil
+}
+
+// Сервер работает с настройками из всех источников: игроки помещаются на арену до предела из окружения
+func ScenarioPrecedence(harness *Harness) error {
+	config := gameserver.GetApp().GetConfig()
+	defaults := gameserver.NewDefaultConfig().Arena
+	if (config.Arena.MaxClients != defaults.MaxClients+2) || (config.Arena.HitBurst != defaults.HitBurst+3) {
+		return fmt.Errorf("Server config maxClients %d, hitBurst %d not loaded from env and flags", config.Arena.MaxClients, config.Arena.HitBurst)
+	}
+
+	clients := make([]*Client, 0, config.Arena.MaxClients)
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	for i := 0; i < config.Arena.MaxClients; i++ {
+		client, err := harness.Join()
+		if err != nil {
+			return err
+		}
+		clients = append(clients, client)
+	}
+	arena, err := harness.FindClientArena(clients[0].ID)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(CLIENT_READ_TIMEOUT)
+	for len(arena.Clients) != config.Arena.MaxClients {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Arena %d has %d clients, expected %d", arena.ID, len(arena.Clients), config.Arena.MaxClients)
+		}
+		time.Sleep(time.Millisecond)
+		arena, err = harness.FindClientArena(clients[0].ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Клиент, который не читает, быстро становится медленным, короткий тик ускоряет отправку состояний
+func ConfigureSlowClient(config *gameserver.Config) error {
+	config.Client.SlowTimeout = gameserver.ConfigDuration(SCENARIO_SLOW_TIMEOUT)
+	config.Client.PingPeriod = 0
+	config.Arena.UpdatePeriod = gameserver.ConfigDuration(SCENARIO_SLOW_TICK)
+	return nil
+}
+
+// Монстры не атакуют, чтобы игроки не проиграли, пока сервер ждет медленного клиента
+func PrepareSlowClient(staticInfo *gameserver.StaticInfo) {
+	staticInfo.Units[SCENARIO_MONSTER_NAME].Power = 0
+}
+
+// Игрок, который перестал читать, отключается как медленный, второй игрок продолжает получать состояния.
+// Состояние арены отправляется только при изменениях, поэтому второй игрок все время двигается
+func ScenarioSlowClient(harness *Harness) error {
+	slow, err := DialReadBuffer(harness.Address, SCENARIO_SLOW_READ_BUFFER)
+	if err != nil {
+		return err
+	}
+	defer slow.Close()
+	err = slow.Join()
+	if err != nil {
+		return err
+	}
+	fast, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer fast.Close()
+	err = checkSharedArena(harness, slow, fast)
+	if err != nil {
+		return err
+	}
+
+	// Монстры увеличивают состояние арены, чтобы буферы соединения заполнились быстрее
+	arena, err := harness.FindClientArena(fast.ID)
+	if err != nil {
+		return err
+	}
+	points := make([][2]float64, 2)
+	for i := range points {
+		points[i][0], points[i][1], err = WalkablePoint(fast.ArenaInfo, i)
+		if err != nil {
+			return err
+		}
+	}
+	for i := 0; i < SCENARIO_SLOW_MONSTERS; i++ {
+		_, err = harness.SpawnMonster(arena.ID, SCENARIO_MONSTER_NAME, points[0][0], points[0][1])
+		if err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(SCENARIO_SLOW_WAIT)
+	for i := 0; time.Now().Before(deadline); i++ {
+		x, y := points[i%2][0], points[i%2][1]
+		err = fast.Move(x, y)
+		if err != nil {
+			return err
+		}
+		state, err := fast.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+			clientState := FindClientState(state, fast.ID)
+			return (clientState != nil) && (clientState.X == x) && (clientState.Y == y)
+		})
+		if err != nil {
+			return fmt.Errorf("Fast client: %s", err)
+		}
+		if FindClientState(state, slow.ID) != nil {
+			continue
+		}
+
+		buffer := &bytes.Buffer{}
+		harness.GetServer().GetMetrics().WriteText(buffer)
+		if value := parseMetrics(buffer.String())["gameserver_slow_disconnects_total"]; value != 1 {
+			return fmt.Errorf("Slow disconnects %v, expected 1", value)
+		}
+		return nil
+	}
+	return fmt.Errorf("Slow client %d not disconnected in %s", slow.ID, SCENARIO_SLOW_WAIT)
+}
+
+// Клиент по KCP через прокси, который теряет и переставляет пакеты, входит на арену с игроком по TCP,
+// видит его и сам виден, а серия движений доходит до сервера целиком и по порядку
+func ScenarioLossyKCP(harness *Harness) error {
+	if harness.KCPAddress == "" {
+		return errors.New("Harness started without KCP")
+	}
+	proxy, err := bot.NewLossyProxy(HARNESS_LISTEN_ADDRESS, harness.KCPAddress, SCENARIO_PROXY_LOSS, SCENARIO_PROXY_REORDER, SCENARIO_PROXY_SEED)
+	if err != nil {
+		return err
+	}
+	defer proxy.Close()
+
+	kcpClient, err := DialKCP(proxy.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer kcpClient.Close()
+	kcpClient.ReadTimeout = SCENARIO_PROXY_READ
+	err = kcpClient.Join()
+	if err != nil {
+		return fmt.Errorf("Join through lossy proxy: %s", err)
+	}
+	tcpClient, err := harness.Join()
+	if err != nil {
+		return err
+	}
+	defer tcpClient.Close()
+	err = checkSharedArena(harness, tcpClient, kcpClient)
+	if err != nil {
+		return err
+	}
+
+	// Повтор или обгон команды сервер отбросил бы как устаревшую, последнее движение тогда не применится
+	points := make([]gameserver.PointFloat, 0, SCENARIO_PROXY_MOVES)
+	for i := 0; i < SCENARIO_PROXY_MOVES; i++ {
+		x, y, err := WalkablePoint(kcpClient.ArenaInfo, i)
+		if err != nil {
+			return err
+		}
+		points = append(points, gameserver.NewPointFloat(x, y))
+		err = kcpClient.Send(gameserver.ClientCommand{
+			Seq:         uint32(i + 1),
+			CommandType: gameserver.CLIENT_COMMAND_TYPE_MOVE,
+			X:           x,
+			Y:           y,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	last := points[len(points)-1]
+	_, err = tcpClient.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		clientState := FindClientState(state, kcpClient.ID)
+		return (clientState != nil) && (clientState.LastSeq == SCENARIO_PROXY_MOVES) && (clientState.X == last.X) && (clientState.Y == last.Y)
+	})
+	if err != nil {
+		return fmt.Errorf("Moves through lossy proxy: %s", err)
+	}
+	buffer := &bytes.Buffer{}
+	harness.GetServer().GetMetrics().WriteText(buffer)
+	if value := parseMetrics(buffer.String())["gameserver_input_drops_total"]; value != 0 {
+		return fmt.Errorf("Input drops %v through lossy proxy, expected commands in order", value)
+	}
+
+	forwarded, dropped, reordered := proxy.GetStats()
+	if (dropped == 0) || (reordered == 0) {
+		return fmt.Errorf("Proxy forwarded %d, dropped %d, reordered %d packets, expected loss and reordering", forwarded, dropped, reordered)
+	}
+	return nil
+}
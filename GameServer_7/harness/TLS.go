@@ -0,0 +1,186 @@
+package harness
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// Файлы в папке тестового CA
+const (
+	TLS_CA_FILE          = "ca.pem"
+	TLS_CA_KEY_FILE      = "ca-key.pem"
+	TLS_SERVER_CERT_FILE = "server.pem"
+	TLS_SERVER_KEY_FILE  = "server-key.pem"
+	TLS_CLIENT_CERT_FILE = "client.pem"
+	TLS_CLIENT_KEY_FILE  = "client-key.pem"
+	TLS_CERT_LIFETIME    = time.Hour
+)
+
+// Самоподписанный CA с сертификатами сервера (127.0.0.1, localhost) и клиента для сценариев с TLS
+type TestPKI struct {
+	Dir    string
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+}
+
+// Новый CA и сертификаты сервера и клиента в папке dir
+func NewTestPKI(dir string) (*TestPKI, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newCertTemplate("GameServer test CA")
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	caData, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caData)
+	if err != nil {
+		return nil, err
+	}
+
+	pki := &TestPKI{
+		Dir:    dir,
+		caCert: caCert,
+		caKey:  caKey,
+	}
+	err = pki.writeCert(TLS_CA_FILE, TLS_CA_KEY_FILE, caData, caKey)
+	if err != nil {
+		return nil, err
+	}
+	_, err = pki.IssueServerCert()
+	if err != nil {
+		return nil, err
+	}
+	err = pki.issueCert("GameServer test client", x509.ExtKeyUsageClientAuth, TLS_CLIENT_CERT_FILE, TLS_CLIENT_KEY_FILE)
+	if err != nil {
+		return nil, err
+	}
+	return pki, nil
+}
+
+// CA, созданный ранее в папке dir
+func LoadTestPKI(dir string) (*TestPKI, error) {
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, TLS_CA_FILE), filepath.Join(dir, TLS_CA_KEY_FILE))
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	caKey, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if ok == false {
+		return nil, errors.New("CA key is not ECDSA")
+	}
+	pki := &TestPKI{
+		Dir:    dir,
+		caCert: caCert,
+		caKey:  caKey,
+	}
+	return pki, nil
+}
+
+func (pki *TestPKI) Path(file string) string {
+	return filepath.Join(pki.Dir, file)
+}
+
+// Новый сертификат сервера поверх старых файлов, возвращается его серийный номер
+func (pki *TestPKI) IssueServerCert() (*big.Int, error) {
+	err := pki.issueCert("127.0.0.1", x509.ExtKeyUsageServerAuth, TLS_SERVER_CERT_FILE, TLS_SERVER_KEY_FILE)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.LoadX509KeyPair(pki.Path(TLS_SERVER_CERT_FILE), pki.Path(TLS_SERVER_KEY_FILE))
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return cert.SerialNumber, nil
+}
+
+// Настройки клиента, доверяющего тестовому CA, withClientCert - с клиентским сертификатом
+func (pki *TestPKI) ClientTLSConfig(withClientCert bool) (*tls.Config, error) {
+	pool := x509.NewCertPool()
+	pool.AddCert(pki.caCert)
+	config := &tls.Config{
+		RootCAs: pool,
+	}
+	if withClientCert {
+		pair, err := tls.LoadX509KeyPair(pki.Path(TLS_CLIENT_CERT_FILE), pki.Path(TLS_CLIENT_KEY_FILE))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+func (pki *TestPKI) issueCert(commonName string, usage x509.ExtKeyUsage, certFile string, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template, err := newCertTemplate(commonName)
+	if err != nil {
+		return err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	if usage == x509.ExtKeyUsageServerAuth {
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+		template.DNSNames = []string{"localhost"}
+	}
+	data, err := x509.CreateCertificate(rand.Reader, template, pki.caCert, &key.PublicKey, pki.caKey)
+	if err != nil {
+		return err
+	}
+	return pki.writeCert(certFile, keyFile, data, key)
+}
+
+func (pki *TestPKI) writeCert(certFile string, keyFile string, data []byte, key *ecdsa.PrivateKey) error {
+	keyData, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(pki.Path(keyFile), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyData}), 0600)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pki.Path(certFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: data}), 0644)
+}
+
+func newCertTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(TLS_CERT_LIFETIME),
+	}
+	return template, nil
+}
@@ -0,0 +1,271 @@
+package harness
+
+import (
+	"GoTests/GameServer_7/gameserver"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+// Сервер с ручными часами: таймеры простоя и подземелья срабатывают только от Advance
+func startManual(t *testing.T, configure func(config *gameserver.Config)) *Harness {
+	if testing.Verbose() == false {
+		log.SetOutput(ioutil.Discard)
+	}
+	config, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configure != nil {
+		configure(config)
+	}
+	harness, err := StartClocked(config, nil, gameserver.NewManualClock(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return harness
+}
+
+func stopManual(t *testing.T, harness *Harness) {
+	err := harness.Stop()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func joinManual(t *testing.T, harness *Harness) *Client {
+	client, err := harness.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// Ожидание закрытия арены без сдвига часов, false - арена работает весь timeout
+func waitArenaClosed(harness *Harness, arenaId uint32, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if harness.GetServer().FindArena(arenaId) == nil {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+// Движение игрока под ручными часами, после него игрок попадает в состояние арены
+func moveManual(t *testing.T, harness *Harness, client *Client) {
+	x, y, err := WalkablePoint(client.ArenaInfo, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Move(x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := gameserver.GetApp().GetConfig().Arena.UpdatePeriod.Duration()
+	for i := 0; i < SCENARIO_REWIND_STEPS; i++ {
+		arena, err := harness.FindClientArena(client.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, info := range arena.Clients {
+			if (info.State.ID == client.ID) && (info.State.X == x) && (info.State.Y == y) {
+				return
+			}
+		}
+		time.Sleep(SCENARIO_REWIND_READ)
+		err = harness.Step(arena.ID, step, arena.Tick+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Fatalf("Client %d not moved in %d ticks", client.ID, SCENARIO_REWIND_STEPS)
+}
+
+// Игроки заполняют арену до MaxClients, следующий игрок получает новую арену
+func TestArenaCapacity(t *testing.T) {
+	harness := startManual(t, func(config *gameserver.Config) {
+		config.Arena.MaxClients = 2
+	})
+	defer stopManual(t, harness)
+
+	clients := make([]*Client, 0, 3)
+	for i := 0; i < 3; i++ {
+		client := joinManual(t, harness)
+		defer client.Close()
+		clients = append(clients, client)
+	}
+
+	first, err := harness.FindClientArena(clients[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := harness.FindClientArena(clients[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := harness.FindClientArena(clients[2].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (first.ID != second.ID) || (len(first.Clients) != 2) {
+		t.Fatalf("First two clients in arenas %d and %d, expected one full arena", first.ID, second.ID)
+	}
+	if (third.ID == first.ID) || (len(third.Clients) != 1) {
+		t.Fatalf("Third client in arena %d with %d clients, expected a new arena", third.ID, len(third.Clients))
+	}
+	if count := harness.GetServer().FindArena(first.ID).GetClientsCount(); count != 2 {
+		t.Fatalf("Full arena clients count %d, expected 2", count)
+	}
+}
+
+// Арена без игроков работает до IdleTimeout по часам арены и закрывается после него
+func TestArenaIdleTimeout(t *testing.T) {
+	const idleTimeout = time.Minute
+	harness := startManual(t, func(config *gameserver.Config) {
+		config.Arena.IdleTimeout = gameserver.ConfigDuration(idleTimeout)
+	})
+	defer stopManual(t, harness)
+
+	client := joinManual(t, harness)
+	arena, err := harness.FindClientArena(client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	// Таймер простоя запускается в цикле арены при удалении последнего игрока
+	serverArena := harness.GetServer().FindArena(arena.ID)
+	deadline := time.Now().Add(CLIENT_READ_TIMEOUT)
+	for {
+		info, ok := serverArena.GetAdminInfo()
+		if (ok == false) || (len(info.Clients) == 0) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Client %d not removed from arena %d", client.ID, arena.ID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	harness.Clock.Advance(idleTimeout - time.Second)
+	if waitArenaClosed(harness, arena.ID, 100*time.Millisecond) {
+		t.Fatalf("Arena %d closed before idle timeout", arena.ID)
+	}
+	harness.Clock.Advance(time.Second)
+	if waitArenaClosed(harness, arena.ID, CLIENT_READ_TIMEOUT) == false {
+		t.Fatalf("Arena %d not closed after idle timeout", arena.ID)
+	}
+}
+
+// Таймер подземелья по часам арены завершает его: арена получает статус завершения, игрок - победу
+func TestDungeonTimer(t *testing.T) {
+	harness := startManual(t, nil)
+	defer stopManual(t, harness)
+
+	client := joinManual(t, harness)
+	defer client.Close()
+	moveManual(t, harness, client)
+	arena, err := harness.FindClientArena(client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dungeon := gameserver.GetApp().GetStaticInfo().Dungeons[arena.Dungeon]
+	dungeonTime := time.Duration(dungeon.Timer * float64(time.Second))
+
+	// Часы уже сдвинуты на тики движения, до конца подземелья остается меньше dungeonTime
+	harness.Clock.Advance(dungeonTime - time.Second)
+	time.Sleep(100 * time.Millisecond)
+	arena, err = harness.FindClientArena(client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arena.Status != gameserver.GAME_ROOM_STATUS_ACTIVE {
+		t.Fatalf("Arena status %d before dungeon timer, expected active", arena.Status)
+	}
+
+	harness.Clock.Advance(time.Second)
+	state, err := client.WaitArenaState(func(state *gameserver.GameArenaState) bool {
+		return state.Status == gameserver.GAME_ROOM_STATUS_COMPLETED
+	})
+	if err != nil {
+		t.Fatalf("Dungeon not completed: %s", err)
+	}
+	clientState := FindClientState(state, client.ID)
+	if (clientState == nil) || (clientState.Status != gameserver.CLIENT_STATUS_WIN) {
+		t.Fatalf("Client state %+v in completed arena, expected win", clientState)
+	}
+	if waitArenaClosed(harness, arena.ID, CLIENT_READ_TIMEOUT) == false {
+		t.Fatalf("Arena %d not closed after dungeon completion", arena.ID)
+	}
+}
+
+// Клиент без JOIN входит на арену через JoinWait по часам арены, до этого его нет среди игроков
+func TestJoinWait(t *testing.T) {
+	const joinSteps = 4
+	harness := startManual(t, func(config *gameserver.Config) {
+		config.Client.JoinWait = gameserver.ConfigDuration(joinSteps * config.Arena.UpdatePeriod.Duration())
+	})
+	defer stopManual(t, harness)
+
+	client := joinManual(t, harness)
+	defer client.Close()
+	legacy, err := harness.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacy.Close()
+
+	// Срок JOIN отсчитывается от появления клиента на арене
+	deadline := time.Now().Add(CLIENT_READ_TIMEOUT)
+	for {
+		arena, err := harness.FindClientArena(client.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if arena.Joining == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Client without JOIN not waiting on arena")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	step := gameserver.GetApp().GetConfig().Arena.UpdatePeriod.Duration()
+	joined := func() int {
+		arena, err := harness.FindClientArena(client.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = harness.Step(arena.ID, step, arena.Tick+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		arena, err = harness.FindClientArena(client.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(arena.Clients)
+	}
+	for i := 1; i < joinSteps; i++ {
+		if count := joined(); count != 1 {
+			t.Fatalf("Arena clients %d after %d of %d join wait ticks", count, i, joinSteps)
+		}
+	}
+	count := 1
+	for i := 0; (i < SCENARIO_REWIND_STEPS) && (count == 1); i++ {
+		count = joined()
+	}
+	if count != 2 {
+		t.Fatalf("Client without JOIN not joined after join wait, arena clients %d", count)
+	}
+	err = legacy.WaitJoin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.ArenaInfo.Resources == nil {
+		t.Fatal("No resources for client without JOIN")
+	}
+}
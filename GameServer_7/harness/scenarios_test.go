@@ -0,0 +1,27 @@
+package harness
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+// Каждый сценарий на отдельном сервере, один сценарий: go test ./harness -run TestScenarios/hit
+func TestScenarios(t *testing.T) {
+	if testing.Verbose() == false {
+		log.SetOutput(ioutil.Discard)
+	}
+	for _, scenario := range Scenarios {
+		scenario := scenario
+		t.Run(scenario.Name, func(t *testing.T) {
+			config, err := NewConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = RunScenario(config, scenario)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
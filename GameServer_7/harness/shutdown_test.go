@@ -0,0 +1,94 @@
+package harness
+
+import (
+	"GoTests/GameServer_7/gameserver"
+	"io/ioutil"
+	"log"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const SHUTDOWN_SETTLE_TIMEOUT = 2 * time.Second // горутины завершаются после закрытия соединений не мгновенно
+
+// После Server.Shutdown с подключенными игроками, наблюдателем и клиентом без JOIN не остается горутин сервера.
+// Клиенты теста не закрываются до проверки, чтобы их отключение не завершало горутины за сервер
+func TestShutdownGoroutines(t *testing.T) {
+	if testing.Verbose() == false {
+		log.SetOutput(ioutil.Discard)
+	}
+	before := runtime.NumGoroutine()
+
+	config, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Server.AdminListenAddress = HARNESS_LISTEN_ADDRESS
+	config.Server.AdminToken = SCENARIO_ADMIN_TOKEN
+	config.Server.MetricsListenAddress = HARNESS_LISTEN_ADDRESS
+	config.Client.JoinWait = gameserver.ConfigDuration(SCENARIO_JOIN_WAIT)
+	harness, err := Start(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients := make([]*Client, 0)
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		client, err := harness.Join()
+		if err != nil {
+			harness.Stop()
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+	client, err := harness.JoinWebSocket()
+	if err != nil {
+		harness.Stop()
+		t.Fatal(err)
+	}
+	clients = append(clients, client)
+	// Игрок без JOIN ждет на арене JoinWait
+	client, err = harness.Dial()
+	if err != nil {
+		harness.Stop()
+		t.Fatal(err)
+	}
+	clients = append(clients, client)
+	spectator, err := Dial(harness.SpectatorAddr)
+	if err != nil {
+		harness.Stop()
+		t.Fatal(err)
+	}
+	clients = append(clients, spectator)
+	_, err = spectator.Expect("ArenasList")
+	if err != nil {
+		harness.Stop()
+		t.Fatal(err)
+	}
+	if running := runtime.NumGoroutine(); running <= before {
+		harness.Stop()
+		t.Fatalf("Goroutines %d with running server, %d before start", running, before)
+	}
+
+	err = harness.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := runtime.NumGoroutine()
+	deadline := time.Now().Add(SHUTDOWN_SETTLE_TIMEOUT)
+	for (after > before) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		after = runtime.NumGoroutine()
+	}
+	if after > before {
+		buffer := make([]byte, 1<<20)
+		buffer = buffer[:runtime.Stack(buffer, true)]
+		t.Fatalf("Goroutines %d after shutdown, %d before start:\n%s", after, before, buffer)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	//"log"
 	//"runtime/trace"
 	//"github.com/pkg/profile"
-	"fmt"
+	"bufio"
 	"log"
+	"os"
+	"strings"
 	//"github.com/pquerna/ffjson/ffjson"
 )
 
@@ -28,7 +30,13 @@ func main() {
 	    defer trace.Stop()
 	*/
 
-	err := gameserver.MakeApp()
+	config, err := gameserver.LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Printf("Config not loaded: %s\n", err)
+		os.Exit(2)
+	}
+
+	err = gameserver.MakeApp(config)
 	if err != nil {
 		log.Printf("App not created: %s\n", err)
 		return
@@ -40,13 +48,21 @@ func main() {
 		return
 	}
 
-	for {
-		var input string
-		fmt.Scanln(&input)
-
-		if input == "exit" {
-			gameserver.GetApp().ExitServer()
-			break
+	// Выход по команде exit или по запросу из админки
+	exitCh := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) == "exit" {
+				close(exitCh)
+				return
+			}
 		}
+	}()
+
+	select {
+	case <-exitCh:
+	case <-gameserver.GetApp().ShutdownRequested():
 	}
+	gameserver.GetApp().ExitServer()
 }
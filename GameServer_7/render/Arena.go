@@ -0,0 +1,43 @@
+package render
+
+import (
+	"GoTests/GameServer_7/gameserver"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+// Арена для отрисовки: сетка платформ любого размера, как в дампах arenaDump*.json
+type Arena struct {
+	Platforms [][]*gameserver.Platform `json:"platforms"`
+}
+
+func NewArenaFromModel(model *gameserver.ArenaModel) *Arena {
+	arena := &Arena{
+		Platforms: make([][]*gameserver.Platform, len(model.Platforms)),
+	}
+	for y := range model.Platforms {
+		arena.Platforms[y] = append(arena.Platforms[y], model.Platforms[y][:]...)
+	}
+	return arena
+}
+
+func NewArenaFromReader(reader io.Reader) (*Arena, error) {
+	arena := &Arena{}
+	decoder := json.NewDecoder(reader)
+	err := decoder.Decode(arena)
+	return arena, err
+}
+
+func NewArenaFromFile(filePath string) (*Arena, error) {
+	// Загрузка арены из файла
+	f, err := os.Open(filePath)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewArenaFromReader(f)
+}
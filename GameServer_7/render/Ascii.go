@@ -0,0 +1,100 @@
+package render
+
+import (
+	"GoTests/GameServer_7/gameserver"
+	"strings"
+)
+
+const ASCII_SIDE_BY_SIDE_GAP = "   "
+
+// Символы объектов по типам
+var asciiObjects = map[gameserver.PlatformObjectType]byte{
+	gameserver.PLATFORM_OBJ_TYPE_FLOOR:       'f',
+	gameserver.PLATFORM_OBJ_TYPE_WALL:        'w',
+	gameserver.PLATFORM_OBJ_TYPE_CORNER:      'c',
+	gameserver.PLATFORM_OBJ_TYPE_ARCHE:       'a',
+	gameserver.PLATFORM_OBJ_TYPE_PILLAR:      'P',
+	gameserver.PLATFORM_OBJ_TYPE_COFFIN:      'C',
+	gameserver.PLATFORM_OBJ_TYPE_ENVIRONMENT: 'e',
+	gameserver.PLATFORM_OBJ_TYPE_DECOR:       'd',
+}
+
+// Символы типов ячеек, у ямы, стены и дыры в стене одинаковое значение
+var asciiCells = map[gameserver.PlatformCellType]byte{
+	gameserver.CELL_TYPE_UNDEF: ' ',
+	gameserver.CELL_TYPE_BLOCK: '#',
+	gameserver.CELL_TYPE_WALL:  '~',
+	gameserver.CELL_TYPE_GLASS: '"',
+	gameserver.CELL_TYPE_GRASS: ',',
+	gameserver.CELL_TYPE_SPACE: '.',
+}
+
+const ASCII_LEGEND = `' ' no platform   '#' block   '.' space   '~' pit/wall/hole   ',' grass   '"' glass
+'X' exit   'S' enter   objects: w wall, c corner, a arch, P pillar, C coffin, e environment, d decor, f floor, ? unknown`
+
+func (cell *GridCell) Symbol() byte {
+	switch {
+	case cell.Enter:
+		return 'S'
+	case cell.Exit:
+		return 'X'
+	case cell.HasObject && cell.Known:
+		if symbol, exists := asciiObjects[cell.Object]; exists {
+			return symbol
+		}
+		return '?'
+	case cell.HasObject:
+		return '?'
+	case cell.Platform == false:
+		return ' '
+	}
+	if symbol, exists := asciiCells[cell.Type]; exists {
+		return symbol
+	}
+	return '0' + byte(cell.Type&7)
+}
+
+// Строки арены, по символу на ячейку
+func (grid *Grid) Lines() []string {
+	lines := make([]string, grid.Height)
+	line := make([]byte, grid.Width)
+	for y := 0; y < grid.Height; y++ {
+		for x := 0; x < grid.Width; x++ {
+			line[x] = grid.At(x, y).Symbol()
+		}
+		lines[y] = string(line)
+	}
+	return lines
+}
+
+func (grid *Grid) ASCII() string {
+	return strings.Join(grid.Lines(), "\n") + "\n"
+}
+
+// Несколько арен рядом, например одна и та же арена до и после изменения генератора
+func SideBySideASCII(grids ...*Grid) string {
+	columns := make([][]string, len(grids))
+	height := 0
+	for i, grid := range grids {
+		columns[i] = grid.Lines()
+		if grid.Height > height {
+			height = grid.Height
+		}
+	}
+
+	builder := strings.Builder{}
+	for y := 0; y < height; y++ {
+		for i, grid := range grids {
+			if i > 0 {
+				builder.WriteString(ASCII_SIDE_BY_SIDE_GAP)
+			}
+			if y < len(columns[i]) {
+				builder.WriteString(columns[i][y])
+			} else {
+				builder.WriteString(strings.Repeat(" ", grid.Width))
+			}
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
@@ -0,0 +1,149 @@
+package render
+
+import "GoTests/GameServer_7/gameserver"
+
+// Ячейка арены со всем, что на ней нужно показать
+type GridCell struct {
+	Platform  bool                          // ячейка принадлежит платформе
+	Type      gameserver.PlatformCellType   // тип ячейки платформы
+	HasObject bool                          // на ячейке стоит объект
+	Object    gameserver.PlatformObjectType // тип объекта, если он известен
+	Known     bool                          // описание объекта найдено
+	Exit      bool                          // выход с платформы
+	Enter     bool                          // точка входа на платформу
+}
+
+// Ячейки всей арены в мировых координатах
+type Grid struct {
+	Width  int
+	Height int
+	Cells  []GridCell
+}
+
+// Раскладка арены по ячейкам, objects - описания объектов по id для их ячеек,
+// без описания объект отмечается только в своей позиции
+type Renderer struct {
+	objects map[string]*gameserver.PlatformObjectInfo
+}
+
+// Описания объектов берутся из всех платформ статических данных, staticInfo может быть nil
+func NewRenderer(staticInfo *gameserver.StaticInfo) *Renderer {
+	renderer := &Renderer{
+		objects: make(map[string]*gameserver.PlatformObjectInfo),
+	}
+	if staticInfo == nil {
+		return renderer
+	}
+	for _, platformInfo := range staticInfo.Platforms {
+		for i := range platformInfo.Objects {
+			info := &platformInfo.Objects[i]
+			if _, exists := renderer.objects[info.Id]; (info.Id != "") && (exists == false) {
+				renderer.objects[info.Id] = info
+			}
+		}
+	}
+	return renderer
+}
+
+func (grid *Grid) At(x, y int) *GridCell {
+	if (x < 0) || (y < 0) || (x >= grid.Width) || (y >= grid.Height) {
+		return nil
+	}
+	return &grid.Cells[y*grid.Width+x]
+}
+
+func (renderer *Renderer) Layout(arena *Arena) *Grid {
+	// Размер по самым дальним платформам
+	grid := &Grid{}
+	for _, row := range arena.Platforms {
+		for _, platform := range row {
+			if platform == nil {
+				continue
+			}
+			if right := int(platform.PosX) + int(platform.Width); right > grid.Width {
+				grid.Width = right
+			}
+			if bottom := int(platform.PosY) + int(platform.Height); bottom > grid.Height {
+				grid.Height = bottom
+			}
+		}
+	}
+	grid.Cells = make([]GridCell, grid.Width*grid.Height)
+
+	for _, row := range arena.Platforms {
+		for _, platform := range row {
+			if platform == nil {
+				continue
+			}
+			renderer.layoutPlatform(grid, platform)
+		}
+	}
+	return grid
+}
+
+func (renderer *Renderer) layoutPlatform(grid *Grid, platform *gameserver.Platform) {
+	posX := int(platform.PosX)
+	posY := int(platform.PosY)
+
+	// Ячейки
+	for i, cellType := range platform.Cells {
+		cell := grid.At(posX+i%int(platform.Width), posY+i/int(platform.Width))
+		if cell != nil {
+			cell.Platform = true
+			cell.Type = cellType
+		}
+	}
+
+	// Объекты, блоки пола уже видны по ячейкам
+	for i := range platform.Objects {
+		object := &platform.Objects[i]
+		info := renderer.objects[object.Id]
+		points := object.Cells(info)
+		if len(points) == 0 {
+			points = []gameserver.Point16{gameserver.NewPoint16(int16(object.X), int16(object.Y))}
+		}
+		for _, point := range points {
+			// Арки частично стоят на мостах соседних платформ, их ячейки за краем не рисуются
+			if (point.X < 0) || (point.Y < 0) || (int(point.X) >= int(platform.Width)) || (int(point.Y) >= int(platform.Height)) {
+				continue
+			}
+			cell := grid.At(posX+int(point.X), posY+int(point.Y))
+			if cell == nil {
+				continue
+			}
+			cell.HasObject = true
+			cell.Known = info != nil
+			if info != nil {
+				cell.Object = info.Type
+			}
+		}
+	}
+
+	// Выходы в координатах платформы, вход в мировых
+	for dir := gameserver.DIR_NORTH; dir <= gameserver.DIR_WEST; dir++ {
+		point, exists := platform.ExitPoint(dir)
+		if exists == false {
+			continue
+		}
+		if cell := grid.At(posX+int(point.X), posY+int(point.Y)); cell != nil {
+			cell.Exit = true
+		}
+	}
+	if cell := grid.At(int(platform.EnterX), int(platform.EnterY)); cell != nil {
+		cell.Enter = true
+	}
+}
+
+// Количество отличающихся ячеек, ячейки за краем другой арены считаются отличающимися
+func (grid *Grid) Diff(other *Grid) int {
+	count := 0
+	for y := 0; y < grid.Height; y++ {
+		for x := 0; x < grid.Width; x++ {
+			otherCell := other.At(x, y)
+			if (otherCell == nil) || (*otherCell != *grid.At(x, y)) {
+				count++
+			}
+		}
+	}
+	return count
+}
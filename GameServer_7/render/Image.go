@@ -0,0 +1,143 @@
+package render
+
+import (
+	"GoTests/GameServer_7/gameserver"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+)
+
+const (
+	IMAGE_DEFAULT_SCALE     = 8 // пикселей на ячейку
+	IMAGE_SIDE_BY_SIDE_GAP  = 2 // ячеек между аренами
+	IMAGE_OBJECT_BORDER_DIV = 4 // объект рисуется квадратом внутри ячейки с отступом scale/4
+)
+
+var (
+	imageBackground = color.RGBA{0x10, 0x10, 0x18, 0xff}
+	imageUnknown    = color.RGBA{0xff, 0x00, 0xff, 0xff}
+	imageExit       = color.RGBA{0xff, 0x30, 0x30, 0xff}
+	imageEnter      = color.RGBA{0x30, 0xff, 0x30, 0xff}
+)
+
+// Цвета типов ячеек
+var imageCells = map[gameserver.PlatformCellType]color.RGBA{
+	gameserver.CELL_TYPE_UNDEF: {0x00, 0x00, 0x00, 0xff},
+	gameserver.CELL_TYPE_BLOCK: {0x30, 0x30, 0x38, 0xff},
+	gameserver.CELL_TYPE_WALK:  {0x90, 0x90, 0x70, 0xff},
+	gameserver.CELL_TYPE_PROJ:  {0x40, 0x40, 0x90, 0xff},
+	gameserver.CELL_TYPE_WALL:  {0x70, 0x50, 0x40, 0xff},
+	gameserver.CELL_TYPE_GLASS: {0x80, 0xc0, 0xe0, 0xff},
+	gameserver.CELL_TYPE_GRASS: {0x50, 0xa0, 0x40, 0xff},
+	gameserver.CELL_TYPE_SPACE: {0xc8, 0xc0, 0xa8, 0xff},
+}
+
+// Цвета объектов по типам
+var imageObjects = map[gameserver.PlatformObjectType]color.RGBA{
+	gameserver.PLATFORM_OBJ_TYPE_FLOOR:       {0xa0, 0xa0, 0xa0, 0xff},
+	gameserver.PLATFORM_OBJ_TYPE_WALL:        {0x60, 0x30, 0x10, 0xff},
+	gameserver.PLATFORM_OBJ_TYPE_CORNER:      {0x80, 0x40, 0x10, 0xff},
+	gameserver.PLATFORM_OBJ_TYPE_ARCHE:       {0xe0, 0x90, 0x20, 0xff},
+	gameserver.PLATFORM_OBJ_TYPE_PILLAR:      {0x20, 0x20, 0x20, 0xff},
+	gameserver.PLATFORM_OBJ_TYPE_COFFIN:      {0x90, 0x20, 0xa0, 0xff},
+	gameserver.PLATFORM_OBJ_TYPE_ENVIRONMENT: {0xff, 0xe0, 0x40, 0xff},
+	gameserver.PLATFORM_OBJ_TYPE_DECOR:       {0x30, 0x90, 0xa0, 0xff},
+}
+
+func fillRect(img *image.RGBA, rect image.Rectangle, c color.RGBA) {
+	draw.Draw(img, rect, &image.Uniform{c}, image.Point{}, draw.Src)
+}
+
+// Отрисовка ячейки в квадрат rect: цвет типа ячейки, поверх объект, выходы и вход рамкой
+func drawCell(img *image.RGBA, rect image.Rectangle, cell *GridCell) {
+	cellColor := imageBackground
+	if cell.Platform {
+		if c, exists := imageCells[cell.Type]; exists {
+			cellColor = c
+		} else {
+			cellColor = imageUnknown
+		}
+	}
+	fillRect(img, rect, cellColor)
+
+	scale := rect.Dx()
+	if cell.Exit || cell.Enter {
+		markColor := imageExit
+		if cell.Enter {
+			markColor = imageEnter
+		}
+		fillRect(img, rect, markColor)
+		border := scale / IMAGE_OBJECT_BORDER_DIV / 2
+		if border < 1 {
+			border = 1
+		}
+		fillRect(img, rect.Inset(border), cellColor)
+	}
+	if cell.HasObject {
+		objectColor := imageUnknown
+		if c, exists := imageObjects[cell.Object]; exists && cell.Known {
+			objectColor = c
+		}
+		fillRect(img, rect.Inset(scale/IMAGE_OBJECT_BORDER_DIV), objectColor)
+	}
+}
+
+func (grid *Grid) drawTo(img *image.RGBA, offsetX, scale int) {
+	for y := 0; y < grid.Height; y++ {
+		for x := 0; x < grid.Width; x++ {
+			rect := image.Rect(offsetX+x*scale, y*scale, offsetX+(x+1)*scale, (y+1)*scale)
+			drawCell(img, rect, grid.At(x, y))
+		}
+	}
+}
+
+func (grid *Grid) Image(scale int) *image.RGBA {
+	return SideBySideImage(scale, grid)
+}
+
+// Несколько арен рядом на одной картинке
+func SideBySideImage(scale int, grids ...*Grid) *image.RGBA {
+	if scale <= 0 {
+		scale = IMAGE_DEFAULT_SCALE
+	}
+	width := 0
+	height := 0
+	for i, grid := range grids {
+		if i > 0 {
+			width += IMAGE_SIDE_BY_SIDE_GAP
+		}
+		width += grid.Width
+		if grid.Height > height {
+			height = grid.Height
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width*scale, height*scale))
+	fillRect(img, img.Bounds(), imageBackground)
+	offsetX := 0
+	for _, grid := range grids {
+		grid.drawTo(img, offsetX, scale)
+		offsetX += (grid.Width + IMAGE_SIDE_BY_SIDE_GAP) * scale
+	}
+	return img
+}
+
+func WritePNG(writer io.Writer, img image.Image) error {
+	return png.Encode(writer, img)
+}
+
+func WritePNGFile(filePath string, img image.Image) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, img)
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}